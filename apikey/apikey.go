@@ -0,0 +1,349 @@
+// Package apikey provides API keys that authenticate the clients of the
+// servers of a library, see File, with a scope that limits them to reading
+// the library or also allows them to change it.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is what an API key allows its client to do.
+type Scope string
+
+const (
+	// ScopeRead allows queries of the library and watching its changes.
+	ScopeRead Scope = "read"
+	// ScopeWrite allows executing commands that change the library, as
+	// well as everything ScopeRead allows.
+	ScopeWrite Scope = "write"
+)
+
+// Allows reports whether a key with the scope is allowed a request that
+// requires the other scope.
+func (s Scope) Allows(required Scope) bool {
+	return s == ScopeWrite || s == required
+}
+
+// ParseScope parses the name of a scope.
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeRead, ScopeWrite:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("invalid scope %q, must be read or write", s)
+	}
+}
+
+var (
+	// ErrUnauthenticated is returned for a request without a token or
+	// with a token that is not a key, e.g. because it was revoked.
+	ErrUnauthenticated = errors.New("invalid or missing API key")
+	// ErrPermissionDenied is returned for a request that requires a scope
+	// that its key does not allow.
+	ErrPermissionDenied = errors.New("API key scope does not allow the request")
+	// ErrKeyNotFound is returned when revoking a key that does not exist.
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// Key is an API key. Only the hash of its token is kept, so that the token
+// cannot be recovered from the keys file.
+type Key struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"` // Name of the client, e.g. "circulation desk".
+	Scope   Scope     `json:"scope"`
+	Hash    string    `json:"hash"` // Hex encoded SHA-256 of the token.
+	Created time.Time `json:"created"`
+}
+
+// tokenPrefix is the prefix of the tokens, which makes them easy to recognize,
+// e.g. by secret scanners.
+const tokenPrefix = "lk_"
+
+// File is a JSON file of API keys, managed with Create and Revoke, that
+// authenticates the tokens of the requests of clients.
+//
+// The file is read again when it is modified, so that a server picks up the
+// keys created and revoked while it is running.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time // Modification time of the file when read.
+	size    int64     // Size of the file when read.
+	keys    []Key
+}
+
+// keysFile is the JSON encoding of a File.
+type keysFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// List returns the keys.
+func (f *File) List() ([]Key, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	return append([]Key(nil), f.keys...), nil
+}
+
+// Create creates a key for a client with a scope and returns it and its token.
+// The token is only returned once, as only its hash is kept.
+func (f *File) Create(name string, scope Scope) (string, Key, error) {
+	if _, err := ParseScope(string(scope)); err != nil {
+		return "", Key{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return "", Key{}, err
+	}
+
+	id, err := randomHex(4)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	token := tokenPrefix + id + "_" + secret
+
+	key := Key{
+		ID:      id,
+		Name:    name,
+		Scope:   scope,
+		Hash:    hash(token),
+		Created: time.Now().UTC(),
+	}
+
+	if err := f.save(append(f.keys, key)); err != nil {
+		return "", Key{}, err
+	}
+
+	return token, key, nil
+}
+
+// Revoke revokes the key with the ID, so that its token is no longer
+// authenticated.
+func (f *File) Revoke(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	keys := make([]Key, 0, len(f.keys))
+
+	for _, key := range f.keys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == len(f.keys) {
+		return fmt.Errorf("%w, %s", ErrKeyNotFound, id)
+	}
+
+	return f.save(keys)
+}
+
+// Authenticate returns the key of a token, or an error wrapping
+// ErrUnauthenticated if it is not the token of a key.
+func (f *File) Authenticate(token string) (*Key, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(token, tokenPrefix), "_")
+	if !ok || !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrUnauthenticated, err)
+	}
+
+	h := hash(token)
+
+	for i := range f.keys {
+		key := &f.keys[i]
+
+		if key.ID == id && subtle.ConstantTimeCompare([]byte(key.Hash), []byte(h)) == 1 {
+			k := *key
+
+			return &k, nil
+		}
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+// Authorize returns the key of the token if it allows the scope, or an error
+// wrapping ErrUnauthenticated or ErrPermissionDenied.
+func (f *File) Authorize(token string, scope Scope) (*Key, error) {
+	key, err := f.Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.Scope.Allows(scope) {
+		return nil, fmt.Errorf("%w, key %s has scope %s, %s required", ErrPermissionDenied, key.ID, key.Scope, scope)
+	}
+
+	return key, nil
+}
+
+// keyContextKey is the context key of the key of a request.
+type keyContextKey struct{}
+
+// NewContext returns a context with the key that authenticated a request,
+// e.g. so that the request is rate limited by its key rather than by its
+// address.
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the key that authenticated a request, if any, see
+// NewContext. Require sets it for the requests it serves.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(*Key)
+
+	return key, ok
+}
+
+// Require returns a handler that serves the requests with a token of a key
+// that allows the scope with h, and responds to the others with 401
+// Unauthorized or 403 Forbidden.
+//
+// The token is read from the Authorization header as a bearer token, e.g.
+// "Authorization: Bearer lk_...", or else from the access_token query
+// parameter, since browsers cannot set the headers of a WebSocket.
+func (f *File) Require(scope Scope, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		key, err := f.Authorize(token, scope)
+
+		switch {
+		case err == nil:
+			h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), key)))
+		case errors.Is(err, ErrPermissionDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="library"`)
+			http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+		}
+	})
+}
+
+// load reads the keys from the file if it was modified since it was last
+// read. A file that does not exist has no keys.
+func (f *File) load() error {
+	info, err := os.Stat(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.keys, f.modTime = nil, time.Time{}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read API keys, %w", err)
+	}
+
+	if f.keys != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	bs, err := os.ReadFile(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read API keys, %w", err)
+	}
+
+	var file keysFile
+
+	if err := json.Unmarshal(bs, &file); err != nil {
+		return fmt.Errorf("failed to read API keys, invalid keys file %s, %w", f.Path, err)
+	}
+
+	f.keys = append([]Key{}, file.Keys...)
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// save replaces the keys of the file. The file is replaced atomically so that
+// a server never reads a partially written file.
+func (f *File) save(keys []Key) error {
+	bs, err := json.MarshalIndent(keysFile{Keys: keys}, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal API keys, %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write API keys, %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(bs, '\n')); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("failed to write API keys, %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write API keys, %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("failed to write API keys, %w", err)
+	}
+
+	// The keys are read again on the next use rather than kept, so that
+	// the modification time of the file is that of this write.
+	f.keys = nil
+
+	return nil
+}
+
+// hash returns the hash of a token that is kept in the keys file.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes hex encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key, %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
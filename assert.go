@@ -0,0 +1,55 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrAssertionFailed is returned by the ASSERT commands when the state
+	// of the library does not match the expectation.
+	ErrAssertionFailed = errors.New("assertion failed")
+)
+
+// diffIDs returns a human readable diff of the expected and actual IDs, e.g.
+// "expected [1 2], got [1 3], missing [2], unexpected [3]", or an empty string
+// if they contain the same IDs regardless of order.
+func diffIDs(expected, actual []int) string {
+	expected = slices.Clone(expected)
+	actual = slices.Clone(actual)
+
+	slices.Sort(expected)
+	slices.Sort(actual)
+
+	var missing, unexpected []int
+
+	for _, id := range expected {
+		if !slices.Contains(actual, id) {
+			missing = append(missing, id)
+		}
+	}
+
+	for _, id := range actual {
+		if !slices.Contains(expected, id) {
+			unexpected = append(unexpected, id)
+		}
+	}
+
+	if len(missing) == 0 && len(unexpected) == 0 && len(expected) == len(actual) {
+		return ""
+	}
+
+	diff := []string{fmt.Sprintf("expected %v, got %v", expected, actual)}
+
+	if len(missing) > 0 {
+		diff = append(diff, fmt.Sprintf("missing %v", missing))
+	}
+
+	if len(unexpected) > 0 {
+		diff = append(diff, fmt.Sprintf("unexpected %v", unexpected))
+	}
+
+	return strings.Join(diff, ", ")
+}
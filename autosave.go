@@ -0,0 +1,83 @@
+package library
+
+import (
+	"context"
+	"time"
+)
+
+// AutosaveStore is a Store that saves the state of the library periodically as
+// the commands are executed, rather than only when Save is called, so that a
+// crash during a long import only loses the commands since the last save.
+//
+// The state is saved after every Every commands or once Interval has passed
+// since the last save according to the library clock, whichever comes first.
+// A zero Every or Interval disables that trigger.
+type AutosaveStore struct {
+	Store
+
+	Every    int           // Number of commands between saves.
+	Interval time.Duration // Time between saves.
+
+	commands int       // Commands appended since the last save.
+	saved    time.Time // Time of the last save, zero before the first.
+}
+
+// Load implements Store.
+func (s *AutosaveStore) Load(l *Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements ContextStore by loading the underlying store with the
+// context, see LoadStore.
+func (s *AutosaveStore) LoadContext(ctx context.Context, l *Library) error {
+	if err := LoadStore(ctx, s.Store, l); err != nil {
+		return err
+	}
+
+	s.commands = 0
+	s.saved = l.Now()
+
+	return nil
+}
+
+// Append implements Store by appending the command to the underlying store and
+// saving the state if a save is due.
+func (s *AutosaveStore) Append(l *Library, inv *Invocation) error {
+	if err := s.Store.Append(l, inv); err != nil {
+		return err
+	}
+
+	s.commands++
+
+	dueByCount := s.Every > 0 && s.commands >= s.Every
+	dueByTime := s.Interval > 0 && l.Now().Sub(s.saved) >= s.Interval
+
+	if !dueByCount && !dueByTime {
+		return nil
+	}
+
+	return s.Save(l)
+}
+
+// Save implements Store.
+func (s *AutosaveStore) Save(l *Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements ContextStore by saving the underlying store with the
+// context, see SaveStore.
+func (s *AutosaveStore) SaveContext(ctx context.Context, l *Library) error {
+	if err := SaveStore(ctx, s.Store, l); err != nil {
+		return err
+	}
+
+	s.commands = 0
+	s.saved = l.Now()
+
+	return nil
+}
+
+// Ping implements Pinger by pinging the underlying store, see PingStore.
+func (s *AutosaveStore) Ping(ctx context.Context) error {
+	return PingStore(ctx, s.Store)
+}
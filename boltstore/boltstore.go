@@ -0,0 +1,143 @@
+// Package boltstore provides a library.Store backed by a bbolt database, so
+// that the state is updated transactionally in place.
+package boltstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/admtnnr/library"
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateBucket is the bucket of the library state, one command of the state in
+// the format written by library.Export per key, keyed by sequence so that the
+// commands are loaded in order.
+var stateBucket = []byte("state")
+
+// Store is a library.Store backed by a bbolt database.
+//
+// Each Save replaces the state in a single transaction, so it is cheap enough
+// to save after every command, see Append, and a crash loses at most the
+// command in progress rather than the whole batch.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the bbolt database at path, creating it if it does not exist.
+//
+// The database is locked while open, Open fails if another process does not
+// release the lock within a second.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s, %w", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Load implements library.Store.
+func (s *Store) Load(l *library.Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements library.ContextStore.
+func (s *Store) LoadContext(ctx context.Context, l *library.Library) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	var buf bytes.Buffer
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(stateBucket)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			buf.Write(v)
+			buf.WriteByte('\n')
+
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	return l.ImportContext(ctx, &buf, library.ImportOptions{})
+}
+
+// Save implements library.Store.
+func (s *Store) Save(l *library.Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements library.ContextStore. The state is replaced in a
+// single transaction, which is rolled back if the context is done before it
+// commits.
+func (s *Store) SaveContext(ctx context.Context, l *library.Library) error {
+	var buf bytes.Buffer
+
+	if err := l.ExportContext(ctx, &buf, library.ExportOptions{}); err != nil {
+		return err
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(stateBucket); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+
+		b, err := tx.CreateBucket(stateBucket)
+		if err != nil {
+			return err
+		}
+
+		sc := bufio.NewScanner(&buf)
+		sc.Buffer(nil, bolt.MaxValueSize)
+
+		for seq := uint64(1); sc.Scan(); seq++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := b.Put(binary.BigEndian.AppendUint64(nil, seq), bytes.Clone(sc.Bytes())); err != nil {
+				return err
+			}
+		}
+
+		return sc.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	return nil
+}
+
+// Append implements library.Store by saving the whole state, which is cheap
+// enough with bbolt that a crash loses at most the command in progress.
+func (s *Store) Append(l *library.Library, inv *library.Invocation) error {
+	return s.Save(l)
+}
+
+// Close implements library.Store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping implements library.Pinger by checking that the database is open.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.db.Path(), err)
+	}
+
+	return nil
+}
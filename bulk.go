@@ -0,0 +1,276 @@
+package library
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BookSpec describes a book to add to the catalog, see AddBooks.
+type BookSpec struct {
+	ID    int    // ID of the book.
+	Name  string // Name of the book.
+	Count int    // Number of copies of the book, must not be negative.
+
+	BookMetadata
+}
+
+// AccountSpec describes an account to create, see CreateAccounts.
+type AccountSpec struct {
+	ID   int    // ID of the account.
+	Name string // Name of the account.
+}
+
+// CheckoutSpec describes a checkout to make, see CheckoutBooks.
+type CheckoutSpec struct {
+	AccountID int // ID of the account checking out the book.
+	BookID    int // ID of the book being checked out.
+
+	// At is the time the book is checked out, now if zero, see
+	// CheckoutBookAt.
+	At time.Time
+	// Due is the time the book is due back, after the loan period of the
+	// policy if zero. The checkout limit is not enforced for a checkout
+	// with a due time, see CheckoutBookUntil.
+	Due time.Time
+}
+
+// AddBooks adds books to the library catalog, e.g. to load a large catalog
+// programmatically, either all of the books or none of them.
+//
+// The same rules as AddBook apply to each book, and the books are validated
+// together before any is added, so an ID may not be listed more than once and
+// the quota must allow all of them. The books are added with the lock acquired
+// once rather than once for each book, and are undone together, see Undo.
+func (l *Library) AddBooks(specs []BookSpec) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := make(map[int]bool, len(specs))
+
+	for _, spec := range specs {
+		if _, ok := l.books[spec.ID]; ok || seen[spec.ID] {
+			return &DuplicateError{Kind: KindBook, ID: spec.ID}
+		}
+
+		if spec.Count < 0 {
+			return fmt.Errorf("%w, cannot add negative copies of book (%d)", ErrInvalidArgument, spec.ID)
+		}
+
+		seen[spec.ID] = true
+	}
+
+	if l.quota.Books > 0 && len(l.books)+len(specs) > l.quota.Books {
+		return &QuotaError{Kind: KindBook, Limit: l.quota.Books}
+	}
+
+	if len(specs) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(specs))
+
+	for _, spec := range specs {
+		book := &Book{
+			ID:           spec.ID,
+			Name:         spec.Name,
+			Count:        spec.Count,
+			Version:      1,
+			BookMetadata: spec.BookMetadata,
+		}
+		book.Tags = slices.Clone(spec.Tags)
+
+		l.books[book.ID] = book
+		ids = append(ids, book.ID)
+	}
+
+	l.pushUndo(fmt.Sprintf("add %d books to the catalog", len(ids)), func() {
+		for _, id := range ids {
+			delete(l.books, id)
+		}
+	})
+
+	return nil
+}
+
+// CreateAccounts creates accounts in the library system, either all of the
+// accounts or none of them, in the same way as AddBooks.
+func (l *Library) CreateAccounts(specs []AccountSpec) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := make(map[int]bool, len(specs))
+
+	for _, spec := range specs {
+		if _, ok := l.accounts[spec.ID]; ok || seen[spec.ID] {
+			return &DuplicateError{Kind: KindAccount, ID: spec.ID}
+		}
+
+		seen[spec.ID] = true
+	}
+
+	if l.quota.Accounts > 0 && len(l.accounts)+len(specs) > l.quota.Accounts {
+		return &QuotaError{Kind: KindAccount, Limit: l.quota.Accounts}
+	}
+
+	if len(specs) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(specs))
+
+	for _, spec := range specs {
+		l.accounts[spec.ID] = &Account{
+			ID:      spec.ID,
+			Name:    spec.Name,
+			Version: 1,
+		}
+
+		ids = append(ids, spec.ID)
+	}
+
+	l.pushUndo(fmt.Sprintf("create %d accounts", len(ids)), func() {
+		for _, id := range ids {
+			delete(l.accounts, id)
+		}
+	})
+
+	return nil
+}
+
+// CheckoutBooks checks out books to accounts, e.g. to restore the checkouts
+// of a large catalog programmatically, either all of the checkouts or none of
+// them, in the same way as AddBooks.
+//
+// The same rules as CheckoutBook apply to each checkout, counting the
+// checkouts listed before it, so an account may not check out the same book
+// twice and the checkout limit and hold queues apply to the checkouts without
+// a due time together.
+func (l *Library) CheckoutBooks(specs []CheckoutSpec) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	type key struct{ accountID, bookID int }
+
+	now := l.clock.Now()
+
+	var (
+		counts = make(map[int]int)
+		taken  = make(map[int]int)
+		seen   = make(map[key]bool, len(specs))
+	)
+
+	for _, spec := range specs {
+		account, ok := l.accounts[spec.AccountID]
+		if !ok {
+			return &NotExistError{Kind: KindAccount, ID: spec.AccountID}
+		}
+
+		book, ok := l.books[spec.BookID]
+		if !ok {
+			return &NotExistError{Kind: KindBook, ID: spec.BookID}
+		}
+
+		checkouts := l.checkoutsByAccount[account.ID]
+
+		if spec.Due.IsZero() && len(checkouts)+counts[account.ID] >= l.policy.CheckoutLimit {
+			return &LimitError{
+				AccountID: account.ID,
+				Limit:     l.policy.CheckoutLimit,
+				detail:    fmt.Sprintf("%s (%d) cannot checkout more than %d books at a time", account.Name, account.ID, l.policy.CheckoutLimit),
+			}
+		}
+
+		k := key{accountID: account.ID, bookID: book.ID}
+
+		if seen[k] || slices.ContainsFunc(checkouts, func(checkout *Checkout) bool { return checkout.BookID == book.ID }) {
+			return alreadyCheckedOut(account, book)
+		}
+
+		// The holds of the accounts checking out the book before it are
+		// fulfilled by their checkouts rather than ahead of it.
+		fulfilled := func(accountID int) bool {
+			return seen[key{accountID: accountID, bookID: book.ID}]
+		}
+
+		if err := l.checkAvailable(account, book, taken[book.ID]); err != nil {
+			return err
+		}
+
+		if spec.Due.IsZero() {
+			at := spec.At
+			if at.IsZero() {
+				at = now
+			}
+
+			if err := l.checkHolds(account, book, at, taken[book.ID], fulfilled); err != nil {
+				return err
+			}
+		}
+
+		seen[k] = true
+		counts[account.ID]++
+		taken[book.ID]++
+	}
+
+	if len(specs) == 0 {
+		return nil
+	}
+
+	var (
+		added        = make(map[*Checkout]bool, len(specs))
+		restoreHolds []func()
+	)
+
+	for _, spec := range specs {
+		checkout := &Checkout{
+			AccountID:  spec.AccountID,
+			BookID:     spec.BookID,
+			CheckedOut: spec.At,
+			Due:        spec.Due,
+		}
+
+		if checkout.CheckedOut.IsZero() {
+			checkout.CheckedOut = now
+		}
+
+		if checkout.Due.IsZero() {
+			checkout.Due = checkout.CheckedOut.Add(l.policy.LoanPeriod)
+		}
+
+		l.checkoutsByAccount[checkout.AccountID] = append(l.checkoutsByAccount[checkout.AccountID], checkout)
+		l.checkoutsByBook[checkout.BookID] = append(l.checkoutsByBook[checkout.BookID], checkout)
+		l.history = append(l.history, checkout)
+
+		l.books[checkout.BookID].Version++
+		l.accounts[checkout.AccountID].Version++
+
+		added[checkout] = true
+		restoreHolds = append(restoreHolds, l.fulfillHold(checkout.AccountID, checkout.BookID))
+	}
+
+	l.pushUndo(fmt.Sprintf("checkout of %d books", len(added)), func() {
+		// The checkouts are removed at once rather than one at a time,
+		// see uncheckout, since there may be many of them.
+		match := func(c *Checkout) bool {
+			return added[c]
+		}
+
+		for checkout := range added {
+			l.checkoutsByAccount[checkout.AccountID] = slices.DeleteFunc(l.checkoutsByAccount[checkout.AccountID], match)
+			l.checkoutsByBook[checkout.BookID] = slices.DeleteFunc(l.checkoutsByBook[checkout.BookID], match)
+			l.books[checkout.BookID].Version++
+			l.accounts[checkout.AccountID].Version++
+		}
+
+		l.history = slices.DeleteFunc(l.history, match)
+
+		// The holds are restored in the reverse order they were
+		// fulfilled, so that each is restored in its place.
+		for _, restore := range slices.Backward(restoreHolds) {
+			restore()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// sequenceComment is the comment an ExportSince export records the
+	// sequence number of the library in, which is the checkpoint to
+	// export the next changes since.
+	sequenceComment = "# sequence"
+	// atComment is the comment an ExportSince export records the time
+	// each command was executed in, so that Import executes it as of
+	// that time.
+	atComment = "# at"
+)
+
+// watchBuffer is the number of changes a watcher may fall behind by before
+// it is stopped, see Watch.
+const watchBuffer = 256
+
+// Change is a command that mutated the library, see ExportSince and Watch.
+type Change struct {
+	// Seq is the sequence number of the library after the command, see
+	// Sequence.
+	Seq int
+	// Invocation is the command and the time it was executed. Its output
+	// and result are not kept.
+	Invocation Invocation
+	// Available are the holds the command made a copy available for, e.g.
+	// by returning a book, in the order of the hold queues, see
+	// EventHoldAvailable.
+	Available []Hold
+}
+
+// watcher is a watcher of the changes of a library, see Watch.
+type watcher struct {
+	changes chan Change
+}
+
+// Sequence returns the sequence number of the library, which increases with
+// every mutation, to checkpoint the changes exported by ExportSince.
+func (l *Library) Sequence() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.version
+}
+
+// recordChange records the invocation as a change if it mutated the library
+// since the last change was recorded.
+//
+// A command that executes other commands, e.g. INCLUDE, is not recorded if
+// its changes were already recorded by the commands it executed.
+func (l *Library) recordChange(inv *Invocation) {
+	l.mu.Lock()
+
+	if l.version == l.changed {
+		l.mu.Unlock()
+
+		return
+	}
+
+	c := Change{
+		Seq:        l.version,
+		Invocation: Invocation{Command: inv.Command, At: inv.At},
+		Available:  l.available,
+	}
+
+	l.available = nil
+
+	l.changed = l.version
+	l.changes = append(l.changes, c)
+
+	// A change made since a mark of the undo stack is sent once the mark
+	// is collapsed, or discarded if it is rolled back, see markUndo.
+	if l.marks > 0 {
+		l.mu.Unlock()
+
+		return
+	}
+
+	l.sendChange(c)
+
+	onEvent := l.onEvent
+
+	l.mu.Unlock()
+
+	// The handler is called without the lock held so that it may read the
+	// library, e.g. the book of the event.
+	if onEvent != nil {
+		for _, event := range EventsOf(c) {
+			onEvent(event)
+		}
+	}
+}
+
+// sendChange sends the change to the watchers of the library.
+//
+// sendChange must be called with the lock held.
+func (l *Library) sendChange(c Change) {
+	for w := range l.watchers {
+		select {
+		case w.changes <- c:
+		default:
+			// The watcher fell behind, so it is stopped rather than
+			// blocking the library.
+			delete(l.watchers, w)
+			close(w.changes)
+		}
+	}
+}
+
+// Watch returns a channel that receives the changes of the library after the
+// sequence number, see Sequence, first those already made and then each
+// change as it is made, until stop is called.
+//
+// The changes are sent without waiting for the receiver, so a receiver that
+// falls too far behind has the channel closed and must watch again from the
+// sequence number of the last change it received. The channel is also closed
+// when the state of the library is replaced, e.g. by ImportSnapshot. If the changes since the
+// sequence number are no longer recorded, an error is returned, see
+// ExportSince.
+func (l *Library) Watch(since int) (changes <-chan Change, stop func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if since < l.changesFrom || since > l.version {
+		return nil, nil, fmt.Errorf("%w, changes since sequence %d are not recorded, only since %d", ErrInvalidArgument, since, l.changesFrom)
+	}
+
+	var backlog []Change
+
+	settled, _ := l.settled()
+
+	for _, c := range settled {
+		if c.Seq > since {
+			backlog = append(backlog, c)
+		}
+	}
+
+	w := &watcher{changes: make(chan Change, len(backlog)+watchBuffer)}
+
+	for _, c := range backlog {
+		w.changes <- c
+	}
+
+	if l.watchers == nil {
+		l.watchers = make(map[*watcher]bool)
+	}
+
+	l.watchers[w] = true
+
+	stop = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.watchers[w] {
+			delete(l.watchers, w)
+			close(w.changes)
+		}
+	}
+
+	return w.changes, stop, nil
+}
+
+// resetChanges discards the recorded changes, e.g. when the state of the
+// library is replaced, so that changes before it cannot be exported. The
+// watchers are stopped since the changes they received no longer apply.
+//
+// resetChanges must be called with the lock held.
+func (l *Library) resetChanges() {
+	l.changes = nil
+	l.available = nil
+	l.changed = l.version
+	l.changesFrom = l.version
+
+	for w := range l.watchers {
+		close(w.changes)
+	}
+
+	l.watchers = nil
+}
+
+// ExportSince writes the commands that mutated the library after the sequence
+// number, see Sequence, to a writer, so that the changes can be backed up or
+// replicated without exporting the whole state.
+//
+// The export is a command log that Import applies to a library with the state
+// as of the sequence number. It records the sequence number of the library to
+// export the next changes since, and the time each command was executed, so
+// that commands that use the current time, e.g. CHECKOUT_BOOK, are applied as
+// they were executed.
+//
+// The changes are recorded from when the library is created or its state is
+// last replaced, e.g. by ImportSnapshot. If the changes since the sequence
+// number are no longer recorded, or include an UNDO, which reverts a mutation
+// of the library that a library the changes are applied to cannot undo, an
+// error is returned and the whole state must be exported instead.
+func (l *Library) ExportSince(w io.Writer, seq int) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// The changes of a macro or assertion in progress are not exported
+	// until it completes since they may be rolled back, see markUndo.
+	changes, version := l.settled()
+
+	if seq < l.changesFrom || seq > version {
+		return fmt.Errorf("%w, changes since sequence %d are not recorded, only since %d", ErrInvalidArgument, seq, l.changesFrom)
+	}
+
+	for _, c := range changes {
+		if _, ok := c.Invocation.Command.(*Undo); ok && c.Seq > seq {
+			return fmt.Errorf("%w, changes since sequence %d include an UNDO at sequence %d, which cannot be exported", ErrInvalidArgument, seq, c.Seq)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %d\n%s %d\n", versionComment, FormatVersion, sequenceComment, version); err != nil {
+		return fmt.Errorf("failed to write library changes, %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	for _, c := range changes {
+		if c.Seq <= seq {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %s\n", atComment, c.Invocation.At.Format(time.RFC3339Nano)); err != nil {
+			return fmt.Errorf("failed to write library changes, %w", err)
+		}
+
+		// The invocation is copied since marshaling it sets its
+		// RawCommand, and the lock is only held for reading.
+		inv := c.Invocation
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library changes, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseAtComment parses the time of an at comment, see ExportSince. It reports
+// whether the line is an at comment.
+func parseAtComment(line []byte) (time.Time, bool, error) {
+	v, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte(atComment+" "))
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, string(v))
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("%w, invalid command time %q", ErrInvalidArgument, v)
+	}
+
+	return at, true, nil
+}
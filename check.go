@@ -0,0 +1,333 @@
+package library
+
+import (
+	"fmt"
+	"slices"
+)
+
+// The codes of the problems found by Snapshot.Check and Library.Check.
+const (
+	ProblemDuplicateBook     = "DUPLICATE_BOOK"
+	ProblemDuplicateAccount  = "DUPLICATE_ACCOUNT"
+	ProblemDuplicateMacro    = "DUPLICATE_MACRO"
+	ProblemNegativeCopies    = "NEGATIVE_COPIES"
+	ProblemMissingBook       = "MISSING_BOOK"
+	ProblemMissingAccount    = "MISSING_ACCOUNT"
+	ProblemDuplicateCheckout = "DUPLICATE_CHECKOUT"
+	ProblemDuplicateHold     = "DUPLICATE_HOLD"
+	ProblemNotEnoughCopies   = "NOT_ENOUGH_COPIES"
+	ProblemDueBeforeCheckout = "DUE_BEFORE_CHECKOUT"
+	ProblemReturnedEarly     = "RETURNED_BEFORE_CHECKOUT"
+	ProblemIndex             = "INDEX"
+)
+
+// Problem represents an inconsistency of the state of a library, see
+// Snapshot.Check.
+type Problem struct {
+	Code    string `json:"code"` // Code of the problem, e.g. DUPLICATE_BOOK.
+	Message string `json:"message"`
+	Fix     string `json:"fix"` // How Repair fixes the problem.
+}
+
+// Check returns the problems of the snapshot that the commands of a library
+// would never produce, e.g. of a state file that was edited by hand or written
+// by a buggy program:
+//
+//   - books, accounts and macros with the same ID or name
+//   - books with a negative number of copies
+//   - checkouts and holds of books or by accounts that do not exist
+//   - books checked out more than once by the same account
+//   - books held more than once for the same account
+//   - books with more copies checked out than the library has
+//   - checkouts due or returned before they were checked out
+//
+// The snapshots with duplicate IDs or holds, or checkouts or holds of books or
+// accounts that do not exist fail to load, see LoadSnapshot, the others are
+// loaded as they are.
+func (s *Snapshot) Check() []Problem {
+	return s.check(false)
+}
+
+// Repair fixes the problems of the snapshot, see Check, and returns the
+// problems it fixed:
+//
+//   - the books, accounts and macros with an ID or name already used are
+//     removed, keeping the first
+//   - the books with a negative number of copies are set to none
+//   - the checkouts of books or by accounts that do not exist, and those of
+//     books the account had already checked out, are removed
+//   - the holds of books or for accounts that do not exist, and those of books
+//     already held for the account, are removed
+//   - the books with more copies checked out than the library has are set to
+//     the copies checked out, since the copies exist
+//   - the checkouts due before they were checked out are due after the loan
+//     period of the policy
+//   - the checkouts returned before they were checked out are returned when
+//     they were checked out
+func (s *Snapshot) Repair() []Problem {
+	return s.check(true)
+}
+
+// check returns the problems of the snapshot, fixing them if repair is set.
+func (s *Snapshot) check(repair bool) []Problem {
+	var problems []Problem
+
+	report := func(code, fix, format string, args ...any) {
+		problems = append(problems, Problem{Code: code, Message: fmt.Sprintf(format, args...), Fix: fix})
+	}
+
+	books := make(map[int]*Book, len(s.Books))
+
+	s.Books = slices.DeleteFunc(s.Books, func(book *Book) bool {
+		if _, ok := books[book.ID]; ok {
+			report(ProblemDuplicateBook, "removed, keeping the first", "book (%d) %q is a duplicate", book.ID, book.Name)
+
+			return repair
+		}
+
+		books[book.ID] = book
+
+		return false
+	})
+
+	accounts := make(map[int]*Account, len(s.Accounts))
+
+	s.Accounts = slices.DeleteFunc(s.Accounts, func(account *Account) bool {
+		if _, ok := accounts[account.ID]; ok {
+			report(ProblemDuplicateAccount, "removed, keeping the first", "account (%d) %q is a duplicate", account.ID, account.Name)
+
+			return repair
+		}
+
+		accounts[account.ID] = account
+
+		return false
+	})
+
+	macros := make(map[string]bool, len(s.Macros))
+
+	s.Macros = slices.DeleteFunc(s.Macros, func(macro *Macro) bool {
+		if macros[macro.Name] {
+			report(ProblemDuplicateMacro, "removed, keeping the first", "macro %s is a duplicate", macro.Name)
+
+			return repair
+		}
+
+		macros[macro.Name] = true
+
+		return false
+	})
+
+	for _, book := range s.Books {
+		if book.Count < 0 {
+			report(ProblemNegativeCopies, "set to 0", "book (%d) has %d copies", book.ID, book.Count)
+
+			if repair {
+				book.Count = 0
+			}
+		}
+	}
+
+	policy := s.Policy
+	if policy == (Policy{}) {
+		policy = DefaultPolicy()
+	}
+
+	// The books checked out by each account and the copies of each book
+	// checked out.
+	type loan struct{ accountID, bookID int }
+
+	loans := make(map[loan]bool)
+	checkedOut := make(map[int]int)
+
+	s.Checkouts = slices.DeleteFunc(s.Checkouts, func(c *Checkout) bool {
+		if _, ok := books[c.BookID]; !ok {
+			report(ProblemMissingBook, "removed", "checkout of book (%d) by account (%d), the book does not exist", c.BookID, c.AccountID)
+
+			return repair
+		}
+
+		if _, ok := accounts[c.AccountID]; !ok {
+			report(ProblemMissingAccount, "removed", "checkout of book (%d) by account (%d), the account does not exist", c.BookID, c.AccountID)
+
+			return repair
+		}
+
+		if c.Due.Before(c.CheckedOut) {
+			report(ProblemDueBeforeCheckout, "due after the loan period", "checkout of book (%d) by account (%d) is due before it was checked out", c.BookID, c.AccountID)
+
+			if repair {
+				c.Due = c.CheckedOut.Add(policy.LoanPeriod)
+			}
+		}
+
+		if !c.Returned.IsZero() {
+			if c.Returned.Before(c.CheckedOut) {
+				report(ProblemReturnedEarly, "returned when checked out", "checkout of book (%d) by account (%d) was returned before it was checked out", c.BookID, c.AccountID)
+
+				if repair {
+					c.Returned = c.CheckedOut
+				}
+			}
+
+			return false
+		}
+
+		if k := (loan{c.AccountID, c.BookID}); loans[k] {
+			report(ProblemDuplicateCheckout, "removed, keeping the first", "book (%d) is checked out more than once by account (%d)", c.BookID, c.AccountID)
+
+			if repair {
+				return true
+			}
+		} else {
+			loans[k] = true
+		}
+
+		checkedOut[c.BookID]++
+
+		return false
+	})
+
+	holds := make(map[loan]bool)
+
+	s.Holds = slices.DeleteFunc(s.Holds, func(h *Hold) bool {
+		if _, ok := books[h.BookID]; !ok {
+			report(ProblemMissingBook, "removed", "hold on book (%d) for account (%d), the book does not exist", h.BookID, h.AccountID)
+
+			return repair
+		}
+
+		if _, ok := accounts[h.AccountID]; !ok {
+			report(ProblemMissingAccount, "removed", "hold on book (%d) for account (%d), the account does not exist", h.BookID, h.AccountID)
+
+			return repair
+		}
+
+		k := loan{h.AccountID, h.BookID}
+
+		if holds[k] {
+			report(ProblemDuplicateHold, "removed, keeping the first", "book (%d) is held more than once for account (%d)", h.BookID, h.AccountID)
+
+			return repair
+		}
+
+		holds[k] = true
+
+		return false
+	})
+
+	for _, book := range s.Books {
+		if n := checkedOut[book.ID]; n > max(book.Count, 0) && books[book.ID] == book {
+			report(ProblemNotEnoughCopies, fmt.Sprintf("set to %d copies", n), "book (%d) has %d copies checked out of %d", book.ID, n, book.Count)
+
+			if repair {
+				book.Count = n
+			}
+		}
+	}
+
+	return problems
+}
+
+// SetSnapshotCheck sets a function LoadSnapshot calls with each snapshot before
+// it is loaded, nil for none, so that the state of a DB can be checked, or
+// repaired, as it is loaded by a Store, see Snapshot.Check. The function may
+// modify the snapshot.
+func (l *Library) SetSnapshotCheck(fn func(s *Snapshot)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.checkSnapshot = fn
+}
+
+// Check returns the problems of the indexes of the checkouts of the library,
+// which must hold the checkouts of the history that are not returned, e.g.
+// after a bug in a mutation. The state itself is checked by Snapshot.Check.
+func (l *Library) Check() []Problem {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.checkIndexes()
+}
+
+// Repair rebuilds the indexes of the checkouts of the library from its history
+// and returns the problems of the indexes it fixed, see Check.
+func (l *Library) Repair() []Problem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	problems := l.checkIndexes()
+	if len(problems) == 0 {
+		return nil
+	}
+
+	l.checkoutsByAccount = make(map[int][]*Checkout)
+	l.checkoutsByBook = make(map[int][]*Checkout)
+
+	// The indexes are rebuilt without changing the version, so the view
+	// of the broken indexes must not be shared, see View.
+	l.view.Store(nil)
+
+	for _, c := range l.history {
+		if c.Returned.IsZero() {
+			l.checkoutsByAccount[c.AccountID] = append(l.checkoutsByAccount[c.AccountID], c)
+			l.checkoutsByBook[c.BookID] = append(l.checkoutsByBook[c.BookID], c)
+		}
+	}
+
+	return problems
+}
+
+// checkIndexes returns the problems of the indexes of the checkouts, see
+// Check.
+func (l *Library) checkIndexes() []Problem {
+	var problems []Problem
+
+	const fix = "rebuilt from the history"
+
+	active := make(map[*Checkout]bool)
+
+	for _, c := range l.history {
+		if c.Returned.IsZero() {
+			active[c] = true
+		}
+	}
+
+	check := func(index map[int][]*Checkout, name string, key func(*Checkout) int) {
+		var indexed int
+
+		for id, checkouts := range index {
+			for _, c := range checkouts {
+				switch {
+				case !active[c]:
+					problems = append(problems, Problem{
+						Code:    ProblemIndex,
+						Message: fmt.Sprintf("checkout of book (%d) by account (%d) is in the index by %s but not checked out", c.BookID, c.AccountID, name),
+						Fix:     fix,
+					})
+				case key(c) != id:
+					problems = append(problems, Problem{
+						Code:    ProblemIndex,
+						Message: fmt.Sprintf("checkout of book (%d) by account (%d) is in the index by %s under (%d)", c.BookID, c.AccountID, name, id),
+						Fix:     fix,
+					})
+				default:
+					indexed++
+				}
+			}
+		}
+
+		if indexed != len(active) {
+			problems = append(problems, Problem{
+				Code:    ProblemIndex,
+				Message: fmt.Sprintf("the index by %s has %d of the %d books checked out", name, indexed, len(active)),
+				Fix:     fix,
+			})
+		}
+	}
+
+	check(l.checkoutsByAccount, "account", func(c *Checkout) int { return c.AccountID })
+	check(l.checkoutsByBook, "book", func(c *Checkout) int { return c.BookID })
+
+	return problems
+}
@@ -0,0 +1,150 @@
+package library
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+var (
+	// ErrCorrupt is returned when imported state does not match its
+	// checksum, e.g. a state file that was truncated or modified.
+	ErrCorrupt = errors.New("state is corrupt")
+)
+
+// checksumHeader is the comment line that starts a checksummed export. The
+// export ends with a trailer of the same comment followed by the hex SHA-256
+// of everything before the trailer, e.g. "# sha256 9f86d0...".
+//
+// Both are comments, so a checksummed export can still be read as a plain
+// command file, and the header is what tells a truncated export, which has
+// lost its trailer, apart from a file that was never checksummed.
+const checksumHeader = "# sha256"
+
+// withChecksum returns a write function that wraps the output of write in a
+// checksum header and trailer.
+func withChecksum(write func(w io.Writer) error) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		h := sha256.New()
+		mw := io.MultiWriter(w, h)
+
+		if _, err := fmt.Fprintln(mw, checksumHeader); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+
+		if err := write(mw); err != nil {
+			return err
+		}
+
+		// The trailer must be on a line of its own, which binary
+		// exports do not necessarily end with, so it is always preceded
+		// by a newline that is not part of the export, see
+		// stripChecksum.
+		if _, err := fmt.Fprintln(mw); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %x\n", checksumHeader, h.Sum(nil)); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+
+		return nil
+	}
+}
+
+// checksum verifies the checksums of the lines of an import as they are read.
+type checksum struct {
+	h hash.Hash // Hash of the lines since the header, nil outside of a checksummed export.
+}
+
+// line records a line of the import and reports whether it is a checksum
+// header or trailer rather than content.
+//
+// If the line is a trailer that does not match the lines before it, an error
+// wrapping ErrCorrupt is returned.
+func (c *checksum) line(line []byte) (bool, error) {
+	trimmed := string(bytes.TrimSpace(line))
+
+	if trimmed == checksumHeader {
+		c.h = sha256.New()
+		c.h.Write(line)
+
+		return true, nil
+	}
+
+	if c.h == nil {
+		return false, nil
+	}
+
+	sum, ok := bytes.CutPrefix([]byte(trimmed), []byte(checksumHeader+" "))
+	if !ok {
+		c.h.Write(line)
+
+		return false, nil
+	}
+
+	expected := hex.EncodeToString(c.h.Sum(nil))
+	c.h = nil
+
+	if string(sum) != expected {
+		return true, fmt.Errorf("%w, checksum %s does not match the contents, expected %s", ErrCorrupt, sum, expected)
+	}
+
+	return true, nil
+}
+
+// done reports an error wrapping ErrCorrupt if the import ended before the
+// trailer of a checksummed export, i.e. the export was truncated.
+func (c *checksum) done() error {
+	if c.h != nil {
+		return fmt.Errorf("%w, missing checksum trailer, the state may be truncated", ErrCorrupt)
+	}
+
+	return nil
+}
+
+// stripChecksum verifies the checksums of the contents of a state file and
+// returns the contents without the checksum headers and trailers, so that a
+// corrupt file is rejected before any of it is loaded.
+//
+// A state file that is empty, or only the start of a checksum header, is
+// corrupt too, since it was truncated before the header that would tell so,
+// and no export is empty.
+func stripChecksum(bs []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(bs); len(trimmed) == 0 || bytes.HasPrefix([]byte(checksumHeader), trimmed) {
+		return nil, fmt.Errorf("%w, the state is empty, it may be truncated", ErrCorrupt)
+	}
+
+	var (
+		c   checksum
+		out bytes.Buffer
+	)
+
+	for _, line := range bytes.SplitAfter(bs, []byte("\n")) {
+		trailer := c.h != nil
+
+		skip, err := c.line(line)
+		if err != nil {
+			return nil, err
+		}
+
+		// The newline before the trailer is not part of the export.
+		if skip && trailer {
+			out.Truncate(max(out.Len()-1, 0))
+		}
+
+		if !skip {
+			out.Write(line)
+		}
+	}
+
+	if err := c.done(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package library
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and waits for time to pass.
+//
+// The Library uses a real clock by default. A SimulatedClock can be set with
+// SetClock so that scripts and tests that wait for time to pass, e.g. for a
+// book to become overdue, run instantly.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep waits for the duration to pass.
+	Sleep(d time.Duration)
+}
+
+// realClock is a Clock backed by the system time.
+type realClock struct{}
+
+// Now implements Clock.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep implements Clock.
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// SimulatedClock is a Clock whose time only passes when Sleep is called,
+// which advances the time immediately rather than waiting.
+type SimulatedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewSimulatedClock returns a SimulatedClock starting at the provided time.
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start}
+}
+
+// Now implements Clock.
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep implements Clock by advancing the time by the duration.
+func (c *SimulatedClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
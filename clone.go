@@ -0,0 +1,108 @@
+package library
+
+import (
+	"maps"
+	"slices"
+)
+
+// Clone returns an independent deep copy of the library, e.g. to simulate
+// commands or compare the outcome of a change without changing the library:
+//
+//	c := l.Clone()
+//	if err := c.CheckoutBook(1, 7); err != nil {
+//		// The checkout would fail.
+//	}
+//
+// The copy has the same books, accounts, checkouts and indexes, history, holds,
+// macros, inventory audit in progress, policy, quota, clock, logger and
+// read-only mode, none of which is shared with the library. It has none of the
+// undo history, changes, watchers, event handler or snapshot check of the
+// library, and is not backed by its write-ahead log, so that changes to the
+// copy are never reported or persisted as changes to the library.
+func (l *Library) Clone() *Library {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.clone()
+}
+
+// clone returns a deep copy of the library, see Clone.
+//
+// clone must be called with the lock held.
+func (l *Library) clone() *Library {
+	c := &Library{
+		books:              make(map[int]*Book, len(l.books)),
+		accounts:           make(map[int]*Account, len(l.accounts)),
+		checkoutsByAccount: make(map[int][]*Checkout, len(l.checkoutsByAccount)),
+		checkoutsByBook:    make(map[int][]*Checkout, len(l.checkoutsByBook)),
+		history:            make([]*Checkout, 0, len(l.history)),
+		holds:              make(map[int][]*Hold, len(l.holds)),
+		clock:              l.clock,
+		policy:             l.policy,
+		quota:              l.quota,
+		version:            l.version,
+		macros:             make(map[string]*Macro, len(l.macros)),
+		macroOrder:         slices.Clone(l.macroOrder),
+		logger:             l.logger,
+		readOnly:           l.readOnly,
+	}
+
+	c.changed = c.version
+	c.changesFrom = c.version
+
+	for id, book := range l.books {
+		c.books[id] = book.clone()
+	}
+
+	for id, account := range l.accounts {
+		c.accounts[id] = account.clone()
+	}
+
+	// The indexes hold the same checkouts as the history, so each checkout
+	// is copied once and the copies are indexed in its place.
+	checkouts := make(map[*Checkout]*Checkout, len(l.history))
+
+	copyCheckout := func(checkout *Checkout) *Checkout {
+		cc, ok := checkouts[checkout]
+		if !ok {
+			cc = checkout.clone()
+			checkouts[checkout] = cc
+		}
+
+		return cc
+	}
+
+	for _, checkout := range l.history {
+		c.history = append(c.history, copyCheckout(checkout))
+	}
+
+	for id, index := range l.checkoutsByAccount {
+		for _, checkout := range index {
+			c.checkoutsByAccount[id] = append(c.checkoutsByAccount[id], copyCheckout(checkout))
+		}
+	}
+
+	for id, index := range l.checkoutsByBook {
+		for _, checkout := range index {
+			c.checkoutsByBook[id] = append(c.checkoutsByBook[id], copyCheckout(checkout))
+		}
+	}
+
+	for id, queue := range l.holds {
+		c.holds[id] = cloneAll(queue)
+	}
+
+	for name, macro := range l.macros {
+		c.macros[name] = macro.clone()
+	}
+
+	if l.inventory != nil {
+		c.inventory = &inventory{
+			started:  l.inventory.started,
+			barcodes: slices.Clone(l.inventory.barcodes),
+			scanned:  maps.Clone(l.inventory.scanned),
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// backupPrefix is the prefix of the names of the backup files, which are
+// followed by the UTC time of the backup so that they sort in the order they
+// were made.
+const backupPrefix = "backup-"
+
+// runBackup implements the backup subcommand:
+//
+//	library [flags] backup [--dir backups] [--keep 7]
+//	library [flags] backup --verify [backup-file...]
+//
+// A backup is a checksummed copy of the DB written to a timestamped file in
+// the backup directory, in the format of --db-format and encrypted with
+// --key-file if set. Only the newest --keep backups are kept.
+//
+// With --verify, no backup is made. Instead the backup files, or every backup
+// in the backup directory if none are named, are loaded into memory to
+// confirm that they are intact.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+
+	dir := fs.String("dir", "backups", "directory to write the backups to")
+	keep := fs.Int("keep", 7, "number of backups to keep, 0 to keep every backup")
+	verify := fs.Bool("verify", false, "load the backups to confirm they are intact rather than making a backup")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	key, err := backupKey()
+	if err != nil {
+		return err
+	}
+
+	if *verify {
+		paths := fs.Args()
+
+		if len(paths) == 0 {
+			if paths, err = listBackups(*dir); err != nil {
+				return err
+			}
+		}
+
+		return verifyBackups(paths, key)
+	}
+
+	l := library.New()
+
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library DB, %w", err)
+	}
+	defer store.Close()
+
+	if err := store.Load(l); err != nil {
+		return fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return storageErrorf("failed to create backup directory, %w", err)
+	}
+
+	path := filepath.Join(*dir, backupPrefix+time.Now().UTC().Format("20060102T150405Z")+".db")
+
+	backup := &library.FileStore{Path: path, Format: library.Format(*dbFormat), Key: key}
+
+	if err := backup.Save(l); err != nil {
+		return storageErrorf("failed to write backup, %w", err)
+	}
+
+	slog.Info("backed up", "db", *dbPath, "backup", path)
+
+	if *keep <= 0 {
+		return nil
+	}
+
+	backups, err := listBackups(*dir)
+	if err != nil {
+		return err
+	}
+
+	for _, old := range backups[:max(len(backups)-*keep, 0)] {
+		if err := os.Remove(old); err != nil {
+			return storageErrorf("failed to remove old backup, %w", err)
+		}
+
+		slog.Info("removed old backup", "backup", old)
+	}
+
+	return nil
+}
+
+// runRestore implements the restore subcommand:
+//
+//	library [flags] restore <backup-file>
+//
+// The backup is loaded, which verifies it, before it replaces the state of
+// the DB, so a corrupt backup never replaces the DB. When run interactively,
+// the restore is confirmed unless --yes is set.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return usageErrorf("restore requires exactly one backup file")
+	}
+
+	path := fs.Arg(0)
+
+	key, err := backupKey()
+	if err != nil {
+		return err
+	}
+
+	// A missing file would load as an empty library.
+	if _, err := os.Stat(path); err != nil {
+		return storageErrorf("failed to open backup, %w", err)
+	}
+
+	l := library.New()
+
+	if err := (&library.FileStore{Path: path, Key: key}).Load(l); err != nil {
+		return storageErrorf("failed to load backup %s, %w", path, err)
+	}
+
+	if !*yes && isTerminal(os.Stdin) {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+
+			if !confirm(tty, os.Stderr)(fmt.Sprintf("replace the library DB %s with backup %s", *dbPath, path)) {
+				return fmt.Errorf("%w, restore of %s", library.ErrNotConfirmed, path)
+			}
+		}
+	}
+
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library DB, %w", err)
+	}
+	defer store.Close()
+
+	// The current state is loaded, and discarded, before it is replaced
+	// since some stores only replace state they loaded, e.g. s3 and wal.
+	if err := store.Load(library.New()); err != nil {
+		return fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	slog.Info("restored", "db", *dbPath, "backup", path)
+
+	return nil
+}
+
+// backupKey returns the key of --key-file, if set, to encrypt and decrypt the
+// backups with.
+func backupKey() ([]byte, error) {
+	if *keyFile == "" {
+		return nil, nil
+	}
+
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return nil, usageError{err}
+	}
+
+	return key, nil
+}
+
+// listBackups returns the paths of the backups in the directory from oldest to
+// newest.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, storageErrorf("failed to list backups, %w", err)
+	}
+
+	var paths []string
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), backupPrefix) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	slices.Sort(paths)
+
+	return paths, nil
+}
+
+// verifyBackups loads each backup into memory and reports whether it is
+// intact, returning an error if any is not.
+func verifyBackups(paths []string, key []byte) error {
+	var failed int
+
+	for _, path := range paths {
+		l := library.New()
+
+		err := error(nil)
+		if _, err = os.Stat(path); err == nil {
+			err = (&library.FileStore{Path: path, Key: key}).Load(l)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "%s is invalid, %v\n", path, err)
+			failed++
+
+			continue
+		}
+
+		var books, accounts int
+
+		l.EachBook(func(*library.Book) { books++ })
+		l.EachAccount(func(*library.Account) { accounts++ })
+
+		fmt.Fprintf(os.Stdout, "%s is ok, %d books, %d accounts\n", path, books, accounts)
+	}
+
+	if failed > 0 {
+		return storageErrorf("%d of %d backups are invalid", failed, len(paths))
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// benchResult is the outcome of the bench subcommand.
+type benchResult struct {
+	Source    string `json:"source"` // State file, or "seed" for a generated workload.
+	Runs      int    `json:"runs"`
+	Commands  int    `json:"commands"` // Commands of the exported state.
+	Bytes     int    `json:"bytes"`    // Size of the exported state.
+	Books     int    `json:"books"`
+	Accounts  int    `json:"accounts"`
+	Checkouts int    `json:"checkouts"`
+
+	// The times are the medians of the runs, in nanoseconds.
+	ExportNs int64 `json:"exportNs"`
+	ImportNs int64 `json:"importNs"`
+	// ImportRate is the number of commands imported per second.
+	ImportRate float64 `json:"importRate"`
+
+	Latencies []benchLatency `json:"latencies"`
+}
+
+// benchLatency is the latency of the executions of a command, in nanoseconds.
+type benchLatency struct {
+	Command string `json:"command"`
+	Count   int    `json:"count"`
+	MeanNs  int64  `json:"meanNs"`
+	P50Ns   int64  `json:"p50Ns"`
+	P99Ns   int64  `json:"p99Ns"`
+	MaxNs   int64  `json:"maxNs"`
+}
+
+// runBench implements the bench subcommand:
+//
+//	library [flags] bench [--runs 3] [--queries 1000] [--books 10000] [--accounts 2000] [--checkouts 5000] [--history 20000] [--seed 1] [state-file]
+//
+// The state file, decrypted with --key-file if set, or else a workload
+// generated as by the seed subcommand, is exported as commands and the export
+// imported into an empty library --runs times, measuring the median time of
+// each and the commands imported per second, followed by --queries random
+// read-only commands, e.g. PRINT_BOOK and SEARCH_BOOKS, against the imported
+// library. The latency of each command, parsed and executed on import or
+// executed by the queries, is written with the times, as a table or, with
+// --output json or yaml, in nanoseconds to track regressions. The DB is
+// neither loaded nor saved.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+
+	runs := fs.Int("runs", 3, "number of times to export and import the state")
+	queries := fs.Int("queries", 1000, "number of read-only commands to execute after the import")
+	books := fs.Int("books", 10000, "number of books of the generated workload")
+	accounts := fs.Int("accounts", 2000, "number of accounts of the generated workload")
+	checkouts := fs.Int("checkouts", 5000, "number of books checked out in the generated workload")
+	history := fs.Int("history", 20000, "number of returned checkouts of the generated workload")
+	seed := fs.Uint64("seed", 1, "seed of the generated workload and the queries")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case fs.NArg() > 1:
+		return usageErrorf("bench takes at most one state file")
+	case *runs < 1:
+		return usageErrorf("bench --runs must be positive")
+	case *queries < 0:
+		return usageErrorf("bench --queries must not be negative")
+	}
+
+	l := library.New()
+	result := &benchResult{Source: "seed", Runs: *runs}
+
+	if fs.NArg() == 1 {
+		result.Source = fs.Arg(0)
+
+		key, err := backupKey()
+		if err != nil {
+			return err
+		}
+
+		// A missing file would load as an empty library.
+		if _, err := os.Stat(fs.Arg(0)); err != nil {
+			return storageErrorf("failed to open %s, %w", fs.Arg(0), err)
+		}
+
+		if err := (&library.FileStore{Path: fs.Arg(0), Key: key}).Load(l); err != nil {
+			return storageErrorf("failed to load %s, %w", fs.Arg(0), err)
+		}
+	} else if err := newSeeder(l, *seed).seed(*books, *accounts, *checkouts, *history); err != nil {
+		return err
+	}
+
+	b := &bench{latencies: make(map[string][]time.Duration)}
+
+	var (
+		exports, imports []time.Duration
+		imported         *library.Library
+	)
+
+	for range *runs {
+		var buf bytes.Buffer
+
+		start := time.Now()
+
+		if err := l.Export(&buf, library.ExportOptions{}); err != nil {
+			return fmt.Errorf("failed to export, %w", err)
+		}
+
+		exports = append(exports, time.Since(start))
+
+		result.Bytes = buf.Len()
+
+		imported = library.New()
+
+		var commands int
+
+		start = time.Now()
+		last := start
+
+		// The latency of a command is the time since the result of the
+		// command before it, which includes reading and parsing it.
+		err := imported.Import(&buf, library.ImportOptions{
+			OnResult: func(r library.Result) {
+				now := time.Now()
+				b.record(r.Command, now.Sub(last))
+				last = now
+				commands++
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to import, %w", err)
+		}
+
+		imports = append(imports, time.Since(start))
+
+		result.Commands = commands
+	}
+
+	if err := b.query(imported, *queries, rand.New(rand.NewPCG(*seed, *seed))); err != nil {
+		return err
+	}
+
+	result.ExportNs = int64(median(exports))
+	result.ImportNs = int64(median(imports))
+	result.ImportRate = float64(result.Commands) / median(imports).Seconds()
+
+	imported.EachBook(func(*library.Book) { result.Books++ })
+	imported.EachAccount(func(*library.Account) { result.Accounts++ })
+	imported.AllCheckouts(func(*library.Checkout) { result.Checkouts++ })
+
+	result.Latencies = b.summarize()
+
+	switch *output {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(result)
+	case "yaml":
+		return encodeYAML(os.Stdout, result)
+	case "text":
+		return writeBench(os.Stdout, result)
+	default:
+		return usageErrorf("unknown --output %q, expected text, json or yaml", *output)
+	}
+}
+
+// bench records the latencies of the commands executed by the bench
+// subcommand.
+type bench struct {
+	latencies map[string][]time.Duration // Latencies by command name.
+}
+
+// record records the latency of an execution of the command.
+func (b *bench) record(command string, d time.Duration) {
+	b.latencies[command] = append(b.latencies[command], d)
+}
+
+// query executes n read-only commands of books and accounts of the library
+// chosen at random, recording their latencies.
+func (b *bench) query(l *library.Library, n int, rnd *rand.Rand) error {
+	var (
+		books    []*library.Book
+		accounts []int
+	)
+
+	l.EachBook(func(book *library.Book) { books = append(books, book) })
+	l.EachAccount(func(account *library.Account) { accounts = append(accounts, account.ID) })
+
+	if n > 0 && (len(books) == 0 || len(accounts) == 0) {
+		return usageErrorf("bench --queries requires a state with books and accounts")
+	}
+
+	for range n {
+		book := books[rnd.IntN(len(books))]
+		accountID := accounts[rnd.IntN(len(accounts))]
+
+		var cmd any
+
+		switch rnd.IntN(5) {
+		case 0:
+			cmd = &library.PrintBook{ID: book.ID}
+		case 1:
+			cmd = &library.PrintAccount{ID: accountID}
+		case 2:
+			// A word of the title of the book, so that the search
+			// matches.
+			query := book.Name
+			if words := strings.Fields(book.Name); len(words) > 0 {
+				query = words[rnd.IntN(len(words))]
+			}
+
+			cmd = &library.SearchBooks{Query: query}
+		case 3:
+			cmd = &library.ListCheckouts{AccountID: &accountID}
+		default:
+			cmd = &library.TopBooks{Limit: 10}
+		}
+
+		inv := &library.Invocation{Command: cmd}
+
+		start := time.Now()
+
+		if err := inv.Exec(l); err != nil {
+			return fmt.Errorf("failed to query, %w", err)
+		}
+
+		b.record(inv.Result.Command, time.Since(start))
+	}
+
+	return nil
+}
+
+// summarize returns the latencies of the commands, by command name.
+func (b *bench) summarize() []benchLatency {
+	latencies := make([]benchLatency, 0, len(b.latencies))
+
+	for command, ds := range b.latencies {
+		slices.Sort(ds)
+
+		var total time.Duration
+		for _, d := range ds {
+			total += d
+		}
+
+		latencies = append(latencies, benchLatency{
+			Command: command,
+			Count:   len(ds),
+			MeanNs:  int64(total) / int64(len(ds)),
+			P50Ns:   int64(percentile(ds, 50)),
+			P99Ns:   int64(percentile(ds, 99)),
+			MaxNs:   int64(ds[len(ds)-1]),
+		})
+	}
+
+	slices.SortFunc(latencies, func(a, b benchLatency) int { return strings.Compare(a.Command, b.Command) })
+
+	return latencies
+}
+
+// percentile returns the pth percentile of the sorted durations, by the
+// nearest rank.
+func percentile(ds []time.Duration, p int) time.Duration {
+	rank := (len(ds)*p + 99) / 100
+
+	return ds[max(rank, 1)-1]
+}
+
+// median returns the median of the durations.
+func median(ds []time.Duration) time.Duration {
+	sorted := slices.Clone(ds)
+	slices.Sort(sorted)
+
+	return percentile(sorted, 50)
+}
+
+// writeBench writes the result of the bench subcommand as text.
+func writeBench(w io.Writer, r *benchResult) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Bench %s\n", r.Source)
+	fmt.Fprintf(&sb, "State: %d books, %d accounts, %d checkouts, %d commands, %d bytes\n", r.Books, r.Accounts, r.Checkouts, r.Commands, r.Bytes)
+	fmt.Fprintf(&sb, "Export: %s (median of %d runs)\n", formatLatency(r.ExportNs), r.Runs)
+	fmt.Fprintf(&sb, "Import: %s, %.0f commands/s (median of %d runs)\n", formatLatency(r.ImportNs), r.ImportRate, r.Runs)
+
+	sb.WriteString("\n## Latency\n")
+
+	tw := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Command\tCount\tMean\tp50\tp99\tMax")
+
+	for _, l := range r.Latencies {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", l.Command, l.Count, formatLatency(l.MeanNs), formatLatency(l.P50Ns), formatLatency(l.P99Ns), formatLatency(l.MaxNs))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	out := sb.String()
+
+	if useColor(os.Stdout) {
+		out = colorize(out, library.Result{Status: library.StatusOK})
+	}
+
+	_, err := io.WriteString(w, out)
+
+	return err
+}
+
+// formatLatency formats a latency in nanoseconds, e.g. 12.3µs.
+func formatLatency(ns int64) string {
+	d := time.Duration(ns)
+
+	switch {
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	case d >= time.Microsecond:
+		return d.Round(100 * time.Nanosecond).String()
+	default:
+		return d.String()
+	}
+}
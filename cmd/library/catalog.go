@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// runCatalog implements the catalog subcommand:
+//
+//	library [flags] catalog [--columns id,name,count,author,isbn,tags] [--out file]
+//
+// The catalog of the DB is written as CSV, see Library.ExportCatalogCSV, to
+// open in a spreadsheet. The available and checkedOut columns add the current
+// availability of the books.
+func runCatalog(args []string) error {
+	fs := flag.NewFlagSet("catalog", flag.ContinueOnError)
+
+	columns := fs.String("columns", strings.Join(library.DefaultCatalogColumns, ","), "comma separated columns to write, any of "+strings.Join(library.CatalogColumns, ","))
+	out := fs.String("out", "-", "path to write the CSV to, - for stdout")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("catalog takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	w := os.Stdout
+
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s, %w", *out, err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if err := l.ExportCatalogCSV(w, strings.Split(*columns, ",")); err != nil {
+		return err
+	}
+
+	if w != os.Stdout {
+		return w.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/admtnnr/library"
+)
+
+// checkpointImport configures the import to record its progress to the
+// checkpoint file at path after every command and, if the file exists because
+// an import failed, to resume from the progress it records.
+//
+// Only the bolt and wal stores persist every command as it is executed, so a
+// checkpoint is only consistent with the DB with those stores.
+func checkpointImport(path string, opts *library.ImportOptions) error {
+	if *storeKind != "bolt" && *storeKind != "wal" {
+		return usageErrorf("--checkpoint requires --store bolt or wal, which persist every command")
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read checkpoint, %w", err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(bs, &opts.Resume); err != nil {
+			return fmt.Errorf("failed to read checkpoint %s, %w", path, err)
+		}
+
+		slog.Info("resuming from checkpoint", "path", path, "line", opts.Resume.Line)
+	}
+
+	opts.Checkpoint = func(p library.Progress) error {
+		bs, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+
+		// The checkpoint is replaced atomically so that a crash while
+		// it is written leaves the previous checkpoint.
+		tmp := path + ".tmp"
+
+		if err := os.WriteFile(tmp, bs, 0644); err != nil {
+			return storageError{err}
+		}
+
+		return asStorageError(os.Rename(tmp, path))
+	}
+
+	return nil
+}
+
+// removeCheckpoint removes the checkpoint file at path, if it exists.
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// The ANSI escape codes of the styles of colorized output, see colorize.
+const (
+	ansiReset = "\033[0m"
+	ansiBold  = "\033[1m"
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+)
+
+// useColor reports whether the output written to f is colorized, which it is
+// if f is a terminal unless --no-color is set or the NO_COLOR environment
+// variable is set to anything, see https://no-color.org.
+func useColor(f *os.File) bool {
+	return !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// errorText returns the message of an error to write to f, in red if the
+// output written to f is colorized.
+func errorText(f *os.File, msg string) string {
+	if !useColor(f) {
+		return msg
+	}
+
+	return paint(ansiRed, msg)
+}
+
+// colorize returns the human readable output of a command styled by its
+// result: red if the command failed, or else its headings, e.g. those of
+// PRINT_CATALOG, in bold, or all of it in green if it has none.
+func colorize(output string, result library.Result) string {
+	if result.Status == library.StatusError {
+		return paint(ansiRed, output)
+	}
+
+	lines := strings.Split(output, "\n")
+	headings := false
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			lines[i] = paint(ansiBold, line)
+			headings = true
+		}
+	}
+
+	if !headings {
+		return paint(ansiGreen, output)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// paint returns s in the style of the ANSI escape code, styling each line on
+// its own so that the lines are still styled when they are paged or filtered.
+func paint(style, s string) string {
+	lines := strings.Split(s, "\n")
+
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = style + line + ansiReset
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
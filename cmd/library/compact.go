@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/admtnnr/library"
+)
+
+// runCompact implements the compact subcommand:
+//
+//	library [flags] compact
+//
+// The DB is loaded and rewritten from the resulting state, so a command log
+// that has grown by appending commands, e.g. a commands file used as the DB,
+// is replaced by the minimal commands that restore the same state: copies
+// added by ADD_COPIES are folded into the ADD_BOOK of the book and a
+// CHECKOUT_BOOK followed by its RETURN_BOOK becomes a single ADD_HISTORY. The
+// write-ahead log of a wal DB is folded into its snapshot.
+func runCompact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("compact takes no arguments")
+	}
+
+	before := fileSize(*dbPath, *dbPath+".wal")
+
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library DB, %w", err)
+	}
+	defer store.Close()
+
+	l := library.New()
+
+	if err := store.Load(l); err != nil {
+		return fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	after := fileSize(*dbPath, *dbPath+".wal")
+
+	if before == 0 {
+		slog.Info("compacted", "db", *dbPath)
+	} else {
+		slog.Info("compacted", "db", *dbPath, "before", before, "after", after)
+	}
+
+	return nil
+}
+
+// fileSize returns the total size of the files that exist at the paths, 0 if
+// none do, e.g. for a DB that is not a local file.
+func fileSize(paths ...string) int64 {
+	var size int64
+
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
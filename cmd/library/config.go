@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/admtnnr/library"
+)
+
+// config is the config file of the defaults of the flags, see loadConfig.
+type config struct {
+	// path is the path of the config file.
+	path string
+	// flags are the values of the flags, the keys at the top level.
+	flags map[string]string
+	// subcommands are the values of the flags of the subcommands by the
+	// name of the subcommand, the keys of the table named like it.
+	subcommands map[string]map[string]string
+	// policy is the policy of the policy table, nil if there is none.
+	policy *configPolicy
+}
+
+// configPolicy is the policy table of the config file, see
+// library.Policy, of which the keys that are not set keep the default.
+type configPolicy struct {
+	CheckoutLimit int           // checkout-limit, 0 if not set.
+	LoanPeriod    time.Duration // loan-period, 0 if not set.
+	FineRate      *int          // fine-rate, nil if not set.
+	HoldWindow    time.Duration // hold-window, 0 if not set.
+}
+
+// cfg is the config file loaded by loadConfig, empty if there is none.
+var cfg config
+
+// defaultConfigPath returns the path of the config file read without
+// --config, $XDG_CONFIG_HOME/library/config.toml, or
+// ~/.config/library/config.toml if XDG_CONFIG_HOME is not set.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "library", "config.toml")
+}
+
+// loadConfig loads the config file of --config, or else the default config
+// file if it exists, into cfg and sets the flags not in set, those set on the
+// command line, to its values, so that the flags override the config file,
+// e.g.:
+//
+//	db = "/var/lib/library/state.db"
+//	store = "wal"
+//
+//	[policy]
+//	checkout-limit = 6
+//	loan-period = "504h"
+//	fine-rate = 10
+//	hold-window = "168h"
+//
+//	[serve]
+//	addr = ":8080"
+//	cors-origins = ["https://catalog.example.com"]
+//
+// The keys at the top level are the flags, and the tables are the flags of
+// the subcommand of the same name, which are set by parseFlags, except for
+// the policy table, see configPolicy. Lists are the comma separated values of
+// a flag.
+func loadConfig(set map[string]bool) error {
+	path := *configPath
+	if path == "" {
+		path = defaultConfigPath()
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	var raw map[string]any
+
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		// The default config file is optional, unlike the one of
+		// --config.
+		if *configPath == "" && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read config file, %w", err)
+	}
+
+	c := config{path: path, subcommands: make(map[string]map[string]string)}
+
+	var err error
+
+	if c.flags, err = configFlags(raw); err != nil {
+		return fmt.Errorf("invalid config file %s, %w", path, err)
+	}
+
+	for name, v := range raw {
+		table, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if name == "policy" {
+			if c.policy, err = decodeConfigPolicy(table); err != nil {
+				return fmt.Errorf("invalid policy in config file %s, %w", path, err)
+			}
+
+			continue
+		}
+
+		if _, ok := subcommands[name]; !ok {
+			return fmt.Errorf("invalid config file %s, unknown subcommand %q", path, name)
+		}
+
+		if c.subcommands[name], err = configFlags(table); err != nil {
+			return fmt.Errorf("invalid config file %s, %s, %w", path, name, err)
+		}
+	}
+
+	if _, ok := c.flags["config"]; ok {
+		return fmt.Errorf("invalid config file %s, config cannot be set in the config file", path)
+	}
+
+	if err := setFlags(flag.CommandLine, c.flags, set); err != nil {
+		return fmt.Errorf("invalid config file %s, %w", path, err)
+	}
+
+	cfg = c
+
+	return nil
+}
+
+// parseFlags parses the flags of a subcommand from its arguments, which may
+// include the global flags, e.g. library run --db other.db commands.ndjson,
+// then loads the config file, see loadConfig, sets up the logging, see
+// setupLogging, and sets the flags that were not set on the command line to the
+// values of the table of the subcommand in the config file. Every subcommand
+// calls it first.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	// The global flags share their values with those of the flag set, but
+	// are listed with their defaults rather than their values so far.
+	flag.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	// The flags set on the command line, before or after the name of the
+	// subcommand, are those the config file does not override.
+	set := setFlagNames(flag.CommandLine)
+
+	for name := range setFlagNames(fs) {
+		set[name] = true
+	}
+
+	if err := loadConfig(set); err != nil {
+		return usageError{err}
+	}
+
+	if err := setupLogging(); err != nil {
+		return err
+	}
+
+	if err := checkReadOnly(fs.Name()); err != nil {
+		return err
+	}
+
+	if err := setFlags(fs, cfg.subcommands[fs.Name()], set); err != nil {
+		return usageErrorf("invalid config file %s, %s, %w", cfg.path, fs.Name(), err)
+	}
+
+	return nil
+}
+
+// setFlagNames returns the names of the flags of the flag set that were set.
+func setFlagNames(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	return set
+}
+
+// setFlags sets the flags of the flag set that are not in set, those set on
+// the command line, to the values.
+func setFlags(fs *flag.FlagSet, values map[string]string, set map[string]bool) error {
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value of %s, %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// configFlags returns the values of the flags of a table of the config file,
+// ignoring its tables.
+func configFlags(table map[string]any) (map[string]string, error) {
+	flags := make(map[string]string)
+
+	for name, v := range table {
+		if _, ok := v.(map[string]any); ok {
+			continue
+		}
+
+		value, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s, %w", name, err)
+		}
+
+		flags[name] = value
+	}
+
+	return flags, nil
+}
+
+// configValue returns the value of a flag of the config file as it is given
+// on the command line.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		values := make([]string, 0, len(v))
+
+		for _, item := range v {
+			value, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			values = append(values, value)
+		}
+
+		return strings.Join(values, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// decodeConfigPolicy decodes the policy table of the config file.
+func decodeConfigPolicy(table map[string]any) (*configPolicy, error) {
+	var p configPolicy
+
+	for name, v := range table {
+		var ok bool
+
+		switch name {
+		case "checkout-limit":
+			var n int64
+
+			n, ok = v.(int64)
+			p.CheckoutLimit = int(n)
+		case "loan-period":
+			var s string
+
+			if s, ok = v.(string); ok {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid loan-period, %w", err)
+				}
+
+				p.LoanPeriod = d
+			}
+		case "fine-rate":
+			var n int64
+
+			n, ok = v.(int64)
+			rate := int(n)
+			p.FineRate = &rate
+		case "hold-window":
+			var s string
+
+			if s, ok = v.(string); ok {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hold-window, %w", err)
+				}
+
+				p.HoldWindow = d
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", name)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("invalid value of %s, %v", name, v)
+		}
+	}
+
+	return &p, nil
+}
+
+// applyConfigPolicy sets the policy of the library to the policy of the config
+// file, if any, if the library has the default policy, e.g. a new DB, so that
+// a policy set by SET_POLICY is kept.
+func applyConfigPolicy(l *library.Library) error {
+	if cfg.policy == nil || l.Policy() != library.DefaultPolicy() {
+		return nil
+	}
+
+	policy := library.DefaultPolicy()
+
+	if cfg.policy.CheckoutLimit != 0 {
+		policy.CheckoutLimit = cfg.policy.CheckoutLimit
+	}
+
+	if cfg.policy.LoanPeriod != 0 {
+		policy.LoanPeriod = cfg.policy.LoanPeriod
+	}
+
+	if cfg.policy.FineRate != nil {
+		policy.FineRate = *cfg.policy.FineRate
+	}
+
+	if cfg.policy.HoldWindow != 0 {
+		policy.HoldWindow = cfg.policy.HoldWindow
+	}
+
+	if err := l.SetPolicy(policy); err != nil {
+		return fmt.Errorf("invalid policy in config file %s, %w", cfg.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/boltstore"
+)
+
+// convertFormats are the formats of the convert subcommand: the formats of the
+// file DB, see --db-format, and the bolt and wal DBs.
+var convertFormats = []string{"commands", "snapshot", "gob", "proto", "bolt", "wal"}
+
+// convertAliases are the other names of convertFormats.
+var convertAliases = map[string]string{
+	"json":        "snapshot",
+	"command-log": "commands",
+	"ndjson":      "commands",
+}
+
+// runConvert implements the convert subcommand:
+//
+//	library [flags] convert [--from commands] --to snapshot|gob|proto|bolt|wal <in> <out>
+//
+// The state file <in>, in the format of --from, is loaded and written to <out>
+// in the format of --to, e.g. library convert --from json --to bolt old.db
+// new.db, to migrate a DB to another backend. The formats of the file DB are
+// detected when loaded, so --from only needs to be set for a bolt or wal DB.
+// The file formats are decrypted and encrypted with --key-file, if set. <out>
+// must not exist, so a DB is never overwritten, and the DB of --db is neither
+// loaded nor saved.
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+
+	from := fs.String("from", "commands", "format of the input, commands, snapshot, gob, proto, bolt or wal")
+	to := fs.String("to", "", "format of the output, commands, snapshot, gob, proto, bolt or wal")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return usageErrorf("convert requires an input and an output state file")
+	}
+
+	if *to == "" {
+		return usageErrorf("convert requires --to")
+	}
+
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	if filepath.Clean(in) == filepath.Clean(out) {
+		return usageErrorf("convert cannot write %s to itself", in)
+	}
+
+	// A missing file would load as an empty library, and a bolt or wal DB
+	// would be created.
+	if _, err := os.Stat(in); err != nil {
+		return storageErrorf("failed to open %s, %w", in, err)
+	}
+
+	if _, err := os.Stat(out); err == nil {
+		return usageErrorf("convert will not overwrite %s, which already exists", out)
+	}
+
+	src, err := openConvertStore(*from, in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	l := library.New()
+
+	if err := src.Load(l); err != nil {
+		return storageErrorf("failed to load %s, %w", in, err)
+	}
+
+	dst, err := openConvertStore(*to, out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := dst.Save(l); err != nil {
+		return storageErrorf("failed to save %s, %w", out, err)
+	}
+
+	slog.Info("converted", "from", in, "to", out, "format", *to)
+
+	return nil
+}
+
+// openConvertStore returns the store of the state file at path in the format,
+// one of convertFormats or convertAliases.
+func openConvertStore(format, path string) (library.Store, error) {
+	if alias, ok := convertAliases[format]; ok {
+		format = alias
+	}
+
+	switch {
+	case format == "sqlite":
+		return nil, usageErrorf("there is no sqlite store, expected commands, snapshot, gob, proto, bolt or wal")
+	case !slices.Contains(convertFormats, format):
+		return nil, usageErrorf("unknown format %q, expected commands, snapshot, gob, proto, bolt or wal", format)
+	}
+
+	key, err := backupKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil && (format == "bolt" || format == "wal") {
+		return nil, usageErrorf("--key-file is only supported with the file formats")
+	}
+
+	var store library.Store
+
+	switch format {
+	case "bolt":
+		store, err = boltstore.Open(path)
+	case "wal":
+		store, err = library.OpenWALStore(path)
+	default:
+		return &library.FileStore{Path: path, Format: library.Format(format), Key: key}, nil
+	}
+
+	if err != nil {
+		return nil, storageErrorf("failed to open %s, %w", path, err)
+	}
+
+	return store, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsHandler returns a handler that allows browsers of the origins to make
+// cross-origin requests to h, e.g. a web app served from another domain, by
+// responding to their preflight requests and setting the
+// Access-Control-Allow-Origin header of their responses. An origin of "*"
+// allows every origin. Requests of the other origins are served without the
+// headers, so browsers do not let their pages read the responses, and their
+// preflight requests are rejected with 403 Forbidden.
+//
+// The requests are authenticated by the bearer tokens of API keys rather than
+// cookies, so credentials are not allowed.
+func corsHandler(origins []string, h http.Handler) http.Handler {
+	any := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		allowed := any || slices.Contains(origins, origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !allowed {
+			if preflight {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+
+				return
+			}
+
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		if any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-Library-Tenant")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// splitList returns the comma-separated items of a flag, without spaces and
+// empty items.
+func splitList(s string) []string {
+	var items []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// writeStateDiff writes the difference, a line for each book, account,
+// checkout and hold added (+), changed (~) and removed (-), followed by a
+// summary. The
+// returns are written as added, since returning a book is what usually
+// changes a checkout.
+func writeStateDiff(w io.Writer, d *library.StateDiff) {
+	for _, book := range d.Books.Added {
+		fmt.Fprintf(w, "+ book %s (%d) with %d copies\n", book.Name, book.ID, book.Count)
+	}
+
+	for _, c := range d.Books.Changed {
+		fmt.Fprintf(w, "~ book %s (%d)%s\n", c.After.Name, c.After.ID, bookChanges(c.Before, c.After))
+	}
+
+	for _, book := range d.Books.Removed {
+		fmt.Fprintf(w, "- book %s (%d)\n", book.Name, book.ID)
+	}
+
+	for _, account := range d.Accounts.Added {
+		fmt.Fprintf(w, "+ account %s (%d)\n", account.Name, account.ID)
+	}
+
+	for _, c := range d.Accounts.Changed {
+		fmt.Fprintf(w, "~ account %s (%d)%s\n", c.After.Name, c.After.ID, accountChanges(c.Before, c.After))
+	}
+
+	for _, account := range d.Accounts.Removed {
+		fmt.Fprintf(w, "- account %s (%d)\n", account.Name, account.ID)
+	}
+
+	var changed int
+
+	for _, checkout := range d.Checkouts.Added {
+		fmt.Fprintf(w, "+ checkout of book (%d) by account (%d)\n", checkout.BookID, checkout.AccountID)
+	}
+
+	for _, c := range d.Checkouts.Changed {
+		if c.Before.Returned.IsZero() && !c.After.Returned.IsZero() {
+			fmt.Fprintf(w, "+ return of book (%d) by account (%d)\n", c.After.BookID, c.After.AccountID)
+
+			continue
+		}
+
+		changed++
+		fmt.Fprintf(w, "~ checkout of book (%d) by account (%d)%s\n", c.After.BookID, c.After.AccountID, checkoutChanges(c.Before, c.After))
+	}
+
+	for _, checkout := range d.Checkouts.Removed {
+		fmt.Fprintf(w, "- checkout of book (%d) by account (%d)\n", checkout.BookID, checkout.AccountID)
+	}
+
+	for _, hold := range d.Holds.Added {
+		fmt.Fprintf(w, "+ hold on book (%d) for account (%d)\n", hold.BookID, hold.AccountID)
+	}
+
+	for _, c := range d.Holds.Changed {
+		fmt.Fprintf(w, "~ hold on book (%d) for account (%d), placed %s -> %s\n", c.After.BookID, c.After.AccountID, c.Before.Placed.Format(time.RFC3339), c.After.Placed.Format(time.RFC3339))
+	}
+
+	for _, hold := range d.Holds.Removed {
+		fmt.Fprintf(w, "- hold on book (%d) for account (%d)\n", hold.BookID, hold.AccountID)
+	}
+
+	if d.Empty() {
+		fmt.Fprintln(w, "No changes")
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Books: %d added, %d changed, %d removed\n", len(d.Books.Added), len(d.Books.Changed), len(d.Books.Removed))
+	fmt.Fprintf(w, "Accounts: %d added, %d changed, %d removed\n", len(d.Accounts.Added), len(d.Accounts.Changed), len(d.Accounts.Removed))
+	fmt.Fprintf(w, "Checkouts: %d added, %d returned, %d changed, %d removed\n", len(d.Checkouts.Added), len(d.Returned()), changed, len(d.Checkouts.Removed))
+	fmt.Fprintf(w, "Holds: %d added, %d changed, %d removed\n", len(d.Holds.Added), len(d.Holds.Changed), len(d.Holds.Removed))
+}
+
+// bookChanges describes the changes from one version of a book to another,
+// e.g. ", count 3 -> 2".
+func bookChanges(old, book *library.Book) string {
+	var s string
+
+	if old.Name != book.Name {
+		s += fmt.Sprintf(", name %q -> %q", old.Name, book.Name)
+	}
+
+	if old.Count != book.Count {
+		s += fmt.Sprintf(", count %d -> %d", old.Count, book.Count)
+	}
+
+	if old.Author != book.Author {
+		s += fmt.Sprintf(", author %q -> %q", old.Author, book.Author)
+	}
+
+	if old.ISBN != book.ISBN {
+		s += fmt.Sprintf(", isbn %q -> %q", old.ISBN, book.ISBN)
+	}
+
+	if !slices.Equal(old.Tags, book.Tags) {
+		s += fmt.Sprintf(", tags %q -> %q", old.Tags, book.Tags)
+	}
+
+	return s
+}
+
+// accountChanges describes the changes from one version of an account to
+// another, e.g. ", balance $0.00 -> -$0.50".
+func accountChanges(old, account *library.Account) string {
+	var s string
+
+	if old.Name != account.Name {
+		s += fmt.Sprintf(", name %q -> %q", old.Name, account.Name)
+	}
+
+	if old.Balance != account.Balance {
+		s += fmt.Sprintf(", balance %s -> %s", formatCents(old.Balance), formatCents(account.Balance))
+	}
+
+	return s
+}
+
+// checkoutChanges describes the changes from one version of a checkout to
+// another, e.g. ", due 2024-01-15 -> 2024-01-29".
+func checkoutChanges(old, checkout *library.Checkout) string {
+	var s string
+
+	if !old.Due.Equal(checkout.Due) {
+		s += fmt.Sprintf(", due %s -> %s", formatDate(old.Due), formatDate(checkout.Due))
+	}
+
+	if !old.Returned.Equal(checkout.Returned) {
+		s += fmt.Sprintf(", returned %s -> %s", formatDate(old.Returned), formatDate(checkout.Returned))
+	}
+
+	return s
+}
+
+// formatDate formats a time as a date, or "never" if it is zero.
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Format(time.DateOnly)
+}
+
+// formatCents formats an amount in cents as dollars, e.g. -$0.50.
+func formatCents(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// runDiff implements the diff subcommand:
+//
+//	library [flags] diff [--exit-code] <state-file> <state-file>
+//
+// Both state files, e.g. a DB and its backup, or a DB before and after a
+// migration, are loaded, decrypted with --key-file if set, and the books,
+// accounts and checkouts added, changed and removed from the first to the
+// second, see library.Diff, are written in the format of --output. With
+// --exit-code, it fails if the states differ, like diff(1).
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+
+	exitCode := fs.Bool("exit-code", false, "fail if the states differ")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return usageErrorf("diff takes two state files")
+	}
+
+	key, err := backupKey()
+	if err != nil {
+		return err
+	}
+
+	var states [2]*library.Snapshot
+
+	for i, path := range fs.Args() {
+		// A missing file would load as an empty library.
+		if _, err := os.Stat(path); err != nil {
+			return storageErrorf("failed to open %s, %w", path, err)
+		}
+
+		l := library.New()
+
+		if err := (&library.FileStore{Path: path, Key: key}).Load(l); err != nil {
+			return storageErrorf("failed to load %s, %w", path, err)
+		}
+
+		states[i] = l.Snapshot()
+	}
+
+	d := library.Diff(states[0], states[1])
+
+	switch *output {
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(d)
+	case "yaml":
+		err = encodeYAML(os.Stdout, d)
+	default:
+		fmt.Fprintf(os.Stdout, "# Diff %s %s\n\n", fs.Arg(0), fs.Arg(1))
+		writeStateDiff(os.Stdout, d)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if *exitCode && !d.Empty() {
+		return errors.New("the states differ")
+	}
+
+	return nil
+}
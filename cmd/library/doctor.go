@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/admtnnr/library"
+)
+
+// doctorReport is the outcome of the doctor subcommand.
+type doctorReport struct {
+	DB       string            `json:"db"`
+	Problems []library.Problem `json:"problems"`
+	// Repaired reports whether the problems were fixed and the DB saved,
+	// see --repair.
+	Repaired bool `json:"repaired"`
+}
+
+// runDoctor implements the doctor subcommand:
+//
+//	library [flags] doctor [--repair]
+//
+// The DB is loaded and its state checked for the problems the commands of the
+// library would never produce, e.g. checkouts of books that do not exist or
+// books with more copies checked out than they have, see
+// library.Snapshot.Check, as well as the consistency of the indexes of the
+// checkouts, see library.Library.Check. The snapshot is checked before it is
+// loaded, so that the problems that prevent the DB from loading, e.g. two
+// books with the same ID, are reported too. The problems are written in the
+// format of --output, with how they are fixed, and the subcommand fails if
+// there are any. With --repair, the problems are fixed and the DB is saved.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+
+	repair := fs.Bool("repair", false, "fix the problems that can be fixed and save the DB")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("doctor takes no arguments")
+	}
+
+	if *repair && *readOnly {
+		return usageErrorf("--readonly cannot be used with doctor --repair")
+	}
+
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library DB, %w", err)
+	}
+	defer store.Close()
+
+	report := &doctorReport{DB: *dbPath, Problems: []library.Problem{}}
+
+	var checked bool
+
+	check := func(s *library.Snapshot) {
+		checked = true
+
+		if *repair {
+			report.Problems = append(report.Problems, s.Repair()...)
+		} else {
+			report.Problems = append(report.Problems, s.Check()...)
+		}
+	}
+
+	l := library.New()
+	l.SetSnapshotCheck(check)
+
+	if err := store.Load(l); err != nil {
+		// The problems that prevented the DB from loading are reported
+		// before the error.
+		if len(report.Problems) > 0 {
+			if err := encodeDoctor(report); err != nil {
+				return err
+			}
+		}
+
+		return fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	l.SetSnapshotCheck(nil)
+
+	// The DBs that are loaded by executing commands, e.g. a command log,
+	// are not loaded from a snapshot, so their state is checked once it is
+	// loaded.
+	if !checked {
+		s := l.Snapshot()
+
+		check(s)
+
+		if *repair && len(report.Problems) > 0 {
+			if err := l.LoadSnapshot(s); err != nil {
+				return fmt.Errorf("failed to repair library DB, %w", err)
+			}
+		}
+	}
+
+	if *repair {
+		report.Problems = append(report.Problems, l.Repair()...)
+	} else {
+		report.Problems = append(report.Problems, l.Check()...)
+	}
+
+	if *repair && len(report.Problems) > 0 {
+		if err := store.Save(l); err != nil {
+			return fmt.Errorf("failed to save library state to DB, %w", err)
+		}
+
+		report.Repaired = true
+	}
+
+	if err := encodeDoctor(report); err != nil {
+		return err
+	}
+
+	if len(report.Problems) > 0 && !report.Repaired {
+		return fmt.Errorf("found problems in %s", *dbPath)
+	}
+
+	return nil
+}
+
+// encodeDoctor writes the report of the doctor subcommand to stdout in the
+// format of --output.
+func encodeDoctor(r *doctorReport) error {
+	switch *output {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(r)
+	case "yaml":
+		return encodeYAML(os.Stdout, r)
+	case "text":
+		return writeDoctor(os.Stdout, r)
+	default:
+		return usageErrorf("unknown --output %q, expected text, json or yaml", *output)
+	}
+}
+
+// writeDoctor writes the report of the doctor subcommand as text.
+func writeDoctor(w io.Writer, r *doctorReport) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Doctor %s\n\n", r.DB)
+
+	if len(r.Problems) == 0 {
+		sb.WriteString("No problems\n")
+	} else {
+		tw := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "Problem\tDetails\tRepair")
+
+		for _, p := range r.Problems {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Code, p.Message, p.Fix)
+		}
+
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if r.Repaired {
+			fmt.Fprintf(&sb, "\n%d problems, repaired\n", len(r.Problems))
+		} else {
+			fmt.Fprintf(&sb, "\n%d problems, run doctor --repair to repair them\n", len(r.Problems))
+		}
+	}
+
+	out := sb.String()
+
+	if useColor(os.Stdout) {
+		out = colorize(out, library.Result{Status: library.StatusOK})
+	}
+
+	_, err := io.WriteString(w, out)
+
+	return err
+}
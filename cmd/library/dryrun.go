@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/admtnnr/library"
+)
+
+// writeDryRunDiff writes what the commands of a dry run would change, the
+// books and accounts added, changed and removed and the checkouts and returns,
+// from the state before to the state after, followed by a summary.
+func writeDryRunDiff(w io.Writer, before, after *library.Snapshot) {
+	fmt.Fprintln(w, "# Dry Run")
+	fmt.Fprintln(w)
+
+	writeStateDiff(w, library.Diff(before, after))
+
+	fmt.Fprintln(w, "The DB was not changed.")
+}
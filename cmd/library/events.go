@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/admtnnr/library"
+	"golang.org/x/net/websocket"
+)
+
+// eventsHandler returns the handler of the /events WebSocket, which pushes
+// the events of the changes of the library as JSON messages, see
+// library.Event, as they happen.
+//
+// By default only the events of changes after the connection is opened are
+// pushed. With the since query parameter, the events of the changes after the
+// sequence number are pushed first, so that a client that is disconnected
+// reconnects from the sequence number of the last event it received. The
+// connection is closed if the client falls too far behind.
+func eventsHandler(l *library.Library) http.Handler {
+	// The origin is not checked, as the events are read-only and
+	// dashboards may be served from another origin.
+	return websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		since := l.Sequence()
+
+		if s := ws.Request().URL.Query().Get("since"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				websocket.JSON.Send(ws, map[string]string{"error": fmt.Sprintf("invalid since %q", s)})
+
+				return
+			}
+
+			since = n
+		}
+
+		changes, stop, err := l.Watch(since)
+		if err != nil {
+			websocket.JSON.Send(ws, map[string]string{"error": err.Error()})
+
+			return
+		}
+		defer stop()
+
+		// Messages from the client are discarded, reading them only to
+		// notice when the connection is closed.
+		ctx, cancel := context.WithCancel(ws.Request().Context())
+		defer cancel()
+
+		go func() {
+			io.Copy(io.Discard, ws)
+			cancel()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c, ok := <-changes:
+				if !ok {
+					return
+				}
+
+				for _, event := range library.EventsOf(c) {
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}}
+}
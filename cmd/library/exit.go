@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/admtnnr/library"
+)
+
+// The exit codes of the failures, so that scripts can branch on why the
+// library failed, see exitCode.
+const (
+	// exitFailure is the exit code of any other failure, e.g. of a
+	// server.
+	exitFailure = 1
+	// exitUsage is the exit code of invalid flags or arguments, as for
+	// the flags the flag package fails to parse.
+	exitUsage = 2
+	// exitParse is the exit code of a command that cannot be parsed.
+	exitParse = 3
+	// exitCommand is the exit code of a command that violates the rules
+	// of the library, e.g. checking out a book to an account that does
+	// not exist.
+	exitCommand = 4
+	// exitStorage is the exit code of a failure to load or save the DB.
+	exitStorage = 5
+)
+
+// usageError is an error of the flags or arguments, see exitUsage.
+type usageError struct{ error }
+
+// Unwrap returns the error.
+func (e usageError) Unwrap() error { return e.error }
+
+// usageErrorf returns a usageError formatted like fmt.Errorf.
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// storageError is an error of the storage of the DB, see exitStorage.
+type storageError struct{ error }
+
+// Unwrap returns the error.
+func (e storageError) Unwrap() error { return e.error }
+
+// storageErrorf returns a storageError formatted like fmt.Errorf.
+func storageErrorf(format string, args ...any) error {
+	return storageError{fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code of the error: the storage and usage errors
+// of the CLI, and the errors of a commands file by whether the failed line
+// could not be parsed as a command or the command failed.
+func exitCode(err error) int {
+	var (
+		storage storageError
+		usage   usageError
+		ie      *library.ImportError
+	)
+
+	switch {
+	case errors.As(err, &storage):
+		return exitStorage
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, library.ErrInvalidCommand), errors.Is(err, library.ErrCorrupt):
+		return exitParse
+	case errors.As(err, &ie):
+		// The lines that fail before they are parsed as a command,
+		// e.g. with an invalid version comment, have no command.
+		if ie.Command == "" {
+			return exitParse
+		}
+
+		return exitCommand
+	default:
+		return exitFailure
+	}
+}
+
+// storageStore is a Store whose errors are storage errors, see exitStorage.
+type storageStore struct {
+	library.Store
+}
+
+// Load implements library.Store.
+func (s *storageStore) Load(l *library.Library) error {
+	return asStorageError(s.Store.Load(l))
+}
+
+// Save implements library.Store.
+func (s *storageStore) Save(l *library.Library) error {
+	return asStorageError(s.Store.Save(l))
+}
+
+// LoadContext implements library.ContextStore, see library.LoadStore.
+func (s *storageStore) LoadContext(ctx context.Context, l *library.Library) error {
+	return asStorageError(library.LoadStore(ctx, s.Store, l))
+}
+
+// SaveContext implements library.ContextStore, see library.SaveStore.
+func (s *storageStore) SaveContext(ctx context.Context, l *library.Library) error {
+	return asStorageError(library.SaveStore(ctx, s.Store, l))
+}
+
+// Append implements library.Store.
+func (s *storageStore) Append(l *library.Library, inv *library.Invocation) error {
+	return asStorageError(s.Store.Append(l, inv))
+}
+
+// Ping implements library.Pinger by pinging the underlying store, see
+// library.PingStore.
+func (s *storageStore) Ping(ctx context.Context) error {
+	return library.PingStore(ctx, s.Store)
+}
+
+// asStorageError returns the error as a storageError, unless it is nil or
+// already a usageError, e.g. of an invalid DB path.
+func asStorageError(err error) error {
+	var usage usageError
+
+	if err == nil || errors.As(err, &usage) {
+		return err
+	}
+
+	return storageError{err}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/admtnnr/library"
+)
+
+// runExport implements the export subcommand:
+//
+//	library [flags] export [--format commands] [--books 1,2 | --only-books] [--accounts 3 | --only-accounts] [--out file]
+//
+// The state of the DB is written in the format, commands, snapshot, gob or
+// proto, see Library.Export, e.g. to move it to another store or to share the
+// catalog with another branch. The books and accounts may be filtered, see
+// library.ExportOptions.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+
+	format := fs.String("format", "commands", "format to write, commands, snapshot, gob or proto")
+	books := fs.String("books", "", "comma separated IDs of the books to export, all if empty")
+	accounts := fs.String("accounts", "", "comma separated IDs of the accounts to export, all if empty")
+	onlyBooks := fs.Bool("only-books", false, "export the books without any accounts")
+	onlyAccounts := fs.Bool("only-accounts", false, "export the accounts without any books")
+	out := fs.String("out", "-", "path to write the state to, - for stdout")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("export takes no arguments")
+	}
+
+	opts := library.ExportOptions{
+		Format:       library.Format(*format),
+		OnlyBooks:    *onlyBooks,
+		OnlyAccounts: *onlyAccounts,
+	}
+
+	var err error
+
+	if opts.BookIDs, err = parseIDs(*books); err != nil {
+		return usageErrorf("invalid --books, %w", err)
+	}
+
+	if opts.AccountIDs, err = parseIDs(*accounts); err != nil {
+		return usageErrorf("invalid --accounts, %w", err)
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *out != "-" {
+		return l.ExportFile(*out, opts)
+	}
+
+	return l.Export(os.Stdout, opts)
+}
+
+// parseIDs parses comma separated IDs.
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+
+	for _, item := range splitList(s) {
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", item)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
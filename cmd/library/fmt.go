@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// runFmt implements the fmt subcommand:
+//
+//	library [flags] fmt [-w] [-l] [commands-file...]
+//
+// Like gofmt, the commands files, or stdin if there are none, are written to
+// stdout in the canonical format, see formatCommands, or with -w rewritten in
+// place. With -l, the files whose format differs are listed instead. The
+// commands are not executed and the DB is neither loaded nor saved.
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+
+	write := fs.Bool("w", false, "rewrite the commands files in place rather than writing them to stdout")
+	list := fs.Bool("l", false, "list the commands files whose format differs from the canonical format")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		if *write || *list {
+			return usageErrorf("fmt -w and -l take one or more commands files")
+		}
+
+		return formatCommands(os.Stdout, os.Stdin)
+	}
+
+	for _, path := range fs.Args() {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s, %w", path, err)
+		}
+
+		var buf bytes.Buffer
+
+		if err := formatCommands(&buf, bytes.NewReader(bs)); err != nil {
+			return fmt.Errorf("failed to format %s, %w", path, err)
+		}
+
+		switch {
+		case *list:
+			if !bytes.Equal(bs, buf.Bytes()) {
+				fmt.Fprintln(os.Stdout, path)
+			}
+		case *write:
+			if bytes.Equal(bs, buf.Bytes()) {
+				continue
+			}
+
+			if err := replaceFile(path, buf.Bytes()); err != nil {
+				return err
+			}
+		default:
+			if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatCommands writes the commands file read from r to w in the canonical
+// format: each command on a line of its own as compact JSON, its name in the
+// case of the registered command, e.g. add_book as ADD_BOOK, followed by its
+// arguments with their keys sorted. Comments are kept, without their leading
+// and trailing whitespace, and runs of blank lines are collapsed into one.
+//
+// Every command that cannot be parsed is reported, by line, before anything
+// is written, so that a file is never partially formatted. A checksummed
+// export is rejected since formatting it would break its checksum.
+func formatCommands(w io.Writer, r io.Reader) error {
+	var (
+		out      bytes.Buffer
+		problems []error
+		blank    bool
+	)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<26)
+
+	for n := 1; sc.Scan(); n++ {
+		trimmed := strings.TrimSpace(sc.Text())
+
+		switch {
+		case trimmed == "":
+			// Leading blank lines are dropped too.
+			blank = out.Len() > 0
+
+			continue
+		case strings.HasPrefix(trimmed, "# sha256"):
+			return usageErrorf("line %d, checksummed exports cannot be formatted without breaking their checksum", n)
+		}
+
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+			out.WriteString(trimmed + "\n")
+
+			continue
+		}
+
+		formatted, err := formatCommand([]byte(trimmed))
+		if err != nil {
+			problems = append(problems, &library.ImportError{Line: n, Raw: trimmed, Err: err})
+
+			continue
+		}
+
+		out.Write(formatted)
+		out.WriteString("\n")
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("failed to read commands, %w", err)
+	}
+
+	if len(problems) > 0 {
+		return errors.Join(problems...)
+	}
+
+	_, err := w.Write(out.Bytes())
+
+	return err
+}
+
+// formatCommand returns a command in the canonical format, see
+// formatCommands.
+func formatCommand(line []byte) ([]byte, error) {
+	var cmd struct {
+		Name      string `json:"name"`
+		Arguments any    `json:"arguments"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&cmd); err != nil {
+		return nil, fmt.Errorf("%w, %w", library.ErrInvalidCommand, err)
+	}
+
+	if dec.More() {
+		return nil, fmt.Errorf("%w, more than one command on the line", library.ErrInvalidCommand)
+	}
+
+	if cmd.Name == "" {
+		return nil, fmt.Errorf("%w, missing name", library.ErrInvalidCommand)
+	}
+
+	// Names that are not commands in any case are left as they are since
+	// they may be macros, whose names are case sensitive.
+	if _, ok := library.NewCommand(strings.ToUpper(cmd.Name)); ok {
+		cmd.Name = strings.ToUpper(cmd.Name)
+	}
+
+	if cmd.Arguments == nil {
+		cmd.Arguments = map[string]any{}
+	}
+
+	// The arguments are decoded as maps, which are encoded with their
+	// keys sorted, and the numbers as they were written.
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(cmd); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// replaceFile atomically replaces the contents of the file at path, keeping
+// its permissions.
+func replaceFile(path string, bs []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s, %w", path, err)
+	}
+
+	return nil
+}
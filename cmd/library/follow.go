@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// followInterval is how often a followed commands file is checked for new
+// commands once all of its commands have been executed.
+const followInterval = 250 * time.Millisecond
+
+// followCommands executes the commands of the commands file at path, a file or
+// a named pipe, and then the commands appended to it as they are appended,
+// until the context is done, see --follow.
+//
+// Unlike the commands of a commands file that is not followed, a command that
+// fails is reported and the following commands are still executed, so that
+// one bad command does not stop the processes feeding the file. The DB is
+// saved whenever the commands read so far have been executed, so that the
+// commands are persisted as they arrive rather than only when following
+// stops.
+func followCommands(ctx context.Context, l *library.Library, store library.Store, path string, opts library.ImportOptions) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s, %w", path, err)
+	}
+
+	pipe := fi.Mode()&os.ModeNamedPipe != 0
+
+	// A named pipe is opened for writing too, so that opening it does not
+	// wait for a writer and reading it does not end once the writers
+	// close it. Reading it waits for commands, so it is closed to stop.
+	flags := os.O_RDONLY
+	if pipe {
+		flags = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s, %w", path, err)
+	}
+	defer f.Close()
+
+	if pipe {
+		stop := context.AfterFunc(ctx, func() { f.Close() })
+		defer stop()
+	}
+
+	li := newLineImporter(l, opts)
+
+	saved := l.Sequence()
+
+	save := func() error {
+		if l.Sequence() == saved {
+			return nil
+		}
+
+		if err := store.Save(l); err != nil {
+			return fmt.Errorf("failed to save library state to DB, %w", err)
+		}
+
+		saved = l.Sequence()
+
+		return nil
+	}
+
+	br := bufio.NewReader(f)
+
+	var (
+		line   []byte // Line being read, which may be partially written.
+		offset int64  // Offset of the end of the lines read.
+	)
+
+	for {
+		bs, err := br.ReadBytes('\n')
+		line = append(line, bs...)
+		offset += int64(len(bs))
+
+		if err == nil {
+			if err := li.importLine(line); err != nil {
+				slog.Error("failed to execute command", "path", path, "line", li.n, "err", err)
+			}
+
+			line = nil
+
+			// The commands are saved once the commands read so far
+			// have been executed, rather than after every command.
+			if br.Buffered() == 0 {
+				if err := save(); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return save()
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read %s, %w", path, err)
+		}
+
+		if err := save(); err != nil {
+			return err
+		}
+
+		// A file that was truncated, e.g. by the process feeding it
+		// once the commands were executed, is followed from its
+		// start.
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read %s, %w", path, err)
+			}
+
+			br.Reset(f)
+			line, offset, li.n = nil, 0, 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return save()
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// lineImporter imports the commands of a commands file one line at a time,
+// so that a command that fails does not stop the commands after it, see
+// followCommands and promptCommands.
+type lineImporter struct {
+	l    *library.Library
+	opts library.ImportOptions
+	n    int // Line of the last line imported.
+}
+
+// newLineImporter returns a lineImporter that imports the lines into the
+// library with the options. Since each line is imported on its own, the
+// results are numbered and written here with the line of the command in the
+// file rather than by the import.
+func newLineImporter(l *library.Library, opts library.ImportOptions) *lineImporter {
+	li := &lineImporter{l: l}
+
+	var results *json.Encoder
+	if opts.ResultWriter != nil {
+		results = json.NewEncoder(opts.ResultWriter)
+		opts.ResultWriter = nil
+	}
+
+	onResult := opts.OnResult
+
+	opts.OnResult = func(result library.Result) {
+		result.Line = li.n
+
+		if onResult != nil {
+			onResult(result)
+		}
+
+		if results != nil {
+			if err := results.Encode(result); err != nil {
+				slog.Error("failed to write invocation result", "err", err)
+			}
+		}
+	}
+
+	li.opts = opts
+
+	return li
+}
+
+// importLine imports the next line of the commands file, returning the error
+// of its command, if any, with the line of the command in the file.
+func (li *lineImporter) importLine(line []byte) error {
+	li.n++
+
+	err := li.l.Import(bytes.NewReader(line), li.opts)
+
+	var ie *library.ImportError
+	if errors.As(err, &ie) {
+		ie.Line = li.n
+	}
+
+	return err
+}
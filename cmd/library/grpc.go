@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/apikey"
+	librarygrpc "github.com/admtnnr/library/grpc"
+	"github.com/admtnnr/library/metrics"
+	"github.com/admtnnr/library/ratelimit"
+)
+
+// runGRPC implements the grpc subcommand:
+//
+//	library [flags] grpc [--addr :50051] [--keys keys.json] [--rate 0 --burst 10] [--webhooks config.json] [--metrics-addr addr]
+//
+// The DB is loaded and served as the gRPC LibraryService, see the grpc
+// package, until the process is interrupted, then the DB is saved. Each
+// command executed by a call is appended to the store, so with --store bolt or
+// wal the commands are persisted as they are executed rather than only on
+// shutdown.
+//
+// With --keys, each call requires the token of an API key of the keys file,
+// see the keys subcommand and librarygrpc.KeyAuth. With --rate, the calls of
+// each client are limited like the requests of the serve subcommand, failing
+// with ResourceExhausted, see librarygrpc.RateLimit. With --metrics-addr, the
+// Prometheus metrics of the calls and the DB are served over HTTP at /metrics,
+// see the metrics package. With --webhooks, the events are POSTed to the
+// webhook targets of the config file, as by the serve subcommand.
+func runGRPC(args []string) error {
+	fs := flag.NewFlagSet("grpc", flag.ContinueOnError)
+
+	addr := fs.String("addr", ":50051", "address to listen on")
+	keysPath := fs.String("keys", "", "path to the API keys file to require keys of, see the keys subcommand")
+	rateLimit := fs.Float64("rate", 0, "calls per second of each client, unlimited if 0")
+	burst := fs.Int("burst", 10, "calls each client can make at once with --rate")
+	webhooks := fs.String("webhooks", "", "path to the config file of the webhook targets to deliver the events to")
+	metricsAddr := fs.String("metrics-addr", "", "address to serve the Prometheus metrics on at /metrics, none if empty")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("grpc takes no arguments")
+	}
+
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library DB, %w", err)
+	}
+	defer store.Close()
+
+	l := library.New()
+	m := metrics.New(l)
+
+	// The saves of the autosave store are observed too.
+	store = m.Store(store)
+
+	if *autosaveN > 0 || *autosaveDur > 0 {
+		store = &library.AutosaveStore{Store: store, Every: *autosaveN, Interval: *autosaveDur}
+	}
+
+	if err := store.Load(l); err != nil {
+		return fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	if err := applyConfigPolicy(l); err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s, %w", *addr, err)
+	}
+
+	stopWebhooks, err := startWebhooks(l, *webhooks)
+	if err != nil {
+		return err
+	}
+	defer stopWebhooks()
+
+	// The calls are logged first so that those rejected by the other
+	// interceptors are logged too.
+	opts := logCalls()
+
+	// The calls are limited before they are authenticated so that the
+	// calls with invalid keys are limited too.
+	if *rateLimit > 0 {
+		opts = append(opts, librarygrpc.RateLimit(&ratelimit.Limiter{Rate: *rateLimit, Burst: *burst})...)
+	}
+
+	if *keysPath != "" {
+		opts = append(opts, librarygrpc.KeyAuth(&apikey.File{Path: *keysPath})...)
+	}
+
+	s := librarygrpc.NewServer(&librarygrpc.Server{
+		Library: l,
+		AfterExec: func(inv *library.Invocation) error {
+			return store.Append(l, inv)
+		},
+		OnResult: m.ObserveResult,
+	}, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		// Watch streams only end when canceled, so the server is
+		// stopped rather than waiting for them.
+		s.Stop()
+	}()
+
+	if *metricsAddr != "" {
+		mlis, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s, %w", *metricsAddr, err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		defer srv.Close()
+
+		go srv.Serve(mlis)
+	}
+
+	slog.Info("serving", "db", *dbPath, "addr", lis.Addr().String())
+
+	if err := s.Serve(lis); err != nil {
+		return fmt.Errorf("failed to serve, %w", err)
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// handleHealthz responds with 200 OK while the server is running, for
+// liveness probes, regardless of the state of the DB.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz responds with 200 OK if the server is ready to serve the
+// library, for readiness probes and load balancers: the DB is loaded and its
+// storage is reachable, see library.PingStore. Otherwise it responds with 503
+// Service Unavailable and the checks that failed, e.g.:
+//
+//	{"status":"unavailable","checks":{"state":"not loaded","storage":"ok"}}
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	checks, ok := s.checks(ctx)
+
+	resp := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ok", Checks: checks}
+
+	status := http.StatusOK
+
+	if !ok {
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// checks returns the readiness checks of the server, see handleReadyz, and
+// whether they all passed.
+func (s *server) checks(ctx context.Context) (map[string]string, bool) {
+	checks := map[string]string{"state": "ok", "storage": "ok"}
+	ok := true
+
+	if !s.ready.Load() {
+		checks["state"] = "not loaded"
+		ok = false
+	}
+
+	if err := library.PingStore(ctx, s.store); err != nil {
+		checks["storage"] = err.Error()
+		ok = false
+	}
+
+	return checks, ok
+}
+
+// buildVersion is the version of the binary, see handleVersion.
+type buildVersion struct {
+	Version  string `json:"version"`            // Version of the module, "(devel)" if built from a checkout.
+	Revision string `json:"revision,omitempty"` // VCS revision it was built from.
+	Time     string `json:"time,omitempty"`     // Time of the revision.
+	Modified bool   `json:"modified,omitempty"` // Whether the checkout had uncommitted changes.
+	Go       string `json:"go"`                 // Version of Go it was built with.
+}
+
+// handleVersion responds with the version of the binary, see
+// readBuildVersion.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, readBuildVersion())
+}
+
+// readBuildVersion returns the version of the binary, as recorded by the Go
+// toolchain when it was built.
+func readBuildVersion() buildVersion {
+	v := buildVersion{Version: "unknown", Go: runtime.Version()}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v.Version = info.Main.Version
+
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				v.Revision = setting.Value
+			case "vcs.time":
+				v.Time = setting.Value
+			case "vcs.modified":
+				v.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	return v
+}
+
+// writeJSON writes v as the JSON body of a response with the status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/admtnnr/library"
+)
+
+// runHelp implements the help subcommand:
+//
+//	library [flags] help [command]
+//
+// The arguments of the command, in any case or as one of replAliases, e.g.
+// library help checkout, are written in the format of --output: their names,
+// types, whether they are required and their constraints, followed by an
+// example of the command as a line of a commands file, see
+// library.DescribeCommand. Without a command, the commands are listed.
+func runHelp(args []string) error {
+	fs := flag.NewFlagSet("help", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	args = fs.Args()
+
+	switch len(args) {
+	case 0:
+		writeCommandList(os.Stdout)
+		fmt.Fprintln(os.Stdout, "library help <command> describes the arguments of a command.")
+
+		return nil
+	case 1:
+	default:
+		return usageErrorf("help takes at most one command")
+	}
+
+	h, ok := describeCommand(args[0])
+	if !ok {
+		return usageErrorf("unknown command %q, see library help", args[0])
+	}
+
+	switch *output {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(h)
+	case "yaml":
+		return encodeYAML(os.Stdout, h)
+	case "text":
+		return writeCommandHelp(os.Stdout, h)
+	default:
+		return usageErrorf("unknown --output %q, expected text, json or yaml", *output)
+	}
+}
+
+// describeCommand returns the help of the command of the name, in any case or
+// as one of replAliases, or false if there is none.
+func describeCommand(name string) (*library.CommandHelp, bool) {
+	if alias, ok := replAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+
+	return library.DescribeCommand(strings.ToUpper(name))
+}
+
+// writeCommandHelp writes the help of a command as text, e.g.:
+//
+//	ADD_COPIES
+//
+//	Arguments:
+//	  id     integer  required  ID of the book
+//	  count  integer  required  number of copies to add, must be positive
+//
+//	Example:
+//	  {"name":"ADD_COPIES","arguments":{"count":2,"id":1}}
+func writeCommandHelp(w io.Writer, h *library.CommandHelp) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s\n\n", h.Name)
+
+	if len(h.Arguments) == 0 {
+		sb.WriteString("No arguments.\n")
+	} else {
+		sb.WriteString("Arguments:\n")
+
+		tw := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+
+		for _, arg := range h.Arguments {
+			required := "optional"
+			if arg.Required {
+				required = "required"
+			}
+
+			row := fmt.Sprintf("  %s\t%s\t%s", arg.Name, arg.Type, required)
+			if arg.Help != "" {
+				row += "\t" + arg.Help
+			}
+
+			fmt.Fprintln(tw, row)
+		}
+
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(&sb, "\nExample:\n  %s\n", h.Example)
+
+	_, err := io.WriteString(w, sb.String())
+
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/admtnnr/library/apikey"
+)
+
+// runKeys implements the keys subcommand:
+//
+//	library keys [--keys keys.json] create [--scope read] <name>
+//	library keys [--keys keys.json] revoke <id>
+//	library keys [--keys keys.json] list
+//
+// The API keys of the keys file, see the apikey package, that the grpc and
+// serve subcommands require with their --keys flag are managed. The token of
+// a created key is written once and cannot be recovered, as only its hash is
+// kept. Keys created and revoked take effect on the running servers.
+func runKeys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+
+	path := fs.String("keys", "keys.json", "path to the API keys file")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	keys := &apikey.File{Path: *path}
+
+	switch fs.Arg(0) {
+	case "create":
+		create := flag.NewFlagSet("keys create", flag.ContinueOnError)
+
+		scope := create.String("scope", string(apikey.ScopeRead), "scope of the key, read or write")
+
+		if err := create.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+
+		if create.NArg() != 1 {
+			return usageErrorf("keys create takes the name of the client of the key")
+		}
+
+		s, err := apikey.ParseScope(*scope)
+		if err != nil {
+			return err
+		}
+
+		token, key, err := keys.Create(create.Arg(0), s)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "created %s key %s for %s, its token is only shown once:\n%s\n", key.Scope, key.ID, key.Name, token)
+	case "revoke":
+		if fs.NArg() != 2 {
+			return usageErrorf("keys revoke takes the ID of the key")
+		}
+
+		if err := keys.Revoke(fs.Arg(1)); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "revoked key %s\n", fs.Arg(1))
+	case "list":
+		if fs.NArg() != 1 {
+			return usageErrorf("keys list takes no arguments")
+		}
+
+		list, err := keys.List()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "ID\tSCOPE\tCREATED\tNAME")
+
+		for _, key := range list {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.ID, key.Scope, key.Created.Format(time.RFC3339), key.Name)
+		}
+
+		return tw.Flush()
+	default:
+		return usageErrorf("keys takes create, revoke or list")
+	}
+
+	return nil
+}
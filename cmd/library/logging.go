@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// setupLogging sets the default logger, which the library and the servers log
+// to, to write to stderr at --log-level in --log-format, text or json for log
+// collectors. --log-level warn or error quiets the informational logs, e.g.
+// of batch runs, and debug logs every command executed.
+func setupLogging() error {
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return usageErrorf("unknown --log-level %q, expected debug, info, warn or error", *logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+
+	switch *logFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return usageErrorf("unknown --log-format %q, expected text or json", *logFormat)
+	}
+
+	slog.SetDefault(slog.New(h))
+
+	return nil
+}
+
+// logRequests returns a handler that logs the requests to h once they are
+// served, with their status and duration. The requests of the probes, e.g.
+// /healthz, are only logged at the debug level since they are frequent.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(sw, r)
+
+		level := slog.LevelInfo
+
+		switch {
+		case sw.status >= 500:
+			level = slog.LevelError
+		case strings.HasSuffix(r.URL.Path, "/healthz"), strings.HasSuffix(r.URL.Path, "/readyz"), r.URL.Path == "/metrics":
+			level = slog.LevelDebug
+		}
+
+		slog.Log(r.Context(), level, "served request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// statusWriter records the status of a response. It can be hijacked and
+// flushed like the response writer it wraps, for WebSockets and server-sent
+// events.
+type statusWriter struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the response writer it wraps, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack implements http.Hijacker.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Flush implements http.Flusher.
+func (w *statusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// logCalls returns the gRPC server options that log the calls once they
+// return, with their code and duration.
+func logCalls() []grpc.ServerOption {
+	logCall := func(ctx context.Context, method string, start time.Time, err error) {
+		code := status.Code(err)
+
+		level := slog.LevelInfo
+		if err != nil {
+			level = slog.LevelWarn
+		}
+
+		slog.Log(ctx, level, "served call", "method", method, "code", code.String(), "duration", time.Since(start))
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			start := time.Now()
+
+			resp, err := handler(ctx, req)
+			logCall(ctx, info.FullMethod, start, err)
+
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+
+			err := handler(srv, ss)
+			logCall(ss.Context(), info.FullMethod, start, err)
+
+			return err
+		}),
+	}
+}
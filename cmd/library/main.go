@@ -1,72 +1,187 @@
 // library is a simple library management system that reads a list of commands
 // from a file and executes them against the library system.
 //
-// library [flags] <commands-file>
+// Usage:
 //
-// Flags:
+//	library [flags] [run] <commands-file>...
+//	library [flags] <subcommand> [flags] [arguments]
 //
-//	--db string         path to DB file (default "state.db")
-//	--help              display help and exits
+// The subcommands are:
 //
-// The <commands-file> can be a file or stdin. If the file is "-", then stdin
-// is used.
+//	run       execute the commands files against the DB, the default, see runRun
+//	export    write the state of the DB in a format, see runExport
+//	repl      execute commands as they are entered, see runREPL
+//	query     print a book, an account or a search, see runQuery
+//	stats     print a summary of the DB, see runStats
+//	validate  check the commands files without executing them, see runValidate
+//	diff      compare two state files, see runDiff
+//	fmt       write the commands files in a canonical format, see runFmt
+//	help      describe the arguments of a command, see runHelp
+//	seed      generate a reproducible dataset into the DB, see runSeed
+//	bench     measure the import and commands of a state file, see runBench
+//	convert   write a state file in another format or store, see runConvert
+//	doctor    check the DB for problems and repair them, see runDoctor
+//	backup    write a checksummed copy of the DB or verify one, see runBackup
+//	restore   replace the DB with a backup, see runRestore
+//	compact   rewrite the DB as the minimal commands of its state, see runCompact
+//	catalog   write the books of the DB as CSV, see runCatalog
+//	grpc      serve the DB as the gRPC LibraryService, see runGRPC
+//	serve     serve the DB over HTTP, see runServe
+//	rpc       execute the commands of JSON-RPC requests, see runRPC
+//	mcp       serve the DB as a Model Context Protocol server, see runMCP
+//	keys      manage the API keys of the servers, see runKeys
 //
-// The commands file is a newline-delimited JSON file with one command per
-// line. Each command is JSON object with the following structure:
-//
-//	{
-//		"name": "command-name",
-//		"arguments": {
-//			"arg1": "value1",
-//			"arg2": "value2",
-//			...
-//		}
-//	}
-//
-// The following commands are supported:
-// - ADD_BOOK
-// - CREATE_ACCOUNT
-// - CHECKOUT_BOOK
-// - RETURN_BOOK
-// - ADD_COPIES
-// - REMOVE_COPIES
-// - PRINT_CATALOG
-// - PRINT_ACCOUNTS
-//
-// Commands are executed in the order they appear in the file. If any command
-// fails, the program will exit with a non-zero exit code. Any changes made to
-// the library system prior to the failure will *NOT* be persisted back to the
-// DB.
+// The flags, e.g. --db, may precede the name of the subcommand or follow it
+// along with the flags of the subcommand, see parseFlags, and are listed by
+// library --help and library <subcommand> --help.
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/boltstore"
+	"github.com/admtnnr/library/s3store"
 )
 
 var (
-	dbPath = flag.String("db", "state.db", "path to DB file")
+	dbPath       = flag.String("db", "state.db", "path to DB file")
+	resultsPath  = flag.String("results", "", "path to write NDJSON invocation results to")
+	simulate     = flag.Bool("simulate-time", false, "advance a simulated clock on WAIT instead of sleeping")
+	yes          = flag.Bool("yes", false, "execute destructive commands without confirmation")
+	csvCommand   = flag.String("csv", "", "read the commands file as CSV rows of the command")
+	storeKind    = flag.String("store", "file", "kind of DB, file, bolt, wal or s3")
+	dbFormat     = flag.String("db-format", "commands", "format of the file DB, commands, snapshot, gob or proto")
+	keyFile      = flag.String("key-file", "", "path to a hex encoded AES key to encrypt the file DB with")
+	s3Endpoint   = flag.String("s3-endpoint", "", "URL of the S3-compatible service of the s3 DB")
+	autosaveN    = flag.Int("autosave-every", 0, "save the DB after every N commands")
+	autosaveDur  = flag.Duration("autosave-interval", 0, "save the DB once the interval has passed since the last save")
+	checkpoint   = flag.String("checkpoint", "", "path to record the progress of the commands file to, to resume it from if it fails")
+	onConflict   = flag.String("on-conflict", "fail", "how to resolve a book or account that already exists, fail, skip, overwrite or merge")
+	output       = flag.String("output", "text", "format of the output of the commands, text, json or yaml")
+	follow       = flag.Bool("follow", false, "keep executing the commands appended to the commands file, or named pipe, until interrupted")
+	showProgress = flag.Bool("progress", false, "report the progress of the commands files to stderr, as a progress bar on a terminal")
+	configPath   = flag.String("config", "", "path to the TOML config file of the defaults of the flags (default ~/.config/library/config.toml)")
+	logLevel     = flag.String("log-level", "info", "level of the logs written to stderr, debug, info, warn or error")
+	logFormat    = flag.String("log-format", "text", "format of the logs, text or json")
+	noColor      = flag.Bool("no-color", false, "do not colorize the output, even on a terminal")
+	dryRun       = flag.Bool("dry-run", false, "execute the commands against a copy of the DB and print what would change without saving it")
+	readOnly     = flag.Bool("readonly", false, "only execute the commands that read the DB, such as PRINT_BOOK, and never save it")
 
 	usage = `library is a simple library management system that reads a list of commands
 from a file and executes them against the library system.
 
-library [flags] <commands-file>
+Usage:
+
+  library [flags] [run] <commands-file>...
+  library [flags] <subcommand> [flags] [arguments]
+
+The subcommands are:
 
-The <commands-file> can be a file or stdin. If the file is "-", then stdin
-is used.
+  run       execute the commands files against the DB, the default
+  export    write the state of the DB in a format
+  repl      execute commands as they are entered
+  query     print a book, an account or a search
+  stats     print a summary of the DB
+  validate  check the commands files without executing them
+  diff      compare two state files
+  fmt       write the commands files in a canonical format
+  help      describe the arguments of a command
+  seed      generate a reproducible dataset into the DB
+  bench     measure the import and commands of a state file
+  convert   write a state file in another format or store
+  doctor    check the DB for problems and repair them
+  backup    write a checksummed copy of the DB or verify one
+  restore   replace the DB with a backup
+  compact   rewrite the DB as the minimal commands of its state
+  catalog   write the books of the DB as CSV
+  grpc      serve the DB as the gRPC LibraryService
+  serve     serve the DB over HTTP
+  rpc       execute the commands of JSON-RPC requests
+  mcp       serve the DB as a Model Context Protocol server
+  keys      manage the API keys of the servers
+
+The flags may also follow the name of the subcommand. Use library <subcommand>
+--help for the flags of a subcommand and library help <command> for the
+arguments of a command.
+
+The exit code is 2 for invalid flags or arguments, 3 for a command that cannot
+be parsed, 4 for a command that violates the rules of the library, e.g.
+checking out a book to an account that does not exist, 5 for a failure to load
+or save the DB, and 1 for any other failure.
 
 Flags:
 
      --db string         path to DB file (default "state.db")
+     --results string    path to write NDJSON invocation results to
+     --simulate-time     advance a simulated clock on WAIT instead of sleeping
+     --yes               execute destructive commands without confirmation
+     --csv string        read the commands file as CSV rows of the command
+     --store string      kind of DB, file, bolt, wal or s3 (default "file")
+     --db-format string  format of the file DB, commands, snapshot, gob or proto (default "commands")
+     --key-file string   path to a hex encoded AES key to encrypt the file DB with
+     --s3-endpoint string
+                         URL of the S3-compatible service of the s3 DB
+     --autosave-every int
+                         save the DB after every N commands
+     --autosave-interval duration
+                         save the DB once the interval has passed since the last save
+     --checkpoint string
+                         path to record the progress of the commands file to, to resume it from if it fails
+     --on-conflict string
+                         how to resolve a book or account that already exists, fail, skip, overwrite or merge (default "fail")
+     --output string     format of the output of the commands, text, json or yaml (default "text")
+     --dry-run           execute the commands against a copy of the DB and print what would change without saving it
+     --readonly          only execute the commands that read the DB, such as PRINT_BOOK, and never save it
+     --follow            keep executing the commands appended to the commands file, or named pipe, until interrupted
+     --progress          report the progress of the commands files to stderr, as a progress bar on a terminal
+     --config string     path to the TOML config file of the defaults of the flags (default ~/.config/library/config.toml)
+     --log-level string  level of the logs written to stderr, debug, info, warn or error (default "info")
+     --log-format string format of the logs, text or json (default "text")
+     --no-color          do not colorize the output, even on a terminal
      --help              display help and exits
 `
 )
 
+// subcommands are the subcommands by name, which are run with the arguments
+// that follow the name instead of executing a commands file. They are set by
+// init since the subcommands refer to them, e.g. to load the config file.
+var subcommands map[string]func(args []string) error
+
 func init() {
+	subcommands = map[string]func(args []string) error{
+		"run":      runRun,
+		"export":   runExport,
+		"repl":     runREPL,
+		"backup":   runBackup,
+		"restore":  runRestore,
+		"compact":  runCompact,
+		"catalog":  runCatalog,
+		"grpc":     runGRPC,
+		"serve":    runServe,
+		"rpc":      runRPC,
+		"mcp":      runMCP,
+		"keys":     runKeys,
+		"query":    runQuery,
+		"stats":    runStats,
+		"validate": runValidate,
+		"diff":     runDiff,
+		"fmt":      runFmt,
+		"help":     runHelp,
+		"seed":     runSeed,
+		"bench":    runBench,
+		"convert":  runConvert,
+		"doctor":   runDoctor,
+	}
+
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, usage)
 	}
@@ -75,72 +190,229 @@ func init() {
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	// The commands files are executed by the run subcommand, so that
+	// library commands.ndjson is short for library run commands.ndjson.
+	name, args := "run", flag.Args()
+
+	if _, ok := subcommands[flag.Arg(0)]; ok {
+		name, args = flag.Arg(0), flag.Args()[1:]
+	} else if flag.NArg() == 0 {
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	err := subcommands[name](args)
+	if err == nil {
+		return
+	}
+
+	// With --output json or yaml, stdout is left to the output of the
+	// commands so that it can be parsed.
+	errOut := os.Stdout
+	if *output != "text" {
+		errOut = os.Stderr
+	}
+
+	msg := err.Error()
+	if name == flag.Arg(0) {
+		msg = fmt.Sprintf("%s failed, %v", name, err)
+	}
+
+	fmt.Fprintln(errOut, errorText(errOut, msg))
+	os.Exit(exitCode(err))
+}
+
+// loadLibrary opens the store of the DB of the flags, which saves the DB
+// periodically with --autosave-every or --autosave-interval, and loads the
+// library from it. Only the commands executed afterwards can be undone, not the
+// loading of the existing library state. With --readonly, the library only
+// executes the commands that read it and the store never writes the DB. The
+// store must be closed once the library is no longer used.
+func loadLibrary() (*library.Library, library.Store, error) {
+	store, err := openStore(*storeKind, *dbPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open library DB, %w", err)
+	}
+
+	if *autosaveN > 0 || *autosaveDur > 0 {
+		store = &library.AutosaveStore{Store: store, Every: *autosaveN, Interval: *autosaveDur}
 	}
 
 	l := library.New()
 
-	db, err := os.OpenFile(*dbPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err := store.Load(l); err != nil {
+		store.Close()
+
+		return nil, nil, fmt.Errorf("failed to load library DB from %s, %w", *dbPath, err)
+	}
+
+	if err := applyConfigPolicy(l); err != nil {
+		store.Close()
+
+		return nil, nil, err
+	}
+
+	l.ClearUndo()
+
+	if *readOnly {
+		l.SetReadOnly(true)
+		store = &readOnlyStore{Store: store}
+	}
+
+	return l, store, nil
+}
+
+// openStore opens the store of the kind for the DB at path. The errors of the
+// store are storage errors, see exitStorage.
+//
+// The file DB is gzip compressed if its path has a .gz extension, encrypted
+// with AES-GCM with --key-file, and written with a SHA-256 checksum so that a
+// truncated or modified DB fails to load. With --store s3, the DB is an object
+// in S3-compatible object storage named by --db s3://bucket/prefix.
+func openStore(kind, path string) (library.Store, error) {
+	if *keyFile != "" && kind != "file" {
+		return nil, usageErrorf("--key-file is only supported with --store file")
+	}
+
+	var (
+		store library.Store
+		err   error
+	)
+
+	switch kind {
+	case "file":
+		file := &library.FileStore{Path: path, Format: library.Format(*dbFormat)}
+
+		if *keyFile != "" {
+			if file.Key, err = readKey(*keyFile); err != nil {
+				return nil, usageError{err}
+			}
+		}
+
+		store = file
+	case "bolt":
+		store, err = boltstore.Open(path)
+	case "wal":
+		store, err = library.OpenWALStore(path)
+	case "s3":
+		store, err = openS3Store(path)
+	default:
+		return nil, usageErrorf("unknown store %q, expected file, bolt, wal or s3", kind)
+	}
+
+	if err != nil {
+		return nil, asStorageError(err)
+	}
+
+	return &storageStore{Store: store}, nil
+}
+
+// openS3Store opens the s3 store for a DB of the form s3://bucket/prefix.
+//
+// The region and credentials are read from the standard AWS environment
+// variables. Services other than AWS are addressed with path-style requests,
+// which most of them require.
+func openS3Store(db string) (library.Store, error) {
+	u, err := url.Parse(db)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, usageErrorf("invalid s3 DB %q, expected s3://bucket/prefix", db)
+	}
+
+	return s3store.Open(s3store.Options{
+		Endpoint:  *s3Endpoint,
+		Region:    os.Getenv("AWS_REGION"),
+		Bucket:    u.Host,
+		Prefix:    strings.TrimPrefix(u.Path, "/"),
+		PathStyle: *s3Endpoint != "",
+	})
+}
+
+// readKey reads a hex encoded AES key from the file at path, e.g. one
+// generated with:
+//
+//	openssl rand -hex 32 > library.key
+func readKey(path string) ([]byte, error) {
+	bs, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Fprintf(os.Stdout, "failed to open library DB, %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to read key, %w", err)
 	}
-	defer db.Close()
 
-	if err := l.Import(db, library.ImportOptions{}); err != nil {
-		fmt.Fprintf(os.Stdout, "failed to load library DB from %s, %v\n", *dbPath, err)
-		os.Exit(1)
+	key, err := hex.DecodeString(strings.TrimSpace(string(bs)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key from %s, expected hex, %w", path, err)
 	}
 
-	commandsPath := flag.Arg(0)
-	var commands io.ReadCloser
+	return key, nil
+}
 
-	if commandsPath == "-" {
-		commands = os.Stdin
-	} else {
-		var err error
+// importCommands imports the commands from the commands file, or stdin if the
+// path is "-".
+//
+// Commands typed at a terminal are executed as they are entered, see
+// promptCommands. Commands files are imported with ImportFile so that the paths
+// of the files they INCLUDE are resolved relative to them, while CSV files are
+// imported with ImportCSV and ONIX feeds with ImportONIX.
+func importCommands(l *library.Library, path string, opts library.ImportOptions) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	isCSV := *csvCommand != "" || ext == ".csv"
+	isONIX := ext == ".xml" || ext == ".onix"
 
-		if commands, err = os.Open(commandsPath); err != nil {
-			fmt.Fprintf(os.Stdout, "failed to open commands file, %v\n", err)
-			os.Exit(1)
+	if path == "-" {
+		if isCSV {
+			return l.ImportCSV(os.Stdin, *csvCommand, opts)
 		}
-		defer commands.Close()
+
+		// A checkpoint records the progress through the whole of
+		// stdin, so it is imported at once even from a terminal.
+		if isTerminal(os.Stdin) && opts.Checkpoint == nil {
+			return promptCommands(l, opts)
+		}
+
+		return l.Import(os.Stdin, opts)
 	}
 
-	if err := l.Import(commands, library.ImportOptions{LogOutput: true}); err != nil {
-		fmt.Fprintf(os.Stdout, "failed to execute commands from %s, %v\n", commandsPath, err)
-		os.Exit(1)
+	if !isCSV && !isONIX {
+		return l.ImportFile(path, opts)
 	}
 
-	// Create a temporary file to export the library state to before we
-	// replace the existing library state file.
-	//
-	// We do this in an attempt to ensure that we do not lose or corrupt
-	// the existing library state if the export fails during some
-	// combination of truncating and writing directly into the existing
-	// state file.
-	export, err := os.CreateTemp("", "state.db")
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Fprintf(os.Stdout, "failed to create temporary export file, %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to open %s, %w", path, err)
+	}
+	defer f.Close()
+
+	if isONIX {
+		return l.ImportONIX(f, opts)
 	}
-	defer os.Remove(export.Name())
 
-	if err := l.Export(export); err != nil {
-		fmt.Fprintf(os.Stdout, "failed to save library state to DB, %v\n", err)
-		os.Exit(1)
+	return l.ImportCSV(f, *csvCommand, opts)
+}
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
 	}
 
-	// Force the export file to be written to disk before we replace the existing
-	// library state file.
-	export.Sync()
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm returns a confirmation prompt for ImportOptions.Confirm that writes
+// the prompt to w and reads the answer from r, where anything other than "y"
+// or "yes" declines.
+func confirm(r io.Reader, w io.Writer) func(desc string) bool {
+	answers := bufio.NewScanner(r)
+
+	return func(desc string) bool {
+		fmt.Fprintf(w, "%s? [y/N] ", desc)
+
+		if !answers.Scan() {
+			return false
+		}
+
+		answer := strings.ToLower(strings.TrimSpace(answers.Text()))
 
-	// Rename is atomic on Linux systems, so we should not lose the
-	// existing library state should it fail.
-	if err := os.Rename(export.Name(), *dbPath); err != nil {
-		fmt.Fprintf(os.Stdout, "failed to replace library DB file, %v\n", err)
-		os.Exit(1)
+		return answer == "y" || answer == "yes"
 	}
 }
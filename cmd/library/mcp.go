@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// runMCP implements the mcp subcommand:
+//
+//	library [flags] mcp
+//
+// The DB is loaded and served as a Model Context Protocol (MCP) server over
+// stdio, so that AI assistants, e.g. at the reference desk, can search the
+// catalog, check out books and look up patrons with typed calls of tools
+// rather than writing commands. The messages are JSON-RPC 2.0 requests read
+// from stdin and responses written to stdout, one per line, as in the rpc
+// subcommand. The tools are:
+//
+//	search_catalog  search the catalog, see library.ParseSearchQuery
+//	checkout_book   check out a book for an account with CHECKOUT_BOOK
+//	get_account     look up an account, its balance and its checkouts
+//
+// The arguments of each call are validated against the input schema of its
+// tool, and invalid arguments or commands that fail are reported as tool
+// errors, so that the model can correct the call. Each checkout is appended to
+// the store as it is executed, and the DB is saved once stdin is closed.
+func runMCP(args []string) error {
+	fs := flag.NewFlagSet("mcp", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("mcp takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	s := &mcpServer{l: l, store: store}
+
+	if err := serveRPC(os.Stdin, os.Stdout, s); err != nil {
+		return err
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	return nil
+}
+
+// mcpProtocolVersions are the versions of MCP supported, latest first.
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// mcpTool is a tool of the MCP server, as listed by tools/list.
+type mcpTool struct {
+	Name         string          `json:"name"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	InputSchema  json.RawMessage `json:"inputSchema"`
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+	Annotations  mcpAnnotations  `json:"annotations"`
+}
+
+// mcpAnnotations are the hints of the behavior of a tool to clients.
+type mcpAnnotations struct {
+	ReadOnly    bool `json:"readOnlyHint"`
+	Destructive bool `json:"destructiveHint"`
+	Idempotent  bool `json:"idempotentHint"`
+	OpenWorld   bool `json:"openWorldHint"`
+}
+
+// mcpTools are the tools of the MCP server.
+var mcpTools = []mcpTool{
+	{
+		Name:  "search_catalog",
+		Title: "Search catalog",
+		Description: "Search the books of the library catalog. The query is a list of space separated terms: " +
+			"terms of the form field:value filter on the field, where field is one of author, isbn, tag, or available, " +
+			"and all other terms are matched against the title. Values containing spaces may be quoted, " +
+			`e.g. gatsby author:fitzgerald available:yes, or author:"Jane Austen".`,
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"query": {"type": "string", "description": "Search query, empty to list the whole catalog."},
+				"limit": {"type": "integer", "minimum": 1, "maximum": 100, "description": "Maximum number of books to return, 20 by default."}
+			},
+			"required": ["query"],
+			"additionalProperties": false
+		}`),
+		OutputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"total": {"type": "integer", "description": "Number of books matching the query."},
+				"books": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"properties": {
+							"id": {"type": "integer"},
+							"name": {"type": "string"},
+							"author": {"type": "string"},
+							"isbn": {"type": "string"},
+							"tags": {"type": "array", "items": {"type": "string"}},
+							"count": {"type": "integer", "description": "Number of copies."},
+							"available": {"type": "integer", "description": "Number of copies not checked out."}
+						},
+						"required": ["id", "name", "count", "available"]
+					}
+				}
+			},
+			"required": ["total", "books"]
+		}`),
+		Annotations: mcpAnnotations{ReadOnly: true, Idempotent: true},
+	},
+	{
+		Name:        "checkout_book",
+		Title:       "Check out book",
+		Description: "Check out a copy of a book for an account, due after the loan period of the library's policy.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"accountId": {"type": "integer", "minimum": 1, "description": "ID of the account checking out the book."},
+				"bookId": {"type": "integer", "minimum": 1, "description": "ID of the book to check out."}
+			},
+			"required": ["accountId", "bookId"],
+			"additionalProperties": false
+		}`),
+		OutputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"accountId": {"type": "integer"},
+				"bookId": {"type": "integer"},
+				"checkedOut": {"type": "string", "format": "date-time"},
+				"due": {"type": "string", "format": "date-time"},
+				"message": {"type": "string"}
+			},
+			"required": ["accountId", "bookId", "checkedOut", "due", "message"]
+		}`),
+	},
+	{
+		Name:        "get_account",
+		Title:       "Get account",
+		Description: "Look up a patron account: its balance and fines in cents, and the books it has checked out.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"accountId": {"type": "integer", "minimum": 1, "description": "ID of the account."}
+			},
+			"required": ["accountId"],
+			"additionalProperties": false
+		}`),
+		OutputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"id": {"type": "integer"},
+				"name": {"type": "string"},
+				"balance": {"type": "integer", "description": "Balance in cents, negative if fines are owed."},
+				"fines": {"type": "integer", "description": "Fines owed in cents."},
+				"accruedFines": {"type": "integer", "description": "Fines accruing on overdue checkouts in cents."},
+				"checkouts": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"properties": {
+							"bookId": {"type": "integer"},
+							"name": {"type": "string"},
+							"checkedOut": {"type": "string", "format": "date-time"},
+							"due": {"type": "string", "format": "date-time"},
+							"overdue": {"type": "boolean"},
+							"fine": {"type": "integer"}
+						},
+						"required": ["bookId", "name", "checkedOut", "due", "overdue", "fine"]
+					}
+				}
+			},
+			"required": ["id", "name", "balance", "fines", "accruedFines", "checkouts"]
+		}`),
+		Annotations: mcpAnnotations{ReadOnly: true, Idempotent: true},
+	},
+}
+
+// mcpServer serves MCP requests to drive a library DB, see runMCP.
+type mcpServer struct {
+	l     *library.Library
+	store library.Store
+}
+
+// call implements rpcHandler.
+func (s *mcpServer) call(method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		return s.initialize(params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+	case "tools/call":
+		return s.callTool(params)
+	default:
+		// Notifications, e.g. notifications/initialized, need no
+		// response, and are discarded regardless.
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+// initialize negotiates the version of the protocol with the client, the
+// version it requested if supported or else the latest.
+func (s *mcpServer) initialize(params json.RawMessage) (any, *rpcError) {
+	var req struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	version := mcpProtocolVersions[0]
+	if slices.Contains(mcpProtocolVersions, req.ProtocolVersion) {
+		version = req.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+		"serverInfo":      map[string]any{"name": "library", "version": readBuildVersion().Version},
+		"instructions": "Tools of a library management system. Search the catalog for the IDs of books, " +
+			"and look up accounts by ID before checking out books for them.",
+	}, nil
+}
+
+// mcpContent is a content block of the result of a tool call.
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of a tool call.
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+// callTool calls a tool. Unknown tools are errors of the request, while
+// invalid arguments and failures of the tool are errors of its result.
+func (s *mcpServer) callTool(params json.RawMessage) (any, *rpcError) {
+	var req struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	var (
+		result any
+		err    error
+	)
+
+	switch req.Name {
+	case "search_catalog":
+		result, err = s.searchCatalog(req.Arguments)
+	case "checkout_book":
+		result, err = s.checkoutBook(req.Arguments)
+	case "get_account":
+		result, err = s.getAccount(req.Arguments)
+	default:
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("unknown tool %q", req.Name)}
+	}
+
+	if err != nil {
+		return &mcpToolResult{
+			Content: []mcpContent{{Type: "text", Text: fmt.Sprintf("%s: %v", library.ErrorCodeOf(err), err)}},
+			IsError: true,
+		}, nil
+	}
+
+	// The structured content is also the text content, for clients that
+	// predate structured content.
+	bs, merr := json.Marshal(result)
+	if merr != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: merr.Error()}
+	}
+
+	return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(bs)}}, StructuredContent: result}, nil
+}
+
+// decodeToolArguments decodes the arguments of a tool call into v, rejecting
+// unknown arguments and values of the wrong types. The required arguments are
+// pointer fields of v, checked to be set by the tool.
+func decodeToolArguments(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w, %v", library.ErrInvalidArgument, err)
+	}
+
+	return nil
+}
+
+// mcpBook is a book of the result of search_catalog.
+type mcpBook struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Author    string   `json:"author,omitempty"`
+	ISBN      string   `json:"isbn,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Count     int      `json:"count"`
+	Available int      `json:"available"`
+}
+
+// searchCatalog implements the search_catalog tool.
+func (s *mcpServer) searchCatalog(raw json.RawMessage) (any, error) {
+	var args struct {
+		Query *string `json:"query"`
+		Limit *int    `json:"limit"`
+	}
+
+	if err := decodeToolArguments(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if args.Query == nil {
+		return nil, fmt.Errorf("%w, missing required argument query", library.ErrInvalidArgument)
+	}
+
+	limit := 20
+
+	if args.Limit != nil {
+		if *args.Limit < 1 || *args.Limit > 100 {
+			return nil, fmt.Errorf("%w, limit must be from 1 to 100", library.ErrInvalidArgument)
+		}
+
+		limit = *args.Limit
+	}
+
+	q, err := library.ParseSearchQuery(*args.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	books := s.l.Search(q)
+
+	result := struct {
+		Total int       `json:"total"`
+		Books []mcpBook `json:"books"`
+	}{Total: len(books), Books: []mcpBook{}}
+
+	for _, book := range books[:min(limit, len(books))] {
+		result.Books = append(result.Books, mcpBook{
+			ID:        book.ID,
+			Name:      book.Name,
+			Author:    book.Author,
+			ISBN:      book.ISBN,
+			Tags:      book.Tags,
+			Count:     book.Count,
+			Available: book.Count - len(s.l.CheckoutsByBook(book.ID)),
+		})
+	}
+
+	return result, nil
+}
+
+// checkoutBook implements the checkout_book tool.
+func (s *mcpServer) checkoutBook(raw json.RawMessage) (any, error) {
+	var args struct {
+		AccountID *int `json:"accountId"`
+		BookID    *int `json:"bookId"`
+	}
+
+	if err := decodeToolArguments(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if args.AccountID == nil || args.BookID == nil {
+		return nil, fmt.Errorf("%w, missing required argument accountId or bookId", library.ErrInvalidArgument)
+	}
+
+	inv := library.Invocation{Command: &library.CheckoutBook{AccountID: *args.AccountID, BookID: *args.BookID}}
+
+	if err := inv.Exec(s.l); err != nil {
+		return nil, &mcpCommandError{output: inv.Output, err: err}
+	}
+
+	if err := s.store.Append(s.l, &inv); err != nil {
+		return nil, fmt.Errorf("failed to append checkout to DB, %w", err)
+	}
+
+	result := struct {
+		AccountID  int       `json:"accountId"`
+		BookID     int       `json:"bookId"`
+		CheckedOut time.Time `json:"checkedOut"`
+		Due        time.Time `json:"due"`
+		Message    string    `json:"message"`
+	}{AccountID: *args.AccountID, BookID: *args.BookID, Message: inv.Output}
+
+	for _, checkout := range s.l.CheckoutsByAccount(*args.AccountID) {
+		if checkout.BookID == *args.BookID && checkout.CheckedOut.After(result.CheckedOut) {
+			result.CheckedOut = checkout.CheckedOut
+			result.Due = checkout.Due
+		}
+	}
+
+	return result, nil
+}
+
+// mcpCommandError is the error of a command executed by a tool, with the
+// output of the command as its message, e.g. naming the account and book.
+type mcpCommandError struct {
+	output string
+	err    error
+}
+
+// Error implements the error interface.
+func (e *mcpCommandError) Error() string {
+	return e.output
+}
+
+// Unwrap returns the error of the command, e.g. for its error code.
+func (e *mcpCommandError) Unwrap() error {
+	return e.err
+}
+
+// mcpCheckout is a checkout of the result of get_account.
+type mcpCheckout struct {
+	BookID     int       `json:"bookId"`
+	Name       string    `json:"name"`
+	CheckedOut time.Time `json:"checkedOut"`
+	Due        time.Time `json:"due"`
+	Overdue    bool      `json:"overdue"`
+	Fine       int       `json:"fine"`
+}
+
+// getAccount implements the get_account tool.
+func (s *mcpServer) getAccount(raw json.RawMessage) (any, error) {
+	var args struct {
+		AccountID *int `json:"accountId"`
+	}
+
+	if err := decodeToolArguments(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if args.AccountID == nil {
+		return nil, fmt.Errorf("%w, missing required argument accountId", library.ErrInvalidArgument)
+	}
+
+	account := s.l.Account(*args.AccountID)
+	if account == nil {
+		return nil, &library.NotExistError{Kind: library.KindAccount, ID: *args.AccountID}
+	}
+
+	result := struct {
+		ID           int           `json:"id"`
+		Name         string        `json:"name"`
+		Balance      int           `json:"balance"`
+		Fines        int           `json:"fines"`
+		AccruedFines int           `json:"accruedFines"`
+		Checkouts    []mcpCheckout `json:"checkouts"`
+	}{
+		ID:        account.ID,
+		Name:      account.Name,
+		Balance:   account.Balance,
+		Fines:     max(0, -account.Balance),
+		Checkouts: []mcpCheckout{},
+	}
+
+	now := s.l.Now()
+
+	for _, checkout := range s.l.CheckoutsByAccount(account.ID) {
+		fine := s.l.Fine(checkout)
+
+		result.AccruedFines += fine
+
+		var name string
+		if book := s.l.Book(checkout.BookID); book != nil {
+			name = book.Name
+		}
+
+		result.Checkouts = append(result.Checkouts, mcpCheckout{
+			BookID:     checkout.BookID,
+			Name:       name,
+			CheckedOut: checkout.CheckedOut,
+			Due:        checkout.Due,
+			Overdue:    checkout.Overdue(now),
+			Fine:       fine,
+		})
+	}
+
+	return result, nil
+}
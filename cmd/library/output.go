@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/admtnnr/library"
+	"gopkg.in/yaml.v3"
+)
+
+// outputRecord is the machine-readable output of a command with --output json
+// or yaml, its Result and its human readable output.
+type outputRecord struct {
+	library.Result
+
+	// Output is the human readable output of the command, without the
+	// trailing newline.
+	Output string `json:"output"`
+}
+
+// setOutput sets the Output of the options to write the output of the commands
+// to w in the format of --output: text writes the human readable output,
+// colorized if stdout is a terminal, see useColor, while json writes an
+// outputRecord per command as a line of JSON and yaml as a YAML document, for
+// scripts that consume the outcome of the commands rather than scraping the
+// human readable output.
+func setOutput(opts *library.ImportOptions, w io.Writer) error {
+	var encode func(record outputRecord) error
+
+	switch *output {
+	case "text":
+		if !useColor(os.Stdout) {
+			opts.Output = w
+
+			return nil
+		}
+
+		// The output is colorized by the result of the command, see
+		// colorize, so it is written with the result.
+		encode = func(record outputRecord) error {
+			if record.Output == "" {
+				return nil
+			}
+
+			_, err := fmt.Fprintf(w, "%s\n", colorize(record.Output, record.Result))
+
+			return err
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+
+		encode = func(record outputRecord) error { return enc.Encode(record) }
+	case "yaml":
+		encode = func(record outputRecord) error { return encodeYAML(w, record) }
+	default:
+		return usageErrorf("unknown --output %q, expected text, json or yaml", *output)
+	}
+
+	// The output of a command is written before its result, so it is
+	// buffered until the result is known.
+	var buf bytes.Buffer
+
+	onResult := opts.OnResult
+
+	opts.Output = &buf
+	opts.OnResult = func(result library.Result) {
+		if onResult != nil {
+			onResult(result)
+		}
+
+		record := outputRecord{Result: result, Output: strings.TrimSuffix(buf.String(), "\n")}
+		buf.Reset()
+
+		if err := encode(record); err != nil {
+			fmt.Fprintf(w, "failed to write output, %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// encodeYAML writes the value as a YAML document to w, with the field names
+// and order of its JSON encoding so that both formats are alike.
+func encodeYAML(w io.Writer, v any) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML, but its quoted strings and flow style lists are
+	// reset to the block style of YAML, e.g. for multiline output.
+	var node yaml.Node
+
+	if err := yaml.Unmarshal(bs, &node); err != nil {
+		return err
+	}
+
+	resetStyle(&node)
+
+	bs, err = yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "---\n%s", bs)
+
+	return err
+}
+
+// resetStyle resets the style of the node and its children to the default.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+const (
+	// progressBarInterval is how often the progress bar is redrawn.
+	progressBarInterval = 100 * time.Millisecond
+	// progressLineInterval is how often a line of progress is written
+	// when stderr is not a terminal, e.g. to a log.
+	progressLineInterval = 5 * time.Second
+	// progressBarWidth is the width of the progress bar in characters.
+	progressBarWidth = 30
+)
+
+// progress reports the progress of the import of a commands file to stderr,
+// see --progress: a progress bar redrawn in place if stderr is a terminal, or
+// else a line of progress every progressLineInterval.
+type progress struct {
+	w    io.Writer
+	tty  bool
+	name string // Name of the commands file.
+
+	// total is the number of lines of the commands file, 0 if it is not
+	// known, e.g. for stdin, in which case there is no ETA.
+	total int
+
+	start    time.Time
+	reported time.Time // Time the progress was last reported.
+	line     int       // Line of the last command executed.
+	commands int       // Number of commands executed.
+}
+
+// newProgress returns the progress of the import of the commands file at path,
+// or stdin if it is "-", reported to stderr.
+func newProgress(path string) *progress {
+	now := time.Now()
+
+	p := &progress{
+		w:        os.Stderr,
+		tty:      isTerminal(os.Stderr),
+		name:     path,
+		start:    now,
+		reported: now,
+	}
+
+	if path == "-" {
+		p.name = "stdin"
+	} else {
+		p.total = countLines(path)
+	}
+
+	return p
+}
+
+// countLines returns the number of lines of the file at path, or 0 if it
+// cannot be read or is compressed or encrypted, since its lines cannot be
+// counted without decoding it.
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	// A gzip compressed file starts with its magic number, and an
+	// encrypted file is not text.
+	if head, _ := br.Peek(2); bytes.Equal(head, []byte{0x1f, 0x8b}) {
+		return 0
+	}
+
+	var (
+		n    int
+		last byte
+		buf  = make([]byte, 64<<10)
+	)
+
+	for {
+		k, err := br.Read(buf)
+		n += bytes.Count(buf[:k], []byte("\n"))
+
+		if k > 0 {
+			last = buf[k-1]
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// The last line may not end with a newline.
+	if last != 0 && last != '\n' {
+		n++
+	}
+
+	return n
+}
+
+// onResult returns an ImportOptions.OnResult that records the progress of
+// each result and calls next, if any.
+func (p *progress) onResult(next func(result library.Result)) func(result library.Result) {
+	return func(result library.Result) {
+		if next != nil {
+			next(result)
+		}
+
+		p.commands++
+		p.line = max(p.line, result.Line)
+
+		interval := progressLineInterval
+		if p.tty {
+			interval = progressBarInterval
+		}
+
+		if time.Since(p.reported) >= interval {
+			p.report()
+		}
+	}
+}
+
+// done reports the final progress once the import is done.
+func (p *progress) done() {
+	p.report()
+
+	if p.tty {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// report writes the progress.
+func (p *progress) report() {
+	p.reported = time.Now()
+
+	elapsed := time.Since(p.start)
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.commands) / elapsed.Seconds()
+	}
+
+	var s strings.Builder
+
+	fmt.Fprintf(&s, "%s: ", p.name)
+
+	if p.total > 0 {
+		line := min(p.line, p.total)
+		fraction := float64(line) / float64(p.total)
+
+		if p.tty {
+			filled := int(fraction * progressBarWidth)
+
+			fmt.Fprintf(&s, "[%s%s] ", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled))
+		}
+
+		fmt.Fprintf(&s, "%3.0f%%, %d/%d lines", fraction*100, line, p.total)
+	} else {
+		fmt.Fprintf(&s, "%d lines", p.line)
+	}
+
+	fmt.Fprintf(&s, ", %.0f commands/s", rate)
+
+	if p.total > 0 && p.line > 0 && p.line < p.total {
+		eta := time.Duration(float64(elapsed) / float64(p.line) * float64(p.total-p.line))
+
+		fmt.Fprintf(&s, ", ETA %s", eta.Round(time.Second))
+	}
+
+	if p.tty {
+		// The line is cleared since it may be shorter than the one it
+		// replaces.
+		fmt.Fprintf(p.w, "\r%s\033[K", s.String())
+	} else {
+		fmt.Fprintln(p.w, s.String())
+	}
+}
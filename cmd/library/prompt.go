@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/admtnnr/library"
+)
+
+// prompt is the prompt written before each line of commands read from a
+// terminal, see promptCommands and the repl subcommand.
+const prompt = "library> "
+
+// promptCommands executes the commands read from stdin, a terminal, as they
+// are entered, e.g. library -, rather than once stdin is closed. The prompt is
+// written to stderr before each line and the output of each command as soon
+// as it is executed. A command that fails is reported without stopping the
+// commands after it, so that a typo does not end the session.
+func promptCommands(l *library.Library, opts library.ImportOptions) error {
+	// Each command of a dry run is executed against its own copy of the
+	// library, so the state the command left is carried to the next.
+	var state *library.Snapshot
+
+	if opts.DryRun {
+		onDryRun := opts.OnDryRun
+
+		opts.OnDryRun = func(s *library.Snapshot) {
+			if onDryRun != nil {
+				onDryRun(s)
+			}
+
+			state = s
+		}
+	}
+
+	li := newLineImporter(l, opts)
+	br := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read stdin, %w", err)
+		}
+
+		if len(line) > 0 {
+			if err := li.importLine(line); err != nil {
+				fmt.Fprintln(os.Stderr, errorText(os.Stderr, err.Error()))
+			}
+
+			if state != nil {
+				if err := l.LoadSnapshot(state); err != nil {
+					return err
+				}
+
+				state = nil
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(os.Stderr)
+
+			return nil
+		}
+	}
+}
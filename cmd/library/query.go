@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// runQuery implements the query subcommand:
+//
+//	library [flags] query book <id>
+//	library [flags] query account <id>
+//	library [flags] query search <query>
+//
+// The DB is loaded and the answer printed as by PRINT_BOOK, PRINT_ACCOUNT or
+// SEARCH_BOOKS, in the format of --output, but the DB is never saved, so
+// that simple lookups do not require authoring a commands file.
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cmd, err := queryCommand(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	opts := library.ImportOptions{}
+
+	if err := setOutput(&opts, os.Stdout); err != nil {
+		return err
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	bs, err := json.Marshal(&library.Invocation{Command: cmd})
+	if err != nil {
+		return err
+	}
+
+	// The query is executed as a commands file of one line, so that its
+	// output is written exactly as it would be by the run subcommand.
+	return l.Import(bytes.NewReader(bs), opts)
+}
+
+// queryCommand returns the command that answers the query of the arguments of
+// the query subcommand.
+func queryCommand(args []string) (any, error) {
+	if len(args) == 0 {
+		return nil, usageErrorf("query takes book, account or search")
+	}
+
+	switch args[0] {
+	case "book", "account":
+		if len(args) != 2 {
+			return nil, usageErrorf("query %s takes the ID of the %s", args[0], args[0])
+		}
+
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, usageErrorf("invalid %s ID %q", args[0], args[1])
+		}
+
+		if args[0] == "book" {
+			return &library.PrintBook{ID: id}, nil
+		}
+
+		return &library.PrintAccount{ID: id}, nil
+	case "search":
+		if len(args) < 2 {
+			return nil, usageErrorf("query search takes the query")
+		}
+
+		// The words of the query may be passed unquoted, e.g. query
+		// search great gatsby.
+		return &library.SearchBooks{Query: strings.Join(args[1:], " ")}, nil
+	default:
+		return nil, usageErrorf("unknown query %q, expected book, account or search", args[0])
+	}
+}
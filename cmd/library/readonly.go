@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/admtnnr/library"
+)
+
+// writingSubcommands are the subcommands that exist to change the DB, or to
+// serve clients that do, which cannot be run with --readonly.
+var writingSubcommands = []string{"restore", "compact", "seed", "serve", "grpc"}
+
+// checkReadOnly returns a usage error if the subcommand cannot be run with
+// --readonly, see writingSubcommands.
+func checkReadOnly(subcommand string) error {
+	if !*readOnly {
+		return nil
+	}
+
+	if slices.Contains(writingSubcommands, subcommand) {
+		return usageErrorf("--readonly cannot be used with the %s subcommand", subcommand)
+	}
+
+	switch {
+	case *checkpoint != "":
+		return usageErrorf("--readonly cannot be used with --checkpoint")
+	case *autosaveN > 0 || *autosaveDur > 0:
+		return usageErrorf("--readonly cannot be used with --autosave-every or --autosave-interval")
+	}
+
+	return nil
+}
+
+// readOnlyStore is a Store that loads the DB but never writes it, for
+// --readonly: Save and Append do nothing, so a file DB is not even exported
+// to a temporary file and renamed.
+type readOnlyStore struct {
+	library.Store
+}
+
+// Save implements library.Store by not saving the library.
+func (s *readOnlyStore) Save(*library.Library) error {
+	return nil
+}
+
+// LoadContext implements library.ContextStore by loading the underlying store
+// with the context, see library.LoadStore.
+func (s *readOnlyStore) LoadContext(ctx context.Context, l *library.Library) error {
+	return library.LoadStore(ctx, s.Store, l)
+}
+
+// SaveContext implements library.ContextStore by not saving the library.
+func (s *readOnlyStore) SaveContext(context.Context, *library.Library) error {
+	return nil
+}
+
+// Append implements library.Store by not persisting the command.
+func (s *readOnlyStore) Append(*library.Library, *library.Invocation) error {
+	return nil
+}
+
+// Ping implements library.Pinger by pinging the underlying store, see
+// library.PingStore.
+func (s *readOnlyStore) Ping(ctx context.Context) error {
+	return library.PingStore(ctx, s.Store)
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/admtnnr/library"
+	"golang.org/x/term"
+)
+
+// runREPL implements the repl subcommand:
+//
+//	library [flags] repl
+//
+// The DB is loaded and the commands are read interactively, one per line, and
+// executed as they are entered, printing their output immediately. Each line
+// is either a command as in a commands file or its shorthand, the name of the
+// command, in any case, or one of replAliases, followed by its arguments
+// either in the order of the fields of the command or as name=value, e.g.:
+//
+//	library> checkout 12 34
+//	library> add_book id=1 name="The Hobbit" count=3 tags=fantasy,classic
+//	library> search gatsby author:fitzgerald
+//
+// From a terminal, the previous lines are recalled with the up and down arrows
+// and the names of the commands are completed with tab. SAVE saves the DB,
+// which is also saved on EXIT or at the end of the input, e.g. Ctrl-D. HELP
+// lists the commands and HELP <command> describes the arguments of one, see
+// runHelp.
+func runREPL(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("repl takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	r := &repl{l: l, store: store, out: &countingWriter{w: os.Stdout}}
+
+	r.opts = library.ImportOptions{
+		OnConflict: library.Conflict(*onConflict),
+		AfterExec: func(inv *library.Invocation) error {
+			return store.Append(l, inv)
+		},
+	}
+
+	if err := setOutput(&r.opts, r.out); err != nil {
+		return err
+	}
+
+	if isTerminal(os.Stdin) {
+		err = r.runTerminal()
+	} else {
+		err = r.run(bufio.NewReader(os.Stdin), os.Stdout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return r.save()
+}
+
+// replAliases are the short names of the commands in the repl.
+var replAliases = map[string]string{
+	"add":      "ADD_BOOK",
+	"checkout": "CHECKOUT_BOOK",
+	"return":   "RETURN_BOOK",
+	"book":     "PRINT_BOOK",
+	"account":  "PRINT_ACCOUNT",
+	"catalog":  "PRINT_CATALOG",
+	"accounts": "PRINT_ACCOUNTS",
+	"search":   "SEARCH_BOOKS",
+	"overdue":  "LIST_OVERDUE",
+	"undo":     "UNDO",
+}
+
+// repl is an interactive session of the repl subcommand.
+type repl struct {
+	l     *library.Library
+	store library.Store
+	opts  library.ImportOptions // Options of the commands, writing to out.
+	out   *countingWriter
+}
+
+// runTerminal runs the session on the terminal of stdin, with the history of
+// the lines and tab completion of the names of the commands.
+func (r *repl) runTerminal() error {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to set up terminal, %w", err)
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+
+	r.out.w = t
+
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+
+		return completeCommand(line, pos)
+	}
+
+	for {
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(t)
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read line, %w", err)
+		}
+
+		if done := r.handle(line, t); done {
+			return nil
+		}
+	}
+}
+
+// run runs the session on lines read from br, e.g. piped to stdin, without a
+// prompt.
+func (r *repl) run(br *bufio.Reader, w io.Writer) error {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read line, %w", err)
+		}
+
+		if done := r.handle(line, w); done || err != nil {
+			return nil
+		}
+	}
+}
+
+// handle handles a line, writing the output of the repl itself to w and that
+// of the commands to out, and reports whether the session is done.
+func (r *repl) handle(line string, w io.Writer) (done bool) {
+	line = strings.TrimSpace(line)
+
+	switch strings.ToUpper(line) {
+	case "":
+		return false
+	case "EXIT", "QUIT":
+		return true
+	case "SAVE":
+		if err := r.save(); err != nil {
+			fmt.Fprintln(w, err)
+		} else {
+			fmt.Fprintln(w, "saved")
+		}
+
+		return false
+	case "HELP":
+		writeREPLHelp(w)
+
+		return false
+	}
+
+	if word, name, ok := strings.Cut(line, " "); ok && strings.EqualFold(word, "HELP") {
+		h, ok := describeCommand(strings.TrimSpace(name))
+		if !ok {
+			fmt.Fprintln(w, errorText(os.Stdout, fmt.Sprintf("unknown command %q, see HELP", strings.TrimSpace(name))))
+
+			return false
+		}
+
+		if err := writeCommandHelp(w, h); err != nil {
+			fmt.Fprintln(w, err)
+		}
+
+		return false
+	}
+
+	cmd, err := parseREPLLine(line)
+	if err != nil {
+		fmt.Fprintln(w, err)
+
+		return false
+	}
+
+	bs, err := json.Marshal(cmd)
+	if err != nil {
+		fmt.Fprintln(w, err)
+
+		return false
+	}
+
+	// The command is executed as a commands file of one line, so that it
+	// behaves exactly as it would in a commands file.
+	r.out.n = 0
+
+	err = r.l.Import(strings.NewReader(string(bs)+"\n"), r.opts)
+
+	// Commands that fail write their own output, but commands that fail
+	// to parse do not.
+	if err != nil && r.out.n == 0 {
+		fmt.Fprintln(w, errorText(os.Stdout, err.Error()))
+	}
+
+	return false
+}
+
+// save saves the DB.
+func (r *repl) save() error {
+	if err := r.store.Save(r.l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+
+	return n, err
+}
+
+// parseREPLLine parses a line of the repl into a command, either a command as
+// in a commands file or its shorthand, see runREPL.
+func parseREPLLine(line string) (*library.Command, error) {
+	if strings.HasPrefix(line, "{") {
+		var cmd library.Command
+
+		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+			return nil, fmt.Errorf("%w, %v", library.ErrInvalidCommand, err)
+		}
+
+		return &cmd, nil
+	}
+
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%w, missing command", library.ErrInvalidCommand)
+	}
+
+	name := strings.ToUpper(words[0])
+	if alias, ok := replAliases[strings.ToLower(words[0])]; ok {
+		name = alias
+	}
+
+	v, ok := library.NewCommand(name)
+	if !ok {
+		return nil, fmt.Errorf("%w, unknown command %q, see HELP", library.ErrInvalidCommand, words[0])
+	}
+
+	fields := commandFields(reflect.TypeOf(v).Elem())
+	args := make(map[string]json.RawMessage)
+
+	var (
+		next int    // Index of the next positional argument.
+		rest string // Value of the last positional argument.
+	)
+
+	for _, word := range words[1:] {
+		key, value, named := strings.Cut(word, "=")
+
+		if !named {
+			if next == len(fields) {
+				// The words after the last argument are part of
+				// it if it is a string, e.g. of a search query.
+				if next == 0 || fields[next-1].Type.Kind() != reflect.String {
+					return nil, fmt.Errorf("%w, too many arguments for %s", library.ErrInvalidArgument, name)
+				}
+
+				rest += " " + word
+				args[fields[next-1].name] = mustMarshal(rest)
+
+				continue
+			}
+
+			key, value, rest = fields[next].name, word, word
+			next++
+		}
+
+		field := slices.IndexFunc(fields, func(f commandField) bool { return f.name == key })
+		if field < 0 {
+			return nil, fmt.Errorf("%w, unknown argument %q of %s", library.ErrInvalidArgument, key, name)
+		}
+
+		args[key] = argumentValue(fields[field].Type, value)
+	}
+
+	bs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &library.Command{Name: name, Arguments: bs}, nil
+}
+
+// commandField is an argument of a command, a JSON field of its type.
+type commandField struct {
+	reflect.StructField
+
+	name string // Name of the argument.
+}
+
+// commandFields returns the arguments of the struct type of a command, in the
+// order of its fields, including the fields of embedded structs.
+func commandFields(t reflect.Type) []commandField {
+	var fields []commandField
+
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, commandFields(f.Type)...)
+
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, commandField{StructField: f, name: name})
+	}
+
+	return fields
+}
+
+// argumentValue returns the JSON value of an argument of the type. Strings
+// are quoted, lists of strings or numbers are comma separated, and the other
+// values are JSON, e.g. numbers and booleans, or else strings, e.g. times, so
+// that an invalid value is reported when the arguments are unmarshaled.
+func argumentValue(t reflect.Type, value string) json.RawMessage {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.String:
+		return mustMarshal(value)
+	case t.Kind() == reflect.Slice && !strings.HasPrefix(value, "["):
+		var items []json.RawMessage
+
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, argumentValue(t.Elem(), strings.TrimSpace(item)))
+		}
+
+		return mustMarshal(items)
+	case json.Valid([]byte(value)):
+		return json.RawMessage(value)
+	default:
+		return mustMarshal(value)
+	}
+}
+
+// mustMarshal returns the JSON encoding of a value that cannot fail to be
+// encoded, e.g. a string.
+func mustMarshal(v any) json.RawMessage {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return bs
+}
+
+// splitWords splits a line into words on spaces, keeping quoted words
+// together, e.g. name="The Hobbit".
+func splitWords(line string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		quoted bool
+	)
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("%w, unterminated quote", library.ErrInvalidCommand)
+	}
+
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// replNames returns the names the first word of a line may be, the commands,
+// their aliases and the commands of the repl itself.
+func replNames() []string {
+	names := append(library.CommandNames(), "SAVE", "HELP", "EXIT")
+
+	for alias := range replAliases {
+		names = append(names, alias)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// completeCommand completes the name of the command being typed at the start
+// of a line, to the longest prefix common to the names it may be.
+func completeCommand(line string, pos int) (string, int, bool) {
+	prefix := line[:pos]
+	if strings.ContainsRune(prefix, ' ') {
+		return "", 0, false
+	}
+
+	var matches []string
+
+	for _, name := range replNames() {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			matches = append(matches, name)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	common := matches[0]
+
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(strings.ToLower(match), strings.ToLower(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(matches) == 1 {
+		common += " "
+	}
+
+	if len(common) <= len(prefix) {
+		return "", 0, false
+	}
+
+	return common + line[pos:], len(common), true
+}
+
+// writeREPLHelp writes the commands of the repl and their arguments.
+func writeREPLHelp(w io.Writer) {
+	writeCommandList(w)
+	fmt.Fprintln(w, "HELP <command> describes the arguments of a command.")
+	fmt.Fprintln(w, "SAVE saves the DB, EXIT saves it and exits.")
+}
+
+// writeCommandList writes the commands, with their shorthand aliases, and
+// their arguments in order.
+func writeCommandList(w io.Writer) {
+	aliases := make(map[string]string)
+
+	for alias, name := range replAliases {
+		aliases[name] = alias
+	}
+
+	fmt.Fprintln(w, "Commands, with their arguments in order:")
+
+	for _, name := range library.CommandNames() {
+		v, _ := library.NewCommand(name)
+
+		var args []string
+
+		for _, f := range commandFields(reflect.TypeOf(v).Elem()) {
+			args = append(args, f.name)
+		}
+
+		line := "  " + strings.ToLower(name)
+		if alias, ok := aliases[name]; ok {
+			line += " (" + alias + ")"
+		}
+
+		fmt.Fprintln(w, strings.TrimRight(line+" "+strings.Join(args, " "), " "))
+	}
+}
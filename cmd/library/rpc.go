@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/admtnnr/library"
+)
+
+// runRPC implements the rpc subcommand:
+//
+//	library [flags] rpc
+//
+// The DB is loaded and driven by JSON-RPC 2.0 requests read from stdin, one
+// request or batch of requests per line, with the responses written to stdout
+// one per line, e.g.:
+//
+//	--> {"jsonrpc":"2.0","id":1,"method":"execute","params":{"name":"CHECKOUT_BOOK","arguments":{"bookId":1,"accountId":1}}}
+//	<-- {"jsonrpc":"2.0","id":1,"result":{"output":"...","result":{"command":"CHECKOUT_BOOK","status":"ok","bookIds":[1],"accountIds":[1]}}}
+//
+// so that editor plugins and orchestration tools can execute commands one at
+// a time and react to each result, rather than writing a whole commands file.
+// The methods are:
+//
+//	execute   execute the command of the params, see rpcServer.execute
+//	sequence  the sequence number of the last change, see library.Change
+//	save      save the DB
+//
+// Each command is appended to the store as it is executed, and the DB is saved
+// once stdin is closed. Destructive commands are executed without
+// confirmation, as stdin is the protocol rather than a terminal.
+func runRPC(args []string) error {
+	fs := flag.NewFlagSet("rpc", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("rpc takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	s := &rpcServer{l: l, store: store}
+
+	if err := serveRPC(os.Stdin, os.Stdout, s); err != nil {
+		return err
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	return nil
+}
+
+// The codes of the errors of the JSON-RPC responses. Those other than
+// rpcCommandFailed are defined by the JSON-RPC 2.0 specification.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// rpcCommandFailed is the code of the error of an execute request
+	// whose command failed, with the rpcExecuteResult of the command as
+	// its data.
+	rpcCommandFailed = -32000
+)
+
+// rpcRequest is a JSON-RPC request, or a notification if it has no ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// rpcResponse is a JSON-RPC response.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a JSON-RPC response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// rpcExecuteResult is the result of an execute request.
+type rpcExecuteResult struct {
+	Output string         `json:"output"`
+	Result library.Result `json:"result"`
+}
+
+// rpcHandler handles the methods of JSON-RPC requests, see serveRPC.
+type rpcHandler interface {
+	// call calls a method with its params and returns its result, or
+	// the error of the response.
+	call(method string, params json.RawMessage) (any, *rpcError)
+}
+
+// rpcServer serves JSON-RPC requests to drive a library DB, see runRPC.
+type rpcServer struct {
+	l     *library.Library
+	store library.Store
+}
+
+// serveRPC reads the requests from r, one request or batch of requests per
+// line, and writes the responses of h to w until r is closed.
+func serveRPC(r io.Reader, w io.Writer, h rpcHandler) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read request, %w", err)
+		}
+
+		if resp := handleRPCLine(h, line); resp != nil {
+			if _, err := bw.Write(append(resp, '\n')); err != nil {
+				return fmt.Errorf("failed to write response, %w", err)
+			}
+
+			if err := bw.Flush(); err != nil {
+				return fmt.Errorf("failed to write response, %w", err)
+			}
+		}
+
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// handleRPCLine handles the request or batch of requests of a line and
+// returns the encoded response, or nil if there is none, e.g. for a
+// notification.
+func handleRPCLine(h rpcHandler, line []byte) []byte {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	if line[0] != '[' {
+		resp := handleRPC(h, line)
+		if resp == nil {
+			return nil
+		}
+
+		return encodeRPC(resp)
+	}
+
+	var batch []json.RawMessage
+
+	if err := json.Unmarshal(line, &batch); err != nil {
+		return encodeRPC(errorResponse(nil, rpcParseError, err.Error()))
+	}
+
+	if len(batch) == 0 {
+		return encodeRPC(errorResponse(nil, rpcInvalidRequest, "empty batch"))
+	}
+
+	var resps []*rpcResponse
+
+	for _, raw := range batch {
+		if resp := handleRPC(h, raw); resp != nil {
+			resps = append(resps, resp)
+		}
+	}
+
+	if len(resps) == 0 {
+		return nil
+	}
+
+	return encodeRPC(resps)
+}
+
+// handleRPC handles a request and returns its response, or nil if it is a
+// notification.
+func handleRPC(h rpcHandler, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return errorResponse(nil, rpcParseError, err.Error())
+		}
+
+		return errorResponse(nil, rpcInvalidRequest, err.Error())
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, rpcInvalidRequest, `request must have jsonrpc "2.0" and a method`)
+	}
+
+	result, rerr := h.call(req.Method, req.Params)
+
+	if req.ID == nil {
+		return nil
+	}
+
+	if rerr != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: rerr}
+	}
+
+	bs, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, rpcInternalError, err.Error())
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: bs}
+}
+
+// call implements rpcHandler.
+func (s *rpcServer) call(method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "execute":
+		return s.execute(params)
+	case "sequence":
+		return s.l.Sequence(), nil
+	case "save":
+		if err := s.store.Save(s.l); err != nil {
+			return nil, &rpcError{Code: rpcInternalError, Message: fmt.Sprintf("failed to save library state to DB, %v", err)}
+		}
+
+		return true, nil
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+// execute executes the command of the params, which are a command as in a
+// commands file, e.g. {"name":"ADD_BOOK","arguments":{...}}, and returns its
+// output and result. If the command fails, the error has the code
+// rpcCommandFailed and the output and result as its data.
+func (s *rpcServer) execute(params json.RawMessage) (any, *rpcError) {
+	var inv library.Invocation
+
+	if err := json.Unmarshal(params, &inv); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("%v, %v", library.ErrInvalidCommand, err)}
+	}
+
+	err := inv.Exec(s.l)
+	if err == nil {
+		err = s.store.Append(s.l, &inv)
+	}
+
+	result := rpcExecuteResult{Output: inv.Output, Result: inv.Result}
+
+	if err != nil {
+		return nil, &rpcError{Code: rpcCommandFailed, Message: err.Error(), Data: result}
+	}
+
+	return result, nil
+}
+
+// encodeRPC encodes a response, or a batch of responses.
+func encodeRPC(resp any) []byte {
+	bs, err := json.Marshal(resp)
+	if err != nil {
+		bs, _ = json.Marshal(errorResponse(nil, rpcInternalError, err.Error()))
+	}
+
+	return bs
+}
+
+// errorResponse returns a response with an error.
+func errorResponse(id json.RawMessage, code int, msg string) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}}
+}
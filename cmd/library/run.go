@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// runRun implements the run subcommand:
+//
+//	library [flags] run <commands-file>...
+//
+// The commands of the commands files, or stdin for "-", are executed against
+// the DB, one file after another in order, and the DB is saved once they all
+// succeed, e.g. library run setup.ndjson daily.ndjson - executes the commands
+// of stdin after those of the files. When stdin is a terminal, its commands are
+// executed as they are entered, see promptCommands. The run subcommand may be
+// omitted, e.g. library commands.ndjson, except for a commands file named like
+// a subcommand, which is run as e.g. library ./backup.
+//
+// A commands file has a command per line as a JSON object, e.g.:
+//
+//	{"name": "ADD_BOOK", "arguments": {"id": 1, "name": "The Hobbit", "count": 3}}
+//
+// see library help for the commands. Empty lines and lines starting with # or
+// // are ignored, so that commands files can be annotated with comments. A file
+// with a .csv extension, or any file with --csv, is read as CSV rows of the
+// command of --csv or of a "command" column, with a header naming the argument
+// of each column, and one with a .xml or .onix extension as a publisher ONIX
+// 3.0 product feed of books to add, see library.Library.ImportONIX.
+//
+// If a command fails, the changes of the commands before it are not saved,
+// except with --store bolt or wal, which persist every command as it is
+// executed, or as of the last save of --autosave-every or --autosave-interval.
+// With --checkpoint, which requires one of those stores, running a commands
+// file that failed again resumes it after the last command executed.
+//
+// When stdin is a terminal, destructive commands such as REMOVE_COPIES are
+// confirmed before they are executed unless --yes is set. With --on-conflict,
+// an ADD_BOOK or CREATE_ACCOUNT of an existing ID is skipped, overwrites it, or
+// is merged into it rather than failing. With --output json or yaml, the
+// outcome of each command is written rather than its output, see setOutput.
+// With --dry-run, the commands are executed against a copy of the DB and what
+// they would change is printed, but the DB is not saved.
+//
+// With --follow, the commands appended to the commands file, or written to it
+// if it is a named pipe, are executed as they arrive until the process is
+// interrupted, so that other processes can feed the library continuously, see
+// followCommands.
+//
+// With --progress, the progress of each commands file is reported to stderr,
+// the lines executed, the commands per second and, for a file whose lines can
+// be counted, the percentage and ETA, see progress.
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	args = fs.Args()
+
+	if len(args) == 0 {
+		return usageErrorf("run takes one or more commands files")
+	}
+
+	var stdin int
+
+	for _, path := range args {
+		if path == "-" {
+			stdin++
+		}
+	}
+
+	if stdin > 1 {
+		return usageErrorf("run reads stdin at most once")
+	}
+
+	if *checkpoint != "" && len(args) > 1 {
+		return usageErrorf("--checkpoint cannot be used with more than one commands file")
+	}
+
+	if *follow {
+		switch {
+		case len(args) > 1 || args[0] == "-":
+			return usageErrorf("--follow takes a single commands file other than stdin")
+		case *dryRun || *checkpoint != "":
+			return usageErrorf("--follow cannot be used with --dry-run or --checkpoint")
+		case *showProgress:
+			return usageErrorf("--follow cannot be used with --progress")
+		case *csvCommand != "" || slices.Contains([]string{".csv", ".xml", ".onix"}, strings.ToLower(filepath.Ext(args[0]))):
+			return usageErrorf("--follow only supports NDJSON commands files")
+		}
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The simulated clock starts at the current time so that the commands
+	// behave as they would with the real clock until they WAIT.
+	if *simulate {
+		l.SetClock(library.NewSimulatedClock(time.Now()))
+	}
+
+	opts := library.ImportOptions{OnConflict: library.Conflict(*onConflict)}
+
+	if err := setOutput(&opts, os.Stdout); err != nil {
+		return err
+	}
+
+	if *resultsPath != "" {
+		results, err := os.Create(*resultsPath)
+		if err != nil {
+			return fmt.Errorf("failed to create results file, %w", err)
+		}
+		defer results.Close()
+
+		opts.ResultWriter = results
+	}
+
+	// Stores that persist every command, e.g. the bolt and wal stores,
+	// do so as they are executed rather than only once all of the commands
+	// succeed.
+	opts.AfterExec = func(inv *library.Invocation) error {
+		return store.Append(l, inv)
+	}
+
+	if !*yes && isTerminal(os.Stdin) {
+		// The confirmations are read from the terminal rather than
+		// stdin since the commands may be read from stdin.
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+
+			opts.Confirm = confirm(tty, os.Stderr)
+		}
+	}
+
+	if *follow {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return followCommands(ctx, l, store, args[0], opts)
+	}
+
+	// after is the state of the copy of the DB the commands of a dry run
+	// are executed against.
+	var after *library.Snapshot
+
+	if *dryRun {
+		if *checkpoint != "" {
+			return usageErrorf("--dry-run cannot be used with --checkpoint")
+		}
+
+		// The changes are written after the output of the commands,
+		// to stderr unless the output is text so that it can still be
+		// parsed.
+		w := os.Stdout
+		if *output != "text" {
+			w = os.Stderr
+		}
+
+		before := l.Snapshot()
+		after = before
+
+		// Each file is executed against a copy of the state the file
+		// before it left, which is never saved.
+		opts.DryRun = true
+		opts.OnDryRun = func(state *library.Snapshot) {
+			after = state
+		}
+
+		defer func() {
+			writeDryRunDiff(w, before, after)
+		}()
+	}
+
+	if *checkpoint != "" {
+		if err := checkpointImport(*checkpoint, &opts); err != nil {
+			return err
+		}
+	}
+
+	for _, commandsPath := range args {
+		fileOpts := opts
+
+		var p *progress
+
+		if *showProgress {
+			p = newProgress(commandsPath)
+			fileOpts.OnResult = p.onResult(opts.OnResult)
+		}
+
+		err := importCommands(l, commandsPath, fileOpts)
+
+		if p != nil {
+			p.done()
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to execute commands from %s, %w", commandsPath, err)
+		}
+
+		if *dryRun {
+			if err := l.LoadSnapshot(after); err != nil {
+				return fmt.Errorf("failed to execute commands from %s, %w", commandsPath, err)
+			}
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	// The library state is replaced atomically so that we do not lose or
+	// corrupt the existing library state if the save fails.
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	// The checkpoint is only removed once the commands are saved, so that
+	// they are not executed again.
+	if *checkpoint != "" {
+		if err := removeCheckpoint(*checkpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// The words the books and accounts of the seed subcommand are made of.
+var (
+	seedAdjectives = []string{
+		"Silent", "Hidden", "Last", "Broken", "Golden", "Forgotten", "Crimson", "Endless", "Winter", "Distant",
+		"Burning", "Secret", "Quiet", "Wild", "Lost", "Midnight", "Shattered", "Hollow", "Bright", "Iron",
+	}
+	seedNouns = []string{
+		"River", "Garden", "Kingdom", "Letter", "Storm", "Island", "Mirror", "Harbor", "Forest", "Song",
+		"Empire", "Lighthouse", "Orchard", "Voyage", "Crown", "Shadow", "Bridge", "Archive", "Tide", "Compass",
+	}
+	seedPlaces = []string{
+		"Venice", "the North", "Avalon", "the Valley", "Prague", "the Desert", "Kyoto", "the City", "Lisbon", "the Sea",
+	}
+	seedFirstNames = []string{
+		"Ada", "Alan", "Grace", "Linus", "Margaret", "Dennis", "Barbara", "Ken", "Frances", "Edsger",
+		"Radia", "Donald", "Katherine", "John", "Hedy", "Tim", "Sophie", "Niklaus", "Joan", "Rob",
+	}
+	seedLastNames = []string{
+		"Lovelace", "Turing", "Hopper", "Torvalds", "Hamilton", "Ritchie", "Liskov", "Thompson", "Allen", "Dijkstra",
+		"Perlman", "Knuth", "Johnson", "McCarthy", "Lamarr", "Berners-Lee", "Wilson", "Wirth", "Clarke", "Pike",
+	}
+	seedTags = []string{
+		"fiction", "fantasy", "mystery", "romance", "history", "science", "poetry", "biography", "travel", "children",
+	}
+)
+
+// seeder generates the books, accounts and checkouts of the seed subcommand
+// from a seeded source, so that the same seed generates the same dataset.
+type seeder struct {
+	l   *library.Library
+	rnd *rand.Rand
+	now time.Time
+
+	books, accounts []int // IDs of the books and accounts generated.
+}
+
+// runSeed implements the seed subcommand:
+//
+//	library [flags] seed [--books 1000] [--accounts 200] [--checkouts 500] [--history 2000] [--seed 1]
+//
+// A reproducible dataset is generated into the DB for demos, benchmarks and
+// load tests: --books books with titles, authors, valid ISBNs, tags and a few
+// copies each, --accounts accounts, --checkouts books checked out over the
+// last loan period and a half, so that about a third of them are overdue, and
+// --history checkouts returned over the last two years, with the popular
+// books checked out far more often than the others. The same --seed generates
+// the same dataset, with the dates relative to the time it is generated.
+// The IDs follow those of the books and accounts already in the DB.
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+
+	books := fs.Int("books", 1000, "number of books to generate")
+	accounts := fs.Int("accounts", 200, "number of accounts to generate")
+	checkouts := fs.Int("checkouts", 500, "number of books to check out")
+	history := fs.Int("history", 2000, "number of returned checkouts to generate")
+	seed := fs.Uint64("seed", 1, "seed of the generated dataset")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("seed takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := newSeeder(l, *seed).seed(*books, *accounts, *checkouts, *history); err != nil {
+		return err
+	}
+
+	if err := store.Save(l); err != nil {
+		return fmt.Errorf("failed to save library state to DB, %w", err)
+	}
+
+	slog.Info("seeded", "db", *dbPath, "books", *books, "accounts", *accounts, "checkouts", *checkouts, "history", *history, "seed", *seed)
+
+	return nil
+}
+
+// newSeeder returns a seeder of the library with the seed.
+func newSeeder(l *library.Library, seed uint64) *seeder {
+	return &seeder{
+		l:   l,
+		rnd: rand.New(rand.NewPCG(seed, seed)),
+		now: l.Now().Truncate(time.Second),
+	}
+}
+
+// seed generates the books, accounts, checkouts and history, see runSeed.
+func (s *seeder) seed(books, accounts, checkouts, history int) error {
+	switch limit := s.l.Policy().CheckoutLimit; {
+	case books < 0 || accounts < 0 || checkouts < 0 || history < 0:
+		return usageErrorf("--books, --accounts, --checkouts and --history must not be negative")
+	case (checkouts > 0 || history > 0) && (books == 0 || accounts == 0):
+		return usageErrorf("--checkouts and --history require --books and --accounts")
+	case checkouts > accounts*limit:
+		return usageErrorf("--checkouts must be at most %d, the checkout limit of %d books of each of the %d accounts", accounts*limit, limit, accounts)
+	}
+
+	if err := s.seedBooks(books); err != nil {
+		return err
+	}
+
+	if err := s.seedAccounts(accounts); err != nil {
+		return err
+	}
+
+	if err := s.seedCheckouts(checkouts); err != nil {
+		return err
+	}
+
+	return s.seedHistory(history)
+}
+
+// seedBooks adds n books after the books of the library.
+func (s *seeder) seedBooks(n int) error {
+	next := 1
+
+	s.l.EachBook(func(book *library.Book) { next = max(next, book.ID+1) })
+
+	for id := next; id < next+n; id++ {
+		// Most books have a copy or two, a few have many.
+		count := 1 + int(s.rnd.ExpFloat64())
+
+		if err := s.l.AddBook(id, s.title(), count); err != nil {
+			return fmt.Errorf("failed to seed book (%d), %w", id, err)
+		}
+
+		meta := library.BookMetadata{
+			Author: s.name(),
+			ISBN:   s.isbn(),
+			Tags:   s.tags(),
+		}
+
+		if err := s.l.SetBookMetadata(id, meta); err != nil {
+			return fmt.Errorf("failed to seed book (%d), %w", id, err)
+		}
+
+		s.books = append(s.books, id)
+	}
+
+	return nil
+}
+
+// seedAccounts creates n accounts after the accounts of the library.
+func (s *seeder) seedAccounts(n int) error {
+	next := 1
+
+	s.l.EachAccount(func(account *library.Account) { next = max(next, account.ID+1) })
+
+	for id := next; id < next+n; id++ {
+		if err := s.l.CreateAccount(id, s.name()); err != nil {
+			return fmt.Errorf("failed to seed account (%d), %w", id, err)
+		}
+
+		s.accounts = append(s.accounts, id)
+	}
+
+	return nil
+}
+
+// seedCheckouts checks out n books, with the copies of a book and the checkout
+// limit of an account respected, over the last loan period and a half.
+func (s *seeder) seedCheckouts(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	book := s.popularity()
+	period := s.l.Policy().LoanPeriod
+
+	// Every account may check out the same number of books, so that n
+	// checkouts are possible, see runSeed, but the popular books may be
+	// checked out too often to be checked out at all, so a book is chosen
+	// a number of times before giving up.
+	for i, attempts := 0, 0; i < n; attempts++ {
+		if attempts > 100*n {
+			return fmt.Errorf("failed to seed checkouts, only %d of %d books could be checked out, add more books", i, n)
+		}
+
+		accountID := s.accounts[s.rnd.IntN(len(s.accounts))]
+		bookID := s.books[book()]
+
+		if len(s.l.CheckoutsByBook(bookID)) >= s.l.Book(bookID).Count {
+			continue
+		}
+
+		at := s.now.Add(-time.Duration(s.rnd.Int64N(int64(period) * 3 / 2))).Truncate(time.Second)
+
+		if err := s.l.CheckoutBookAt(accountID, bookID, at); err != nil {
+			// The account is at its limit or already has the book.
+			continue
+		}
+
+		i++
+	}
+
+	return nil
+}
+
+// seedHistory adds n checkouts returned over the last two years.
+func (s *seeder) seedHistory(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	book := s.popularity()
+	late := s.l.Policy().LoanPeriod + 7*24*time.Hour
+
+	for i := 0; i < n; i++ {
+		accountID := s.accounts[s.rnd.IntN(len(s.accounts))]
+		bookID := s.books[book()]
+
+		// The books are returned up to a week after they are due, which
+		// is before now.
+		ago := late + time.Duration(s.rnd.Int64N(int64(2*365*24*time.Hour-late)))
+
+		checkedOut := s.now.Add(-ago).Truncate(time.Second)
+		returned := checkedOut.Add(time.Duration(s.rnd.Int64N(int64(late)))).Truncate(time.Second)
+
+		if err := s.l.AddHistory(accountID, bookID, checkedOut, returned); err != nil {
+			return fmt.Errorf("failed to seed history, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// popularity returns a function that chooses the index of a book, the first
+// books far more often than the last, as the checkouts of a real catalog are
+// concentrated on its popular books.
+func (s *seeder) popularity() func() int {
+	zipf := rand.NewZipf(s.rnd, 1.1, 10, uint64(len(s.books)-1))
+
+	// The popular books are spread over the catalog rather than being the
+	// books with the lowest IDs.
+	order := s.rnd.Perm(len(s.books))
+
+	return func() int { return order[zipf.Uint64()] }
+}
+
+// title returns a book title, e.g. The Silent River.
+func (s *seeder) title() string {
+	adjective := seedAdjectives[s.rnd.IntN(len(seedAdjectives))]
+	noun := seedNouns[s.rnd.IntN(len(seedNouns))]
+	other := seedNouns[s.rnd.IntN(len(seedNouns))]
+
+	switch s.rnd.IntN(4) {
+	case 0:
+		return "The " + adjective + " " + noun
+	case 1:
+		return "The " + noun + " of the " + other
+	case 2:
+		article := "A "
+		if strings.ContainsAny(noun[:1], "AEIOU") {
+			article = "An "
+		}
+
+		return article + noun + " in " + seedPlaces[s.rnd.IntN(len(seedPlaces))]
+	default:
+		return adjective + " " + noun + "s"
+	}
+}
+
+// name returns a person's name, e.g. Ada Lovelace.
+func (s *seeder) name() string {
+	return seedFirstNames[s.rnd.IntN(len(seedFirstNames))] + " " + seedLastNames[s.rnd.IntN(len(seedLastNames))]
+}
+
+// isbn returns an ISBN-13 with a valid check digit.
+func (s *seeder) isbn() string {
+	digits := fmt.Sprintf("978%09d", s.rnd.IntN(1_000_000_000))
+
+	var sum int
+
+	for i, d := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+
+		sum += int(d-'0') * weight
+	}
+
+	return fmt.Sprintf("%s%d", digits, (10-sum%10)%10)
+}
+
+// tags returns one to three distinct tags.
+func (s *seeder) tags() []string {
+	perm := s.rnd.Perm(len(seedTags))
+	tags := make([]string, 1+s.rnd.IntN(3))
+
+	for i := range tags {
+		tags[i] = seedTags[perm[i]]
+	}
+
+	return tags
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/apikey"
+	"github.com/admtnnr/library/graphql"
+	"github.com/admtnnr/library/metrics"
+	"github.com/admtnnr/library/ratelimit"
+	"github.com/admtnnr/library/sru"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// runServe implements the serve subcommand:
+//
+//	library [flags] serve [--addr localhost:8080] [--keys keys.json] [--rate 0 --burst 10] [--webhooks config.json] [--tls-cert cert.pem --tls-key key.pem | --autocert domains] [--cors-origins origins] [--max-body 33554432] [--allow-file-commands]
+//	library serve [--addr localhost:8080] [--rate 0 --burst 10] --tenants tenants.json
+//
+// The DB is loaded and served over HTTP until the process is interrupted:
+//
+//	/graphql   GraphQL queries of the books and accounts, see the graphql package
+//	/sru       SRU searches of the catalog for other library systems, with
+//	           Dublin Core or MARCXML records, see the sru package
+//	/commands  POST a commands file to execute it, with the results streamed as
+//	           server-sent events if accepted, see handleCommands
+//	/events    WebSocket of the events of the changes, see eventsHandler
+//	/healthz   200 OK while the server is running, see handleHealthz
+//	/readyz    200 OK once the DB is loaded if its storage is reachable, see
+//	           handleReadyz
+//	/version   version of the binary, see handleVersion
+//	/metrics   Prometheus metrics, see the metrics package
+//	/          admin UI to browse the catalog, look up patrons, and check out
+//	           and return books, see uiHandler
+//
+// The server listens before the DB is loaded, and the other paths respond
+// with 503 Service Unavailable until it is, so that it can run behind liveness
+// and readiness probes. The health and metrics paths never require an API key.
+//
+// With --keys, each request requires the token of an API key of the keys file,
+// see the keys subcommand: /graphql, /sru and /events a key with the read
+// scope, and /commands one with the write scope.
+//
+// With --webhooks, the events are also POSTed to the webhook targets of the
+// config file as they happen, see startWebhooks.
+//
+// With --rate, the requests of each client, identified by its IP address, are
+// limited to --rate per second, with bursts of up to --burst requests, and
+// those over the limit are rejected with 429 Too Many Requests, so that one
+// client cannot monopolize the library. The requests are limited before their
+// API keys are checked, so that a client cannot guess keys at any rate.
+//
+// With --tenants, the libraries of the tenants of the config file are served
+// instead of --db, each with its own DB, API keys, webhooks and quota, under
+// /t/{tenant}/ or for the tenant of the X-Library-Tenant header, see
+// tenantsHandler.
+//
+// With --tls-cert and --tls-key, or --autocert, the server serves HTTPS, so
+// that it can be exposed directly to browsers, rather than behind a proxy
+// that terminates TLS. --autocert gets the certificates of the domains from
+// Let's Encrypt, which validates them with TLS-ALPN-01, so the server must be
+// reachable on port 443 of the domains, and caches them in --autocert-cache.
+//
+// With --cors-origins, browsers allow pages of the origins, or of every
+// origin if "*", to make cross-origin requests, see corsHandler.
+//
+// The bodies of the requests, e.g. commands files, are limited to --max-body
+// bytes, and larger requests are rejected with 413 Content Too Large, see
+// limitBody.
+//
+// With --autosave-every or --autosave-interval, each DB is also saved
+// periodically as the commands of the requests are executed, rather than only
+// once each commands file is, see newServer.
+//
+// The commands of the requests cannot read or write the files of the server,
+// e.g. with INCLUDE or EXPORT, which are rejected with the FILE_ACCESS error
+// code, since a client could otherwise read or overwrite any file the server
+// can, such as the DB of another tenant, see library.Library.SetFileAccess.
+// With --allow-file-commands, they are executed as by the CLI, for a server
+// whose clients are all trusted with the files of the server, whichever
+// address it listens on. It cannot be set with --tenants.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	keysPath := fs.String("keys", "", "path to the API keys file to require keys of, see the keys subcommand")
+	rateLimit := fs.Float64("rate", 0, "requests per second of each client, unlimited if 0")
+	burst := fs.Int("burst", 10, "requests each client can make at once with --rate")
+	webhooks := fs.String("webhooks", "", "path to the config file of the webhook targets to deliver the events to")
+	tenantsPath := fs.String("tenants", "", "path to the config file of the tenants to host instead of --db")
+	tlsCert := fs.String("tls-cert", "", "path to the PEM certificate to serve HTTPS with, with --tls-key")
+	tlsKey := fs.String("tls-key", "", "path to the PEM private key of --tls-cert")
+	autocertDomains := fs.String("autocert", "", "comma-separated domains to serve HTTPS for with certificates from Let's Encrypt")
+	autocertCache := fs.String("autocert-cache", "autocert", "directory to cache the certificates of --autocert in")
+	corsOrigins := fs.String("cors-origins", "", "comma-separated origins allowed to make cross-origin requests, or *")
+	maxBody := fs.Int64("max-body", 32<<20, "maximum size in bytes of the body of a request, unlimited if 0")
+	allowFiles := fs.Bool("allow-file-commands", false, "execute the commands that read or write files, e.g. INCLUDE and EXPORT, which are rejected by default")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("serve takes no arguments")
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return usageErrorf("--tls-cert and --tls-key must be set together")
+	}
+
+	if *tlsCert != "" && *autocertDomains != "" {
+		return usageErrorf("--autocert cannot be set with --tls-cert")
+	}
+
+	var limiter *ratelimit.Limiter
+
+	if *rateLimit > 0 {
+		limiter = &ratelimit.Limiter{Rate: *rateLimit, Burst: *burst}
+	}
+
+	var (
+		handler http.Handler
+		servers []*server
+	)
+
+	if *tenantsPath != "" {
+		if *keysPath != "" || *webhooks != "" {
+			return usageErrorf("--keys and --webhooks are configured for each tenant with --tenants")
+		}
+
+		// The tenants could read and overwrite the DBs of one another.
+		if *allowFiles {
+			return usageErrorf("--allow-file-commands cannot be used with --tenants")
+		}
+
+		config, err := loadTenants(*tenantsPath)
+		if err != nil {
+			return err
+		}
+
+		handler, servers, err = tenantsHandler(config, limiter)
+		if err != nil {
+			return err
+		}
+	} else {
+		store, err := openStore(*storeKind, *dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open library DB, %w", err)
+		}
+
+		s := newServer(*dbPath, store)
+		s.limiter = limiter
+		s.webhooks = *webhooks
+		s.fileAccess = *allowFiles
+
+		if *keysPath != "" {
+			s.keys = &apikey.File{Path: *keysPath}
+		}
+
+		handler = s.handler()
+		servers = []*server{s}
+	}
+
+	for _, s := range servers {
+		defer s.store.Close()
+	}
+
+	if *maxBody > 0 {
+		handler = limitBody(*maxBody, handler)
+	}
+
+	if origins := splitList(*corsOrigins); len(origins) > 0 {
+		handler = corsHandler(origins, handler)
+	}
+
+	handler = logRequests(handler)
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second, MaxHeaderBytes: 64 << 10}
+
+	if domains := splitList(*autocertDomains); len(domains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+
+		srv.TLSConfig = m.TLSConfig()
+	} else if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate, %w", err)
+		}
+
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s, %w", *addr, err)
+	}
+
+	scheme := "http"
+	if srv.TLSConfig != nil {
+		scheme = "https"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Shutdown does not wait for the WebSockets, which are hijacked
+		// connections, and they are closed when the process exits.
+		srv.Shutdown(shutdown)
+	}()
+
+	// The server is started before the DBs are loaded so that /healthz
+	// responds while a large DB is loaded, and /readyz once it is.
+	served := make(chan error, 1)
+
+	go func() {
+		if srv.TLSConfig != nil {
+			// The certificates are those of the TLSConfig.
+			served <- srv.ServeTLS(lis, "", "")
+
+			return
+		}
+
+		served <- srv.Serve(lis)
+	}()
+
+	name := *dbPath
+	if *tenantsPath != "" {
+		name = fmt.Sprintf("%d tenants", len(servers))
+	}
+
+	slog.Info("serving", "db", name, "addr", fmt.Sprintf("%s://%s", scheme, lis.Addr()))
+
+	defer func() {
+		for _, s := range servers {
+			if s.stopWebhooks != nil {
+				s.stopWebhooks()
+			}
+		}
+	}()
+
+	if err := loadServers(servers); err != nil {
+		stop()
+		<-served
+
+		return err
+	}
+
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve, %w", err)
+	}
+
+	return nil
+}
+
+// server serves a library DB over HTTP, see runServe.
+type server struct {
+	name    string // Name of the DB, e.g. its path, for errors.
+	l       *library.Library
+	store   library.Store
+	metrics *metrics.Metrics
+
+	// keys, if set, are the API keys the requests require.
+	keys *apikey.File
+	// limiter, if set, limits the rate of the requests of each client.
+	limiter *ratelimit.Limiter
+	// webhooks, if set, is the path of the config file of the webhook
+	// targets to deliver the events to.
+	webhooks string
+	// quota is the quota of the library once it is loaded, see
+	// library.Library.SetQuota.
+	quota library.Quota
+	// fileAccess allows the commands of the requests to read and write
+	// files once the library is loaded, see --allow-file-commands.
+	fileAccess bool
+
+	// mu serializes the commands files so that the commands of each are
+	// executed and saved together.
+	mu sync.Mutex
+
+	// ready is set once the DB is loaded, see load.
+	ready atomic.Bool
+	// stopWebhooks stops delivering the events to the webhooks, see
+	// startWebhooks, once the DB is loaded.
+	stopWebhooks func()
+}
+
+// newServer returns a server of the library DB of a store, which is also saved
+// periodically with --autosave-every or --autosave-interval, as with the run
+// subcommand.
+func newServer(name string, store library.Store) *server {
+	l := library.New()
+	m := metrics.New(l)
+
+	// The saves of the autosave store are observed too.
+	store = m.Store(store)
+
+	if *autosaveN > 0 || *autosaveDur > 0 {
+		store = &library.AutosaveStore{Store: store, Every: *autosaveN, Interval: *autosaveDur}
+	}
+
+	return &server{name: name, l: l, store: store, metrics: m}
+}
+
+// handler returns the handler of the paths of the server, see runServe.
+func (s *server) handler() http.Handler {
+	// Without a keys file every request is allowed, relying on the server
+	// only being reachable from trusted clients.
+	require := func(scope apikey.Scope, h http.Handler) http.Handler { return h }
+
+	if s.keys != nil {
+		require = s.keys.Require
+	}
+
+	// The requests are limited before they are authenticated, so that
+	// the requests with invalid keys are limited too, e.g. of a client
+	// guessing keys, and the clients are identified by their addresses.
+	limit := func(h http.Handler) http.Handler { return h }
+
+	if s.limiter != nil {
+		limit = s.limiter.Handler
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", s.whenReady(limit(require(apikey.ScopeRead, &graphql.Handler{Library: s.l}))))
+	mux.Handle("/sru", s.whenReady(limit(require(apikey.ScopeRead, &sru.Handler{Library: s.l}))))
+	mux.Handle("/commands", s.whenReady(limit(require(apikey.ScopeWrite, http.HandlerFunc(s.handleCommands)))))
+	mux.Handle("/events", s.whenReady(limit(require(apikey.ScopeRead, eventsHandler(s.l)))))
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/version", handleVersion)
+	mux.Handle("/metrics", s.metrics.Handler())
+	mux.Handle("/", uiHandler())
+
+	return mux
+}
+
+// loadServers loads the DBs of the servers concurrently, see server.load, and
+// returns the first error.
+func loadServers(servers []*server) error {
+	errs := make(chan error, len(servers))
+
+	for _, s := range servers {
+		go func(s *server) {
+			errs <- s.load()
+		}(s)
+	}
+
+	var err error
+
+	for range servers {
+		if lerr := <-errs; lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+
+	return err
+}
+
+// load loads the DB, starts delivering the events to the webhook targets, if
+// any, and marks the server ready to serve the library.
+func (s *server) load() error {
+	if err := s.store.Load(s.l); err != nil {
+		return fmt.Errorf("failed to load library DB from %s, %w", s.name, err)
+	}
+
+	if err := applyConfigPolicy(s.l); err != nil {
+		return err
+	}
+
+	// Only the commands executed by the server can be undone, not the
+	// loading of the existing library state.
+	s.l.ClearUndo()
+
+	// The commands of the clients cannot access files unless allowed,
+	// which is only set once the DB is loaded in case its log has any,
+	// see runServe.
+	s.l.SetFileAccess(s.fileAccess)
+
+	// The quota is set once the DB is loaded so that a DB that already
+	// exceeds it is still loaded.
+	if err := s.l.SetQuota(s.quota); err != nil {
+		return fmt.Errorf("failed to set quota of %s, %w", s.name, err)
+	}
+
+	stopWebhooks, err := startWebhooks(s.l, s.webhooks)
+	if err != nil {
+		return err
+	}
+
+	s.stopWebhooks = stopWebhooks
+	s.ready.Store(true)
+
+	return nil
+}
+
+// limitBody returns a handler that limits the bodies of the requests to h to
+// n bytes. Requests with a larger Content-Length are rejected with 413 Content
+// Too Large, and reading more than n bytes of the others fails with an
+// http.MaxBytesError.
+func limitBody(n int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, fmt.Sprintf("request body larger than %d bytes", n), http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// whenReady returns a handler that serves the requests with h once the DB is
+// loaded, and responds to the others with 503 Service Unavailable.
+func (s *server) whenReady(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "library DB is loading", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleCommands executes the commands file in the body of a POST, in the
+// same way as the CLI, and responds with the NDJSON results of the
+// invocations, with status 422 if a command failed.
+//
+// If the request accepts text/event-stream, the results are instead streamed
+// as server-sent events as each command is executed, see streamCommands.
+//
+// Each command is appended to the store as it is executed, and the DB is
+// saved once the commands file is executed. Unlike the CLI, the commands
+// before a command that fails are kept and saved, since they have already
+// changed the library being served. Once the request is canceled, e.g. by the
+// client disconnecting, the commands stop before the next one and the save is
+// abandoned, leaving the commands already executed to the next save.
+//
+// Also unlike the CLI, the commands that read or write files, e.g. INCLUDE and
+// EXPORT, are rejected, including those of macros, see runServe.
+func (s *server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamCommands(w, r)
+
+		return
+	}
+
+	var results bytes.Buffer
+
+	status := http.StatusOK
+
+	start := time.Now()
+
+	if err := s.l.ImportContext(r.Context(), r.Body, s.importOptions(&results)); err != nil {
+		status = http.StatusUnprocessableEntity
+
+		// The commands before the limit are still executed, as with a
+		// command that fails.
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+	}
+
+	s.metrics.ObserveImport(time.Since(start))
+
+	if err := library.SaveStore(r.Context(), s.store, s.l); err != nil {
+		http.Error(w, fmt.Sprintf("failed to save library state to DB, %v", err), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(status)
+	w.Write(results.Bytes())
+}
+
+// streamCommands executes the commands file in the body of a request like
+// handleCommands, but streams the result of each invocation as a result
+// server-sent event as it is executed, so that a web UI can show the progress
+// of a long commands file and its failures as they happen. The line of each
+// command is the line of its result, see library.Result. The same commands are
+// rejected as by handleCommands, e.g. those that read or write files.
+//
+// Once the commands file is executed and the DB saved, a done event reports
+// the outcome, with the error if a command failed or the DB could not be
+// saved, e.g.:
+//
+//	event: result
+//	data: {"command":"ADD_BOOK","status":"ok","bookIds":[1],"line":1}
+//
+//	event: done
+//	data: {"status":"ok"}
+func (s *server) streamCommands(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// The commands are read from the body as the results are written, which
+	// HTTP/1.x servers do not allow by default. HTTP/2 always allows it.
+	rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	events := &sseWriter{w: w, rc: rc, event: "result"}
+
+	done := struct {
+		Status library.Status `json:"status"`
+		Error  string         `json:"error,omitempty"`
+	}{Status: library.StatusOK}
+
+	start := time.Now()
+
+	if err := s.l.ImportContext(r.Context(), r.Body, s.importOptions(events)); err != nil {
+		done.Status = library.StatusError
+		done.Error = err.Error()
+	}
+
+	s.metrics.ObserveImport(time.Since(start))
+
+	if err := library.SaveStore(r.Context(), s.store, s.l); err != nil {
+		done.Status = library.StatusError
+		done.Error = fmt.Sprintf("failed to save library state to DB, %v", err)
+	}
+
+	bs, err := json.Marshal(done)
+	if err != nil {
+		return
+	}
+
+	events.event = "done"
+	events.Write(append(bs, '\n'))
+}
+
+// importOptions returns the options to execute a commands file with, writing
+// the results to w.
+func (s *server) importOptions(w io.Writer) library.ImportOptions {
+	return library.ImportOptions{
+		ResultWriter: w,
+		OnConflict:   library.Conflict(*onConflict),
+		OnResult:     s.metrics.ObserveResult,
+		AfterExec: func(inv *library.Invocation) error {
+			return s.store.Append(s.l, inv)
+		},
+	}
+}
+
+// sseWriter writes each line written to it as the data of a server-sent event
+// and flushes it to the client.
+type sseWriter struct {
+	w     io.Writer
+	rc    *http.ResponseController
+	event string // Type of the events.
+	buf   []byte // Partial line not yet written.
+}
+
+// Write implements io.Writer.
+func (s *sseWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+
+	for {
+		line, rest, ok := bytes.Cut(s.buf, []byte("\n"))
+		if !ok {
+			break
+		}
+
+		if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", s.event, line); err != nil {
+			return 0, err
+		}
+
+		s.buf = rest
+	}
+
+	if err := s.rc.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
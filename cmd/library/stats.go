@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// stats is the summary of a DB written by the stats subcommand.
+type stats struct {
+	Books        int         `json:"books"`
+	Copies       int         `json:"copies"`
+	Accounts     int         `json:"accounts"`
+	CheckedOut   int         `json:"checkedOut"`
+	Overdue      int         `json:"overdue"`
+	OverdueFines int         `json:"overdueFines"` // Fines in cents accrued by the overdue checkouts.
+	TopBooks     []statsBook `json:"topBooks"`
+
+	DB      string `json:"db"`
+	Store   string `json:"store"`
+	Format  string `json:"format,omitempty"` // Format of the file DB.
+	Version int    `json:"version"`          // FormatVersion of the DB once loaded.
+	Size    int64  `json:"size"`             // Size in bytes of the DB files, 0 if unknown.
+}
+
+// statsBook is a book of the top books of the stats.
+type statsBook struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Checkouts int    `json:"checkouts"`
+}
+
+// runStats implements the stats subcommand:
+//
+//	library [flags] stats [--top 5]
+//
+// A summary of the DB is written in the format of --output: the number of
+// books, copies, accounts and checkouts, the overdue checkouts and their
+// fines, the --top most checked out books, and the kind, format version and
+// size of the DB. No commands are executed and the DB is not saved.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+
+	top := fs.Int("top", 5, "number of the most checked out books to list")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		return usageErrorf("stats takes no arguments")
+	}
+
+	l, store, err := loadLibrary()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	s := stats{
+		DB:      *dbPath,
+		Store:   *storeKind,
+		Version: library.FormatVersion,
+	}
+
+	l.EachBook(func(book *library.Book) {
+		s.Books++
+		s.Copies += book.Count
+	})
+
+	l.EachAccount(func(*library.Account) { s.Accounts++ })
+	l.AllCheckouts(func(*library.Checkout) { s.CheckedOut++ })
+
+	for _, overdue := range l.OverdueCheckouts() {
+		s.Overdue++
+		s.OverdueFines += overdue.Fine
+	}
+
+	s.TopBooks = []statsBook{}
+
+	if *top > 0 {
+		for _, circulation := range l.TopBooks(*top, time.Time{}, time.Time{}) {
+			book := statsBook{ID: circulation.BookID, Checkouts: circulation.Checkouts}
+
+			// The history of a book outlives the book.
+			if b := l.Book(circulation.BookID); b != nil {
+				book.Name = b.Name
+			}
+
+			s.TopBooks = append(s.TopBooks, book)
+		}
+	}
+
+	switch *storeKind {
+	case "file":
+		s.Format = *dbFormat
+		s.Size = fileSize(*dbPath)
+	case "bolt":
+		s.Size = fileSize(*dbPath)
+	case "wal":
+		s.Size = fileSize(*dbPath, *dbPath+".wal")
+	}
+
+	return writeStats(os.Stdout, s)
+}
+
+// writeStats writes the stats to w in the format of --output.
+func writeStats(w io.Writer, s stats) error {
+	switch *output {
+	case "json":
+		return json.NewEncoder(w).Encode(s)
+	case "yaml":
+		return encodeYAML(w, s)
+	case "text":
+	default:
+		return usageErrorf("unknown --output %q, expected text, json or yaml", *output)
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("# Library Stats\n")
+	fmt.Fprintf(&sb, "Books: %d (%d copies)\n", s.Books, s.Copies)
+	fmt.Fprintf(&sb, "Accounts: %d\n", s.Accounts)
+	fmt.Fprintf(&sb, "Checked Out: %d\n", s.CheckedOut)
+	fmt.Fprintf(&sb, "Overdue: %d, %s in fines\n", s.Overdue, formatCents(s.OverdueFines))
+
+	fmt.Fprintf(&sb, "DB: %s, %s store", s.DB, s.Store)
+
+	if s.Format != "" {
+		fmt.Fprintf(&sb, ", %s format", s.Format)
+	}
+
+	fmt.Fprintf(&sb, ", version %d", s.Version)
+
+	if s.Size > 0 {
+		fmt.Fprintf(&sb, ", %d bytes", s.Size)
+	}
+
+	sb.WriteString("\n")
+
+	if len(s.TopBooks) > 0 {
+		sb.WriteString("\n## Top Books\n")
+
+		for i, book := range s.TopBooks {
+			fmt.Fprintf(&sb, "%d. %s (%d): %d checkouts\n", i+1, book.Name, book.ID, book.Checkouts)
+		}
+	}
+
+	out := sb.String()
+
+	if useColor(os.Stdout) {
+		out = colorize(out, library.Result{Status: library.StatusOK})
+	}
+
+	_, err := io.WriteString(w, out)
+
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/apikey"
+	"github.com/admtnnr/library/ratelimit"
+)
+
+// tenantsConfig is the config file of the tenants of the serve subcommand,
+// e.g.:
+//
+//	{
+//	  "tenants": {
+//	    "north": {"db": "north.db", "keys": "north-keys.json", "quota": {"books": 10000, "rate": 50}},
+//	    "south": {"db": "south.db", "store": "bolt", "webhooks": "south-webhooks.json"}
+//	  }
+//	}
+type tenantsConfig struct {
+	Tenants map[string]tenantConfig `json:"tenants"`
+}
+
+// tenantConfig is the config of a tenant, see tenantsConfig. The paths are
+// relative to the working directory of the server, like those of the flags.
+type tenantConfig struct {
+	DB       string      `json:"db"`                 // Path of the DB of the tenant.
+	Store    string      `json:"store,omitempty"`    // Kind of store of the DB, --store if empty.
+	Keys     string      `json:"keys,omitempty"`     // Path of the API keys file, if any, see --keys.
+	Webhooks string      `json:"webhooks,omitempty"` // Path of the webhooks config file, if any, see --webhooks.
+	Quota    tenantQuota `json:"quota,omitempty"`
+}
+
+// tenantQuota is the quota of a tenant: the quota of its library, see
+// library.Quota, and the rate of the requests of the tenant as a whole, in
+// requests per second with bursts of up to Burst requests, unlimited if 0.
+type tenantQuota struct {
+	library.Quota
+
+	Rate  float64 `json:"rate,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+// tenantIDPattern is the pattern of the IDs of the tenants, which are used in
+// the paths of the requests.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// loadTenants reads the config file of the tenants.
+func loadTenants(path string) (*tenantsConfig, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants config, %w", err)
+	}
+
+	var config tenantsConfig
+
+	if err := json.Unmarshal(bs, &config); err != nil {
+		return nil, fmt.Errorf("%w, invalid tenants config %s, %w", library.ErrInvalidArgument, path, err)
+	}
+
+	if len(config.Tenants) == 0 {
+		return nil, fmt.Errorf("%w, tenants config %s has no tenants", library.ErrInvalidArgument, path)
+	}
+
+	for id, tenant := range config.Tenants {
+		if !tenantIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%w, invalid tenant ID %q", library.ErrInvalidArgument, id)
+		}
+
+		if tenant.DB == "" {
+			return nil, fmt.Errorf("%w, tenant %s has no DB", library.ErrInvalidArgument, id)
+		}
+
+		if tenant.Quota.Books < 0 || tenant.Quota.Accounts < 0 || tenant.Quota.Rate < 0 || tenant.Quota.Burst < 0 {
+			return nil, fmt.Errorf("%w, tenant %s has a negative quota", library.ErrInvalidArgument, id)
+		}
+	}
+
+	return &config, nil
+}
+
+// tenantsHandler opens the DBs of the tenants of the config and returns the
+// handler of their libraries and their servers, to be loaded, see
+// loadServers.
+//
+// The paths of the library of each tenant, see runServe, are served under
+// /t/{tenant}/, e.g. /t/north/graphql, and at the top level for the tenant of
+// the X-Library-Tenant header of the request. Requests of unknown tenants, or
+// of no tenant, are rejected with 404 Not Found. The tenants are isolated from
+// one another: each has its own library, DB, API keys, webhooks and metrics,
+// and its own quota of books and accounts, see library.Quota, and of the rate
+// of its requests, over which they are rejected with 429 Too Many Requests.
+// limiter, if set, still limits each client of each tenant, see --rate.
+//
+// The top level /healthz, /readyz and /version are those of the server as a
+// whole: /readyz is 200 OK once every tenant is ready.
+func tenantsHandler(config *tenantsConfig, limiter *ratelimit.Limiter) (http.Handler, []*server, error) {
+	ids := make([]string, 0, len(config.Tenants))
+
+	for id := range config.Tenants {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	var servers []*server
+
+	tenants := make(map[string]http.Handler, len(ids))
+
+	for _, id := range ids {
+		tenant := config.Tenants[id]
+
+		kind := tenant.Store
+		if kind == "" {
+			kind = *storeKind
+		}
+
+		store, err := openStore(kind, tenant.DB)
+		if err != nil {
+			for _, s := range servers {
+				s.store.Close()
+			}
+
+			return nil, nil, fmt.Errorf("failed to open library DB of tenant %s, %w", id, err)
+		}
+
+		s := newServer(tenant.DB, store)
+		s.limiter = limiter
+		s.webhooks = tenant.Webhooks
+		s.quota = tenant.Quota.Quota
+		s.l.SetLogger(slog.Default().With("tenant", id))
+
+		if tenant.Keys != "" {
+			s.keys = &apikey.File{Path: tenant.Keys}
+		}
+
+		h := s.handler()
+
+		if tenant.Quota.Rate > 0 {
+			h = tenantLimit(id, &ratelimit.Limiter{Rate: tenant.Quota.Rate, Burst: max(tenant.Quota.Burst, 1)}, h)
+		}
+
+		servers = append(servers, s)
+		tenants[id] = h
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/t/{tenant}/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("tenant")
+
+		h, ok := tenants[id]
+		if !ok {
+			http.Error(w, fmt.Sprintf("tenant %q not found", id), http.StatusNotFound)
+
+			return
+		}
+
+		http.StripPrefix("/t/"+id, h).ServeHTTP(w, r)
+	})
+
+	mux.HandleFunc("/healthz", servers[0].handleHealthz)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleTenantsReadyz(w, r, ids, servers)
+	})
+	mux.HandleFunc("/version", handleVersion)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Library-Tenant")
+		if id == "" {
+			http.Error(w, "tenant required, use /t/{tenant}/ or the X-Library-Tenant header", http.StatusNotFound)
+
+			return
+		}
+
+		h, ok := tenants[id]
+		if !ok {
+			http.Error(w, fmt.Sprintf("tenant %q not found", id), http.StatusNotFound)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+
+	return mux, servers, nil
+}
+
+// tenantLimit returns a handler that limits the rate of the requests of a
+// tenant as a whole, regardless of its clients, and rejects those over the
+// limit with 429 Too Many Requests.
+func tenantLimit(id string, l *ratelimit.Limiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, delay := l.Allow("tenant:" + id); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfter(delay)))
+			http.Error(w, fmt.Sprintf("tenant %s rate limit exceeded", id), http.StatusTooManyRequests)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleTenantsReadyz responds with 200 OK if every tenant is ready, see
+// server.handleReadyz. Otherwise it responds with 503 Service Unavailable and
+// the checks of the tenants that are not, e.g.:
+//
+//	{"status":"unavailable","tenants":{"north":{"state":"not loaded","storage":"ok"}}}
+func handleTenantsReadyz(w http.ResponseWriter, r *http.Request, ids []string, servers []*server) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	resp := struct {
+		Status  string                       `json:"status"`
+		Tenants map[string]map[string]string `json:"tenants,omitempty"`
+	}{Status: "ok"}
+
+	status := http.StatusOK
+
+	for i, s := range servers {
+		checks, ok := s.checks(ctx)
+		if ok {
+			continue
+		}
+
+		if resp.Tenants == nil {
+			resp.Tenants = make(map[string]map[string]string)
+		}
+
+		resp.Tenants[ids[i]] = checks
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles are the files of the admin UI, see uiHandler.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler returns the handler of the admin UI of the serve subcommand, a
+// page to browse the catalog, look up the checkouts and fines of patrons, and
+// check out and return books, for libraries without a frontend of their own.
+//
+// The UI is static and uses /graphql and /commands, so the API key entered in
+// it is required for its requests rather than for the UI itself.
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.FileServerFS(sub)
+}
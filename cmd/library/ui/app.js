@@ -0,0 +1,185 @@
+// Admin UI of the serve subcommand. The catalog and patrons are queried with
+// /graphql and checkouts and returns are executed by POSTing a commands file
+// of a single command to /commands.
+"use strict";
+
+const tokenInput = document.getElementById("token");
+const statusLine = document.getElementById("status");
+
+tokenInput.value = localStorage.getItem("library-token") || "";
+tokenInput.addEventListener("change", () => localStorage.setItem("library-token", tokenInput.value));
+
+function headers(extra) {
+  const h = Object.assign({}, extra);
+  if (tokenInput.value) {
+    h.Authorization = "Bearer " + tokenInput.value;
+  }
+  return h;
+}
+
+function setStatus(message, isError) {
+  statusLine.textContent = message;
+  statusLine.className = isError ? "error" : "";
+}
+
+async function query(q, variables) {
+  const resp = await fetch("graphql", {
+    method: "POST",
+    headers: headers({"Content-Type": "application/json"}),
+    body: JSON.stringify({query: q, variables: variables}),
+  });
+  if (resp.status === 401 || resp.status === 403 || resp.status === 429) {
+    throw new Error(await resp.text());
+  }
+  const body = await resp.json();
+  if (body.errors && body.errors.length) {
+    throw new Error(body.errors.map((e) => e.message).join("; "));
+  }
+  return body.data;
+}
+
+async function execute(name, args) {
+  const resp = await fetch("commands", {
+    method: "POST",
+    headers: headers({"Content-Type": "application/x-ndjson"}),
+    body: JSON.stringify({name: name, arguments: args}) + "\n",
+  });
+  if (resp.status === 401 || resp.status === 403 || resp.status === 429) {
+    throw new Error(await resp.text());
+  }
+  const result = JSON.parse((await resp.text()).trim().split("\n").pop());
+  if (result.status !== "ok") {
+    throw new Error(result.error);
+  }
+  return result;
+}
+
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text == null ? "" : text;
+  row.appendChild(td);
+  return td;
+}
+
+function money(cents) {
+  return (cents < 0 ? "-$" : "$") + (Math.abs(cents) / 100).toFixed(2);
+}
+
+function day(time) {
+  return time ? time.slice(0, 10) : "";
+}
+
+// Tabs.
+document.querySelectorAll("nav button").forEach((button) => {
+  button.addEventListener("click", () => {
+    document.querySelectorAll("nav button, .tab").forEach((el) => el.classList.remove("active"));
+    button.classList.add("active");
+    document.getElementById(button.dataset.tab).classList.add("active");
+    setStatus("");
+  });
+});
+
+// Catalog.
+const catalogForm = document.getElementById("catalog-search");
+
+async function searchCatalog() {
+  const search = catalogForm.elements.search.value.trim();
+  try {
+    const data = await query(
+      "query($search: String) { books(search: $search) { id name author isbn tags count available } }",
+      search ? {search: search} : {},
+    );
+    const tbody = document.getElementById("books");
+    tbody.replaceChildren();
+    for (const book of data.books) {
+      const row = document.createElement("tr");
+      cell(row, book.id);
+      cell(row, book.name);
+      cell(row, book.author);
+      cell(row, book.isbn);
+      cell(row, book.tags.join(", "));
+      cell(row, book.available + " of " + book.count);
+      tbody.appendChild(row);
+    }
+    setStatus(data.books.length + " books");
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+}
+
+catalogForm.addEventListener("submit", (event) => {
+  event.preventDefault();
+  searchCatalog();
+});
+
+// Patrons.
+const patronForm = document.getElementById("patron-lookup");
+
+async function lookUpPatron() {
+  const id = parseInt(patronForm.elements.id.value, 10);
+  const patron = document.getElementById("patron");
+  patron.replaceChildren();
+  try {
+    const data = await query(
+      "query($id: Int!) { account(id: $id) { id name balance fines accruedFines " +
+        "checkouts { book { id name } checkedOut due overdue fine } } }",
+      {id: id},
+    );
+    const account = data.account;
+    if (!account) {
+      setStatus("account (" + id + ") not found", true);
+      return;
+    }
+
+    const heading = document.createElement("h2");
+    heading.textContent = account.name + " (" + account.id + ")";
+    const summary = document.createElement("p");
+    summary.textContent = "Balance " + money(account.balance) + ", fines owed " + money(account.fines) +
+      ", fines accruing " + money(account.accruedFines);
+
+    const table = document.createElement("table");
+    table.innerHTML = "<thead><tr><th>Book</th><th>Checked out</th><th>Due</th><th>Fine</th></tr></thead>";
+    const tbody = document.createElement("tbody");
+    for (const checkout of account.checkouts) {
+      const row = document.createElement("tr");
+      if (checkout.overdue) {
+        row.className = "overdue";
+      }
+      cell(row, checkout.book.name + " (" + checkout.book.id + ")");
+      cell(row, day(checkout.checkedOut));
+      cell(row, day(checkout.due) + (checkout.overdue ? " overdue" : ""));
+      cell(row, money(checkout.fine));
+      tbody.appendChild(row);
+    }
+    table.appendChild(tbody);
+
+    patron.append(heading, summary, table);
+    setStatus(account.checkouts.length + " books checked out");
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+}
+
+patronForm.addEventListener("submit", (event) => {
+  event.preventDefault();
+  lookUpPatron();
+});
+
+// Circulation.
+const circulationForm = document.getElementById("circulation-form");
+
+circulationForm.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const command = event.submitter.value;
+  const accountId = parseInt(circulationForm.elements.accountId.value, 10);
+  const bookId = parseInt(circulationForm.elements.bookId.value, 10);
+  try {
+    await execute(command, {accountId: accountId, bookId: bookId});
+    setStatus((command === "CHECKOUT_BOOK" ? "Checked out" : "Returned") +
+      " book (" + bookId + ") for account (" + accountId + ")");
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+});
+
+searchCatalog();
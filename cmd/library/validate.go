@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// runValidate implements the validate subcommand:
+//
+//	library [flags] validate <commands-file>...
+//
+// Every command of the commands files, or stdin if a file is "-", is parsed
+// and checked without executing it, see library.ValidateCommands, and every
+// problem is reported with its line, e.g.:
+//
+//	commands.ndjson:12: ADD_BOOK, invalid command, invalid arguments, count: missing required argument
+//
+// so that a commands file can be fixed before it is run. The DB is neither
+// loaded nor saved.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return usageErrorf("validate takes one or more commands files")
+	}
+
+	var problems int
+
+	for _, path := range fs.Args() {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".csv" || ext == ".xml" || ext == ".onix" {
+			return usageErrorf("validate only supports NDJSON commands files, not %s", path)
+		}
+
+		n, err := validateCommands(os.Stdout, path)
+		if err != nil {
+			return err
+		}
+
+		problems += n
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w, found %d problems", library.ErrInvalidCommand, problems)
+	}
+
+	return nil
+}
+
+// validateCommands writes the problems of the commands file at path, or stdin
+// if it is "-", to w and returns the number of problems.
+func validateCommands(w io.Writer, path string) (int, error) {
+	r := io.Reader(os.Stdin)
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s, %w", path, err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	problems, err := library.ValidateCommands(r)
+
+	for _, problem := range problems {
+		msg := problem.Err.Error()
+		if problem.Command != "" {
+			msg = problem.Command + ", " + msg
+		}
+
+		fmt.Fprintf(w, "%s:%d: %s\n", path, problem.Line, errorText(os.Stdout, msg))
+	}
+
+	if err != nil {
+		return len(problems), fmt.Errorf("failed to validate %s, %w", path, err)
+	}
+
+	return len(problems), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/admtnnr/library"
+	"github.com/admtnnr/library/webhook"
+)
+
+// startWebhooks starts delivering the events of the library to the webhook
+// targets of the config file, see the webhook package, and returns a function
+// that stops delivering them, dead-lettering the events not yet delivered. It
+// does nothing if path is empty.
+func startWebhooks(l *library.Library, path string) (stop func(), err error) {
+	if path == "" {
+		return func() {}, nil
+	}
+
+	config, err := webhook.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &webhook.Dispatcher{
+		Library:     l,
+		Targets:     config.Targets,
+		MaxAttempts: config.MaxAttempts,
+	}
+
+	var deadLetter io.Closer
+
+	if config.DeadLetter != "" {
+		f, err := os.OpenFile(config.DeadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open webhooks dead letter file, %w", err)
+		}
+
+		d.DeadLetter = f
+		deadLetter = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := d.Run(ctx); err != nil {
+			slog.Error("webhooks stopped", "err", err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+
+		if deadLetter != nil {
+			deadLetter.Close()
+		}
+	}, nil
+}
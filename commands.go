@@ -0,0 +1,1736 @@
+package library
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The built-in commands are registered in the same way as custom commands so
+// that there is a single path for parsing, executing, and serializing
+// commands.
+//
+// The majority of the code in the handlers is concerned with setting the most
+// useful human readable output, particularly around error conditions.
+func init() {
+	register("ADD_BOOK", execAddBook)
+	register("ADD_COPIES", execAddCopies)
+	register("REMOVE_COPIES", execRemoveCopies)
+	register("CREATE_ACCOUNT", execCreateAccount)
+	registerAt("CHECKOUT_BOOK", execCheckoutBook)
+	registerAt("RETURN_BOOK", execReturnBook)
+	register("ADD_HISTORY", execAddHistory)
+	register("PRINT_CATALOG", execPrintCatalog)
+	register("PRINT_ACCOUNTS", execPrintAccounts)
+	register("TOP_BOOKS", execTopBooks)
+	register("PRINT_ACCOUNT", execPrintAccount)
+	registerAt("START_INVENTORY", execStartInventory)
+	register("SCAN_COPY", execScanCopy)
+	register("FINISH_INVENTORY", execFinishInventory)
+	registerAt("BULK_CHECKOUT", execBulkCheckout)
+	registerAt("BULK_RETURN", execBulkReturn)
+	register("ADD_CREDIT", execAddCredit)
+	register("SEARCH_BOOKS", execSearchBooks)
+	register("LIST_CHECKOUTS", execListCheckouts)
+	register("LIST_OVERDUE", execListOverdue)
+	register("PRINT_BOOK", execPrintBook)
+	register("UNDO", execUndo)
+	register("DEFINE_MACRO", execDefineMacro)
+	registerAt("CALL_MACRO", execCallMacro)
+	register("ASSERT_BOOK_COUNT", execAssertBookCount)
+	register("ASSERT_CHECKED_OUT", execAssertCheckedOut)
+	registerAt("ASSERT_ERROR", execAssertError)
+	register("WAIT", execWait)
+	register("EXPORT", execExport)
+	register("INCLUDE", execInclude)
+	register("SET_POLICY", execSetPolicy)
+	registerAt("PLACE_HOLD", execPlaceHold)
+	register("CANCEL_HOLD", execCancelHold)
+}
+
+// AddBook represents the arguments for the ADD_BOOK command.
+//
+// Author, ISBN, and Tags are optional metadata of the book. Version is
+// optional and is primarily used to restore the version of the book from
+// exports, see Book.Version.
+type AddBook struct {
+	ID      int      `json:"id" help:"unique ID of the book" example:"1"`
+	Name    string   `json:"name" help:"title of the book" example:"The Hobbit"`
+	Count   int      `json:"count" help:"number of copies, must not be negative" example:"3"`
+	Author  string   `json:"author,omitempty" help:"author of the book" example:"J. R. R. Tolkien"`
+	ISBN    string   `json:"isbn,omitempty" help:"ISBN of the book"`
+	Tags    []string `json:"tags,omitempty" help:"tags of the book, e.g. its genres" example:"[\"fantasy\",\"classic\"]"`
+	Version int      `json:"version,omitempty" help:"version of the book, to restore it from an export"`
+}
+
+// Validate implements Validator.
+func (cmd *AddBook) Validate() error {
+	var verr ValidationError
+
+	if cmd.Count < 0 {
+		verr.add("count", "must not be negative")
+	}
+
+	if cmd.Version < 0 {
+		verr.add("version", "must not be negative")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *AddBook) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.ID}}
+}
+
+// execAddBook executes the ADD_BOOK command.
+//
+// If a book with the ID exists, it is resolved according to the
+// ImportOptions.OnConflict of the import in progress, see Conflict.
+func execAddBook(l *Library, cmd *AddBook) (string, error) {
+	c := l.onConflict()
+
+	resolved, err := l.resolveBook(cmd, c)
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not be added to the catalog, %v", cmd.Name, cmd.ID, err), err
+	}
+
+	switch {
+	case !resolved:
+	case c == ConflictSkip:
+		return fmt.Sprintf("%s (%d) already in the catalog, skipped", cmd.Name, cmd.ID), nil
+	case c == ConflictOverwrite:
+		return fmt.Sprintf("%s (%d) with %d copies replaced in the catalog", cmd.Name, cmd.ID, cmd.Count), nil
+	default:
+		return fmt.Sprintf("%s (%d) with %d copies merged into the catalog", cmd.Name, cmd.ID, cmd.Count), nil
+	}
+
+	err = l.AddBook(cmd.ID, cmd.Name, cmd.Count)
+	if err == nil && (cmd.Author != "" || cmd.ISBN != "" || len(cmd.Tags) > 0) {
+		err = l.SetBookMetadata(cmd.ID, BookMetadata{Author: cmd.Author, ISBN: cmd.ISBN, Tags: cmd.Tags})
+	}
+	if err == nil && cmd.Version > 0 {
+		l.restoreBookVersion(cmd.ID, cmd.Version)
+	}
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not be added to the catalog, %v", cmd.Name, cmd.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) with %d copies added to the catalog", cmd.Name, cmd.ID, cmd.Count), nil
+}
+
+// AddCopies represents the arguments for the ADD_COPIES command.
+type AddCopies struct {
+	ID    int `json:"id" help:"ID of the book" example:"1"`
+	Count int `json:"count" help:"number of copies to add, must be positive" example:"2"`
+}
+
+// Validate implements Validator.
+func (cmd *AddCopies) Validate() error {
+	var verr ValidationError
+
+	if cmd.Count <= 0 {
+		verr.add("count", "must be positive")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *AddCopies) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.ID}}
+}
+
+// execAddCopies executes the ADD_COPIES command.
+func execAddCopies(l *Library, cmd *AddCopies) (string, error) {
+	err := l.AddCopies(cmd.ID, cmd.Count)
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("could not add %d copies, book (%d) does not exist", cmd.Count, cmd.ID), err
+	}
+
+	book := l.Book(cmd.ID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not add %d copies, %v", book.Name, book.ID, cmd.Count, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) added %d copies", book.Name, book.ID, cmd.Count), nil
+}
+
+// RemoveCopies represents the arguments for the REMOVE_COPIES command.
+type RemoveCopies struct {
+	ID    int `json:"id" help:"ID of the book" example:"1"`
+	Count int `json:"count" help:"number of copies to remove, must be positive and no more than are available" example:"1"`
+}
+
+// Validate implements Validator.
+func (cmd *RemoveCopies) Validate() error {
+	var verr ValidationError
+
+	if cmd.Count <= 0 {
+		verr.add("count", "must be positive")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *RemoveCopies) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.ID}}
+}
+
+// Confirmation implements ConfirmCommand.
+func (cmd *RemoveCopies) Confirmation(l *Library) string {
+	if book := l.Book(cmd.ID); book != nil {
+		return fmt.Sprintf("remove %d of %d copies of %s (%d)", cmd.Count, book.Count, book.Name, book.ID)
+	}
+
+	return fmt.Sprintf("remove %d copies of book (%d)", cmd.Count, cmd.ID)
+}
+
+// execRemoveCopies executes the REMOVE_COPIES command.
+func execRemoveCopies(l *Library, cmd *RemoveCopies) (string, error) {
+	err := l.RemoveCopies(cmd.ID, cmd.Count)
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("could not remove %d copies, book (%d) does not exist", cmd.Count, cmd.ID), err
+	}
+
+	book := l.Book(cmd.ID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not remove %d copies, %v", book.Name, book.ID, cmd.Count, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) removed %d copies", book.Name, book.ID, cmd.Count), nil
+}
+
+// CreateAccount represents the arguments for the CREATE_ACCOUNT command.
+//
+// Balance and Version are optional and are primarily used to restore the
+// balance and version of the account from exports, see Account.Version.
+type CreateAccount struct {
+	ID      int    `json:"id" help:"unique ID of the account" example:"1"`
+	Name    string `json:"name" help:"name of the account holder" example:"Ada Lovelace"`
+	Balance int    `json:"balance,omitempty" help:"balance in cents, to restore it from an export"`
+	Version int    `json:"version,omitempty" help:"version of the account, to restore it from an export"`
+}
+
+// Validate implements Validator.
+func (cmd *CreateAccount) Validate() error {
+	var verr ValidationError
+
+	if cmd.Version < 0 {
+		verr.add("version", "must not be negative")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *CreateAccount) Entities() Entities {
+	return Entities{AccountIDs: []int{cmd.ID}}
+}
+
+// execCreateAccount executes the CREATE_ACCOUNT command.
+//
+// If an account with the ID exists, it is resolved according to the
+// ImportOptions.OnConflict of the import in progress, see Conflict.
+func execCreateAccount(l *Library, cmd *CreateAccount) (string, error) {
+	c := l.onConflict()
+
+	resolved, err := l.resolveAccount(cmd, c)
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not create account, %v", cmd.Name, cmd.ID, err), err
+	}
+
+	switch {
+	case !resolved:
+	case c == ConflictSkip:
+		return fmt.Sprintf("%s (%d) account already exists, skipped", cmd.Name, cmd.ID), nil
+	case c == ConflictOverwrite:
+		return fmt.Sprintf("%s (%d) account replaced", cmd.Name, cmd.ID), nil
+	default:
+		return fmt.Sprintf("%s (%d) account merged", cmd.Name, cmd.ID), nil
+	}
+
+	err = l.CreateAccount(cmd.ID, cmd.Name)
+	if err == nil && cmd.Balance != 0 {
+		err = l.SetBalance(cmd.ID, cmd.Balance)
+	}
+	if err == nil && cmd.Version > 0 {
+		l.restoreAccountVersion(cmd.ID, cmd.Version)
+	}
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not create account, %v", cmd.Name, cmd.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) created account", cmd.Name, cmd.ID), nil
+}
+
+// CheckoutBook represents the arguments for the CHECKOUT_BOOK command.
+//
+// Date is optional and defaults to the current time. It is primarily used to
+// restore checkouts with their original checkout time from exports. Due is
+// optional and defaults to the loan period of the policy after Date, it is
+// used to restore checkouts made under a different loan period.
+type CheckoutBook struct {
+	AccountID int        `json:"accountId" help:"ID of the account" example:"1"`
+	BookID    int        `json:"bookId" help:"ID of the book" example:"1"`
+	Date      *time.Time `json:"date,omitempty" help:"time of the checkout, the current time if not set"`
+	Due       *time.Time `json:"due,omitempty" help:"time the book is due, the loan period of the policy after date if not set, must not be before date"`
+}
+
+// Entities implements EntityCommand.
+func (cmd *CheckoutBook) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}, AccountIDs: []int{cmd.AccountID}}
+}
+
+// Validate implements Validator.
+func (cmd *CheckoutBook) Validate() error {
+	var verr ValidationError
+
+	if cmd.Date != nil && cmd.Due != nil && cmd.Due.Before(*cmd.Date) {
+		verr.add("due", "must not be before date")
+	}
+
+	return verr.err()
+}
+
+// execCheckoutBook executes the CHECKOUT_BOOK command.
+func execCheckoutBook(l *Library, cmd *CheckoutBook, at time.Time) (string, error) {
+	if cmd.Date != nil {
+		at = *cmd.Date
+	}
+
+	var err error
+	if cmd.Due != nil {
+		err = l.CheckoutBookUntil(cmd.AccountID, cmd.BookID, at, *cmd.Due)
+	} else {
+		err = l.CheckoutBookAt(cmd.AccountID, cmd.BookID, at)
+	}
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not checkout book, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("%s (%d) could not checkout book, book (%d) does not exist", account.Name, account.ID, cmd.BookID), err
+	}
+
+	book := l.Book(cmd.BookID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not checkout %s (%d), %v", account.Name, account.ID, book.Name, book.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) checked out %s (%d)", account.Name, account.ID, book.Name, book.ID), nil
+}
+
+// ReturnBook represents the arguments for the RETURN_BOOK command.
+type ReturnBook struct {
+	AccountID int `json:"accountId" help:"ID of the account" example:"1"`
+	BookID    int `json:"bookId" help:"ID of the book" example:"1"`
+}
+
+// Entities implements EntityCommand.
+func (cmd *ReturnBook) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execReturnBook executes the RETURN_BOOK command.
+func execReturnBook(l *Library, cmd *ReturnBook, at time.Time) (string, error) {
+	err := l.ReturnBookAt(cmd.AccountID, cmd.BookID, at)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not return book, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("%s (%d) could not return book, book (%d) does not exist", account.Name, account.ID, cmd.BookID), err
+	}
+
+	book := l.Book(cmd.BookID)
+
+	if errors.Is(err, ErrCheckoutNotExist) {
+		return fmt.Sprintf("%s (%d) could not return %s (%d), no checkout exists", account.Name, account.ID, book.Name, book.ID), err
+	}
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not return %s (%d), %v", account.Name, account.ID, book.Name, book.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) returned %s (%d)", account.Name, account.ID, book.Name, book.ID), nil
+}
+
+// AddHistory represents the arguments for the ADD_HISTORY command.
+type AddHistory struct {
+	AccountID  int       `json:"accountId" help:"ID of the account" example:"1"`
+	BookID     int       `json:"bookId" help:"ID of the book" example:"1"`
+	CheckedOut time.Time `json:"checkedOut" help:"time the book was checked out" example:"2024-01-02T15:04:05Z"`
+	Returned   time.Time `json:"returned" help:"time the book was returned, must not be before checkedOut" example:"2024-01-16T10:00:00Z"`
+}
+
+// Validate implements Validator.
+func (cmd *AddHistory) Validate() error {
+	var verr ValidationError
+
+	if cmd.Returned.Before(cmd.CheckedOut) {
+		verr.add("returned", "must not be before checkedOut")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *AddHistory) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execAddHistory executes the ADD_HISTORY command.
+func execAddHistory(l *Library, cmd *AddHistory) (string, error) {
+	err := l.AddHistory(cmd.AccountID, cmd.BookID, cmd.CheckedOut, cmd.Returned)
+	if err != nil {
+		return fmt.Sprintf("could not add history of book (%d) for account (%d), %v", cmd.BookID, cmd.AccountID, err), err
+	}
+
+	return fmt.Sprintf("added history of book (%d) for account (%d)", cmd.BookID, cmd.AccountID), nil
+}
+
+// PrintCatalog represents the arguments for the PRINT_CATALOG command.
+//
+// SortBy is the optional order of the books, by ID if not set, see SortBy.
+type PrintCatalog struct {
+	SortBy SortBy `json:"sortBy,omitempty" help:"order of the books, id, name or availability, id if not set" example:"name"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *PrintCatalog) ReadOnly() {}
+
+// Validate implements Validator.
+func (cmd *PrintCatalog) Validate() error {
+	var verr ValidationError
+
+	if !validSortBy(cmd.SortBy) {
+		verr.add("sortBy", "must be id, name or availability")
+	}
+
+	return verr.err()
+}
+
+// execPrintCatalog executes the PRINT_CATALOG command.
+func execPrintCatalog(l *Library, cmd *PrintCatalog) (string, error) {
+	var sb strings.Builder
+
+	sb.WriteString("# Library Catalog\n")
+
+	// The catalog is printed from a view so that circulation is not
+	// blocked while it is, however large the catalog.
+	v := l.View()
+
+	page, err := v.ListBooks(ListOptions{SortBy: cmd.SortBy})
+	if err != nil {
+		return fmt.Sprintf("could not print catalog, %v", err), err
+	}
+
+	for _, book := range page.Items {
+		fmt.Fprintf(&sb, "## %s (%d)\n", book.Name, book.ID)
+		fmt.Fprintf(&sb, "Copies: %d\n", book.Count)
+
+		checkouts := v.CheckoutsByBook(book.ID)
+
+		fmt.Fprintf(&sb, "Checked Out: %d\n", len(checkouts))
+
+		sb.WriteRune('\n')
+	}
+
+	return sb.String(), nil
+}
+
+// PrintAccounts represents the arguments for the PRINT_ACCOUNTS command.
+//
+// SortBy is the optional order of the accounts, by ID if not set, see SortBy.
+type PrintAccounts struct {
+	SortBy SortBy `json:"sortBy,omitempty" help:"order of the accounts, id, name or availability, i.e. fewest books checked out first, id if not set" example:"name"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *PrintAccounts) ReadOnly() {}
+
+// Validate implements Validator.
+func (cmd *PrintAccounts) Validate() error {
+	var verr ValidationError
+
+	if !validSortBy(cmd.SortBy) {
+		verr.add("sortBy", "must be id, name or availability")
+	}
+
+	return verr.err()
+}
+
+// execPrintAccounts executes the PRINT_ACCOUNTS command.
+func execPrintAccounts(l *Library, cmd *PrintAccounts) (string, error) {
+	var sb strings.Builder
+
+	sb.WriteString("# Accounts\n\n")
+
+	v := l.View()
+
+	page, err := v.ListAccounts(ListOptions{SortBy: cmd.SortBy})
+	if err != nil {
+		return fmt.Sprintf("could not print accounts, %v", err), err
+	}
+
+	for _, account := range page.Items {
+		fmt.Fprintf(&sb, "## %s (%d)\n", account.Name, account.ID)
+		fmt.Fprintf(&sb, "Balance: %s\n", formatCents(account.Balance))
+
+		sb.WriteString("Checked Out Books:\n")
+
+		checkouts := v.CheckoutsByAccount(account.ID)
+
+		for _, checkout := range checkouts {
+			book := v.Book(checkout.BookID)
+
+			fmt.Fprintf(&sb, "- %s (%d)\n", book.Name, book.ID)
+		}
+
+		sb.WriteRune('\n')
+	}
+
+	return sb.String(), nil
+}
+
+// TopBooks represents the arguments for the TOP_BOOKS command.
+//
+// Limit is the maximum number of books to list, all books are listed if it is
+// not set. From and To are optional dates in either YYYY-MM-DD or RFC 3339
+// format bounding the checkouts counted. A To date without a time includes
+// checkouts made on that day.
+type TopBooks struct {
+	Limit int    `json:"limit,omitempty" help:"maximum number of books to list, all if not set, must not be negative" example:"10"`
+	From  string `json:"from,omitempty" help:"YYYY-MM-DD or RFC 3339 date of the first checkouts counted" example:"2024-01-01"`
+	To    string `json:"to,omitempty" help:"YYYY-MM-DD or RFC 3339 date of the last checkouts counted, including the whole day if it has no time"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *TopBooks) ReadOnly() {}
+
+// Validate implements Validator.
+func (cmd *TopBooks) Validate() error {
+	var verr ValidationError
+
+	if cmd.Limit < 0 {
+		verr.add("limit", "must not be negative")
+	}
+
+	if _, err := parseDate(cmd.From, false); err != nil {
+		verr.add("from", "must be a YYYY-MM-DD or RFC 3339 date")
+	}
+
+	if _, err := parseDate(cmd.To, true); err != nil {
+		verr.add("to", "must be a YYYY-MM-DD or RFC 3339 date")
+	}
+
+	return verr.err()
+}
+
+// execTopBooks executes the TOP_BOOKS command.
+func execTopBooks(l *Library, cmd *TopBooks) (string, error) {
+	from, err := parseDate(cmd.From, false)
+	if err != nil {
+		return fmt.Sprintf("could not list top books, invalid from date, %v", err), err
+	}
+
+	to, err := parseDate(cmd.To, true)
+	if err != nil {
+		return fmt.Sprintf("could not list top books, invalid to date, %v", err), err
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("# Top Books\n")
+
+	for i, top := range l.TopBooks(cmd.Limit, from, to) {
+		book := l.Book(top.BookID)
+
+		fmt.Fprintf(&sb, "%d. %s (%d): %d checkouts\n", i+1, book.Name, book.ID, top.Checkouts)
+	}
+
+	return sb.String(), nil
+}
+
+// PrintAccount represents the arguments for the PRINT_ACCOUNT command.
+type PrintAccount struct {
+	ID int `json:"id" help:"ID of the account" example:"1"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *PrintAccount) ReadOnly() {}
+
+// Entities implements EntityCommand.
+func (cmd *PrintAccount) Entities() Entities {
+	return Entities{AccountIDs: []int{cmd.ID}}
+}
+
+// execPrintAccount executes the PRINT_ACCOUNT command.
+func execPrintAccount(l *Library, cmd *PrintAccount) (string, error) {
+	account := l.Account(cmd.ID)
+	if account == nil {
+		return fmt.Sprintf("could not print account, account (%d) does not exist", cmd.ID), &NotExistError{Kind: KindAccount, ID: cmd.ID}
+	}
+
+	now := l.Now()
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# %s (%d)\n", account.Name, account.ID)
+	fmt.Fprintf(&sb, "Balance: %s\n\n", formatCents(account.Balance))
+
+	sb.WriteString("## Checked Out Books\n")
+
+	for _, checkout := range l.CheckoutsByAccount(account.ID) {
+		book := l.Book(checkout.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d), due %s", book.Name, book.ID, checkout.Due.Format(time.DateOnly))
+
+		if checkout.Overdue(now) {
+			sb.WriteString(" (overdue)")
+		}
+
+		sb.WriteRune('\n')
+	}
+
+	sb.WriteString("\n## Holds\n")
+
+	for _, hold := range l.HoldsByAccount(account.ID) {
+		book := l.Book(hold.BookID)
+
+		position := 1 + slices.IndexFunc(l.HoldsByBook(book.ID), func(h *Hold) bool { return h.AccountID == account.ID })
+
+		fmt.Fprintf(&sb, "- %s (%d), position %d in the queue, placed %s\n", book.Name, book.ID, position, hold.Placed.Format(time.DateOnly))
+	}
+
+	sb.WriteString("\n## Recent History\n")
+
+	// Only the most recent returns are listed, most recent first, to
+	// keep the view focused on current activity.
+	history := l.HistoryByAccount(account.ID)
+
+	for i, n := len(history)-1, 0; i >= 0 && n < recentHistoryLimit; i-- {
+		checkout := history[i]
+		if checkout.Returned.IsZero() {
+			continue
+		}
+
+		book := l.Book(checkout.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d), checked out %s, returned %s\n", book.Name, book.ID, checkout.CheckedOut.Format(time.DateOnly), checkout.Returned.Format(time.DateOnly))
+
+		n++
+	}
+
+	sb.WriteRune('\n')
+
+	return sb.String(), nil
+}
+
+// StartInventory represents the arguments for the START_INVENTORY command.
+//
+// Date is optional and defaults to the current time. It is primarily used to
+// restore an inventory in progress from exports.
+type StartInventory struct {
+	Date *time.Time `json:"date,omitempty" help:"time the inventory started, the current time if not set"`
+}
+
+// execStartInventory executes the START_INVENTORY command.
+func execStartInventory(l *Library, cmd *StartInventory, at time.Time) (string, error) {
+	if cmd.Date != nil {
+		at = *cmd.Date
+	}
+
+	if err := l.StartInventoryAt(at); err != nil {
+		return fmt.Sprintf("could not start inventory, %v", err), err
+	}
+
+	return "started inventory", nil
+}
+
+// ScanCopy represents the arguments for the SCAN_COPY command.
+type ScanCopy struct {
+	Barcode string `json:"barcode" help:"barcode of the copy, of the form <book-id>-<copy>" example:"1-2"`
+}
+
+// Validate implements Validator.
+func (cmd *ScanCopy) Validate() error {
+	var verr ValidationError
+
+	if _, _, err := ParseBarcode(cmd.Barcode); err != nil {
+		verr.add("barcode", "must be of the form <book-id>-<copy>")
+	}
+
+	return verr.err()
+}
+
+// execScanCopy executes the SCAN_COPY command.
+func execScanCopy(l *Library, cmd *ScanCopy) (string, error) {
+	err := l.ScanCopy(cmd.Barcode)
+	if err != nil {
+		return fmt.Sprintf("could not scan copy %s, %v", cmd.Barcode, err), err
+	}
+
+	return fmt.Sprintf("scanned copy %s", cmd.Barcode), nil
+}
+
+// FinishInventory represents the arguments for the FINISH_INVENTORY command.
+//
+// FinishInventory has no arguments, but the type is required to implement the
+// implicit Command interface required by the Invocation.
+type FinishInventory struct{}
+
+// execFinishInventory executes the FINISH_INVENTORY command.
+func execFinishInventory(l *Library, cmd *FinishInventory) (string, error) {
+	report, err := l.FinishInventory()
+	if err != nil {
+		return fmt.Sprintf("could not finish inventory, %v", err), err
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("# Inventory\n")
+	fmt.Fprintf(&sb, "Started: %s\n", report.Started.Format(time.DateOnly))
+	fmt.Fprintf(&sb, "Scanned: %d\n", report.Scanned)
+
+	sb.WriteString("\n## Missing\n")
+
+	for _, count := range report.Missing {
+		book := l.Book(count.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d): %d of %d copies scanned\n", book.Name, book.ID, count.Scanned, count.Expected)
+	}
+
+	sb.WriteString("\n## Surplus\n")
+
+	for _, count := range report.Surplus {
+		book := l.Book(count.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d): %d of %d copies scanned\n", book.Name, book.ID, count.Scanned, count.Expected)
+	}
+
+	sb.WriteString("\n## Unexpected\n")
+
+	for _, barcode := range report.Unexpected {
+		fmt.Fprintf(&sb, "- %s\n", barcode)
+	}
+
+	sb.WriteRune('\n')
+
+	return sb.String(), nil
+}
+
+// BulkCheckout represents the arguments for the BULK_CHECKOUT command.
+//
+// Limit is optional and overrides the per-account checkout limit.
+type BulkCheckout struct {
+	AccountID int   `json:"accountId" help:"ID of the account" example:"1"`
+	BookIDs   []int `json:"bookIds" help:"IDs of the books, must not be empty" example:"[1,2]"`
+	Limit     int   `json:"limit,omitempty" help:"checkout limit of the account overriding the policy, must not be negative"`
+}
+
+// Validate implements Validator.
+func (cmd *BulkCheckout) Validate() error {
+	var verr ValidationError
+
+	if len(cmd.BookIDs) == 0 {
+		verr.add("bookIds", "must not be empty")
+	}
+
+	if cmd.Limit < 0 {
+		verr.add("limit", "must not be negative")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *BulkCheckout) Entities() Entities {
+	return Entities{BookIDs: cmd.BookIDs, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execBulkCheckout executes the BULK_CHECKOUT command.
+func execBulkCheckout(l *Library, cmd *BulkCheckout, at time.Time) (string, error) {
+	err := l.BulkCheckoutAt(cmd.AccountID, cmd.BookIDs, BulkCheckoutOptions{Limit: cmd.Limit}, at)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not checkout books, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not checkout books, %v", account.Name, account.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) checked out %s", account.Name, account.ID, formatBooks(l, cmd.BookIDs)), nil
+}
+
+// BulkReturn represents the arguments for the BULK_RETURN command.
+type BulkReturn struct {
+	AccountID int   `json:"accountId" help:"ID of the account" example:"1"`
+	BookIDs   []int `json:"bookIds" help:"IDs of the books, must not be empty" example:"[1,2]"`
+}
+
+// Validate implements Validator.
+func (cmd *BulkReturn) Validate() error {
+	var verr ValidationError
+
+	if len(cmd.BookIDs) == 0 {
+		verr.add("bookIds", "must not be empty")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *BulkReturn) Entities() Entities {
+	return Entities{BookIDs: cmd.BookIDs, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execBulkReturn executes the BULK_RETURN command.
+func execBulkReturn(l *Library, cmd *BulkReturn, at time.Time) (string, error) {
+	err := l.BulkReturnAt(cmd.AccountID, cmd.BookIDs, at)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not return books, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not return books, %v", account.Name, account.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) returned %s", account.Name, account.ID, formatBooks(l, cmd.BookIDs)), nil
+}
+
+// AddCredit represents the arguments for the ADD_CREDIT command.
+//
+// Amount is in cents.
+type AddCredit struct {
+	AccountID int `json:"accountId" help:"ID of the account" example:"1"`
+	Amount    int `json:"amount" help:"amount in cents, must be positive" example:"500"`
+}
+
+// Validate implements Validator.
+func (cmd *AddCredit) Validate() error {
+	var verr ValidationError
+
+	if cmd.Amount <= 0 {
+		verr.add("amount", "must be positive")
+	}
+
+	return verr.err()
+}
+
+// Entities implements EntityCommand.
+func (cmd *AddCredit) Entities() Entities {
+	return Entities{AccountIDs: []int{cmd.AccountID}}
+}
+
+// execAddCredit executes the ADD_CREDIT command.
+func execAddCredit(l *Library, cmd *AddCredit) (string, error) {
+	err := l.AddCredit(cmd.AccountID, cmd.Amount)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not add credit, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not add %s credit, %v", account.Name, account.ID, formatCents(cmd.Amount), err), err
+	}
+
+	return fmt.Sprintf("%s (%d) added %s credit, balance %s", account.Name, account.ID, formatCents(cmd.Amount), formatCents(account.Balance)), nil
+}
+
+// SearchBooks represents the arguments for the SEARCH_BOOKS command.
+//
+// Query uses the syntax accepted by ParseSearchQuery.
+type SearchBooks struct {
+	Query string `json:"query,omitempty" help:"search query, e.g. gatsby author:fitzgerald available:yes, the whole catalog if not set" example:"hobbit author:tolkien"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *SearchBooks) ReadOnly() {}
+
+// execSearchBooks executes the SEARCH_BOOKS command.
+func execSearchBooks(l *Library, cmd *SearchBooks) (string, error) {
+	q, err := ParseSearchQuery(cmd.Query)
+	if err != nil {
+		return fmt.Sprintf("could not search books, %v", err), err
+	}
+
+	books := l.Search(q)
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Search Results (%d)\n", len(books))
+
+	for _, book := range books {
+		fmt.Fprintf(&sb, "## %s (%d)\n", book.Name, book.ID)
+
+		if book.Author != "" {
+			fmt.Fprintf(&sb, "Author: %s\n", book.Author)
+		}
+
+		if book.ISBN != "" {
+			fmt.Fprintf(&sb, "ISBN: %s\n", book.ISBN)
+		}
+
+		if len(book.Tags) > 0 {
+			fmt.Fprintf(&sb, "Tags: %s\n", strings.Join(book.Tags, ", "))
+		}
+
+		fmt.Fprintf(&sb, "Copies: %d\n", book.Count)
+		fmt.Fprintf(&sb, "Available: %d\n", book.Count-len(l.CheckoutsByBook(book.ID)))
+
+		sb.WriteRune('\n')
+	}
+
+	return sb.String(), nil
+}
+
+// ListCheckouts represents the arguments for the LIST_CHECKOUTS command.
+//
+// AccountID and BookID are optional and limit the checkouts listed to those of
+// the account or book.
+type ListCheckouts struct {
+	AccountID *int `json:"accountId,omitempty" help:"only list the checkouts of the account" example:"1"`
+	BookID    *int `json:"bookId,omitempty" help:"only list the checkouts of the book"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *ListCheckouts) ReadOnly() {}
+
+// execListCheckouts executes the LIST_CHECKOUTS command.
+func execListCheckouts(l *Library, cmd *ListCheckouts) (string, error) {
+	now := l.Now()
+
+	var sb strings.Builder
+
+	sb.WriteString("# Checkouts\n")
+
+	v := l.View()
+
+	for checkout := range v.Checkouts() {
+		if cmd.AccountID != nil && checkout.AccountID != *cmd.AccountID {
+			continue
+		}
+
+		if cmd.BookID != nil && checkout.BookID != *cmd.BookID {
+			continue
+		}
+
+		account := v.Account(checkout.AccountID)
+		book := v.Book(checkout.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d) has %s (%d), checked out %s, due %s", account.Name, account.ID, book.Name, book.ID, checkout.CheckedOut.Format(time.DateOnly), checkout.Due.Format(time.DateOnly))
+
+		if checkout.Overdue(now) {
+			sb.WriteString(" (overdue)")
+		}
+
+		sb.WriteRune('\n')
+	}
+
+	sb.WriteRune('\n')
+
+	return sb.String(), nil
+}
+
+// ListOverdue represents the arguments for the LIST_OVERDUE command.
+//
+// ListOverdue has no arguments, but the type is required to implement the
+// implicit Command interface required by the Invocation.
+type ListOverdue struct{}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *ListOverdue) ReadOnly() {}
+
+// execListOverdue executes the LIST_OVERDUE command.
+func execListOverdue(l *Library, cmd *ListOverdue) (string, error) {
+	overdue := l.OverdueCheckouts()
+
+	var (
+		sb       strings.Builder
+		accounts []int
+		fines    = make(map[int]int)
+	)
+
+	sb.WriteString("# Overdue Checkouts\n")
+
+	for _, checkout := range overdue {
+		account := l.Account(checkout.AccountID)
+		book := l.Book(checkout.BookID)
+
+		fmt.Fprintf(&sb, "- %s (%d) has %s (%d), due %s, %d days overdue, %s accrued\n", account.Name, account.ID, book.Name, book.ID, checkout.Due.Format(time.DateOnly), checkout.Days, formatCents(checkout.Fine))
+
+		if _, ok := fines[account.ID]; !ok {
+			accounts = append(accounts, account.ID)
+		}
+
+		fines[account.ID] += checkout.Fine
+	}
+
+	sb.WriteString("\n## Accrued Fines by Account\n")
+
+	slices.SortStableFunc(accounts, func(a, b int) int {
+		return cmp.Compare(fines[b], fines[a])
+	})
+
+	for _, id := range accounts {
+		account := l.Account(id)
+
+		fmt.Fprintf(&sb, "- %s (%d): %s\n", account.Name, account.ID, formatCents(fines[id]))
+	}
+
+	sb.WriteRune('\n')
+
+	return sb.String(), nil
+}
+
+// PrintBook represents the arguments for the PRINT_BOOK command.
+type PrintBook struct {
+	ID int `json:"id" help:"ID of the book" example:"1"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *PrintBook) ReadOnly() {}
+
+// Entities implements EntityCommand.
+func (cmd *PrintBook) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.ID}}
+}
+
+// execPrintBook executes the PRINT_BOOK command.
+func execPrintBook(l *Library, cmd *PrintBook) (string, error) {
+	book := l.Book(cmd.ID)
+	if book == nil {
+		return fmt.Sprintf("could not print book, book (%d) does not exist", cmd.ID), &NotExistError{Kind: KindBook, ID: cmd.ID}
+	}
+
+	now := l.Now()
+	checkouts := l.CheckoutsByBook(book.ID)
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# %s (%d)\n", book.Name, book.ID)
+
+	if book.Author != "" {
+		fmt.Fprintf(&sb, "Author: %s\n", book.Author)
+	}
+
+	if book.ISBN != "" {
+		fmt.Fprintf(&sb, "ISBN: %s\n", book.ISBN)
+	}
+
+	if len(book.Tags) > 0 {
+		fmt.Fprintf(&sb, "Tags: %s\n", strings.Join(book.Tags, ", "))
+	}
+
+	fmt.Fprintf(&sb, "Copies: %d\n", book.Count)
+	fmt.Fprintf(&sb, "Available: %d\n", book.Count-len(checkouts))
+	fmt.Fprintf(&sb, "Circulation: %d\n", len(l.HistoryByBook(book.ID)))
+
+	sb.WriteString("\n## Borrowers\n")
+
+	for _, checkout := range checkouts {
+		account := l.Account(checkout.AccountID)
+
+		fmt.Fprintf(&sb, "- %s (%d), due %s", account.Name, account.ID, checkout.Due.Format(time.DateOnly))
+
+		if checkout.Overdue(now) {
+			sb.WriteString(" (overdue)")
+		}
+
+		sb.WriteRune('\n')
+	}
+
+	sb.WriteString("\n## Hold Queue\n")
+
+	for i, hold := range l.HoldsByBook(book.ID) {
+		account := l.Account(hold.AccountID)
+
+		fmt.Fprintf(&sb, "%d. %s (%d), placed %s\n", i+1, account.Name, account.ID, hold.Placed.Format(time.DateOnly))
+	}
+
+	sb.WriteRune('\n')
+
+	return sb.String(), nil
+}
+
+// Undo represents the arguments for the UNDO command.
+//
+// Undo has no arguments, but the type is required to implement the implicit
+// Command interface required by the Invocation.
+type Undo struct{}
+
+// execUndo executes the UNDO command.
+func execUndo(l *Library, cmd *Undo) (string, error) {
+	desc, err := l.Undo()
+	if err != nil {
+		return fmt.Sprintf("could not undo, %v", err), err
+	}
+
+	return fmt.Sprintf("undid %s", desc), nil
+}
+
+// DefineMacro represents the arguments for the DEFINE_MACRO command.
+//
+// Commands are the commands of the macro in the same form as a command file,
+// where any string of the form "$param" is replaced with the argument for the
+// parameter when the macro is invoked, see Macro.
+type DefineMacro struct {
+	Name     string            `json:"name" help:"name of the macro, must not be empty" example:"lend"`
+	Params   []string          `json:"params,omitempty" help:"names of the parameters, substituted for $param in the commands" example:"[\"account\",\"book\"]"`
+	Commands []json.RawMessage `json:"commands" help:"commands of the macro, must not be empty" example:"[{\"name\":\"CHECKOUT_BOOK\",\"arguments\":{\"accountId\":\"$account\",\"bookId\":\"$book\"}}]"`
+}
+
+// Validate implements Validator.
+func (cmd *DefineMacro) Validate() error {
+	var verr ValidationError
+
+	if cmd.Name == "" {
+		verr.add("name", "must not be empty")
+	}
+
+	if len(cmd.Commands) == 0 {
+		verr.add("commands", "must not be empty")
+	}
+
+	return verr.err()
+}
+
+// execDefineMacro executes the DEFINE_MACRO command.
+func execDefineMacro(l *Library, cmd *DefineMacro) (string, error) {
+	if err := l.DefineMacro(cmd.Name, cmd.Params, cmd.Commands); err != nil {
+		return fmt.Sprintf("could not define macro %s, %v", cmd.Name, err), err
+	}
+
+	return fmt.Sprintf("defined macro %s", cmd.Name), nil
+}
+
+// CallMacro represents the arguments for the CALL_MACRO command.
+//
+// A macro is usually invoked by using its name as the command name with its
+// arguments as the command arguments, which is unmarshaled into a CallMacro.
+type CallMacro struct {
+	Macro     string          `json:"macro" help:"name of the macro" example:"lend"`
+	Arguments json.RawMessage `json:"arguments,omitempty" help:"arguments of the macro by parameter" example:"{\"account\":1,\"book\":1}"`
+
+	// byName is set if the macro was invoked by its name as the command
+	// name, which is an unknown command if no macro has the name.
+	byName bool
+}
+
+// ReadOnly implements ReadOnlyCommand, the commands of the macro are
+// checked as they are executed.
+func (cmd *CallMacro) ReadOnly() {}
+
+// execCallMacro executes the CALL_MACRO command.
+//
+// The commands of the macro are executed in order and the output of each is
+// included in the output. If a command fails, the mutations made by the
+// commands before it are reverted so that the macro is applied as a whole or
+// not at all, and a successful macro is undone as a single mutation.
+func execCallMacro(l *Library, cmd *CallMacro, at time.Time) (string, error) {
+	macro := l.Macro(cmd.Macro)
+	if macro == nil && cmd.byName {
+		return fmt.Sprintf("could not run %s, unknown command", cmd.Macro), fmt.Errorf("%w, unknown command %q", ErrInvalidCommand, cmd.Macro)
+	}
+
+	if macro == nil {
+		return fmt.Sprintf("could not run macro %s, macro does not exist", cmd.Macro), &NotExistError{Kind: KindMacro, Name: cmd.Macro}
+	}
+
+	commands, err := macro.Expand(cmd.Arguments)
+	if err != nil {
+		return fmt.Sprintf("could not run macro %s, %v", cmd.Macro, err), err
+	}
+
+	mark := l.markUndo()
+	outputs := make([]string, 0, len(commands))
+
+	for i, raw := range commands {
+		var inv Invocation
+
+		err := json.Unmarshal(raw, &inv)
+		if err == nil {
+			err = inv.execAt(l, at)
+		}
+
+		if err != nil {
+			l.rollbackUndo(mark)
+
+			return fmt.Sprintf("could not run macro %s, command %d failed, %v", cmd.Macro, i+1, err), fmt.Errorf("macro %s command %d failed, %w", cmd.Macro, i+1, err)
+		}
+
+		outputs = append(outputs, inv.Output)
+	}
+
+	l.collapseUndo(mark, fmt.Sprintf("macro %s", cmd.Macro))
+
+	return strings.Join(outputs, "\n"), nil
+}
+
+// AssertBookCount represents the arguments for the ASSERT_BOOK_COUNT command.
+//
+// Available is optional and, if set, is the expected number of copies that
+// are not checked out.
+type AssertBookCount struct {
+	BookID    int  `json:"bookId" help:"ID of the book" example:"1"`
+	Count     int  `json:"count" help:"expected number of copies" example:"3"`
+	Available *int `json:"available,omitempty" help:"expected number of copies that are not checked out" example:"2"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *AssertBookCount) ReadOnly() {}
+
+// Entities implements EntityCommand.
+func (cmd *AssertBookCount) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}}
+}
+
+// execAssertBookCount executes the ASSERT_BOOK_COUNT command.
+func execAssertBookCount(l *Library, cmd *AssertBookCount) (string, error) {
+	book := l.Book(cmd.BookID)
+	if book == nil {
+		return fmt.Sprintf("assertion failed, book (%d) does not exist", cmd.BookID), &NotExistError{Kind: KindBook, ID: cmd.BookID}
+	}
+
+	var diff []string
+
+	if book.Count != cmd.Count {
+		diff = append(diff, fmt.Sprintf("expected count %d, got %d", cmd.Count, book.Count))
+	}
+
+	available := book.Count - len(l.CheckoutsByBook(book.ID))
+
+	if cmd.Available != nil && available != *cmd.Available {
+		diff = append(diff, fmt.Sprintf("expected available %d, got %d", *cmd.Available, available))
+	}
+
+	if len(diff) > 0 {
+		msg := strings.Join(diff, ", ")
+
+		return fmt.Sprintf("assertion failed, %s (%d), %s", book.Name, book.ID, msg), fmt.Errorf("%w, %s", ErrAssertionFailed, msg)
+	}
+
+	return fmt.Sprintf("assertion passed, %s (%d) has %d copies, %d available", book.Name, book.ID, book.Count, available), nil
+}
+
+// AssertCheckedOut represents the arguments for the ASSERT_CHECKED_OUT command.
+//
+// BookIDs are all of the books expected to be checked out by the account, in
+// any order, so an empty list asserts that nothing is checked out.
+type AssertCheckedOut struct {
+	AccountID int   `json:"accountId" help:"ID of the account" example:"1"`
+	BookIDs   []int `json:"bookIds" help:"IDs of all of the books expected to be checked out, in any order" example:"[1]"`
+}
+
+// ReadOnly implements ReadOnlyCommand.
+func (cmd *AssertCheckedOut) ReadOnly() {}
+
+// Entities implements EntityCommand.
+func (cmd *AssertCheckedOut) Entities() Entities {
+	return Entities{AccountIDs: []int{cmd.AccountID}, BookIDs: cmd.BookIDs}
+}
+
+// execAssertCheckedOut executes the ASSERT_CHECKED_OUT command.
+func execAssertCheckedOut(l *Library, cmd *AssertCheckedOut) (string, error) {
+	account := l.Account(cmd.AccountID)
+	if account == nil {
+		return fmt.Sprintf("assertion failed, account (%d) does not exist", cmd.AccountID), &NotExistError{Kind: KindAccount, ID: cmd.AccountID}
+	}
+
+	var actual []int
+
+	for _, checkout := range l.CheckoutsByAccount(account.ID) {
+		actual = append(actual, checkout.BookID)
+	}
+
+	if diff := diffIDs(cmd.BookIDs, actual); diff != "" {
+		return fmt.Sprintf("assertion failed, %s (%d) checked out books, %s", account.Name, account.ID, diff), fmt.Errorf("%w, %s", ErrAssertionFailed, diff)
+	}
+
+	return fmt.Sprintf("assertion passed, %s (%d) has %d books checked out", account.Name, account.ID, len(actual)), nil
+}
+
+// AssertError represents the arguments for the ASSERT_ERROR command.
+//
+// Command is the command expected to fail, in the same form as a command file.
+// Code is optional and, if set, is the expected error code of the failure, see
+// Result.
+type AssertError struct {
+	Command Command   `json:"command" help:"command expected to fail" example:"{\"name\":\"RETURN_BOOK\",\"arguments\":{\"accountId\":1,\"bookId\":2}}"`
+	Code    ErrorCode `json:"code,omitempty" help:"expected error code of the failure, e.g. CHECKOUT_NOT_FOUND" example:"CHECKOUT_NOT_FOUND"`
+}
+
+// execAssertError executes the ASSERT_ERROR command.
+//
+// The command is executed and the assertion passes if it fails. If the command
+// unexpectedly succeeds, its mutations are reverted so that the assertion does
+// not change the library.
+func execAssertError(l *Library, cmd *AssertError, at time.Time) (string, error) {
+	bs, err := json.Marshal(cmd.Command)
+	if err != nil {
+		return fmt.Sprintf("could not assert error, %v", err), err
+	}
+
+	var inv Invocation
+
+	mark := l.markUndo()
+
+	err = json.Unmarshal(bs, &inv)
+	if err == nil {
+		err = inv.execAt(l, at)
+	}
+
+	if err == nil {
+		l.rollbackUndo(mark)
+
+		msg := fmt.Sprintf("expected %s to fail, but it succeeded", cmd.Command.Name)
+
+		return fmt.Sprintf("assertion failed, %s", msg), fmt.Errorf("%w, %s", ErrAssertionFailed, msg)
+	}
+
+	// A command that fails may still have made mutations, e.g. the commands
+	// of a file an INCLUDE executed before the one that failed, which are
+	// kept.
+	l.collapseUndo(mark, fmt.Sprintf("%s expected to fail", cmd.Command.Name))
+
+	if code := ErrorCodeOf(err); cmd.Code != "" && code != cmd.Code {
+		msg := fmt.Sprintf("expected %s to fail with %s, got %s, %v", cmd.Command.Name, cmd.Code, code, err)
+
+		return fmt.Sprintf("assertion failed, %s", msg), fmt.Errorf("%w, %s", ErrAssertionFailed, msg)
+	}
+
+	return fmt.Sprintf("assertion passed, %s failed, %v", cmd.Command.Name, err), nil
+}
+
+// Wait represents the arguments for the WAIT command.
+//
+// Duration is a Go duration, e.g. "1.5s" or "72h", or a number of days, e.g.
+// "21d".
+type Wait struct {
+	Duration string `json:"duration" help:"Go duration, e.g. 72h, or number of days, e.g. 21d, must not be negative" example:"14d"`
+}
+
+// Validate implements Validator.
+func (cmd *Wait) Validate() error {
+	var verr ValidationError
+
+	if d, err := parseDuration(cmd.Duration); err != nil {
+		verr.add("duration", err.Error())
+	} else if d < 0 {
+		verr.add("duration", "must not be negative")
+	}
+
+	return verr.err()
+}
+
+// execWait executes the WAIT command.
+//
+// WAIT waits according to the library clock, so it pauses for the duration
+// with the real clock but advances the time instantly with a SimulatedClock.
+func execWait(l *Library, cmd *Wait) (string, error) {
+	d, err := parseDuration(cmd.Duration)
+	if err != nil {
+		return fmt.Sprintf("could not wait, %v", err), err
+	}
+
+	l.Sleep(d)
+
+	return fmt.Sprintf("waited %s, now %s", cmd.Duration, l.Now().Format(time.RFC3339)), nil
+}
+
+// Export represents the arguments for the EXPORT command.
+//
+// Format is the format of the export, see ExportOptions, the command log
+// format if omitted. OnlyBooks, OnlyAccounts, BookIDs and AccountIDs select a
+// subset of the state to export, e.g. only the catalog, see ExportOptions.
+type Export struct {
+	Path         string `json:"path" help:"path of the file to export to, must not be empty" example:"catalog.ndjson"`
+	Format       Format `json:"format,omitempty" help:"commands, snapshot, gob or proto, commands if not set"`
+	OnlyBooks    bool   `json:"onlyBooks,omitempty" help:"only export the books, must not be set with onlyAccounts" example:"true"`
+	OnlyAccounts bool   `json:"onlyAccounts,omitempty" help:"only export the accounts"`
+	BookIDs      []int  `json:"bookIds,omitempty" help:"only export the books and their checkouts"`
+	AccountIDs   []int  `json:"accountIds,omitempty" help:"only export the accounts and their checkouts"`
+}
+
+// FileAccess implements FileCommand.
+func (cmd *Export) FileAccess() {}
+
+// Validate implements Validator.
+func (cmd *Export) Validate() error {
+	var verr ValidationError
+
+	if cmd.Path == "" {
+		verr.add("path", "must not be empty")
+	}
+
+	switch cmd.Format {
+	case "", FormatCommands, FormatSnapshot, FormatGob, FormatProto:
+	default:
+		verr.add("format", "must be commands, snapshot, gob or proto")
+	}
+
+	if cmd.OnlyBooks && cmd.OnlyAccounts {
+		verr.add("onlyAccounts", "must not be set with onlyBooks")
+	}
+
+	return verr.err()
+}
+
+// execExport executes the EXPORT command.
+//
+// The state is exported as of the point in the commands the EXPORT is
+// executed, e.g. to snapshot the state before a risky bulk update, and can be
+// restored by using the file as the DB.
+func execExport(l *Library, cmd *Export) (string, error) {
+	opts := ExportOptions{
+		Format:       cmd.Format,
+		OnlyBooks:    cmd.OnlyBooks,
+		OnlyAccounts: cmd.OnlyAccounts,
+		BookIDs:      cmd.BookIDs,
+		AccountIDs:   cmd.AccountIDs,
+	}
+
+	if err := l.ExportFile(cmd.Path, opts); err != nil {
+		return fmt.Sprintf("could not export library state to %s, %v", cmd.Path, err), err
+	}
+
+	return fmt.Sprintf("exported library state to %s", cmd.Path), nil
+}
+
+// Include represents the arguments for the INCLUDE command.
+//
+// A relative Path is resolved relative to the directory of the command file
+// containing the INCLUDE, if known, so that fragments can include each other
+// regardless of the working directory.
+type Include struct {
+	Path string `json:"path" help:"path of the commands file, relative to the including file, must not be empty" example:"setup.ndjson"`
+}
+
+// ReadOnly implements ReadOnlyCommand, the commands of the included file are
+// checked as they are executed.
+func (cmd *Include) ReadOnly() {}
+
+// FileAccess implements FileCommand.
+func (cmd *Include) FileAccess() {}
+
+// Validate implements Validator.
+func (cmd *Include) Validate() error {
+	var verr ValidationError
+
+	if cmd.Path == "" {
+		verr.add("path", "must not be empty")
+	}
+
+	return verr.err()
+}
+
+// execInclude executes the INCLUDE command.
+//
+// The commands of the included file are executed inline and their output is
+// included in the output. Execution stops at the first command that fails, as
+// it does for the including file.
+func execInclude(l *Library, cmd *Include) (string, error) {
+	path := cmd.Path
+
+	if dir := l.importDir(); dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	var output strings.Builder
+
+	if err := l.ImportFile(path, l.includeOptions(&output)); err != nil {
+		// The output of a failed command already describes the failure,
+		// so only the line is repeated rather than the whole error.
+		msg := err.Error()
+
+		var ierr *ImportError
+		if errors.As(err, &ierr) {
+			msg = fmt.Sprintf("line %d failed", ierr.Line)
+		}
+
+		return fmt.Sprintf("%scould not include %s, %s", output.String(), cmd.Path, msg), fmt.Errorf("%s, %w", cmd.Path, err)
+	}
+
+	return strings.TrimSuffix(output.String(), "\n"), nil
+}
+
+// SetPolicy represents the arguments for the SET_POLICY command.
+//
+// Every argument is optional and only the policies that are set are changed.
+// LoanPeriod and HoldWindow are durations in the same format as WAIT, e.g.
+// "14d", and a HoldWindow of "0" keeps holds until they are canceled.
+type SetPolicy struct {
+	CheckoutLimit *int   `json:"checkoutLimit,omitempty" help:"maximum number of books an account may have checked out, must be positive" example:"5"`
+	LoanPeriod    string `json:"loanPeriod,omitempty" help:"duration as for WAIT, e.g. 21d, must be positive" example:"21d"`
+	FineRate      *int   `json:"fineRate,omitempty" help:"fine in cents per day overdue, must not be negative"`
+	HoldWindow    string `json:"holdWindow,omitempty" help:"duration as for WAIT a hold is queued before it expires, 0 for never, must not be negative"`
+}
+
+// newSetPolicy returns a SetPolicy command that sets every policy to match the
+// provided policy.
+func newSetPolicy(policy Policy) *SetPolicy {
+	cmd := &SetPolicy{
+		CheckoutLimit: &policy.CheckoutLimit,
+		LoanPeriod:    formatDuration(policy.LoanPeriod),
+		FineRate:      &policy.FineRate,
+	}
+
+	if policy.HoldWindow != 0 {
+		cmd.HoldWindow = formatDuration(policy.HoldWindow)
+	}
+
+	return cmd
+}
+
+// Validate implements Validator.
+func (cmd *SetPolicy) Validate() error {
+	var verr ValidationError
+
+	if cmd.CheckoutLimit == nil && cmd.LoanPeriod == "" && cmd.FineRate == nil && cmd.HoldWindow == "" {
+		verr.add("checkoutLimit, loanPeriod, fineRate, holdWindow", "at least one must be set")
+	}
+
+	if cmd.CheckoutLimit != nil && *cmd.CheckoutLimit <= 0 {
+		verr.add("checkoutLimit", "must be positive")
+	}
+
+	if cmd.LoanPeriod != "" {
+		if d, err := parseDuration(cmd.LoanPeriod); err != nil {
+			verr.add("loanPeriod", err.Error())
+		} else if d <= 0 {
+			verr.add("loanPeriod", "must be positive")
+		}
+	}
+
+	if cmd.FineRate != nil && *cmd.FineRate < 0 {
+		verr.add("fineRate", "must not be negative")
+	}
+
+	if cmd.HoldWindow != "" {
+		if d, err := parseDuration(cmd.HoldWindow); err != nil {
+			verr.add("holdWindow", err.Error())
+		} else if d < 0 {
+			verr.add("holdWindow", "must not be negative")
+		}
+	}
+
+	return verr.err()
+}
+
+// execSetPolicy executes the SET_POLICY command.
+func execSetPolicy(l *Library, cmd *SetPolicy) (string, error) {
+	policy := l.Policy()
+
+	if cmd.CheckoutLimit != nil {
+		policy.CheckoutLimit = *cmd.CheckoutLimit
+	}
+
+	if cmd.LoanPeriod != "" {
+		d, err := parseDuration(cmd.LoanPeriod)
+		if err != nil {
+			return fmt.Sprintf("could not set policy, %v", err), err
+		}
+
+		policy.LoanPeriod = d
+	}
+
+	if cmd.FineRate != nil {
+		policy.FineRate = *cmd.FineRate
+	}
+
+	if cmd.HoldWindow != "" {
+		d, err := parseDuration(cmd.HoldWindow)
+		if err != nil {
+			return fmt.Sprintf("could not set policy, %v", err), err
+		}
+
+		policy.HoldWindow = d
+	}
+
+	if err := l.SetPolicy(policy); err != nil {
+		return fmt.Sprintf("could not set policy, %v", err), err
+	}
+
+	output := fmt.Sprintf("set policy, checkout limit %d, loan period %s, fine rate %s per day", policy.CheckoutLimit, formatDuration(policy.LoanPeriod), formatCents(policy.FineRate))
+
+	if policy.HoldWindow > 0 {
+		output += fmt.Sprintf(", hold window %s", formatDuration(policy.HoldWindow))
+	}
+
+	return output, nil
+}
+
+// PlaceHold represents the arguments for the PLACE_HOLD command.
+//
+// Date is optional and defaults to the current time. It is primarily used to
+// restore holds with their original time from exports.
+type PlaceHold struct {
+	AccountID int        `json:"accountId" help:"ID of the account" example:"1"`
+	BookID    int        `json:"bookId" help:"ID of the book" example:"1"`
+	Date      *time.Time `json:"date,omitempty" help:"time the hold was placed, the current time if not set"`
+}
+
+// Entities implements EntityCommand.
+func (cmd *PlaceHold) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execPlaceHold executes the PLACE_HOLD command.
+func execPlaceHold(l *Library, cmd *PlaceHold, at time.Time) (string, error) {
+	if cmd.Date != nil {
+		at = *cmd.Date
+	}
+
+	err := l.PlaceHoldAt(cmd.AccountID, cmd.BookID, at)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not place hold, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("%s (%d) could not place hold, book (%d) does not exist", account.Name, account.ID, cmd.BookID), err
+	}
+
+	book := l.Book(cmd.BookID)
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not place hold on %s (%d), %v", account.Name, account.ID, book.Name, book.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) placed hold on %s (%d), position %d in the queue", account.Name, account.ID, book.Name, book.ID, len(l.HoldsByBook(book.ID))), nil
+}
+
+// CancelHold represents the arguments for the CANCEL_HOLD command.
+type CancelHold struct {
+	AccountID int `json:"accountId" help:"ID of the account" example:"1"`
+	BookID    int `json:"bookId" help:"ID of the book" example:"1"`
+}
+
+// Entities implements EntityCommand.
+func (cmd *CancelHold) Entities() Entities {
+	return Entities{BookIDs: []int{cmd.BookID}, AccountIDs: []int{cmd.AccountID}}
+}
+
+// execCancelHold executes the CANCEL_HOLD command.
+func execCancelHold(l *Library, cmd *CancelHold) (string, error) {
+	err := l.CancelHold(cmd.AccountID, cmd.BookID)
+	if errors.Is(err, ErrAccountNotExist) {
+		return fmt.Sprintf("could not cancel hold, account (%d) does not exist", cmd.AccountID), err
+	}
+
+	account := l.Account(cmd.AccountID)
+
+	if errors.Is(err, ErrBookNotExist) {
+		return fmt.Sprintf("%s (%d) could not cancel hold, book (%d) does not exist", account.Name, account.ID, cmd.BookID), err
+	}
+
+	book := l.Book(cmd.BookID)
+
+	if errors.Is(err, ErrHoldNotExist) {
+		return fmt.Sprintf("%s (%d) could not cancel hold on %s (%d), no hold exists", account.Name, account.ID, book.Name, book.ID), err
+	}
+
+	if err != nil {
+		return fmt.Sprintf("%s (%d) could not cancel hold on %s (%d), %v", account.Name, account.ID, book.Name, book.ID, err), err
+	}
+
+	return fmt.Sprintf("%s (%d) canceled hold on %s (%d)", account.Name, account.ID, book.Name, book.ID), nil
+}
+
+// recentHistoryLimit is the number of returned checkouts listed in the recent
+// history of the PRINT_ACCOUNT command.
+const recentHistoryLimit = 10
+
+// formatBooks formats a list of books by ID for human readable output, e.g.
+// "The Hobbit (7), Moby Dick (8)".
+func formatBooks(l *Library, ids []int) string {
+	names := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		book := l.Book(id)
+
+		names = append(names, fmt.Sprintf("%s (%d)", book.Name, book.ID))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// formatCents formats an amount in cents as dollars for human readable output,
+// e.g. "$1.25" or "-$0.50".
+func formatCents(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// parseDate parses a date argument in either YYYY-MM-DD or RFC 3339 format.
+//
+// An empty string is parsed as the zero time. If end is true, a date without
+// a time is parsed as the start of the following day so that the date can be
+// used as an inclusive upper bound.
+func parseDate(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// formatDuration formats a duration in the format parsed by parseDuration,
+// using a number of days if the duration is a whole number of days.
+func formatDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+
+	if d > 0 && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+
+	return d.String()
+}
+
+// parseDuration parses a duration argument as either a Go duration, e.g.
+// "72h", or a whole number of days, e.g. "21d", which time.ParseDuration does
+// not support.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
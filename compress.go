@@ -0,0 +1,60 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// gzipMagic are the bytes every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader of the decompressed contents of r if r is gzip
+// compressed, detected by its magic bytes, or of r as is otherwise.
+//
+// Exported state is repetitive JSON that compresses well, so state files may
+// be compressed regardless of their name and are read transparently.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed library state, %w", err)
+	}
+
+	return zr, nil
+}
+
+// compressFor returns a write function that gzip compresses the output of
+// write if the path has a .gz extension, or write as is otherwise.
+func compressFor(path string, write func(w io.Writer) error) func(w io.Writer) error {
+	if !strings.HasSuffix(path, ".gz") {
+		return write
+	}
+
+	return func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+
+		if err := write(zw); err != nil {
+			return err
+		}
+
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to write compressed library state, %w", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,167 @@
+package library
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Conflict is the strategy for resolving an imported ADD_BOOK or
+// CREATE_ACCOUNT with the ID of an existing book or account, see
+// ImportOptions.OnConflict.
+type Conflict string
+
+const (
+	// ConflictFail fails the command with ErrDuplicateID.
+	ConflictFail Conflict = "fail"
+	// ConflictSkip keeps the existing book or account and skips the
+	// command.
+	ConflictSkip Conflict = "skip"
+	// ConflictOverwrite replaces the existing book or account with the
+	// imported one.
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictMerge combines the imported book or account with the
+	// existing one: the copies of books and the balances of accounts are
+	// added together, the tags of books are combined, and the other
+	// fields are kept, filling in those that are empty.
+	ConflictMerge Conflict = "merge"
+)
+
+// validConflict reports whether the conflict strategy is known, treating the
+// empty strategy as ConflictFail.
+func validConflict(c Conflict) bool {
+	switch c {
+	case "", ConflictFail, ConflictSkip, ConflictOverwrite, ConflictMerge:
+		return true
+	default:
+		return false
+	}
+}
+
+// onConflict returns the conflict strategy of the import in progress, or
+// ConflictFail if there is none.
+func (l *Library) onConflict() Conflict {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.imports) == 0 || l.imports[len(l.imports)-1].OnConflict == "" {
+		return ConflictFail
+	}
+
+	return l.imports[len(l.imports)-1].OnConflict
+}
+
+// withConflict calls fn as if by an import with the conflict strategy, e.g. to
+// replay a command executed by such an import.
+func (l *Library) withConflict(c Conflict, fn func() error) error {
+	if c == "" {
+		return fn()
+	}
+
+	l.mu.Lock()
+	l.imports = append(l.imports, ImportOptions{OnConflict: c})
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.imports = l.imports[:len(l.imports)-1]
+	}()
+
+	return fn()
+}
+
+// resolveBook resolves the book added by an ADD_BOOK command with the book of
+// the same ID according to the conflict strategy. It reports whether there was
+// a book with the ID to resolve.
+func (l *Library) resolveBook(cmd *AddBook, c Conflict) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	book, ok := l.books[cmd.ID]
+	if !ok || c == ConflictFail || c == "" {
+		return false, nil
+	}
+
+	prev := *book
+	prev.Tags = slices.Clone(book.Tags)
+
+	switch c {
+	case ConflictSkip:
+		return true, nil
+	case ConflictOverwrite:
+		if checkedOut := len(l.checkoutsByBook[book.ID]); cmd.Count < checkedOut {
+			return true, &NotEnoughCopiesError{
+				BookID:    book.ID,
+				Available: checkedOut,
+				detail:    fmt.Sprintf("cannot replace %s (%d) with fewer copies than are checked out (%d)", book.Name, book.ID, checkedOut),
+			}
+		}
+
+		book.Name = cmd.Name
+		book.Count = cmd.Count
+		book.BookMetadata = BookMetadata{Author: cmd.Author, ISBN: cmd.ISBN, Tags: slices.Clone(cmd.Tags)}
+	case ConflictMerge:
+		book.Count += cmd.Count
+		book.Name = cmp.Or(book.Name, cmd.Name)
+		book.Author = cmp.Or(book.Author, cmd.Author)
+		book.ISBN = cmp.Or(book.ISBN, cmd.ISBN)
+
+		for _, tag := range cmd.Tags {
+			if !slices.Contains(book.Tags, tag) {
+				book.Tags = append(book.Tags, tag)
+			}
+		}
+	default:
+		return true, fmt.Errorf("%w, unknown conflict strategy %q", ErrInvalidArgument, c)
+	}
+
+	book.Version = prev.Version + 1
+
+	l.pushUndo(fmt.Sprintf("%s %s (%d) in the catalog", c, book.Name, book.ID), func() {
+		version := book.Version
+		*book = prev
+		book.Version = version + 1
+	})
+
+	return true, nil
+}
+
+// resolveAccount resolves the account created by a CREATE_ACCOUNT command with
+// the account of the same ID according to the conflict strategy. It reports
+// whether there was an account with the ID to resolve.
+func (l *Library) resolveAccount(cmd *CreateAccount, c Conflict) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account, ok := l.accounts[cmd.ID]
+	if !ok || c == ConflictFail || c == "" {
+		return false, nil
+	}
+
+	prev := *account
+
+	switch c {
+	case ConflictSkip:
+		return true, nil
+	case ConflictOverwrite:
+		account.Name = cmd.Name
+		account.Balance = cmd.Balance
+	case ConflictMerge:
+		account.Name = cmp.Or(account.Name, cmd.Name)
+		account.Balance += cmd.Balance
+	default:
+		return true, fmt.Errorf("%w, unknown conflict strategy %q", ErrInvalidArgument, c)
+	}
+
+	account.Version = prev.Version + 1
+
+	l.pushUndo(fmt.Sprintf("%s account %s (%d)", c, account.Name, account.ID), func() {
+		version := account.Version
+		*account = prev
+		account.Version = version + 1
+	})
+
+	return true, nil
+}
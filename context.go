@@ -0,0 +1,70 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ImportContext imports the commands from a reader in the same way as Import,
+// but stops before the next command once the context is done, returning an
+// error wrapping the error of the context, e.g. context.Canceled. The commands
+// before it have already been executed, as with a command that fails.
+//
+// The imports of INCLUDE commands inherit the context.
+func (l *Library) ImportContext(ctx context.Context, r io.Reader, opts ImportOptions) error {
+	opts.ctx = ctx
+
+	return l.Import(r, opts)
+}
+
+// ImportFileContext imports the commands from the file at path in the same way
+// as ImportContext.
+func (l *Library) ImportFileContext(ctx context.Context, path string, opts ImportOptions) error {
+	opts.ctx = ctx
+
+	return l.ImportFile(path, opts)
+}
+
+// ExportContext writes the library state to a writer in the same way as
+// Export, but stops writing once the context is done, returning an error
+// wrapping the error of the context.
+func (l *Library) ExportContext(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	opts.ctx = ctx
+
+	return l.Export(w, opts)
+}
+
+// ExportFileContext writes the library state to the file at path in the same
+// way as ExportContext. If the context is done before the export is written,
+// the file is left as is.
+func (l *Library) ExportFileContext(ctx context.Context, path string, opts ExportOptions) error {
+	opts.ctx = ctx
+
+	return l.ExportFile(path, opts)
+}
+
+// context returns the context of the import, see ImportContext.
+func (opts ImportOptions) context() context.Context {
+	if opts.ctx == nil {
+		return context.Background()
+	}
+
+	return opts.ctx
+}
+
+// contextWriter is a writer that fails once its context is done, so that an
+// export in any format stops at its next write, see ExportContext.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// Write implements io.Writer.
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	return w.w.Write(p)
+}
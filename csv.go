@@ -0,0 +1,297 @@
+package library
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportCSV imports the commands from CSV with one command per row, since
+// catalogs and rosters are usually maintained as spreadsheets rather than as
+// command files.
+//
+// The first row is a header naming the argument each column maps to, e.g.
+// "id,name,count,author,isbn,tags" for ADD_BOOK or "id,name,balance" for
+// CREATE_ACCOUNT. Each row is executed as the command named in the optional
+// "command" column, or as the provided command if the column is absent or
+// empty.
+//
+// Empty cells are omitted so that optional arguments can be left blank, and
+// the values of list arguments such as tags are separated by semicolons.
+// Rows starting with # are ignored. The rows are executed in the same way as
+// the commands of Import, and an *ImportError reports the line of the row.
+// A checkpoint records the line a row starts on, see ImportOptions.Checkpoint.
+func (l *Library) ImportCSV(r io.Reader, command string, opts ImportOptions) error {
+	if ok, err := l.dryRun(opts, func(c *Library, opts ImportOptions) error {
+		return c.ImportCSV(r, command, opts)
+	}); ok {
+		return err
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header, %w", err)
+	}
+
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+	}
+
+	var results *json.Encoder
+	if opts.ResultWriter != nil {
+		results = json.NewEncoder(opts.ResultWriter)
+	}
+
+	var n int
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			if n < opts.Resume.Line {
+				return fmt.Errorf("%w, input ends before the checkpoint at line %d", ErrInvalidArgument, opts.Resume.Line)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read CSV, %w", err)
+		}
+
+		n, _ = cr.FieldPos(0)
+		offset := cr.InputOffset()
+
+		if n == opts.Resume.Line && offset != opts.Resume.Offset {
+			err := fmt.Errorf("%w, input does not match the checkpoint at line %d, offset %d", ErrInvalidArgument, opts.Resume.Line, opts.Resume.Offset)
+
+			return &ImportError{Line: n, Raw: strings.Join(row, ","), Err: err}
+		}
+
+		// The rows up to the checkpoint were executed by the import
+		// being resumed.
+		if n <= opts.Resume.Line {
+			continue
+		}
+
+		line, err := csvCommand(command, header, row)
+		if err != nil {
+			return &ImportError{Line: n, Raw: strings.Join(row, ","), Err: err}
+		}
+
+		if err := l.importLine(n, line, time.Time{}, opts, results); err != nil {
+			return err
+		}
+
+		if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(Progress{Line: n, Offset: offset}); err != nil {
+				return &ImportError{Line: n, Raw: strings.Join(row, ","), Err: fmt.Errorf("failed to checkpoint import, %w", err)}
+			}
+		}
+	}
+}
+
+// CatalogColumns are the columns ExportCatalogCSV can write. The available
+// column is the number of copies available to check out and the checkedOut
+// column the number of copies checked out.
+var CatalogColumns = []string{"id", "name", "count", "author", "isbn", "tags", "available", "checkedOut"}
+
+// DefaultCatalogColumns are the columns ExportCatalogCSV writes by default,
+// the arguments of ADD_BOOK, so that the export can be imported again with
+// ImportCSV.
+var DefaultCatalogColumns = []string{"id", "name", "count", "author", "isbn", "tags"}
+
+// ExportCatalogCSV writes the books of the catalog to a writer as CSV with a
+// header row of the columns, in order of ID, since staff usually want to open
+// the catalog in a spreadsheet.
+//
+// The columns are any of CatalogColumns in any order, DefaultCatalogColumns
+// if empty. The tags are separated by semicolons, as ImportCSV expects.
+func (l *Library) ExportCatalogCSV(w io.Writer, columns []string) error {
+	if len(columns) == 0 {
+		columns = DefaultCatalogColumns
+	}
+
+	for _, column := range columns {
+		if !slices.Contains(CatalogColumns, column) {
+			return fmt.Errorf("%w, unknown catalog column %q, expected one of %s", ErrInvalidArgument, column, strings.Join(CatalogColumns, ", "))
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(columns); err != nil {
+		return fmt.Errorf("failed to write catalog, %w", err)
+	}
+
+	row := make([]string, len(columns))
+
+	for _, book := range l.sortedBooks() {
+		checkedOut := len(l.checkoutsByBook[book.ID])
+
+		for i, column := range columns {
+			switch column {
+			case "id":
+				row[i] = strconv.Itoa(book.ID)
+			case "name":
+				row[i] = book.Name
+			case "count":
+				row[i] = strconv.Itoa(book.Count)
+			case "author":
+				row[i] = book.Author
+			case "isbn":
+				row[i] = book.ISBN
+			case "tags":
+				row[i] = strings.Join(book.Tags, ";")
+			case "available":
+				row[i] = strconv.Itoa(book.Count - checkedOut)
+			case "checkedOut":
+				row[i] = strconv.Itoa(checkedOut)
+			}
+		}
+
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write catalog, %w", err)
+		}
+	}
+
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write catalog, %w", err)
+	}
+
+	return nil
+}
+
+// csvCommand returns the JSON command for a row of CSV.
+//
+// The cells are converted to JSON based on the type of the argument of the
+// command they map to, so that e.g. an ISBN of digits remains a string while
+// a count becomes a number.
+func csvCommand(command string, header, row []string) ([]byte, error) {
+	args := make(map[string]any, len(row))
+
+	for i, cell := range row {
+		cell = strings.TrimSpace(cell)
+
+		if header[i] == "command" {
+			if cell != "" {
+				command = cell
+			}
+
+			continue
+		}
+
+		if cell != "" {
+			args[header[i]] = cell
+		}
+	}
+
+	if command == "" {
+		return nil, fmt.Errorf("%w, no command for row, expected a command column", ErrInvalidCommand)
+	}
+
+	// Macros and unknown commands have no argument types, so their values
+	// are converted based on the values alone.
+	var fields map[string]reflect.Type
+
+	if c, ok := commandByName(command); ok {
+		fields = argumentTypes(reflect.TypeOf(c.factory()).Elem())
+	}
+
+	for name, cell := range args {
+		args[name] = csvValue(fields[name], cell.(string))
+	}
+
+	bs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Command{Name: command, Arguments: bs})
+}
+
+// csvValue converts a CSV cell to the JSON value of an argument of type t, or
+// based on the value alone if t is nil.
+//
+// Values that cannot be converted are left as strings so that the invalid
+// argument is reported when the command is parsed.
+func csvValue(t reflect.Type, cell string) any {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t != nil {
+		switch {
+		case t.Kind() == reflect.String:
+			return cell
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+			values := strings.Split(cell, ";")
+
+			for i, v := range values {
+				values[i] = strings.TrimSpace(v)
+			}
+
+			return values
+		}
+	}
+
+	var v any
+
+	if err := json.Unmarshal([]byte(cell), &v); err == nil {
+		switch v.(type) {
+		case float64, bool:
+			return json.RawMessage(cell)
+		}
+	}
+
+	return cell
+}
+
+// argumentTypes returns the JSON argument names of a Command struct type and
+// the type of each.
+func argumentTypes(t reflect.Type) map[string]reflect.Type {
+	types := make(map[string]reflect.Type)
+
+	if t.Kind() != reflect.Struct {
+		return types
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		types[name] = f.Type
+	}
+
+	return types
+}
@@ -0,0 +1,170 @@
+package library
+
+import "slices"
+
+// StateDiff is the difference from one state of a library to another, see
+// Diff.
+type StateDiff struct {
+	Books     EntityDiff[*Book]     `json:"books"`
+	Accounts  EntityDiff[*Account]  `json:"accounts"`
+	Checkouts EntityDiff[*Checkout] `json:"checkouts"`
+	Holds     EntityDiff[*Hold]     `json:"holds"`
+}
+
+// EntityDiff is the books, accounts, checkouts or holds added, changed and
+// removed from one state to another.
+type EntityDiff[T any] struct {
+	Added   []T               `json:"added"`
+	Changed []Modification[T] `json:"changed"`
+	Removed []T               `json:"removed"`
+}
+
+// Modification is a book, account, checkout or hold that changed, before and
+// after the change.
+type Modification[T any] struct {
+	Before T `json:"before"`
+	After  T `json:"after"`
+}
+
+// Diff returns the difference from the state a to the state b, e.g. of a DB
+// before and after a migration, or of a replica and its primary:
+//
+//	d := library.Diff(replica.Snapshot(), primary.Snapshot())
+//	for _, book := range d.Books.Added {
+//		// Add the book to the replica.
+//	}
+//
+// The books and accounts are matched by ID, the checkouts by their book,
+// account and time, since checkouts have no ID, so a checkout that was
+// returned is changed, see StateDiff.Returned, and the holds by their book and
+// account. The entities are in the order
+// of b, followed by those removed in the order of a. The lists are empty rather
+// than nil, so that they are encoded as empty lists.
+//
+// The snapshots are not modified and the diff shares its entities with them.
+func Diff(a, b *Snapshot) *StateDiff {
+	return &StateDiff{
+		Books:     diffEntities(a.Books, b.Books, bookID, equalBooks),
+		Accounts:  diffEntities(a.Accounts, b.Accounts, accountID, equalAccounts),
+		Checkouts: diffEntities(a.Checkouts, b.Checkouts, keyOfCheckout, equalCheckouts),
+		Holds:     diffEntities(a.Holds, b.Holds, keyOfHold, equalHolds),
+	}
+}
+
+// Empty reports whether the states are the same.
+func (d *StateDiff) Empty() bool {
+	return d.Books.Empty() && d.Accounts.Empty() && d.Checkouts.Empty() && d.Holds.Empty()
+}
+
+// CountChanged returns the books whose number of copies changed.
+func (d *StateDiff) CountChanged() []Modification[*Book] {
+	var changed []Modification[*Book]
+
+	for _, m := range d.Books.Changed {
+		if m.Before.Count != m.After.Count {
+			changed = append(changed, m)
+		}
+	}
+
+	return changed
+}
+
+// Returned returns the checkouts that were returned, those added already
+// returned and those changed to returned, as they are after the return. The
+// checkouts opened are those added, see EntityDiff.Added.
+func (d *StateDiff) Returned() []*Checkout {
+	var returned []*Checkout
+
+	for _, checkout := range d.Checkouts.Added {
+		if !checkout.Returned.IsZero() {
+			returned = append(returned, checkout)
+		}
+	}
+
+	for _, m := range d.Checkouts.Changed {
+		if m.Before.Returned.IsZero() && !m.After.Returned.IsZero() {
+			returned = append(returned, m.After)
+		}
+	}
+
+	return returned
+}
+
+// Empty reports whether nothing was added, changed or removed.
+func (d *EntityDiff[T]) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// checkoutKey identifies a checkout across states, since checkouts have no
+// ID.
+type checkoutKey struct {
+	bookID, accountID int
+	checkedOut        int64
+}
+
+// diffEntities returns the changes from the entities in a to the entities in
+// b, matched by their keys, in the order of b followed by those removed in the
+// order of a.
+func diffEntities[T any, K comparable](a, b []T, key func(T) K, equal func(x, y T) bool) EntityDiff[T] {
+	d := EntityDiff[T]{Added: []T{}, Changed: []Modification[T]{}, Removed: []T{}}
+
+	old := make(map[K]T, len(a))
+	for _, e := range a {
+		old[key(e)] = e
+	}
+
+	matched := make(map[K]bool, len(b))
+
+	for _, e := range b {
+		k := key(e)
+		matched[k] = true
+
+		prev, ok := old[k]
+
+		switch {
+		case !ok:
+			d.Added = append(d.Added, e)
+		case !equal(prev, e):
+			d.Changed = append(d.Changed, Modification[T]{Before: prev, After: e})
+		}
+	}
+
+	for _, e := range a {
+		if !matched[key(e)] {
+			d.Removed = append(d.Removed, e)
+		}
+	}
+
+	return d
+}
+
+// holdKey identifies a hold across states, since an account has at most one
+// hold on a book.
+type holdKey struct{ bookID, accountID int }
+
+// keyOfHold returns the key of the hold.
+func keyOfHold(h *Hold) holdKey {
+	return holdKey{bookID: h.BookID, accountID: h.AccountID}
+}
+
+// keyOfCheckout returns the key of the checkout.
+func keyOfCheckout(c *Checkout) checkoutKey {
+	return checkoutKey{bookID: c.BookID, accountID: c.AccountID, checkedOut: c.CheckedOut.UnixNano()}
+}
+
+// equalBooks reports whether the books are equal. The versions of books, as of
+// accounts, count their changes rather than describe them and are not compared.
+func equalBooks(a, b *Book) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.Count == b.Count && a.Author == b.Author && a.ISBN == b.ISBN && slices.Equal(a.Tags, b.Tags)
+}
+
+// equalAccounts reports whether the accounts are equal.
+func equalAccounts(a, b *Account) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.Balance == b.Balance
+}
+
+// equalCheckouts reports whether the checkouts are equal, including times in
+// different locations.
+func equalCheckouts(a, b *Checkout) bool {
+	return a.BookID == b.BookID && a.AccountID == b.AccountID && a.CheckedOut.Equal(b.CheckedOut) && a.Due.Equal(b.Due) && a.Returned.Equal(b.Returned)
+}
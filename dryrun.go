@@ -0,0 +1,49 @@
+package library
+
+import "slices"
+
+// dryRun executes an import against a copy of the library if the options are
+// a dry run, see ImportOptions.DryRun, and reports whether it did, so that the
+// import functions can return its error rather than importing into the
+// library:
+//
+//	if ok, err := l.dryRun(opts, func(c *Library, opts ImportOptions) error {
+//		return c.Import(r, opts)
+//	}); ok {
+//		return err
+//	}
+func (l *Library) dryRun(opts ImportOptions, imp func(c *Library, opts ImportOptions) error) (bool, error) {
+	if !opts.DryRun {
+		return false, nil
+	}
+
+	c := l.dryRunCopy()
+
+	// The commands are only executed against the copy, so there is
+	// nothing to persist or resume.
+	opts.DryRun = false
+	opts.AfterExec = nil
+	opts.Checkpoint = nil
+
+	err := imp(c, opts)
+
+	if opts.OnDryRun != nil {
+		opts.OnDryRun(c.Snapshot())
+	}
+
+	return true, err
+}
+
+// dryRunCopy returns a copy of the library to execute the commands of a dry
+// run against, see Clone, that is importing the same files as the library so
+// that include cycles are still detected.
+func (l *Library) dryRunCopy() *Library {
+	c := l.Clone()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	c.importing = slices.Clone(l.importing)
+
+	return c
+}
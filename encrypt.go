@@ -0,0 +1,134 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrEncrypted is returned when encrypted state is imported without a
+	// key.
+	ErrEncrypted = errors.New("state is encrypted")
+)
+
+// encryptedHeader is the line an encrypted export starts with, followed by
+// the nonce and the AES-GCM sealed export.
+const encryptedHeader = "# aes-gcm\n"
+
+// encryptWith returns a write function that encrypts the output of write with
+// AES-GCM using the key, or write as is if the key is empty.
+//
+// The key must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+// The export is sealed as a whole, so it is authenticated as well as
+// encrypted and any modification fails to decrypt.
+func encryptWith(key []byte, write func(w io.Writer) error) func(w io.Writer) error {
+	if len(key) == 0 {
+		return write
+	}
+
+	return func(w io.Writer) error {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return err
+		}
+
+		var plaintext bytes.Buffer
+
+		if err := write(&plaintext); err != nil {
+			return err
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("failed to encrypt library state, %w", err)
+		}
+
+		out := append([]byte(encryptedHeader), nonce...)
+		out = gcm.Seal(out, nonce, plaintext.Bytes(), []byte(encryptedHeader))
+
+		if _, err := w.Write(out); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+
+		return nil
+	}
+}
+
+// decrypt returns the decrypted contents of an encrypted export, or the
+// contents as is if they are not encrypted so that plaintext state can still
+// be loaded once a key is configured.
+//
+// If the contents are encrypted and the key is empty, ErrEncrypted is
+// returned. If the key is wrong or the contents were modified, an error
+// wrapping ErrCorrupt is returned.
+func decrypt(bs, key []byte) ([]byte, error) {
+	sealed, ok := bytes.CutPrefix(bs, []byte(encryptedHeader))
+	if !ok {
+		return bs, nil
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w, a key is required to read it", ErrEncrypted)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w, encrypted state is truncated", ErrCorrupt)
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(encryptedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("%w, failed to decrypt, wrong key or modified state", ErrCorrupt)
+	}
+
+	return plaintext, nil
+}
+
+// decryptReader returns a reader of the decrypted contents of r if r is an
+// encrypted export, or of r as is otherwise, see decrypt.
+func decryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(encryptedHeader))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if string(header) != encryptedHeader {
+		return br, nil
+	}
+
+	bs, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if bs, err = decrypt(bs, key); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(bs), nil
+}
+
+// newGCM returns an AES-GCM cipher for the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w, encryption key must be 16, 24 or 32 bytes", ErrInvalidArgument)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,130 @@
+package library
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"slices"
+)
+
+// Equal reports whether the library has the same state as another, see
+// Snapshot.Equal, e.g. to check that a library survives a round trip through
+// Export and Import:
+//
+//	var buf bytes.Buffer
+//	if err := l.Export(&buf, library.ExportOptions{}); err != nil {
+//		return err
+//	}
+//
+//	imported := library.New()
+//	if err := imported.Import(&buf, library.ImportOptions{}); err != nil {
+//		return err
+//	}
+//
+//	if !imported.Equal(l) {
+//		// The export lost some of the state.
+//	}
+func (l *Library) Equal(other *Library) bool {
+	if l == other {
+		return true
+	}
+
+	return l.Snapshot().Equal(other.Snapshot())
+}
+
+// Equal reports whether the snapshot has the same state as another:
+//
+//   - the same policy, the empty policy being the DefaultPolicy
+//   - the same books and accounts, in any order
+//   - the same checkouts in the same order, at the same times in any location
+//   - the same holds, in the same order for each book
+//   - the same macros in the same order, but for the whitespace of their
+//     commands
+//   - the same inventory audit in progress, if any
+//
+// The version and log sequence of the snapshots, which describe how they were
+// stored rather than the state, and the versions of books and accounts, which
+// count their changes, are not compared. Unlike the JSON encodings of
+// the snapshots, the tags of books and the barcodes of an inventory audit that
+// are empty are equal to those that are nil.
+func (s *Snapshot) Equal(other *Snapshot) bool {
+	if s == other {
+		return true
+	}
+
+	policy := func(s *Snapshot) Policy {
+		if s.Policy == (Policy{}) {
+			return DefaultPolicy()
+		}
+
+		return s.Policy
+	}
+
+	return policy(s) == policy(other) &&
+		slices.EqualFunc(sortedByID(s.Books, bookID), sortedByID(other.Books, bookID), equalBooks) &&
+		slices.EqualFunc(sortedByID(s.Accounts, accountID), sortedByID(other.Accounts, accountID), equalAccounts) &&
+		slices.EqualFunc(s.Checkouts, other.Checkouts, equalCheckouts) &&
+		slices.EqualFunc(sortedHolds(s.Holds), sortedHolds(other.Holds), equalHolds) &&
+		slices.EqualFunc(s.Macros, other.Macros, equalMacros) &&
+		equalInventories(s.Inventory, other.Inventory)
+}
+
+// sortedByID returns a copy of the books or accounts sorted by ID.
+func sortedByID[T any](values []T, id func(T) int) []T {
+	return slices.SortedFunc(slices.Values(values), func(a, b T) int {
+		return cmp.Compare(id(a), id(b))
+	})
+}
+
+// bookID returns the ID of the book.
+func bookID(book *Book) int { return book.ID }
+
+// accountID returns the ID of the account.
+func accountID(account *Account) int { return account.ID }
+
+// sortedHolds returns a copy of the holds sorted by the ID of the book, keeping
+// the order of the hold queue of each book.
+func sortedHolds(holds []*Hold) []*Hold {
+	holds = slices.Clone(holds)
+
+	slices.SortStableFunc(holds, func(a, b *Hold) int { return cmp.Compare(a.BookID, b.BookID) })
+
+	return holds
+}
+
+// equalHolds reports whether the holds are equal, placed at the same time in
+// any location.
+func equalHolds(a, b *Hold) bool {
+	return a.AccountID == b.AccountID && a.BookID == b.BookID && a.Placed.Equal(b.Placed)
+}
+
+// equalMacros reports whether the macros are equal, ignoring the whitespace of
+// their commands, e.g. of a snapshot written with indentation.
+func equalMacros(a, b *Macro) bool {
+	return a.Name == b.Name && slices.Equal(a.Params, b.Params) && slices.EqualFunc(a.Commands, b.Commands, equalJSON)
+}
+
+// equalJSON reports whether the JSON values are equal but for whitespace.
+func equalJSON(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+
+	var x, y bytes.Buffer
+
+	if json.Compact(&x, a) != nil || json.Compact(&y, b) != nil {
+		return false
+	}
+
+	return bytes.Equal(x.Bytes(), y.Bytes())
+}
+
+// equalInventories reports whether the inventory audits are equal, both nil
+// if there is none.
+func equalInventories(a, b *SnapshotInventory) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Started.Equal(b.Started) && slices.Equal(a.Barcodes, b.Barcodes)
+}
@@ -0,0 +1,259 @@
+package library
+
+import "fmt"
+
+// The kinds of the entities of the typed errors, e.g. NotExistError.Kind.
+const (
+	KindBook     = "book"
+	KindAccount  = "account"
+	KindCheckout = "checkout"
+	KindMacro    = "macro"
+	KindHold     = "hold"
+)
+
+// NotExistError is returned when a book, account, checkout, macro or hold does
+// not exist. It wraps ErrBookNotExist, ErrAccountNotExist, ErrCheckoutNotExist,
+// ErrMacroNotExist or ErrHoldNotExist by its kind, so that errors.Is matches
+// them, e.g.:
+//
+//	var nerr *library.NotExistError
+//	if errors.As(err, &nerr) && nerr.Kind == library.KindBook {
+//		// Add book nerr.ID.
+//	}
+type NotExistError struct {
+	Kind string // Kind of the entity, e.g. KindBook.
+	// ID is the ID of the book or account, or of the book of a checkout
+	// or hold.
+	ID int
+	// AccountID is the ID of the account of a checkout or hold.
+	AccountID int
+	// Name is the name of a macro.
+	Name string
+
+	detail string // Description of the entity, if not described by its ID.
+}
+
+// Error implements the error interface.
+func (e *NotExistError) Error() string {
+	detail := e.detail
+
+	if detail == "" {
+		switch e.Kind {
+		case KindCheckout:
+			detail = fmt.Sprintf("book (%d) by account (%d)", e.ID, e.AccountID)
+		case KindHold:
+			detail = fmt.Sprintf("book (%d) for account (%d)", e.ID, e.AccountID)
+		case KindMacro:
+			detail = "macro " + e.Name
+		default:
+			detail = fmt.Sprintf("%s (%d)", e.Kind, e.ID)
+		}
+	}
+
+	return e.Unwrap().Error() + ", " + detail
+}
+
+// Unwrap returns the error of the kind of the entity, e.g. ErrBookNotExist.
+func (e *NotExistError) Unwrap() error {
+	switch e.Kind {
+	case KindAccount:
+		return ErrAccountNotExist
+	case KindCheckout:
+		return ErrCheckoutNotExist
+	case KindMacro:
+		return ErrMacroNotExist
+	case KindHold:
+		return ErrHoldNotExist
+	default:
+		return ErrBookNotExist
+	}
+}
+
+// Code returns the ErrorCode of the error, e.g. CodeBookNotFound.
+func (e *NotExistError) Code() ErrorCode {
+	return ErrorCodeOf(e.Unwrap())
+}
+
+// DuplicateError is returned when a book, account or macro is added with the
+// ID or name of one that already exists, or a hold is placed on a book the
+// account already has a hold on. It wraps ErrDuplicateID.
+type DuplicateError struct {
+	Kind string // Kind of the entity, e.g. KindBook.
+	ID   int    // ID of the book or account, or of the book of a hold.
+	Name string // Name of the macro.
+
+	detail string // Description of the entity, if not described by its ID.
+}
+
+// Error implements the error interface.
+func (e *DuplicateError) Error() string {
+	detail := e.detail
+
+	if detail == "" {
+		if e.Kind == KindMacro {
+			detail = "macro " + e.Name
+		} else {
+			detail = fmt.Sprintf("%s (%d)", e.Kind, e.ID)
+		}
+	}
+
+	return ErrDuplicateID.Error() + ", " + detail
+}
+
+// Unwrap returns ErrDuplicateID.
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicateID
+}
+
+// Code returns CodeDuplicateID.
+func (e *DuplicateError) Code() ErrorCode {
+	return CodeDuplicateID
+}
+
+// LimitError is returned when a checkout would exceed the checkout limit of an
+// account. It wraps ErrLimitExceeded.
+type LimitError struct {
+	AccountID int // ID of the account.
+	Limit     int // Checkout limit of the policy.
+
+	detail string // Description of the failure, if not described by the IDs.
+}
+
+// Error implements the error interface.
+func (e *LimitError) Error() string {
+	detail := e.detail
+
+	if detail == "" {
+		detail = fmt.Sprintf("account (%d) cannot checkout more than %d books at a time", e.AccountID, e.Limit)
+	}
+
+	return ErrLimitExceeded.Error() + ", " + detail
+}
+
+// Unwrap returns ErrLimitExceeded.
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Code returns CodeLimitExceeded.
+func (e *LimitError) Code() ErrorCode {
+	return CodeLimitExceeded
+}
+
+// AlreadyCheckedOutError is returned when an account checks out a book it
+// already has checked out. It wraps ErrAlreadyCheckedOut.
+type AlreadyCheckedOutError struct {
+	AccountID int // ID of the account.
+	BookID    int // ID of the book.
+
+	detail string // Description of the failure, if not described by the IDs.
+}
+
+// Error implements the error interface.
+func (e *AlreadyCheckedOutError) Error() string {
+	detail := e.detail
+
+	if detail == "" {
+		detail = fmt.Sprintf("account (%d) cannot checkout more than one copy of book (%d)", e.AccountID, e.BookID)
+	}
+
+	return ErrAlreadyCheckedOut.Error() + ", " + detail
+}
+
+// Unwrap returns ErrAlreadyCheckedOut.
+func (e *AlreadyCheckedOutError) Unwrap() error {
+	return ErrAlreadyCheckedOut
+}
+
+// Code returns CodeAlreadyCheckedOut.
+func (e *AlreadyCheckedOutError) Code() ErrorCode {
+	return CodeAlreadyCheckedOut
+}
+
+// NotEnoughCopiesError is returned when more copies of a book are removed, or
+// fewer copies are kept, than the library has or are checked out, or a book is
+// checked out with no copies available. It wraps ErrNotEnoughCopies.
+type NotEnoughCopiesError struct {
+	BookID    int // ID of the book.
+	Available int // Number of copies that could be removed, must be kept or are available.
+
+	detail string // Description of the failure, if not described by the IDs.
+}
+
+// Error implements the error interface.
+func (e *NotEnoughCopiesError) Error() string {
+	detail := e.detail
+
+	if detail == "" {
+		detail = fmt.Sprintf("book (%d) has %d copies available", e.BookID, e.Available)
+	}
+
+	return ErrNotEnoughCopies.Error() + ", " + detail
+}
+
+// Unwrap returns ErrNotEnoughCopies.
+func (e *NotEnoughCopiesError) Unwrap() error {
+	return ErrNotEnoughCopies
+}
+
+// Code returns CodeNotEnoughCopies.
+func (e *NotEnoughCopiesError) Code() ErrorCode {
+	return CodeNotEnoughCopies
+}
+
+// QuotaError is returned when adding a book or creating an account would
+// exceed the quota of the library. It wraps ErrQuotaExceeded.
+type QuotaError struct {
+	Kind  string // Kind of the entity, KindBook or KindAccount.
+	Limit int    // Number of books or accounts allowed by the quota.
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v, library is limited to %d %ss", ErrQuotaExceeded, e.Limit, e.Kind)
+}
+
+// Unwrap returns ErrQuotaExceeded.
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Code returns CodeQuotaExceeded.
+func (e *QuotaError) Code() ErrorCode {
+	return CodeQuotaExceeded
+}
+
+// VersionError is returned when a book or account is updated conditionally and
+// has changed since the version, see UpdateBookIf. It wraps
+// ErrVersionMismatch.
+type VersionError struct {
+	Kind     string // Kind of the entity, KindBook or KindAccount.
+	ID       int    // ID of the book or account.
+	Version  int    // Current version of the book or account.
+	Expected int    // Version the book or account was expected to be at.
+}
+
+// Error implements the error interface.
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%v, %s (%d) is at version %d, not %d", ErrVersionMismatch, e.Kind, e.ID, e.Version, e.Expected)
+}
+
+// Unwrap returns ErrVersionMismatch.
+func (e *VersionError) Unwrap() error {
+	return ErrVersionMismatch
+}
+
+// Code returns CodeVersionMismatch.
+func (e *VersionError) Code() ErrorCode {
+	return CodeVersionMismatch
+}
+
+// alreadyCheckedOut returns the error of an account checking out a book it
+// already has checked out.
+func alreadyCheckedOut(account *Account, book *Book) error {
+	return &AlreadyCheckedOutError{
+		AccountID: account.ID,
+		BookID:    book.ID,
+		detail:    fmt.Sprintf("%s (%d) cannot checkout more than one copy of %s (%d)", account.Name, account.ID, book.Name, book.ID),
+	}
+}
@@ -0,0 +1,103 @@
+package library
+
+import "time"
+
+// The types of the events of the changes of a library, see EventsOf.
+const (
+	EventBookAdded        = "book.added"
+	EventBookUpdated      = "book.updated"
+	EventAccountCreated   = "account.created"
+	EventAccountUpdated   = "account.updated"
+	EventCheckoutCreated  = "checkout.created"
+	EventCheckoutReturned = "checkout.returned"
+	EventHoldPlaced       = "hold.placed"
+	EventHoldCanceled     = "hold.canceled"
+	// EventHoldAvailable is the event of a copy of a book becoming
+	// available for a hold at the head of its hold queue, e.g. when a copy
+	// is returned, for the account of the hold to be told to check it out.
+	// It follows the event of the command that made the copy available.
+	EventHoldAvailable = "hold.available"
+	// EventCheckoutOverdue is the event of an active checkout becoming
+	// past due, which is made by the passing of time rather than by a
+	// command, so it is not returned by EventsOf.
+	EventCheckoutOverdue = "checkout.overdue"
+	// EventLibraryChanged is the event of a change by any other command,
+	// e.g. SET_POLICY, named by the Command of the event.
+	EventLibraryChanged = "library.changed"
+)
+
+// Event is a domain event of a change of a library, e.g. a checkout, for
+// consumers that react to what happened rather than replaying the commands,
+// e.g. a dashboard.
+type Event struct {
+	Type     string    `json:"type"`
+	Sequence int       `json:"sequence"` // Sequence number of the change, see Change.
+	At       time.Time `json:"at"`
+	// BookID and AccountID are the IDs of the book and account the event
+	// is about, if any.
+	BookID    int `json:"bookId,omitempty"`
+	AccountID int `json:"accountId,omitempty"`
+	// Command is the name of the command that made the change, empty for
+	// events that are not made by a command, e.g. checkout.overdue.
+	Command string `json:"command,omitempty"`
+}
+
+// EventsOf returns the events of a change, e.g. one checkout.created event for
+// each book checked out by a BULK_CHECKOUT, followed by a hold.available event
+// for each hold the change made a copy available for.
+func EventsOf(c Change) []Event {
+	name := ""
+	if cmd, ok := commandByType(c.Invocation.Command); ok {
+		name = cmd.name
+	}
+
+	event := func(typ string, bookID, accountID int) Event {
+		return Event{
+			Type:      typ,
+			Sequence:  c.Seq,
+			At:        c.Invocation.At,
+			BookID:    bookID,
+			AccountID: accountID,
+			Command:   name,
+		}
+	}
+
+	var events []Event
+
+	switch cmd := c.Invocation.Command.(type) {
+	case *AddBook:
+		events = append(events, event(EventBookAdded, cmd.ID, 0))
+	case *AddCopies:
+		events = append(events, event(EventBookUpdated, cmd.ID, 0))
+	case *RemoveCopies:
+		events = append(events, event(EventBookUpdated, cmd.ID, 0))
+	case *CreateAccount:
+		events = append(events, event(EventAccountCreated, 0, cmd.ID))
+	case *AddCredit:
+		events = append(events, event(EventAccountUpdated, 0, cmd.AccountID))
+	case *CheckoutBook:
+		events = append(events, event(EventCheckoutCreated, cmd.BookID, cmd.AccountID))
+	case *ReturnBook:
+		events = append(events, event(EventCheckoutReturned, cmd.BookID, cmd.AccountID))
+	case *BulkCheckout:
+		for _, id := range cmd.BookIDs {
+			events = append(events, event(EventCheckoutCreated, id, cmd.AccountID))
+		}
+	case *BulkReturn:
+		for _, id := range cmd.BookIDs {
+			events = append(events, event(EventCheckoutReturned, id, cmd.AccountID))
+		}
+	case *PlaceHold:
+		events = append(events, event(EventHoldPlaced, cmd.BookID, cmd.AccountID))
+	case *CancelHold:
+		events = append(events, event(EventHoldCanceled, cmd.BookID, cmd.AccountID))
+	default:
+		events = append(events, event(EventLibraryChanged, 0, 0))
+	}
+
+	for _, hold := range c.Available {
+		events = append(events, event(EventHoldAvailable, hold.BookID, hold.AccountID))
+	}
+
+	return events
+}
@@ -0,0 +1,53 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFileAccess is returned when a command that reads or writes files is
+// executed against a library that does not allow it, see SetFileAccess.
+var ErrFileAccess = errors.New("file access is not allowed")
+
+// FileCommand is implemented by the Commands that read or write the files of
+// the host, e.g. INCLUDE and EXPORT, which a library that does not allow file
+// access rejects, see SetFileAccess.
+type FileCommand interface {
+	// FileAccess marks the command as one that reads or writes files.
+	FileAccess()
+}
+
+// FileAccess reports whether the library executes the Commands that read or
+// write files, see SetFileAccess.
+func (l *Library) FileAccess() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return !l.noFileAccess
+}
+
+// SetFileAccess sets whether the library executes the Commands that implement
+// FileCommand, which are allowed by default. A library that does not allow
+// file access rejects them with ErrFileAccess, e.g. when serving the commands
+// of clients, which could otherwise read or overwrite any file the server can,
+// such as the DB of another library. The commands of a CALL_MACRO or
+// ASSERT_ERROR are checked in turn.
+//
+// Only the execution of commands is checked, the methods of the library can
+// still read and write files.
+func (l *Library) SetFileAccess(allowed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.noFileAccess = !allowed
+}
+
+// checkFileAccess returns an error wrapping ErrFileAccess if the library does
+// not allow file access and the command reads or writes files.
+func (l *Library) checkFileAccess(name string, cmd any) error {
+	if _, ok := cmd.(FileCommand); !ok || l.FileAccess() {
+		return nil
+	}
+
+	return fmt.Errorf("%w, %s reads or writes files", ErrFileAccess, name)
+}
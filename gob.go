@@ -0,0 +1,70 @@
+package library
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// gobHeader is the line a gob export starts with so that it can be told
+// apart from the JSON formats when it is loaded.
+const gobHeader = "# gob\n"
+
+// ExportGob writes the library state to a writer as a gob encoded Snapshot.
+//
+// Gob is a compact binary format that is loaded directly rather than by
+// executing commands, so it is much faster to load than the command log for
+// large libraries, but it can only be read by Go programs.
+func (l *Library) ExportGob(w io.Writer) error {
+	return writeGob(w, l.Snapshot())
+}
+
+// writeGob writes the snapshot to a writer as gob, see ExportGob.
+func writeGob(w io.Writer, s *Snapshot) error {
+	if _, err := io.WriteString(w, gobHeader); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	if err := gob.NewEncoder(w).Encode(s); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	return nil
+}
+
+// ImportGob reads a gob encoded Snapshot written by ExportGob and replaces the
+// state of the library with it, in the same way as ImportSnapshot.
+func (l *Library) ImportGob(r io.Reader) error {
+	bs, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if bs, err = stripChecksum(bs); err != nil {
+		return err
+	}
+
+	return l.importGob(bs)
+}
+
+// importGob loads the verified contents of a gob export.
+func (l *Library) importGob(bs []byte) error {
+	bs, ok := bytes.CutPrefix(bs, []byte(gobHeader))
+	if !ok {
+		return fmt.Errorf("%w, not a gob export", ErrInvalidArgument)
+	}
+
+	var s Snapshot
+
+	if err := gob.NewDecoder(bytes.NewReader(bs)).Decode(&s); err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	return l.LoadSnapshot(&s)
+}
+
+// isGob reports whether the contents of a state file are a gob export.
+func isGob(bs []byte) bool {
+	return bytes.HasPrefix(bs, []byte(gobHeader))
+}
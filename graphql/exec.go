@@ -0,0 +1,696 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// object is an object type of the schema.
+type object struct {
+	name   string
+	fields map[string]*fieldDef
+}
+
+// fieldDef is a field of an object type.
+type fieldDef struct {
+	typ  *typeRef
+	args map[string]*typeRef
+	// resolve returns the value of the field of the source object, with
+	// the arguments coerced to int, float64, string, bool, or []any.
+	// Arguments that are not given are not in args. A nil value must be
+	// returned as an untyped nil.
+	resolve func(src any, args map[string]any) (any, error)
+}
+
+// schema is the schema queries are executed against.
+type schema struct {
+	query   *object
+	objects map[string]*object
+}
+
+// scalars are the built-in scalar types.
+var scalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// errNull is returned while completing a value that is null in a non-null
+// position, so that the null propagates to the nearest nullable parent. The
+// error that caused it has already been recorded.
+var errNull = errors.New("null in non-null position")
+
+// queryError is an error in the query itself, e.g. an unknown field, which
+// fails the whole request rather than a single field.
+type queryError struct {
+	msg string
+	pos pos
+}
+
+// executor executes an operation of a document.
+type executor struct {
+	schema  *schema
+	doc     *document
+	vars    map[string]any
+	defined map[string]bool // Variables defined by the operation.
+	errs    []*Error
+}
+
+// execute executes the operation of the request and returns the data, or nil
+// if the null propagated to the root.
+func (e *executor) execute(req Request) (data *orderedObject, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			qerr, ok := r.(*queryError)
+			if !ok {
+				panic(r)
+			}
+
+			err = &Error{Message: qerr.msg, Locations: locations(qerr.pos)}
+		}
+	}()
+
+	op := e.operation(req.OperationName)
+
+	e.checkFragments()
+	e.coerceVariables(op, req.Variables)
+
+	data, err = e.executeSelections(e.schema.query, nil, op.sels, nil)
+	if errors.Is(err, errNull) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// operation returns the operation of the document to execute.
+func (e *executor) operation(name string) *operation {
+	var op *operation
+
+	switch {
+	case name == "" && len(e.doc.operations) > 1:
+		e.fail(pos{}, "operationName is required for a document with more than one operation")
+	case name == "":
+		op = e.doc.operations[0]
+	default:
+		for _, o := range e.doc.operations {
+			if o.name == name {
+				op = o
+			}
+		}
+
+		if op == nil {
+			e.fail(pos{}, "unknown operation %q", name)
+		}
+	}
+
+	if op.kind != "query" {
+		e.fail(op.pos, "%s operations are not supported, only query", op.kind)
+	}
+
+	return op
+}
+
+// checkFragments fails if a fragment spreads itself, directly or through
+// other fragments, or spreads a fragment that is not defined.
+func (e *executor) checkFragments() {
+	done := make(map[string]bool)
+
+	var visit func(sels []selection, path []string)
+
+	visit = func(sels []selection, path []string) {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *field:
+				visit(sel.sels, path)
+			case *inlineFragment:
+				visit(sel.sels, path)
+			case *fragmentSpread:
+				frag, ok := e.doc.fragments[sel.name]
+				if !ok {
+					e.fail(sel.pos, "unknown fragment %q", sel.name)
+				}
+
+				for _, name := range path {
+					if name == sel.name {
+						e.fail(sel.pos, "fragment %q spreads itself", sel.name)
+					}
+				}
+
+				if !done[sel.name] {
+					visit(frag.sels, append(path, sel.name))
+				}
+			}
+		}
+	}
+
+	for name, frag := range e.doc.fragments {
+		visit(frag.sels, []string{name})
+
+		done[name] = true
+	}
+
+	for _, op := range e.doc.operations {
+		visit(op.sels, nil)
+	}
+}
+
+// coerceVariables coerces the variables of the request to the types of the
+// variable definitions of the operation.
+func (e *executor) coerceVariables(op *operation, vars map[string]any) {
+	e.vars = make(map[string]any)
+	e.defined = make(map[string]bool)
+
+	for _, def := range op.vars {
+		e.defined[def.name] = true
+
+		x, ok := vars[def.name]
+
+		switch {
+		case !ok && def.def != nil:
+			v, err := e.coerceLiteral(def.def, def.typ)
+			if err != nil {
+				e.fail(def.def.pos, "invalid default value of $%s, %s", def.name, err)
+			}
+
+			e.vars[def.name] = v
+		case !ok && def.typ.nonNull:
+			e.fail(op.pos, "variable $%s of type %s is required", def.name, def.typ)
+		case ok:
+			v, err := coerceVariable(x, def.typ)
+			if err != nil {
+				e.fail(op.pos, "invalid value of $%s, %s", def.name, err)
+			}
+
+			e.vars[def.name] = v
+		}
+	}
+}
+
+// executeSelections executes the selections of an object, returning errNull
+// if a non-null field is null.
+func (e *executor) executeSelections(obj *object, src any, sels []selection, path []any) (*orderedObject, error) {
+	var groups fieldGroups
+
+	e.collectFields(obj, sels, &groups, make(map[string]bool))
+
+	out := &orderedObject{}
+
+	for _, key := range groups.keys {
+		fields := groups.byKey[key]
+		f := fields[0]
+		fieldPath := append(path[:len(path):len(path)], key)
+
+		if f.name == "__typename" {
+			if f.sels != nil {
+				e.fail(f.pos, "field \"__typename\" of type String! must not have a selection")
+			}
+
+			out.set(key, obj.name)
+
+			continue
+		}
+
+		def, ok := obj.fields[f.name]
+		if !ok {
+			e.fail(f.pos, "cannot query field %q on type %q", f.name, obj.name)
+		}
+
+		e.checkSelection(f, def.typ)
+
+		args := e.coerceArguments(f, def)
+
+		v, err := def.resolve(src, args)
+		if err != nil {
+			e.errs = append(e.errs, &Error{Message: err.Error(), Locations: locations(f.pos), Path: fieldPath})
+
+			if def.typ.nonNull {
+				return nil, errNull
+			}
+
+			out.set(key, nil)
+
+			continue
+		}
+
+		r, err := e.complete(def.typ, fields, v, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+
+		out.set(key, r)
+	}
+
+	return out, nil
+}
+
+// checkSelection fails if a field of an object type has no selection, or a
+// field of a scalar type has one.
+func (e *executor) checkSelection(f *field, t *typeRef) {
+	named := t
+	for named.elem != nil {
+		named = named.elem
+	}
+
+	switch {
+	case scalars[named.name] && f.sels != nil:
+		e.fail(f.pos, "field %q of type %s must not have a selection", f.name, t)
+	case !scalars[named.name] && f.sels == nil:
+		e.fail(f.pos, "field %q of type %s must have a selection", f.name, t)
+	}
+}
+
+// complete completes the value of a field according to its type, returning
+// errNull if the value is null in a non-null position.
+func (e *executor) complete(t *typeRef, fields []*field, v any, path []any) (any, error) {
+	if t.nonNull {
+		nullable := *t
+		nullable.nonNull = false
+
+		r, err := e.completeNullable(&nullable, fields, v, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if r == nil {
+			e.errs = append(e.errs, &Error{
+				Message:   fmt.Sprintf("cannot return null for non-null field of type %s", t),
+				Locations: locations(fields[0].pos),
+				Path:      path,
+			})
+
+			return nil, errNull
+		}
+
+		return r, nil
+	}
+
+	r, err := e.completeNullable(t, fields, v, path)
+	if errors.Is(err, errNull) {
+		return nil, nil
+	}
+
+	return r, err
+}
+
+func (e *executor) completeNullable(t *typeRef, fields []*field, v any, path []any) (any, error) {
+	if isNil(v) {
+		return nil, nil
+	}
+
+	if t.elem != nil {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("complete: %T is not a list", v)
+		}
+
+		list := make([]any, rv.Len())
+
+		for i := range list {
+			item, err := e.complete(t.elem, fields, rv.Index(i).Interface(), append(path[:len(path):len(path)], i))
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = item
+		}
+
+		return list, nil
+	}
+
+	if scalars[t.name] {
+		return v, nil
+	}
+
+	obj := e.schema.objects[t.name]
+
+	var sels []selection
+
+	for _, f := range fields {
+		sels = append(sels, f.sels...)
+	}
+
+	return e.executeSelections(obj, v, sels, path)
+}
+
+// fieldGroups are the fields of a selection set grouped by response key, in
+// the order the keys are first selected.
+type fieldGroups struct {
+	keys  []string
+	byKey map[string][]*field
+}
+
+// collectFields collects the fields of the selections that apply to the
+// object, following fragments and the skip and include directives.
+func (e *executor) collectFields(obj *object, sels []selection, groups *fieldGroups, visited map[string]bool) {
+	if groups.byKey == nil {
+		groups.byKey = make(map[string][]*field)
+	}
+
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.dirs) {
+				continue
+			}
+
+			if _, ok := groups.byKey[sel.alias]; !ok {
+				groups.keys = append(groups.keys, sel.alias)
+			} else if prev := groups.byKey[sel.alias][0]; prev.name != sel.name {
+				e.fail(sel.pos, "fields %q and %q conflict, both are selected as %q", prev.name, sel.name, sel.alias)
+			}
+
+			groups.byKey[sel.alias] = append(groups.byKey[sel.alias], sel)
+		case *inlineFragment:
+			if !e.included(sel.dirs) || !e.applies(sel.on, obj) {
+				continue
+			}
+
+			e.collectFields(obj, sel.sels, groups, visited)
+		case *fragmentSpread:
+			if !e.included(sel.dirs) || visited[sel.name] {
+				continue
+			}
+
+			visited[sel.name] = true
+
+			frag := e.doc.fragments[sel.name]
+
+			if !e.included(frag.dirs) || !e.applies(frag.on, obj) {
+				continue
+			}
+
+			e.collectFields(obj, frag.sels, groups, visited)
+		}
+	}
+}
+
+// applies reports whether a fragment with the type condition applies to the
+// object. The schema has no interfaces or unions, so the condition must name
+// the object, if any.
+func (e *executor) applies(on string, obj *object) bool {
+	if on == "" {
+		return true
+	}
+
+	if _, ok := e.schema.objects[on]; !ok {
+		e.fail(pos{}, "unknown type %q", on)
+	}
+
+	return on == obj.name
+}
+
+// included reports whether a selection is included according to its skip
+// and include directives.
+func (e *executor) included(dirs []*directive) bool {
+	for _, dir := range dirs {
+		if dir.name != "skip" && dir.name != "include" {
+			e.fail(dir.pos, "unknown directive @%s", dir.name)
+		}
+
+		arg, ok := dir.args["if"]
+		if !ok || len(dir.args) != 1 {
+			e.fail(dir.pos, "directive @%s takes a single if argument", dir.name)
+		}
+
+		v, err := e.coerceLiteral(arg, &typeRef{name: "Boolean", nonNull: true})
+		if err != nil || v == nil {
+			e.fail(arg.pos, "invalid if argument of @%s, must be Boolean!", dir.name)
+		}
+
+		if v.(bool) == (dir.name == "skip") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coerceArguments coerces the arguments of a field to the types of the
+// arguments of its definition.
+func (e *executor) coerceArguments(f *field, def *fieldDef) map[string]any {
+	for name := range f.args {
+		if _, ok := def.args[name]; !ok {
+			e.fail(f.pos, "unknown argument %q of field %q", name, f.name)
+		}
+	}
+
+	args := make(map[string]any)
+
+	for name, t := range def.args {
+		v, ok := f.args[name]
+		if ok && v.kind == variableValue {
+			if !e.defined[v.text] {
+				e.fail(v.pos, "variable $%s is not defined", v.text)
+			}
+
+			// A variable that is not given leaves the argument
+			// unset rather than null.
+			_, ok = e.vars[v.text]
+		}
+
+		if !ok {
+			if t.nonNull {
+				e.fail(f.pos, "argument %q of type %s of field %q is required", name, t, f.name)
+			}
+
+			continue
+		}
+
+		x, err := e.coerceLiteral(v, t)
+		if err != nil {
+			e.fail(v.pos, "invalid argument %q of field %q, %s", name, f.name, err)
+		}
+
+		args[name] = x
+	}
+
+	return args
+}
+
+// coerceLiteral coerces a value literal, or the value of a variable, to a
+// type.
+func (e *executor) coerceLiteral(v *value, t *typeRef) (any, error) {
+	switch v.kind {
+	case variableValue:
+		if !e.defined[v.text] {
+			e.fail(v.pos, "variable $%s is not defined", v.text)
+		}
+
+		return coerceVariable(e.vars[v.text], t)
+	case nullValue:
+		if t.nonNull {
+			return nil, fmt.Errorf("must not be null, expected %s", t)
+		}
+
+		return nil, nil
+	}
+
+	if t.elem != nil {
+		if v.kind != listValue {
+			x, err := e.coerceLiteral(v, t.elem)
+			if err != nil {
+				return nil, err
+			}
+
+			return []any{x}, nil
+		}
+
+		list := make([]any, len(v.list))
+
+		for i, item := range v.list {
+			x, err := e.coerceLiteral(item, t.elem)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = x
+		}
+
+		return list, nil
+	}
+
+	switch {
+	case t.name == "Int" && v.kind == intValue:
+		n, err := strconv.ParseInt(v.text, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a 32-bit integer", v.text)
+		}
+
+		return int(n), nil
+	case t.name == "Float" && (v.kind == intValue || v.kind == floatValue):
+		return strconv.ParseFloat(v.text, 64)
+	case t.name == "String" && v.kind == stringValue:
+		return v.text, nil
+	case t.name == "ID" && (v.kind == stringValue || v.kind == intValue):
+		return v.text, nil
+	case t.name == "Boolean" && v.kind == booleanValue:
+		return v.text == "true", nil
+	default:
+		return nil, fmt.Errorf("expected %s", t)
+	}
+}
+
+// coerceVariable coerces the value of a variable, as decoded from JSON, to a
+// type.
+func coerceVariable(x any, t *typeRef) (any, error) {
+	if x == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("must not be null, expected %s", t)
+		}
+
+		return nil, nil
+	}
+
+	if t.elem != nil {
+		items, ok := x.([]any)
+		if !ok {
+			item, err := coerceVariable(x, t.elem)
+			if err != nil {
+				return nil, err
+			}
+
+			return []any{item}, nil
+		}
+
+		list := make([]any, len(items))
+
+		for i, item := range items {
+			v, err := coerceVariable(item, t.elem)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = v
+		}
+
+		return list, nil
+	}
+
+	switch t.name {
+	case "Int":
+		f, ok := number(x)
+		if !ok || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+			return nil, fmt.Errorf("expected %s", t)
+		}
+
+		return int(f), nil
+	case "Float":
+		f, ok := number(x)
+		if !ok {
+			return nil, fmt.Errorf("expected %s", t)
+		}
+
+		return f, nil
+	case "String", "ID":
+		if s, ok := x.(string); ok {
+			return s, nil
+		}
+
+		if f, ok := number(x); ok && t.name == "ID" && f == math.Trunc(f) {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+	case "Boolean":
+		if b, ok := x.(bool); ok {
+			return b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected %s", t)
+}
+
+// number returns the value of a number decoded from JSON.
+func number(x any) (float64, bool) {
+	switch n := x.(type) {
+	case json.Number:
+		f, err := n.Float64()
+
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// isNil reports whether the value is nil, including a nil pointer or slice
+// in an interface.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+func (e *executor) fail(at pos, format string, args ...any) {
+	panic(&queryError{msg: fmt.Sprintf(format, args...), pos: at})
+}
+
+// locations returns the locations of an error at a position, none if the
+// position is unknown.
+func locations(at pos) []Location {
+	if at.line == 0 {
+		return nil
+	}
+
+	return []Location{{Line: at.line, Column: at.column}}
+}
+
+// orderedObject is a JSON object that keeps its keys in the order they are
+// set, so that the response has the fields in the order they were selected.
+type orderedObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *orderedObject) set(key string, v any) {
+	if o.values == nil {
+		o.values = make(map[string]any)
+	}
+
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+
+	o.values[key] = v
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+
+	b.WriteByte('{')
+
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+
+	b.WriteByte('}')
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,148 @@
+// Package graphql provides a GraphQL query endpoint of a library, so that
+// front ends, e.g. a patron portal, can fetch the books, accounts, checkouts
+// and holds in exactly the shape they need in a single request.
+//
+// The schema is described in schema.graphql. Only queries are supported, the
+// library is changed by executing commands. Queries are executed by a small
+// executor over the library rather than a GraphQL library, supporting
+// variables, aliases, fragments, and the skip and include directives, but not
+// introspection other than __typename.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/admtnnr/library"
+)
+
+// Request is a GraphQL request.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is a GraphQL response.
+type Response struct {
+	// Data is the result of the query, omitted if the request failed
+	// before it was executed, and null if a non-null field of the query
+	// was null.
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []*Error        `json:"errors,omitempty"`
+}
+
+// Error is an error of a GraphQL response.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	// Path is the path of the response field the error occurred at, of
+	// field names and list indices.
+	Path []any `json:"path,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Location is a location in the query of an Error, from 1.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Execute executes a GraphQL query against a library.
+func Execute(l *library.Library, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		serr := err.(*syntaxError)
+
+		return &Response{Errors: []*Error{{Message: serr.Error(), Locations: locations(serr.pos)}}}
+	}
+
+	e := &executor{schema: newSchema(l), doc: doc}
+
+	data, err := e.execute(req)
+	if err != nil {
+		if gerr, ok := err.(*Error); ok {
+			return &Response{Errors: []*Error{gerr}}
+		}
+
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	resp := &Response{Data: json.RawMessage("null"), Errors: e.errs}
+
+	if data != nil {
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return &Response{Errors: []*Error{{Message: fmt.Sprintf("failed to marshal data, %v", err)}}}
+		}
+
+		resp.Data = bs
+	}
+
+	return resp
+}
+
+// Handler serves GraphQL queries against a library over HTTP.
+//
+// Queries are accepted as a POST of a JSON Request, or as a GET with the
+// query, operationName and variables query parameters, the variables as JSON.
+// The response is a JSON Response, with status 400 if the request failed
+// before the query was executed, e.g. a syntax error.
+type Handler struct {
+	Library *library.Library
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			dec := json.NewDecoder(strings.NewReader(vars))
+			dec.UseNumber()
+
+			if err := dec.Decode(&req.Variables); err != nil {
+				writeResponse(w, &Response{Errors: []*Error{{Message: fmt.Sprintf("invalid variables, %v", err)}}})
+
+				return
+			}
+		}
+	case http.MethodPost:
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+
+		if err := dec.Decode(&req); err != nil {
+			writeResponse(w, &Response{Errors: []*Error{{Message: fmt.Sprintf("invalid request, %v", err)}}})
+
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	writeResponse(w, Execute(h.Library, req))
+}
+
+// writeResponse writes a response, with status 400 if it has no data.
+func writeResponse(w http.ResponseWriter, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if resp.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
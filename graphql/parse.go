@@ -0,0 +1,762 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed GraphQL document, see parse.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is an operation of a document, e.g. a query.
+type operation struct {
+	kind string // Kind of operation, query, mutation, or subscription.
+	name string
+	vars []*varDef
+	sels []selection
+	pos  pos
+}
+
+// varDef is the definition of a variable of an operation.
+type varDef struct {
+	name string
+	typ  *typeRef
+	def  *value // Default value, nil if none.
+}
+
+// fragment is a named fragment of a document.
+type fragment struct {
+	name string
+	on   string // Type condition.
+	dirs []*directive
+	sels []selection
+}
+
+// selection is a *field, *fragmentSpread, or *inlineFragment of a selection
+// set.
+type selection interface{}
+
+// field is a field of a selection set.
+type field struct {
+	alias string // Response key of the field, the name if there is no alias.
+	name  string
+	args  map[string]*value
+	dirs  []*directive
+	sels  []selection
+	pos   pos
+}
+
+// fragmentSpread is a spread of a named fragment, e.g. ...bookFields.
+type fragmentSpread struct {
+	name string
+	dirs []*directive
+	pos  pos
+}
+
+// inlineFragment is an inline fragment, e.g. ... on Book { name }.
+type inlineFragment struct {
+	on   string // Type condition, empty if there is none.
+	dirs []*directive
+	sels []selection
+}
+
+// directive is a directive of a selection, e.g. @include(if: $full).
+type directive struct {
+	name string
+	args map[string]*value
+	pos  pos
+}
+
+// valueKind is the kind of a value literal.
+type valueKind int
+
+const (
+	variableValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	booleanValue
+	nullValue
+	enumValue
+	listValue
+	objectValue
+)
+
+// value is a value literal of an argument, or a variable.
+type value struct {
+	kind   valueKind
+	text   string // Name of a variable or enum value, or the value of a scalar.
+	list   []*value
+	fields map[string]*value
+	pos    pos
+}
+
+// typeRef is a reference to a type, e.g. [Int!]!.
+type typeRef struct {
+	name    string   // Name of a named type, empty for a list.
+	elem    *typeRef // Element type of a list.
+	nonNull bool
+}
+
+// String returns the type as written in GraphQL.
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+
+	if t.nonNull {
+		s += "!"
+	}
+
+	return s
+}
+
+// pos is the position of a token in a document.
+type pos struct {
+	line, column int
+}
+
+// syntaxError is an error parsing a document.
+type syntaxError struct {
+	msg string
+	pos pos
+}
+
+// Error implements the error interface.
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error, %s", e.msg)
+}
+
+// tokenKind is the kind of a lexical token.
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	punctToken
+	nameToken
+	intToken
+	floatToken
+	stringToken
+)
+
+// token is a lexical token of a document.
+type token struct {
+	kind tokenKind
+	text string // Text of the token, the unescaped value of a string.
+	pos  pos
+}
+
+// parser parses a GraphQL document.
+type parser struct {
+	src  string
+	off  int
+	line int
+	col  int // Offset of the start of the current line.
+	tok  token
+}
+
+// parse parses a GraphQL executable document.
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src, line: 1}
+
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+
+			err = serr
+		}
+	}()
+
+	p.next()
+
+	doc = &document{fragments: make(map[string]*fragment)}
+
+	for p.tok.kind != eofToken {
+		switch {
+		case p.peek("{"):
+			// A lone selection set is a query without a name.
+			op := &operation{kind: "query", pos: p.tok.pos}
+			op.sels = p.selectionSet()
+
+			doc.operations = append(doc.operations, op)
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peekName("fragment"):
+			frag := p.fragment()
+			if _, ok := doc.fragments[frag.name]; ok {
+				p.fail(p.tok.pos, "fragment %q is defined more than once", frag.name)
+			}
+
+			doc.fragments[frag.name] = frag
+		default:
+			p.unexpected()
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		p.fail(p.tok.pos, "document has no operations")
+	}
+
+	return doc, nil
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{pos: p.tok.pos}
+	op.kind = p.name()
+
+	if p.tok.kind == nameToken {
+		op.name = p.name()
+	}
+
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+
+			v := &varDef{name: p.name()}
+
+			p.expect(":")
+
+			v.typ = p.typeRef()
+
+			if p.skip("=") {
+				v.def = p.value(true)
+			}
+
+			op.vars = append(op.vars, v)
+		}
+	}
+
+	p.directives()
+
+	op.sels = p.selectionSet()
+
+	return op
+}
+
+func (p *parser) fragment() *fragment {
+	p.name()
+
+	frag := &fragment{name: p.name()}
+	if frag.name == "on" {
+		p.fail(p.tok.pos, "fragment cannot be named on")
+	}
+
+	p.expectName("on")
+
+	frag.on = p.name()
+	frag.dirs = p.directives()
+	frag.sels = p.selectionSet()
+
+	return frag
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+
+	var sels []selection
+
+	for !p.skip("}") {
+		if p.tok.kind == eofToken {
+			p.unexpected()
+		}
+
+		sels = append(sels, p.selection())
+	}
+
+	return sels
+}
+
+func (p *parser) selection() selection {
+	at := p.tok.pos
+
+	if !p.skip("...") {
+		return p.field()
+	}
+
+	if p.tok.kind == nameToken && p.tok.text != "on" {
+		return &fragmentSpread{name: p.name(), dirs: p.directives(), pos: at}
+	}
+
+	frag := &inlineFragment{}
+
+	if p.peekName("on") {
+		p.next()
+
+		frag.on = p.name()
+	}
+
+	frag.dirs = p.directives()
+	frag.sels = p.selectionSet()
+
+	return frag
+}
+
+func (p *parser) field() *field {
+	f := &field{pos: p.tok.pos}
+	f.name = p.name()
+	f.alias = f.name
+
+	if p.skip(":") {
+		f.name = p.name()
+	}
+
+	f.args = p.arguments(false)
+	f.dirs = p.directives()
+
+	if p.peek("{") {
+		f.sels = p.selectionSet()
+	}
+
+	return f
+}
+
+func (p *parser) arguments(constant bool) map[string]*value {
+	if !p.skip("(") {
+		return nil
+	}
+
+	args := make(map[string]*value)
+
+	for !p.skip(")") {
+		at := p.tok.pos
+		name := p.name()
+
+		if _, ok := args[name]; ok {
+			p.fail(at, "argument %q is given more than once", name)
+		}
+
+		p.expect(":")
+
+		args[name] = p.value(constant)
+	}
+
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var dirs []*directive
+
+	for p.peek("@") {
+		at := p.tok.pos
+
+		p.next()
+
+		dirs = append(dirs, &directive{name: p.name(), args: p.arguments(false), pos: at})
+	}
+
+	return dirs
+}
+
+func (p *parser) typeRef() *typeRef {
+	var t *typeRef
+
+	if p.skip("[") {
+		t = &typeRef{elem: p.typeRef()}
+
+		p.expect("]")
+	} else {
+		t = &typeRef{name: p.name()}
+	}
+
+	if p.skip("!") {
+		t.nonNull = true
+	}
+
+	return t
+}
+
+// value parses a value literal, which may not be a variable if constant.
+func (p *parser) value(constant bool) *value {
+	tok := p.tok
+	v := &value{pos: tok.pos, text: tok.text}
+
+	switch tok.kind {
+	case intToken:
+		v.kind = intValue
+	case floatToken:
+		v.kind = floatValue
+	case stringToken:
+		v.kind = stringValue
+	case nameToken:
+		switch tok.text {
+		case "true", "false":
+			v.kind = booleanValue
+		case "null":
+			v.kind = nullValue
+		default:
+			v.kind = enumValue
+		}
+	case punctToken:
+		switch tok.text {
+		case "$":
+			if constant {
+				p.fail(tok.pos, "variables are not allowed in default values")
+			}
+
+			p.next()
+
+			return &value{kind: variableValue, text: p.name(), pos: tok.pos}
+		case "[":
+			p.next()
+
+			v.kind = listValue
+
+			for !p.skip("]") {
+				v.list = append(v.list, p.value(constant))
+			}
+
+			return v
+		case "{":
+			p.next()
+
+			v.kind = objectValue
+			v.fields = make(map[string]*value)
+
+			for !p.skip("}") {
+				name := p.name()
+
+				p.expect(":")
+
+				v.fields[name] = p.value(constant)
+			}
+
+			return v
+		default:
+			p.unexpected()
+		}
+	default:
+		p.unexpected()
+	}
+
+	p.next()
+
+	return v
+}
+
+// peek reports whether the current token is the punctuator.
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == punctToken && p.tok.text == punct
+}
+
+// peekName reports whether the current token is the name.
+func (p *parser) peekName(name string) bool {
+	return p.tok.kind == nameToken && p.tok.text == name
+}
+
+// skip consumes the current token if it is the punctuator, reporting whether
+// it was.
+func (p *parser) skip(punct string) bool {
+	if !p.peek(punct) {
+		return false
+	}
+
+	p.next()
+
+	return true
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.unexpected()
+	}
+}
+
+func (p *parser) expectName(name string) {
+	if !p.peekName(name) {
+		p.unexpected()
+	}
+
+	p.next()
+}
+
+// name consumes a name token and returns the name.
+func (p *parser) name() string {
+	if p.tok.kind != nameToken {
+		p.unexpected()
+	}
+
+	name := p.tok.text
+
+	p.next()
+
+	return name
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == eofToken {
+		p.fail(p.tok.pos, "unexpected end of document")
+	}
+
+	p.fail(p.tok.pos, "unexpected %q", p.tok.text)
+}
+
+func (p *parser) fail(at pos, format string, args ...any) {
+	panic(&syntaxError{msg: fmt.Sprintf(format, args...), pos: at})
+}
+
+// next reads the next token into tok, skipping whitespace, commas and
+// comments.
+func (p *parser) next() {
+	for p.off < len(p.src) {
+		c := p.src[p.off]
+
+		switch {
+		case c == '\n':
+			p.off++
+			p.newline()
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.off++
+		case c == '#':
+			for p.off < len(p.src) && p.src[p.off] != '\n' {
+				p.off++
+			}
+		case strings.HasPrefix(p.src[p.off:], "\ufeff"):
+			// A byte order mark is ignored like whitespace.
+			p.off += len("\ufeff")
+		default:
+			p.token()
+
+			return
+		}
+	}
+
+	p.tok = token{kind: eofToken, pos: p.pos()}
+}
+
+func (p *parser) newline() {
+	p.line++
+	p.col = p.off
+}
+
+func (p *parser) pos() pos {
+	return pos{line: p.line, column: utf8.RuneCountInString(p.src[p.col:p.off]) + 1}
+}
+
+// token reads the token at the current offset.
+func (p *parser) token() {
+	at := p.pos()
+	start := p.off
+	c := p.src[p.off]
+
+	switch {
+	case strings.HasPrefix(p.src[p.off:], "..."):
+		p.off += 3
+		p.tok = token{kind: punctToken, text: "...", pos: at}
+	case strings.ContainsRune("!$&()[]{}:=@|", rune(c)):
+		p.off++
+		p.tok = token{kind: punctToken, text: string(c), pos: at}
+	case c == '_' || isLetter(c):
+		for p.off < len(p.src) && (p.src[p.off] == '_' || isLetter(p.src[p.off]) || isDigit(p.src[p.off])) {
+			p.off++
+		}
+
+		p.tok = token{kind: nameToken, text: p.src[start:p.off], pos: at}
+	case c == '-' || isDigit(c):
+		p.number(at)
+	case strings.HasPrefix(p.src[p.off:], `"""`):
+		p.blockString(at)
+	case c == '"':
+		p.string(at)
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.off:])
+		p.fail(at, "unexpected character %q", r)
+	}
+}
+
+func (p *parser) number(at pos) {
+	start := p.off
+	kind := intToken
+
+	if p.src[p.off] == '-' {
+		p.off++
+	}
+
+	p.digits(at)
+
+	if p.off < len(p.src) && p.src[p.off] == '.' {
+		kind = floatToken
+		p.off++
+		p.digits(at)
+	}
+
+	if p.off < len(p.src) && (p.src[p.off] == 'e' || p.src[p.off] == 'E') {
+		kind = floatToken
+		p.off++
+
+		if p.off < len(p.src) && (p.src[p.off] == '+' || p.src[p.off] == '-') {
+			p.off++
+		}
+
+		p.digits(at)
+	}
+
+	if p.off < len(p.src) && (p.src[p.off] == '_' || isLetter(p.src[p.off]) || p.src[p.off] == '.') {
+		p.fail(at, "invalid number %q", p.src[start:p.off+1])
+	}
+
+	p.tok = token{kind: kind, text: p.src[start:p.off], pos: at}
+}
+
+func (p *parser) digits(at pos) {
+	start := p.off
+
+	for p.off < len(p.src) && isDigit(p.src[p.off]) {
+		p.off++
+	}
+
+	if p.off == start {
+		p.fail(at, "invalid number")
+	}
+}
+
+func (p *parser) string(at pos) {
+	var b strings.Builder
+
+	p.off++
+
+	for {
+		if p.off >= len(p.src) || p.src[p.off] == '\n' {
+			p.fail(at, "unterminated string")
+		}
+
+		c := p.src[p.off]
+
+		switch c {
+		case '"':
+			p.off++
+			p.tok = token{kind: stringToken, text: b.String(), pos: at}
+
+			return
+		case '\\':
+			if p.off+1 >= len(p.src) {
+				p.fail(at, "unterminated string")
+			}
+
+			esc := p.src[p.off+1]
+			p.off += 2
+
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.off+4 > len(p.src) {
+					p.fail(at, "invalid unicode escape")
+				}
+
+				r, err := strconv.ParseUint(p.src[p.off:p.off+4], 16, 32)
+				if err != nil {
+					p.fail(at, "invalid unicode escape %q", p.src[p.off:p.off+4])
+				}
+
+				b.WriteRune(rune(r))
+				p.off += 4
+			default:
+				p.fail(at, "invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+			p.off++
+		}
+	}
+}
+
+// blockString reads a block string, removing its common indentation and
+// leading and trailing blank lines.
+func (p *parser) blockString(at pos) {
+	p.off += 3
+
+	var raw strings.Builder
+
+	for {
+		if p.off >= len(p.src) {
+			p.fail(at, "unterminated string")
+		}
+
+		switch {
+		case strings.HasPrefix(p.src[p.off:], `\"""`):
+			raw.WriteString(`"""`)
+			p.off += 4
+		case strings.HasPrefix(p.src[p.off:], `"""`):
+			p.off += 3
+			p.tok = token{kind: stringToken, text: blockStringValue(raw.String()), pos: at}
+
+			return
+		default:
+			if p.src[p.off] == '\n' {
+				raw.WriteByte('\n')
+				p.off++
+				p.newline()
+
+				continue
+			}
+
+			raw.WriteByte(p.src[p.off])
+			p.off++
+		}
+	}
+}
+
+// blockStringValue returns the value of the raw text of a block string.
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+
+	indent := -1
+
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,240 @@
+package graphql
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+// newSchema returns the schema of a library, see schema.graphql.
+func newSchema(l *library.Library) *schema {
+	query := &object{name: "Query", fields: map[string]*fieldDef{
+		"book": {
+			typ:  typeOf("Book"),
+			args: map[string]*typeRef{"id": typeOf("Int!")},
+			resolve: func(_ any, args map[string]any) (any, error) {
+				return l.Book(args["id"].(int)), nil
+			},
+		},
+		"books": {
+			typ:  typeOf("[Book!]!"),
+			args: map[string]*typeRef{"ids": typeOf("[Int!]"), "search": typeOf("String")},
+			resolve: func(_ any, args map[string]any) (any, error) {
+				var q library.SearchQuery
+
+				if search, ok := args["search"].(string); ok {
+					var err error
+
+					if q, err = library.ParseSearchQuery(search); err != nil {
+						return nil, err
+					}
+				}
+
+				books := l.Search(q)
+
+				if ids, ok := args["ids"].([]any); ok {
+					books = slices.DeleteFunc(books, func(book *library.Book) bool {
+						return !slices.Contains(ids, any(book.ID))
+					})
+				}
+
+				return books, nil
+			},
+		},
+		"account": {
+			typ:  typeOf("Account"),
+			args: map[string]*typeRef{"id": typeOf("Int!")},
+			resolve: func(_ any, args map[string]any) (any, error) {
+				return l.Account(args["id"].(int)), nil
+			},
+		},
+		"accounts": {
+			typ:  typeOf("[Account!]!"),
+			args: map[string]*typeRef{"ids": typeOf("[Int!]")},
+			resolve: func(_ any, args map[string]any) (any, error) {
+				ids, filter := args["ids"].([]any)
+
+				var accounts []*library.Account
+
+				l.EachAccount(func(account *library.Account) {
+					if !filter || slices.Contains(ids, any(account.ID)) {
+						accounts = append(accounts, account)
+					}
+				})
+
+				slices.SortFunc(accounts, func(a, b *library.Account) int {
+					return cmp.Compare(a.ID, b.ID)
+				})
+
+				return accounts, nil
+			},
+		},
+	}}
+
+	book := &object{name: "Book", fields: map[string]*fieldDef{
+		"id":     bookField("Int!", func(b *library.Book) any { return b.ID }),
+		"name":   bookField("String!", func(b *library.Book) any { return b.Name }),
+		"count":  bookField("Int!", func(b *library.Book) any { return b.Count }),
+		"author": bookField("String", func(b *library.Book) any { return optional(b.Author) }),
+		"isbn":   bookField("String", func(b *library.Book) any { return optional(b.ISBN) }),
+		"tags": bookField("[String!]!", func(b *library.Book) any {
+			return append([]string{}, b.Tags...)
+		}),
+		"available": bookField("Int!", func(b *library.Book) any {
+			return b.Count - len(l.CheckoutsByBook(b.ID))
+		}),
+		"checkedOut": bookField("Int!", func(b *library.Book) any {
+			return len(l.CheckoutsByBook(b.ID))
+		}),
+		"checkouts": bookField("[Checkout!]!", func(b *library.Book) any {
+			return l.CheckoutsByBook(b.ID)
+		}),
+		"history": bookField("[Checkout!]!", func(b *library.Book) any {
+			return l.HistoryByBook(b.ID)
+		}),
+		"holds": bookField("[Hold!]!", func(b *library.Book) any {
+			return l.HoldsByBook(b.ID)
+		}),
+	}}
+
+	account := &object{name: "Account", fields: map[string]*fieldDef{
+		"id":      accountField("Int!", func(a *library.Account) any { return a.ID }),
+		"name":    accountField("String!", func(a *library.Account) any { return a.Name }),
+		"balance": accountField("Int!", func(a *library.Account) any { return a.Balance }),
+		"fines": accountField("Int!", func(a *library.Account) any {
+			return max(0, -a.Balance)
+		}),
+		"accruedFines": accountField("Int!", func(a *library.Account) any {
+			var fines int
+
+			for _, checkout := range l.CheckoutsByAccount(a.ID) {
+				fines += l.Fine(checkout)
+			}
+
+			return fines
+		}),
+		"checkouts": accountField("[Checkout!]!", func(a *library.Account) any {
+			return l.CheckoutsByAccount(a.ID)
+		}),
+		"overdue": accountField("[Checkout!]!", func(a *library.Account) any {
+			now := l.Now()
+
+			var overdue []*library.Checkout
+
+			for _, checkout := range l.CheckoutsByAccount(a.ID) {
+				if checkout.Overdue(now) {
+					overdue = append(overdue, checkout)
+				}
+			}
+
+			return overdue
+		}),
+		"history": accountField("[Checkout!]!", func(a *library.Account) any {
+			return l.HistoryByAccount(a.ID)
+		}),
+		"holds": accountField("[Hold!]!", func(a *library.Account) any {
+			return l.HoldsByAccount(a.ID)
+		}),
+	}}
+
+	checkout := &object{name: "Checkout", fields: map[string]*fieldDef{
+		"book": checkoutField("Book!", func(c *library.Checkout) any {
+			return l.Book(c.BookID)
+		}),
+		"account": checkoutField("Account!", func(c *library.Checkout) any {
+			return l.Account(c.AccountID)
+		}),
+		"checkedOut": checkoutField("String!", func(c *library.Checkout) any { return timestamp(c.CheckedOut) }),
+		"due":        checkoutField("String!", func(c *library.Checkout) any { return timestamp(c.Due) }),
+		"returned":   checkoutField("String", func(c *library.Checkout) any { return timestamp(c.Returned) }),
+		"overdue": checkoutField("Boolean!", func(c *library.Checkout) any {
+			return c.Overdue(l.Now())
+		}),
+		"fine": checkoutField("Int!", func(c *library.Checkout) any {
+			return l.Fine(c)
+		}),
+	}}
+
+	hold := &object{name: "Hold", fields: map[string]*fieldDef{
+		"book": holdField("Book!", func(h *library.Hold) any {
+			return l.Book(h.BookID)
+		}),
+		"account": holdField("Account!", func(h *library.Hold) any {
+			return l.Account(h.AccountID)
+		}),
+		"placed": holdField("String!", func(h *library.Hold) any { return timestamp(h.Placed) }),
+		"position": holdField("Int!", func(h *library.Hold) any {
+			return slices.IndexFunc(l.HoldsByBook(h.BookID), func(queued *library.Hold) bool {
+				return queued.AccountID == h.AccountID
+			}) + 1
+		}),
+	}}
+
+	return &schema{
+		query: query,
+		objects: map[string]*object{
+			query.name:    query,
+			book.name:     book,
+			account.name:  account,
+			checkout.name: checkout,
+			hold.name:     hold,
+		},
+	}
+}
+
+// bookField returns a field of Book without arguments.
+func bookField(typ string, fn func(b *library.Book) any) *fieldDef {
+	return &fieldDef{typ: typeOf(typ), resolve: func(src any, _ map[string]any) (any, error) {
+		return fn(src.(*library.Book)), nil
+	}}
+}
+
+// accountField returns a field of Account without arguments.
+func accountField(typ string, fn func(a *library.Account) any) *fieldDef {
+	return &fieldDef{typ: typeOf(typ), resolve: func(src any, _ map[string]any) (any, error) {
+		return fn(src.(*library.Account)), nil
+	}}
+}
+
+// checkoutField returns a field of Checkout without arguments.
+func checkoutField(typ string, fn func(c *library.Checkout) any) *fieldDef {
+	return &fieldDef{typ: typeOf(typ), resolve: func(src any, _ map[string]any) (any, error) {
+		return fn(src.(*library.Checkout)), nil
+	}}
+}
+
+// holdField returns a field of Hold without arguments.
+func holdField(typ string, fn func(h *library.Hold) any) *fieldDef {
+	return &fieldDef{typ: typeOf(typ), resolve: func(src any, _ map[string]any) (any, error) {
+		return fn(src.(*library.Hold)), nil
+	}}
+}
+
+// typeOf parses a type reference of the schema, e.g. [Book!]!.
+func typeOf(s string) *typeRef {
+	p := &parser{src: s, line: 1}
+
+	p.next()
+
+	return p.typeRef()
+}
+
+// optional returns nil for an empty string, so that missing metadata is null.
+func optional(s string) any {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
+
+// timestamp returns the time formatted as RFC 3339, or nil if it is zero.
+func timestamp(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.Format(time.RFC3339)
+}
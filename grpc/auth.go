@@ -0,0 +1,91 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/admtnnr/library/apikey"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes are the scopes of the API keys required by the methods of the
+// service, see KeyAuth. Methods not listed require apikey.ScopeWrite.
+var methodScopes = map[string]apikey.Scope{
+	"/" + serviceName + "/Watch": apikey.ScopeRead,
+}
+
+// KeyAuth returns the options that a gRPC server must be created with to
+// require the calls to be authenticated by an API key of keys, with the
+// token as a bearer token of the authorization metadata, e.g.
+// "authorization: Bearer lk_...". Watch requires a key with
+// apikey.ScopeRead, and the methods that execute commands a key with
+// apikey.ScopeWrite.
+//
+// Calls that are not authenticated fail with codes.Unauthenticated, and those
+// whose key does not allow the method with codes.PermissionDenied.
+func KeyAuth(keys *apikey.File) []grpcgo.ServerOption {
+	return []grpcgo.ServerOption{
+		grpcgo.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpcgo.UnaryServerInfo, handler grpcgo.UnaryHandler) (any, error) {
+			ctx, err := authorize(ctx, keys, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		}),
+		grpcgo.ChainStreamInterceptor(func(srv any, ss grpcgo.ServerStream, info *grpcgo.StreamServerInfo, handler grpcgo.StreamHandler) error {
+			ctx, err := authorize(ss.Context(), keys, info.FullMethod)
+			if err != nil {
+				return err
+			}
+
+			return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		}),
+	}
+}
+
+// authorize returns the context of a call with its key, see
+// apikey.NewContext, or a gRPC status error unless the call of the method is
+// authorized by its metadata.
+func authorize(ctx context.Context, keys *apikey.File, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = apikey.ScopeWrite
+	}
+
+	var token string
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+
+	key, err := keys.Authorize(token, scope)
+
+	switch {
+	case err == nil:
+		return apikey.NewContext(ctx, key), nil
+	case errors.Is(err, apikey.ErrPermissionDenied):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return nil, status.Error(codes.Unauthenticated, apikey.ErrUnauthenticated.Error())
+	}
+}
+
+// contextStream is a server stream with the context of its call replaced,
+// e.g. with the key that authenticated it.
+type contextStream struct {
+	grpcgo.ServerStream
+
+	ctx context.Context
+}
+
+// Context implements grpcgo.ServerStream.
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	grpcgo "google.golang.org/grpc"
+)
+
+// Client is a client of LibraryService.
+type Client struct {
+	cc grpcgo.ClientConnInterface
+}
+
+// NewClient returns a client of the service served over a connection, e.g.
+// one created with grpc.NewClient.
+func NewClient(cc grpcgo.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// AddBook calls LibraryService.AddBook.
+func (c *Client) AddBook(ctx context.Context, req *AddBookRequest, opts ...grpcgo.CallOption) (*Book, error) {
+	resp := new(Book)
+
+	if err := c.invoke(ctx, "AddBook", req, resp, opts); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Checkout calls LibraryService.Checkout.
+func (c *Client) Checkout(ctx context.Context, req *CheckoutRequest, opts ...grpcgo.CallOption) (*Checkout, error) {
+	resp := new(Checkout)
+
+	if err := c.invoke(ctx, "Checkout", req, resp, opts); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Return calls LibraryService.Return.
+func (c *Client) Return(ctx context.Context, req *ReturnRequest, opts ...grpcgo.CallOption) (*ReturnResponse, error) {
+	resp := new(ReturnResponse)
+
+	if err := c.invoke(ctx, "Return", req, resp, opts); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Watch calls LibraryService.Watch. The changes are received with Recv until
+// it returns an error, io.EOF if the server ended the stream.
+func (c *Client) Watch(ctx context.Context, req *WatchRequest, opts ...grpcgo.CallOption) (*WatchClient, error) {
+	desc := &serviceDesc.Streams[0]
+
+	stream, err := c.cc.NewStream(ctx, desc, method(desc.StreamName), append(opts, grpcgo.ForceCodecV2(codec{}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return &WatchClient{stream: stream}, nil
+}
+
+// WatchClient is the stream of changes of a Watch call.
+type WatchClient struct {
+	stream grpcgo.ClientStream
+}
+
+// Recv receives the next change.
+func (w *WatchClient) Recv() (*Change, error) {
+	change := new(Change)
+
+	if err := w.stream.RecvMsg(change); err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// invoke calls a unary method of the service.
+func (c *Client) invoke(ctx context.Context, name string, req, resp message, opts []grpcgo.CallOption) error {
+	return c.cc.Invoke(ctx, method(name), req, resp, append(opts, grpcgo.ForceCodecV2(codec{}))...)
+}
+
+// method returns the full name of a method of the service.
+func method(name string) string {
+	return fmt.Sprintf("/%s/%s", serviceName, name)
+}
@@ -0,0 +1,418 @@
+package grpc
+
+import (
+	"time"
+
+	"github.com/admtnnr/library"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// codecName is the name of the protocol buffer codec of gRPC.
+const codecName = "proto"
+
+// AddBookRequest is the request of LibraryService.AddBook.
+type AddBookRequest struct {
+	ID     int
+	Name   string
+	Count  int
+	Author string
+	ISBN   string
+	Tags   []string
+}
+
+// CheckoutRequest is the request of LibraryService.Checkout.
+type CheckoutRequest struct {
+	AccountID int
+	BookID    int
+}
+
+// ReturnRequest is the request of LibraryService.Return.
+type ReturnRequest struct {
+	AccountID int
+	BookID    int
+}
+
+// ReturnResponse is the response of LibraryService.Return.
+type ReturnResponse struct {
+	Checkout *Checkout
+	Balance  int // Balance of the account in cents after the return.
+}
+
+// WatchRequest is the request of LibraryService.Watch.
+type WatchRequest struct {
+	Since int // Sequence number to stream the changes after.
+}
+
+// Change is a command that changed the library, streamed by
+// LibraryService.Watch.
+type Change struct {
+	Sequence int
+	At       time.Time
+	Command  string // JSON command, as in a commands file.
+}
+
+// Book is the Book message of a library.Book.
+type Book library.Book
+
+// Checkout is the Checkout message of a library.Checkout.
+type Checkout library.Checkout
+
+// message is implemented by the messages of the service, which are encoded by
+// hand from the schema in library.proto rather than with generated code, as
+// the library state is, see library.ExportProto.
+type message interface {
+	marshal(e *encoder)
+	unmarshal(b []byte) error
+}
+
+// codec is the gRPC codec of the messages of the service. Other messages,
+// e.g. those of services registered on the same server, are passed to the
+// protocol buffer codec of gRPC.
+type codec struct{}
+
+// Marshal implements encoding.CodecV2.
+func (codec) Marshal(v any) (mem.BufferSlice, error) {
+	m, ok := v.(message)
+	if !ok {
+		return encoding.GetCodecV2(codecName).Marshal(v)
+	}
+
+	var e encoder
+
+	m.marshal(&e)
+
+	return mem.BufferSlice{mem.SliceBuffer(e.b)}, nil
+}
+
+// Unmarshal implements encoding.CodecV2.
+func (codec) Unmarshal(data mem.BufferSlice, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return encoding.GetCodecV2(codecName).Unmarshal(data, v)
+	}
+
+	return m.unmarshal(data.Materialize())
+}
+
+// Name implements encoding.CodecV2. The messages are protocol buffers, so
+// clients using code generated from library.proto can call the service.
+func (codec) Name() string {
+	return codecName
+}
+
+func (m *AddBookRequest) marshal(e *encoder) {
+	e.varint(1, int64(m.ID))
+	e.string(2, m.Name)
+	e.varint(3, int64(m.Count))
+	e.string(4, m.Author)
+	e.string(5, m.ISBN)
+	e.strings(6, m.Tags)
+}
+
+func (m *AddBookRequest) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.ID = int(f.varint)
+		case 2:
+			m.Name = string(f.bytes)
+		case 3:
+			m.Count = int(f.varint)
+		case 4:
+			m.Author = string(f.bytes)
+		case 5:
+			m.ISBN = string(f.bytes)
+		case 6:
+			m.Tags = append(m.Tags, string(f.bytes))
+		}
+
+		return nil
+	})
+}
+
+func (m *CheckoutRequest) marshal(e *encoder) {
+	e.varint(1, int64(m.AccountID))
+	e.varint(2, int64(m.BookID))
+}
+
+func (m *CheckoutRequest) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.AccountID = int(f.varint)
+		case 2:
+			m.BookID = int(f.varint)
+		}
+
+		return nil
+	})
+}
+
+func (m *ReturnRequest) marshal(e *encoder) {
+	e.varint(1, int64(m.AccountID))
+	e.varint(2, int64(m.BookID))
+}
+
+func (m *ReturnRequest) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.AccountID = int(f.varint)
+		case 2:
+			m.BookID = int(f.varint)
+		}
+
+		return nil
+	})
+}
+
+func (m *ReturnResponse) marshal(e *encoder) {
+	if m.Checkout != nil {
+		e.message(1, m.Checkout)
+	}
+
+	e.varint(2, int64(m.Balance))
+}
+
+func (m *ReturnResponse) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Checkout = new(Checkout)
+
+			return m.Checkout.unmarshal(f.bytes)
+		case 2:
+			m.Balance = int(f.varint)
+		}
+
+		return nil
+	})
+}
+
+func (m *WatchRequest) marshal(e *encoder) {
+	e.varint(1, int64(m.Since))
+}
+
+func (m *WatchRequest) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.num == 1 {
+			m.Since = int(f.varint)
+		}
+
+		return nil
+	})
+}
+
+func (m *Change) marshal(e *encoder) {
+	e.varint(1, int64(m.Sequence))
+	e.timestamp(2, m.At)
+	e.string(3, m.Command)
+}
+
+func (m *Change) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		var err error
+
+		switch f.num {
+		case 1:
+			m.Sequence = int(f.varint)
+		case 2:
+			m.At, err = decodeTimestamp(f.bytes)
+		case 3:
+			m.Command = string(f.bytes)
+		}
+
+		return err
+	})
+}
+
+func (m *Book) marshal(e *encoder) {
+	e.varint(1, int64(m.ID))
+	e.string(2, m.Name)
+	e.varint(3, int64(m.Count))
+	e.string(4, m.Author)
+	e.string(5, m.ISBN)
+	e.strings(6, m.Tags)
+}
+
+func (m *Book) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.ID = int(f.varint)
+		case 2:
+			m.Name = string(f.bytes)
+		case 3:
+			m.Count = int(f.varint)
+		case 4:
+			m.Author = string(f.bytes)
+		case 5:
+			m.ISBN = string(f.bytes)
+		case 6:
+			m.Tags = append(m.Tags, string(f.bytes))
+		}
+
+		return nil
+	})
+}
+
+func (m *Checkout) marshal(e *encoder) {
+	e.varint(1, int64(m.BookID))
+	e.varint(2, int64(m.AccountID))
+	e.timestamp(3, m.CheckedOut)
+	e.timestamp(4, m.Due)
+	e.timestamp(5, m.Returned)
+}
+
+func (m *Checkout) unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		var err error
+
+		switch f.num {
+		case 1:
+			m.BookID = int(f.varint)
+		case 2:
+			m.AccountID = int(f.varint)
+		case 3:
+			m.CheckedOut, err = decodeTimestamp(f.bytes)
+		case 4:
+			m.Due, err = decodeTimestamp(f.bytes)
+		case 5:
+			m.Returned, err = decodeTimestamp(f.bytes)
+		}
+
+		return err
+	})
+}
+
+// encoder appends the fields of protocol buffer messages to a buffer. Fields
+// with the zero value are omitted as in proto3.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) varint(num protowire.Number, v int64) {
+	if v == 0 {
+		return
+	}
+
+	e.b = protowire.AppendTag(e.b, num, protowire.VarintType)
+	e.b = protowire.AppendVarint(e.b, uint64(v))
+}
+
+func (e *encoder) string(num protowire.Number, s string) {
+	if s == "" {
+		return
+	}
+
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendString(e.b, s)
+}
+
+func (e *encoder) strings(num protowire.Number, values []string) {
+	for _, s := range values {
+		e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+		e.b = protowire.AppendString(e.b, s)
+	}
+}
+
+func (e *encoder) message(num protowire.Number, m message) {
+	var me encoder
+
+	m.marshal(&me)
+
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendBytes(e.b, me.b)
+}
+
+// timestamp encodes a google.protobuf.Timestamp, omitted if t is zero.
+func (e *encoder) timestamp(num protowire.Number, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	var me encoder
+
+	me.varint(1, t.Unix())
+	me.varint(2, int64(t.Nanosecond()))
+
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendBytes(e.b, me.b)
+}
+
+// field represents a decoded field of a protocol buffer message, varint for
+// varint fields and bytes for length-delimited fields.
+type field struct {
+	num    protowire.Number
+	varint int64
+	bytes  []byte
+}
+
+// decodeFields calls fn for each varint and length-delimited field of a
+// protocol buffer message. Fields of other types are skipped.
+func decodeFields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		f := field{num: num}
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			f.varint = int64(v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			f.bytes = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			b = b[n:]
+
+			continue
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp.
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var sec, nsec int64
+
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			sec = f.varint
+		case 2:
+			nsec = f.varint
+		}
+
+		return nil
+	})
+
+	return time.Unix(sec, nsec).UTC(), err
+}
@@ -0,0 +1,55 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/admtnnr/library/ratelimit"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimit returns the options that a gRPC server must be created with to
+// limit the rate of the calls of each client with l, see ratelimit.Limiter.
+// Calls over the limit fail with codes.ResourceExhausted.
+//
+// With KeyAuth, the options should precede those of KeyAuth so that the calls
+// that are not authenticated are limited too, e.g. of a client guessing keys,
+// in which case the clients are identified by their addresses. Following
+// KeyAuth, the clients are identified by their keys instead.
+func RateLimit(l *ratelimit.Limiter) []grpcgo.ServerOption {
+	return []grpcgo.ServerOption{
+		grpcgo.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpcgo.UnaryServerInfo, handler grpcgo.UnaryHandler) (any, error) {
+			if err := allow(ctx, l); err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		}),
+		grpcgo.ChainStreamInterceptor(func(srv any, ss grpcgo.ServerStream, info *grpcgo.StreamServerInfo, handler grpcgo.StreamHandler) error {
+			if err := allow(ss.Context(), l); err != nil {
+				return err
+			}
+
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// allow returns a gRPC status error if the client of a call is over the
+// limit.
+func allow(ctx context.Context, l *ratelimit.Limiter) error {
+	var addr string
+
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+
+	if ok, delay := l.Allow(ratelimit.ClientID(ctx, addr)); !ok {
+		return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %s", delay.Round(time.Millisecond))
+	}
+
+	return nil
+}
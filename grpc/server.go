@@ -0,0 +1,304 @@
+// Package grpc provides the LibraryService gRPC service of a library, see
+// library.proto, so that other backend services can execute commands and
+// watch the changes of a library without shelling out to the CLI.
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/admtnnr/library"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serviceName is the full name of the service in library.proto.
+const serviceName = "library.v1.LibraryService"
+
+// Server implements LibraryService for a library.
+//
+// Each call executes the command of the same name, e.g. Checkout executes
+// CHECKOUT_BOOK, so the commands are validated and executed exactly as in a
+// commands file, and are recorded as changes for Watch and ExportSince.
+type Server struct {
+	Library *library.Library
+	// AfterExec is called after each command is executed successfully,
+	// e.g. the Append of a Store to persist it. If it returns an error the
+	// call fails with the error.
+	AfterExec func(inv *library.Invocation) error
+	// OnResult, if set, is called with the result of each command
+	// executed, including those that fail, e.g. to count them.
+	OnResult func(result library.Result)
+
+	// mu serializes the commands so that AfterExec is called in the order
+	// the commands are executed and the response reflects the command.
+	mu sync.Mutex
+}
+
+// WatchServer is the stream of changes of a Watch call.
+type WatchServer interface {
+	Send(change *Change) error
+	Context() context.Context
+}
+
+// NewServer returns a gRPC server with the service of srv registered, see
+// Register.
+func NewServer(srv *Server, opts ...grpcgo.ServerOption) *grpcgo.Server {
+	s := grpcgo.NewServer(append(opts, ServerOption())...)
+
+	Register(s, srv)
+
+	return s
+}
+
+// ServerOption returns the option that a gRPC server must be created with to
+// serve the service, see Register.
+func ServerOption() grpcgo.ServerOption {
+	return grpcgo.ForceServerCodecV2(codec{})
+}
+
+// Register registers the service of srv on a gRPC server created with
+// ServerOption.
+func Register(s grpcgo.ServiceRegistrar, srv *Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// AddBook adds a book to the catalog with ADD_BOOK and returns it.
+func (s *Server) AddBook(ctx context.Context, req *AddBookRequest) (*Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.exec(&library.AddBook{
+		ID:     req.ID,
+		Name:   req.Name,
+		Count:  req.Count,
+		Author: req.Author,
+		ISBN:   req.ISBN,
+		Tags:   req.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The book is copied since the library may change it once the lock
+	// is released, e.g. by ADD_COPIES.
+	book := *s.Library.Book(req.ID)
+
+	return (*Book)(&book), nil
+}
+
+// Checkout checks out a book to an account with CHECKOUT_BOOK and returns the
+// checkout.
+func (s *Server) Checkout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.exec(&library.CheckoutBook{AccountID: req.AccountID, BookID: req.BookID}); err != nil {
+		return nil, err
+	}
+
+	for _, checkout := range s.Library.CheckoutsByAccount(req.AccountID) {
+		if checkout.BookID == req.BookID {
+			// The checkout is copied since returning the book sets
+			// its Returned.
+			c := *checkout
+
+			return (*Checkout)(&c), nil
+		}
+	}
+
+	return nil, status.Errorf(codes.Internal, "checkout of book (%d) by account (%d) not found", req.BookID, req.AccountID)
+}
+
+// Return returns a book checked out to an account with RETURN_BOOK and
+// returns the returned checkout and the balance of the account.
+func (s *Server) Return(ctx context.Context, req *ReturnRequest) (*ReturnResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.exec(&library.ReturnBook{AccountID: req.AccountID, BookID: req.BookID}); err != nil {
+		return nil, err
+	}
+
+	resp := &ReturnResponse{Balance: s.Library.Account(req.AccountID).Balance}
+
+	history := s.Library.HistoryByAccount(req.AccountID)
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].BookID == req.BookID && !history[i].Returned.IsZero() {
+			c := *history[i]
+			resp.Checkout = (*Checkout)(&c)
+
+			break
+		}
+	}
+
+	return resp, nil
+}
+
+// Watch streams the changes of the library after the sequence number of the
+// request, see library.Library.Watch, until the call is canceled.
+//
+// If the stream falls too far behind or the state of the library is
+// replaced, the call fails with codes.Aborted and the client should watch
+// again from the sequence number of the last change it received.
+func (s *Server) Watch(req *WatchRequest, stream WatchServer) error {
+	changes, stop, err := s.Library.Watch(req.Since)
+	if err != nil {
+		return statusError(err)
+	}
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case c, ok := <-changes:
+			if !ok {
+				return status.Error(codes.Aborted, "watch stopped, watch again from the last sequence received")
+			}
+
+			// The invocation is copied since marshaling it sets its
+			// RawCommand.
+			inv := c.Invocation
+
+			command, err := json.Marshal(&inv)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to marshal change, %v", err)
+			}
+
+			if err := stream.Send(&Change{Sequence: c.Seq, At: c.Invocation.At, Command: string(command)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exec validates and executes a command, then calls AfterExec. The error is
+// a gRPC status error with the code of the error, see statusError.
+func (s *Server) exec(cmd any) error {
+	if v, ok := cmd.(library.Validator); ok {
+		if err := v.Validate(); err != nil {
+			return statusError(err)
+		}
+	}
+
+	inv := library.Invocation{Command: cmd}
+
+	err := inv.Exec(s.Library)
+
+	if s.OnResult != nil {
+		s.OnResult(inv.Result)
+	}
+
+	if err != nil {
+		return statusError(err)
+	}
+
+	if s.AfterExec != nil {
+		if err := s.AfterExec(&inv); err != nil {
+			return status.Errorf(codes.Internal, "%s", err)
+		}
+	}
+
+	return nil
+}
+
+// statusError returns a gRPC status error for an error returned by the
+// library, with the code that corresponds to its library.ErrorCode.
+func statusError(err error) error {
+	var code codes.Code
+
+	switch library.ErrorCodeOf(err) {
+	case library.CodeBookNotFound, library.CodeAccountNotFound, library.CodeCheckoutNotFound, library.CodeMacroNotFound, library.CodeHoldNotFound:
+		code = codes.NotFound
+	case library.CodeDuplicateID:
+		code = codes.AlreadyExists
+	case library.CodeQuotaExceeded:
+		code = codes.ResourceExhausted
+	case library.CodeVersionMismatch:
+		code = codes.Aborted
+	case library.CodeInvalidArguments, library.CodeInvalidCommand:
+		code = codes.InvalidArgument
+	case library.CodeLimitExceeded, library.CodeAlreadyCheckedOut, library.CodeNotEnoughCopies,
+		library.CodeInventoryNotStarted, library.CodeInventoryInProgress, library.CodeNotConfirmed, library.CodeReadOnly:
+		code = codes.FailedPrecondition
+	default:
+		code = codes.Internal
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// serviceDesc describes LibraryService to gRPC, as generated code would.
+var serviceDesc = grpcgo.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpcgo.MethodDesc{
+		unaryMethod("AddBook", (*Server).AddBook),
+		unaryMethod("Checkout", (*Server).Checkout),
+		unaryMethod("Return", (*Server).Return),
+	},
+	Streams: []grpcgo.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpc/library.proto",
+}
+
+// unaryMethod returns the description of a unary method of the service that
+// calls fn.
+func unaryMethod[Req, Resp any, PReq interface {
+	*Req
+	message
+}](name string, fn func(s *Server, ctx context.Context, req PReq) (Resp, error)) grpcgo.MethodDesc {
+	handler := func(srv any, ctx context.Context, dec func(any) error, interceptor grpcgo.UnaryServerInterceptor) (any, error) {
+		req := PReq(new(Req))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+
+		call := func(ctx context.Context, req any) (any, error) {
+			return fn(srv.(*Server), ctx, req.(PReq))
+		}
+
+		if interceptor == nil {
+			return call(ctx, req)
+		}
+
+		info := &grpcgo.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: method(name),
+		}
+
+		return interceptor(ctx, req, info, call)
+	}
+
+	return grpcgo.MethodDesc{MethodName: name, Handler: handler}
+}
+
+// watchHandler handles the Watch stream of the service.
+func watchHandler(srv any, stream grpcgo.ServerStream) error {
+	var req WatchRequest
+
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+
+	return srv.(*Server).Watch(&req, watchServer{stream})
+}
+
+// watchServer is the WatchServer of a gRPC server stream.
+type watchServer struct {
+	grpcgo.ServerStream
+}
+
+// Send implements WatchServer.
+func (s watchServer) Send(change *Change) error {
+	return s.SendMsg(change)
+}
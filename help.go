@@ -0,0 +1,162 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CommandHelp describes a registered command, see DescribeCommand.
+type CommandHelp struct {
+	Name      string         `json:"name"`
+	Arguments []ArgumentHelp `json:"arguments"`
+	// Example is an example of the command as a line of a command file.
+	Example string `json:"example"`
+}
+
+// ArgumentHelp describes an argument of a command, see DescribeCommand.
+type ArgumentHelp struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // JSON type of the argument, e.g. integer or list of strings.
+	Required bool   `json:"required"`
+	// Help describes the argument and its constraints, e.g. "number of
+	// copies, must not be negative".
+	Help string `json:"help,omitempty"`
+}
+
+// DescribeCommand returns the help of the command registered with the name,
+// or false if there is none.
+//
+// The help is generated from the Command type: its arguments are its JSON
+// fields, which are required unless tagged with omitempty, see
+// CommandFactory, described by their help tags, e.g.:
+//
+//	Count int `json:"count" help:"number of copies, must not be negative" example:"3"`
+//
+// The example is the command with the arguments that have an example tag, in
+// order, the string itself for strings and times and JSON otherwise.
+func DescribeCommand(name string) (*CommandHelp, bool) {
+	cmd, ok := NewCommand(name)
+	if !ok {
+		return nil, false
+	}
+
+	h := &CommandHelp{Name: name, Arguments: []ArgumentHelp{}}
+
+	// The arguments of the example are in the order of the fields rather
+	// than sorted, as they would be in a map.
+	var example bytes.Buffer
+
+	t := reflect.TypeOf(cmd).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		argName, opts, _ := strings.Cut(tag, ",")
+		if argName == "" {
+			argName = f.Name
+		}
+
+		h.Arguments = append(h.Arguments, ArgumentHelp{
+			Name:     argName,
+			Type:     argumentType(f.Type),
+			Required: !slices.Contains(strings.Split(opts, ","), "omitempty"),
+			Help:     f.Tag.Get("help"),
+		})
+
+		if v, ok := f.Tag.Lookup("example"); ok {
+			if example.Len() > 0 {
+				example.WriteByte(',')
+			}
+
+			example.Write(mustMarshalJSON(argName))
+			example.WriteByte(':')
+			example.Write(exampleValue(f.Type, v))
+		}
+	}
+
+	args := json.RawMessage("{" + example.String() + "}")
+
+	h.Example = string(mustMarshalJSON(&Command{Name: name, Arguments: args}))
+
+	return h, true
+}
+
+// timeType is the type of the time arguments, which are RFC 3339 strings.
+var timeType = reflect.TypeOf(time.Time{})
+
+// argumentType returns the JSON type of an argument of the Go type.
+func argumentType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return "RFC 3339 time"
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return "JSON"
+	case t == reflect.TypeOf(Command{}):
+		return "command"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		elem := argumentType(t.Elem())
+
+		// The plurals of the types of the elements, e.g. list of
+		// integers, but list of JSON.
+		if !strings.Contains(elem, " ") && elem != "JSON" {
+			elem += "s"
+		}
+
+		return "list of " + elem
+	default:
+		return "object"
+	}
+}
+
+// exampleValue returns the JSON value of the example tag of an argument of the
+// Go type. An example that is not valid JSON is taken as a string.
+func exampleValue(t reflect.Type, example string) json.RawMessage {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.String || t == timeType || !json.Valid([]byte(example)) {
+		return mustMarshalJSON(example)
+	}
+
+	return json.RawMessage(example)
+}
+
+// mustMarshalJSON returns the JSON encoding of a value that cannot fail to be
+// encoded.
+func mustMarshalJSON(v any) json.RawMessage {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return bs
+}
@@ -0,0 +1,348 @@
+package library
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrHoldNotExist is returned when a hold does not exist.
+	ErrHoldNotExist = errors.New("hold does not exist")
+)
+
+// Hold represents a request of an account to check out a book, e.g. once a
+// copy is returned. The holds of a book are queued in the order they were
+// placed, the copies available can only be checked out by the accounts at the
+// head of the queue, see CheckoutBook, and a hold is fulfilled when the account
+// checks out the book. A hold expires once it has been queued for the hold
+// window of the policy, if any, see Policy.HoldWindow, and is then no longer
+// listed or counted.
+type Hold struct {
+	AccountID int       `json:"accountId"` // ID of the account the hold is for.
+	BookID    int       `json:"bookId"`    // ID of the book held.
+	Placed    time.Time `json:"placed"`    // Time the hold was placed.
+}
+
+// clone returns a copy of the hold, nil for nil.
+func (h *Hold) clone() *Hold {
+	if h == nil {
+		return nil
+	}
+
+	c := *h
+
+	return &c
+}
+
+// PlaceHold places a hold on a book for an account at the end of the hold
+// queue of the book.
+//
+// If the account or book does not exist, an error is returned. If the account
+// already has a hold on the book, or the book checked out, an error is
+// returned.
+func (l *Library) PlaceHold(accountID, bookID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.placeHold(accountID, bookID, l.clock.Now())
+}
+
+// PlaceHoldAt places a hold on a book for an account as of the provided time.
+//
+// PlaceHoldAt behaves the same as PlaceHold and exists to allow restoring holds
+// with their original time.
+func (l *Library) PlaceHoldAt(accountID, bookID int, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.placeHold(accountID, bookID, at)
+}
+
+// placeHold places a hold on a book for an account as of the provided time.
+//
+// placeHold must be called with the lock held.
+func (l *Library) placeHold(accountID, bookID int, at time.Time) error {
+	account, ok := l.accounts[accountID]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: accountID}
+	}
+
+	book, ok := l.books[bookID]
+	if !ok {
+		return &NotExistError{Kind: KindBook, ID: bookID}
+	}
+
+	if slices.ContainsFunc(l.holds[book.ID], func(hold *Hold) bool { return hold.AccountID == account.ID && !l.holdExpired(hold, at) }) {
+		return &DuplicateError{
+			Kind:   KindHold,
+			ID:     book.ID,
+			detail: fmt.Sprintf("%s (%d) already has a hold on %s (%d)", account.Name, account.ID, book.Name, book.ID),
+		}
+	}
+
+	if slices.ContainsFunc(l.checkoutsByAccount[account.ID], func(checkout *Checkout) bool { return checkout.BookID == book.ID }) {
+		return &AlreadyCheckedOutError{
+			AccountID: account.ID,
+			BookID:    book.ID,
+			detail:    fmt.Sprintf("%s (%d) cannot place a hold on %s (%d), which it has checked out", account.Name, account.ID, book.Name, book.ID),
+		}
+	}
+
+	// The expired holds are removed so that the account does not have an
+	// expired hold on the book as well as the new one.
+	restoreExpired := l.expireHolds(book.ID, at)
+
+	hold := &Hold{AccountID: account.ID, BookID: book.ID, Placed: at}
+
+	l.holds[book.ID] = append(l.holds[book.ID], hold)
+
+	l.pushUndo(fmt.Sprintf("hold on %s (%d) for %s (%d)", book.Name, book.ID, account.Name, account.ID), func() {
+		l.holds[hold.BookID] = slices.DeleteFunc(l.holds[hold.BookID], func(h *Hold) bool { return h == hold })
+		restoreExpired()
+	})
+
+	return nil
+}
+
+// CancelHold cancels the hold of an account on a book.
+//
+// If the account or book does not exist, or the account has no hold on the
+// book, an error is returned.
+func (l *Library) CancelHold(accountID, bookID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account, ok := l.accounts[accountID]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: accountID}
+	}
+
+	book, ok := l.books[bookID]
+	if !ok {
+		return &NotExistError{Kind: KindBook, ID: bookID}
+	}
+
+	now := l.clock.Now()
+	ready := l.readyHolds(book, now)
+
+	restore := l.removeHold(account.ID, book.ID)
+	if restore == nil {
+		return &NotExistError{Kind: KindHold, ID: book.ID, AccountID: account.ID}
+	}
+
+	// The copy held for the account, if any, is now held for the next
+	// account in the queue.
+	l.noteReadyHolds(book, now, ready)
+
+	l.pushUndo(fmt.Sprintf("cancel of hold on %s (%d) for %s (%d)", book.Name, book.ID, account.Name, account.ID), restore)
+
+	return nil
+}
+
+// removeHold removes the hold of an account on a book, e.g. when the account
+// checks out the book, and returns the function restoring it in its place in
+// the queue, nil if the account has no hold on the book.
+//
+// removeHold must be called with the lock held, and so must the function it
+// returns.
+func (l *Library) removeHold(accountID, bookID int) func() {
+	i := slices.IndexFunc(l.holds[bookID], func(hold *Hold) bool { return hold.AccountID == accountID })
+	if i < 0 {
+		return nil
+	}
+
+	hold := l.holds[bookID][i]
+
+	l.holds[bookID] = slices.Delete(l.holds[bookID], i, i+1)
+
+	if len(l.holds[bookID]) == 0 {
+		delete(l.holds, bookID)
+	}
+
+	return func() {
+		l.holds[bookID] = slices.Insert(l.holds[bookID], min(i, len(l.holds[bookID])), hold)
+	}
+}
+
+// fulfillHold removes the hold of the account on the book it checked out, if
+// any, and returns the function restoring it, which does nothing if there is
+// none, for the undo of the checkout.
+//
+// fulfillHold must be called with the lock held, and so must the function it
+// returns.
+func (l *Library) fulfillHold(accountID, bookID int) func() {
+	if restore := l.removeHold(accountID, bookID); restore != nil {
+		return restore
+	}
+
+	return func() {}
+}
+
+// holdExpired reports whether the hold has expired as of the provided time,
+// see Policy.HoldWindow.
+//
+// holdExpired must be called with the lock held.
+func (l *Library) holdExpired(hold *Hold, at time.Time) bool {
+	return l.policy.HoldWindow > 0 && !at.Before(hold.Placed.Add(l.policy.HoldWindow))
+}
+
+// expireHolds removes the holds on a book that have expired as of the provided
+// time and returns the function restoring them, which does nothing if there
+// are none, for the undo of the mutation that removed them.
+//
+// expireHolds must be called with the lock held, and so must the function it
+// returns.
+func (l *Library) expireHolds(bookID int, at time.Time) func() {
+	queue := l.holds[bookID]
+
+	expired := func(hold *Hold) bool { return l.holdExpired(hold, at) }
+
+	if !slices.ContainsFunc(queue, expired) {
+		return func() {}
+	}
+
+	l.holds[bookID] = slices.DeleteFunc(slices.Clone(queue), expired)
+
+	if len(l.holds[bookID]) == 0 {
+		delete(l.holds, bookID)
+	}
+
+	return func() {
+		l.holds[bookID] = queue
+	}
+}
+
+// activeHolds returns the holds of the queue that have not expired as of the
+// provided time.
+//
+// activeHolds must be called with the lock held.
+func (l *Library) activeHolds(queue []*Hold, at time.Time) []*Hold {
+	if l.policy.HoldWindow <= 0 {
+		return queue
+	}
+
+	return slices.DeleteFunc(slices.Clone(queue), func(hold *Hold) bool { return l.holdExpired(hold, at) })
+}
+
+// holdsAhead returns the holds on a book that have not expired as of the
+// provided time ahead of the hold of the account in the hold queue, the whole
+// queue if the account has no such hold on the book.
+//
+// holdsAhead must be called with the lock held.
+func (l *Library) holdsAhead(accountID, bookID int, at time.Time) []*Hold {
+	queue := l.activeHolds(l.holds[bookID], at)
+
+	if i := slices.IndexFunc(queue, func(hold *Hold) bool { return hold.AccountID == accountID }); i >= 0 {
+		return queue[:i]
+	}
+
+	return queue
+}
+
+// checkHolds returns an error if the copies of a book that are not checked
+// out, less taken copies being checked out along with it, are all held for the
+// accounts ahead of the account in the hold queue as of the provided time, so
+// that a returned copy goes to the head of the queue rather than whoever asks
+// for it first. The holds of the accounts of fulfilled are not counted, e.g.
+// those checking out the book along with the account.
+//
+// checkHolds must be called with the lock held.
+func (l *Library) checkHolds(account *Account, book *Book, at time.Time, taken int, fulfilled func(accountID int) bool) error {
+	var ahead int
+
+	for _, hold := range l.holdsAhead(account.ID, book.ID, at) {
+		if fulfilled == nil || !fulfilled(hold.AccountID) {
+			ahead++
+		}
+	}
+
+	if available := book.Count - len(l.checkoutsByBook[book.ID]) - taken; ahead > 0 && available <= ahead {
+		return &NotEnoughCopiesError{
+			BookID:    book.ID,
+			Available: max(available-ahead, 0),
+			detail:    fmt.Sprintf("%s (%d) cannot check out %s (%d), the copies available are held for the accounts ahead in its hold queue", account.Name, account.ID, book.Name, book.ID),
+		}
+	}
+
+	return nil
+}
+
+// readyHolds returns the holds on a book that have not expired as of the
+// provided time and that a copy not checked out is available for, those at the
+// head of its hold queue. The holds are returned in a new slice, so that they
+// are kept as the queue changes.
+//
+// readyHolds must be called with the lock held.
+func (l *Library) readyHolds(book *Book, at time.Time) []*Hold {
+	queue := l.activeHolds(l.holds[book.ID], at)
+
+	available := book.Count - len(l.checkoutsByBook[book.ID])
+
+	return slices.Clone(queue[:min(max(available, 0), len(queue))])
+}
+
+// noteReadyHolds records the holds on a book that a copy became available for
+// as of the provided time, those ready now that were not among before, for the
+// next change, see Change.Available.
+//
+// noteReadyHolds must be called with the lock held.
+func (l *Library) noteReadyHolds(book *Book, at time.Time, before []*Hold) {
+	for _, hold := range l.readyHolds(book, at) {
+		if !slices.Contains(before, hold) {
+			l.available = append(l.available, *hold)
+		}
+	}
+}
+
+// HoldsByBook returns copies of the holds on a book that have not expired in
+// the order of its hold queue, the next account to check out the book first.
+func (l *Library) HoldsByBook(id int) []*Hold {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return cloneAll(l.activeHolds(l.holds[id], l.clock.Now()))
+}
+
+// HoldsByAccount returns copies of the holds of an account that have not
+// expired, in the order they were placed.
+func (l *Library) HoldsByAccount(id int) []*Hold {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var (
+		holds []*Hold
+		now   = l.clock.Now()
+	)
+
+	for _, queue := range l.holds {
+		for _, hold := range l.activeHolds(queue, now) {
+			if hold.AccountID == id {
+				holds = append(holds, hold.clone())
+			}
+		}
+	}
+
+	slices.SortFunc(holds, func(a, b *Hold) int {
+		return cmp.Or(a.Placed.Compare(b.Placed), cmp.Compare(a.BookID, b.BookID))
+	})
+
+	return holds
+}
+
+// sortedHolds returns the holds of the library by the ID of the book and in
+// the order of the hold queue of each book.
+//
+// sortedHolds must be called with the lock held.
+func (l *Library) sortedHolds() []*Hold {
+	var holds []*Hold
+
+	for _, id := range slices.Sorted(maps.Keys(l.holds)) {
+		holds = append(holds, l.holds[id]...)
+	}
+
+	return holds
+}
@@ -0,0 +1,226 @@
+package library
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInventoryNotStarted is returned when an inventory operation is
+	// attempted without an inventory in progress.
+	ErrInventoryNotStarted = errors.New("inventory not started")
+	// ErrInventoryInProgress is returned when an inventory is started while
+	// another is in progress.
+	ErrInventoryInProgress = errors.New("inventory already in progress")
+)
+
+// inventory represents an inventory audit in progress.
+type inventory struct {
+	started time.Time
+
+	// barcodes are the unique barcodes scanned so far in the order they
+	// were first scanned. The order is kept so that an inventory in
+	// progress can be exported and restored as it was.
+	barcodes []string
+	scanned  map[string]bool
+}
+
+// InventoryReport represents the result of an inventory audit comparing the
+// scanned copies to the catalog.
+type InventoryReport struct {
+	Started time.Time // Time the inventory was started.
+	Scanned int       // Number of unique copies scanned.
+
+	// Missing are the books with fewer copies scanned than are expected
+	// to be on the shelf.
+	Missing []InventoryCount
+	// Surplus are the books with more copies scanned than are expected to
+	// be on the shelf, e.g. a checked out copy that was returned without
+	// being checked in.
+	Surplus []InventoryCount
+	// Unexpected are the scanned barcodes that do not match any copy in
+	// the catalog.
+	Unexpected []string
+}
+
+// InventoryCount represents the expected and scanned copies of a book in an
+// inventory audit.
+type InventoryCount struct {
+	BookID   int // ID of the book.
+	Expected int // Number of copies expected to be on the shelf.
+	Scanned  int // Number of copies scanned.
+}
+
+// Barcode returns the barcode of a copy of a book.
+//
+// Copies are not tracked individually, so a barcode identifies a copy by the
+// ID of the book and the copy number, from 1 to the number of copies of the
+// book, in the form "<book-id>-<copy>".
+func Barcode(bookID, number int) string {
+	return fmt.Sprintf("%d-%d", bookID, number)
+}
+
+// ParseBarcode parses a barcode of a copy of a book into the ID of the book and
+// the copy number.
+func ParseBarcode(barcode string) (bookID, number int, err error) {
+	id, n, ok := strings.Cut(barcode, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w, barcode %q, expected <book-id>-<copy>", ErrInvalidArgument, barcode)
+	}
+
+	if bookID, err = strconv.Atoi(id); err != nil {
+		return 0, 0, fmt.Errorf("%w, barcode %q, invalid book ID", ErrInvalidArgument, barcode)
+	}
+
+	if number, err = strconv.Atoi(n); err != nil || number < 1 {
+		return 0, 0, fmt.Errorf("%w, barcode %q, invalid copy number", ErrInvalidArgument, barcode)
+	}
+
+	return bookID, number, nil
+}
+
+// StartInventory starts an inventory audit.
+//
+// If an inventory is already in progress, an error is returned.
+func (l *Library) StartInventory() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.startInventory(l.clock.Now())
+}
+
+// StartInventoryAt starts an inventory audit as of the provided time.
+//
+// StartInventoryAt behaves the same as StartInventory and exists to allow
+// restoring an inventory in progress with its original start time.
+func (l *Library) StartInventoryAt(at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.startInventory(at)
+}
+
+func (l *Library) startInventory(at time.Time) error {
+	if l.inventory != nil {
+		return ErrInventoryInProgress
+	}
+
+	l.inventory = &inventory{
+		started: at,
+		scanned: make(map[string]bool),
+	}
+
+	l.pushUndo("start inventory", func() {
+		l.inventory = nil
+	})
+
+	return nil
+}
+
+// ScanCopy records a copy of a book as present in the inventory audit in
+// progress.
+//
+// Scanning the same copy more than once has no effect. If no inventory is in
+// progress or the barcode is malformed, an error is returned. Barcodes that do
+// not match a copy in the catalog are accepted and reported as unexpected when
+// the inventory is finished.
+func (l *Library) ScanCopy(barcode string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inventory == nil {
+		return ErrInventoryNotStarted
+	}
+
+	if _, _, err := ParseBarcode(barcode); err != nil {
+		return err
+	}
+
+	if l.inventory.scanned[barcode] {
+		return nil
+	}
+
+	current := l.inventory
+
+	current.scanned[barcode] = true
+	current.barcodes = append(current.barcodes, barcode)
+
+	l.pushUndo(fmt.Sprintf("scan of copy %s", barcode), func() {
+		delete(current.scanned, barcode)
+		current.barcodes = slices.DeleteFunc(current.barcodes, func(b string) bool { return b == barcode })
+	})
+
+	return nil
+}
+
+// FinishInventory finishes the inventory audit in progress and reports the
+// differences between the scanned copies and the catalog.
+//
+// The copies expected to be on the shelf are the copies of each book that are
+// not currently checked out. If no inventory is in progress, an error is
+// returned.
+func (l *Library) FinishInventory() (*InventoryReport, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inventory == nil {
+		return nil, ErrInventoryNotStarted
+	}
+
+	report := &InventoryReport{
+		Started: l.inventory.started,
+		Scanned: len(l.inventory.barcodes),
+	}
+
+	scanned := make(map[int]int)
+
+	for _, barcode := range l.inventory.barcodes {
+		// Barcodes are validated when scanned.
+		bookID, number, _ := ParseBarcode(barcode)
+
+		book, ok := l.books[bookID]
+		if !ok || number > book.Count {
+			report.Unexpected = append(report.Unexpected, barcode)
+			continue
+		}
+
+		scanned[bookID]++
+	}
+
+	for _, book := range l.books {
+		count := InventoryCount{
+			BookID:   book.ID,
+			Expected: book.Count - len(l.checkoutsByBook[book.ID]),
+			Scanned:  scanned[book.ID],
+		}
+
+		switch {
+		case count.Scanned < count.Expected:
+			report.Missing = append(report.Missing, count)
+		case count.Scanned > count.Expected:
+			report.Surplus = append(report.Surplus, count)
+		}
+	}
+
+	byBookID := func(a, b InventoryCount) int {
+		return cmp.Compare(a.BookID, b.BookID)
+	}
+
+	slices.SortFunc(report.Missing, byBookID)
+	slices.SortFunc(report.Surplus, byBookID)
+
+	current := l.inventory
+
+	l.inventory = nil
+
+	l.pushUndo("finish inventory", func() {
+		l.inventory = current
+	})
+
+	return report, nil
+}
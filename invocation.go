@@ -2,9 +2,8 @@ package library
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"strings"
+	"time"
 )
 
 // Invocation represents an action to be executed against the Library and the
@@ -25,18 +24,51 @@ type Invocation struct {
 	RawCommand Command
 	// Command is concrete Command type that was derived from the RawCommand.
 	//
-	// Concrete Command types currently supported are:
+	// The concrete Command type is determined by the factory registered
+	// for the command name, see RegisterCommand. The Command types of the
+	// built-in commands are:
 	// - *AddBook
 	// - *AddCopies
 	// - *RemoveCopies
 	// - *CreateAccount
 	// - *CheckoutBook
 	// - *ReturnBook
+	// - *AddHistory
 	// - *PrintCatalog
 	// - *PrintAccounts
+	// - *TopBooks
+	// - *PrintAccount
+	// - *StartInventory
+	// - *ScanCopy
+	// - *FinishInventory
+	// - *BulkCheckout
+	// - *BulkReturn
+	// - *AddCredit
+	// - *SearchBooks
+	// - *ListCheckouts
+	// - *ListOverdue
+	// - *PrintBook
+	// - *Undo
+	// - *DefineMacro
+	// - *CallMacro
+	// - *AssertBookCount
+	// - *AssertCheckedOut
+	// - *AssertError
+	// - *Wait
+	// - *Export
+	// - *Include
+	// - *SetPolicy
+	// - *PlaceHold
+	// - *CancelHold
 	Command any
 	// Output is the human readable output of the execution of the Command.
 	Output string
+	// Result is the machine-readable outcome of the execution of the
+	// Command.
+	Result Result
+	// At is the time the Command was executed according to the library
+	// clock, e.g. so that a Store can persist when a command was executed.
+	At time.Time
 }
 
 // Command represents an action to be executed against the Library and the
@@ -45,7 +77,8 @@ type Invocation struct {
 // Commands are parsed incrementally to allow for deserializing the Arguments
 // directly into the correct Command type based on the command name.
 type Command struct {
-	// Name is the name of the command. Currently, the following commands are supported:
+	// Name is the name of the command. The following built-in commands
+	// are supported, in addition to any registered with RegisterCommand:
 	//
 	// - ADD_BOOK
 	// - ADD_COPIES
@@ -53,8 +86,33 @@ type Command struct {
 	// - CREATE_ACCOUNT
 	// - CHECKOUT_BOOK
 	// - RETURN_BOOK
+	// - ADD_HISTORY
 	// - PRINT_CATALOG
 	// - PRINT_ACCOUNTS
+	// - TOP_BOOKS
+	// - PRINT_ACCOUNT
+	// - START_INVENTORY
+	// - SCAN_COPY
+	// - FINISH_INVENTORY
+	// - BULK_CHECKOUT
+	// - BULK_RETURN
+	// - ADD_CREDIT
+	// - SEARCH_BOOKS
+	// - LIST_CHECKOUTS
+	// - LIST_OVERDUE
+	// - PRINT_BOOK
+	// - UNDO
+	// - DEFINE_MACRO
+	// - CALL_MACRO
+	// - ASSERT_BOOK_COUNT
+	// - ASSERT_CHECKED_OUT
+	// - ASSERT_ERROR
+	// - WAIT
+	// - EXPORT
+	// - INCLUDE
+	// - SET_POLICY
+	// - PLACE_HOLD
+	// - CANCEL_HOLD
 	Name string `json:"name"`
 	// Arguments are the serialized arguments for the command. The
 	// arguments are deserialized separately into the correct Command type
@@ -65,150 +123,63 @@ type Command struct {
 // Exec executes the Command against the Library and sets the human readable
 // output for optional display to the user.
 //
-// The majority of the code in this method is concerned with setting the most
-// useful human readable output, particularly around error conditions.
+// The Command is executed by the handler registered for its type, see
+// RegisterCommand. A read-only library only executes a ReadOnlyCommand, see
+// Library.SetReadOnly, and a library that does not allow file access does not
+// execute a FileCommand, see Library.SetFileAccess.
 func (inv *Invocation) Exec(l *Library) error {
-	switch cmd := inv.Command.(type) {
-	case *AddBook:
-		err := l.AddBook(cmd.ID, cmd.Name, cmd.Count)
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not be added to the catalog, %v", cmd.Name, cmd.ID, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) with %d copies added to the catalog", cmd.Name, cmd.ID, cmd.Count)
-	case *AddCopies:
-		err := l.AddCopies(cmd.ID, cmd.Count)
-		if errors.Is(err, ErrBookNotExist) {
-			inv.Output = fmt.Sprintf("could not add %d copies, book (%d) does not exist", cmd.ID)
-			return err
-		}
-
-		book := l.Book(cmd.ID)
-
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not add %d copies, %v", book.Name, book.ID, cmd.Count, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) added %d copies", book.Name, book.ID, cmd.Count)
-	case *RemoveCopies:
-		err := l.RemoveCopies(cmd.ID, cmd.Count)
-		if errors.Is(err, ErrBookNotExist) {
-			inv.Output = fmt.Sprintf("could not remove %d copies, book (%d) does not exist", cmd.ID)
-			return err
-		}
-
-		book := l.Book(cmd.ID)
-
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not remove %d copies, %v", book.Name, book.ID, cmd.Count, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) removed %d copies", book.Name, book.ID, cmd.Count)
-	case *CreateAccount:
-		inv.Command = CreateAccount{}
-		err := l.CreateAccount(cmd.ID, cmd.Name)
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not create account, %v", cmd.Name, cmd.ID, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) created account", cmd.Name, cmd.ID)
-	case *CheckoutBook:
-		err := l.CheckoutBook(cmd.AccountID, cmd.BookID)
-		if errors.Is(err, ErrAccountNotExist) {
-			inv.Output = fmt.Sprintf("could not checkout book, account (%d) does not exist", cmd.AccountID)
-			return err
-		}
-
-		account := l.Account(cmd.AccountID)
-
-		if errors.Is(err, ErrBookNotExist) {
-			inv.Output = fmt.Sprintf("%s (%d) could not checkout book, book (%d) does not exist", account.Name, account.ID, cmd.BookID)
-			return err
-		}
-
-		book := l.Book(cmd.BookID)
-
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not checkout %s (%d), %v", account.Name, account.ID, book.Name, book.ID, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) checked out %s (%d)", account.Name, account.ID, book.Name, book.ID)
-	case *ReturnBook:
-		err := l.ReturnBook(cmd.AccountID, cmd.BookID)
-		if errors.Is(err, ErrAccountNotExist) {
-			inv.Output = fmt.Sprintf("could not return book, account (%d) does not exist", cmd.AccountID)
-			return err
-		}
-
-		account := l.Account(cmd.AccountID)
-
-		if errors.Is(err, ErrBookNotExist) {
-			inv.Output = fmt.Sprintf("%s (%d) could not return book, book (%d) does not exist", account.Name, account.ID, cmd.BookID)
-			return err
-		}
-
-		book := l.Book(cmd.BookID)
-
-		if errors.Is(err, ErrCheckoutNotExist) {
-			inv.Output = fmt.Sprintf("%s (%d) could not return %s (%d), no checkout exists", account.Name, account.ID, book.Name, book.ID)
-			return err
-		}
-
-		if err != nil {
-			inv.Output = fmt.Sprintf("%s (%d) could not return %s (%d)", account.Name, account.ID, book.Name, book.ID, err)
-			return err
-		}
-
-		inv.Output = fmt.Sprintf("%s (%d) returned %s (%d)", account.Name, account.ID, book.Name, book.ID)
-	case *PrintCatalog:
-		var sb strings.Builder
-
-		sb.WriteString("# Library Catalog\n")
-
-		l.EachBook(func(book *Book) {
-			fmt.Fprintf(&sb, "## %s (%d)\n", book.Name, book.ID)
-			fmt.Fprintf(&sb, "Copies: %d\n", book.Count)
-
-			checkouts := l.CheckoutsByBook(book.ID)
+	return inv.execAt(l, time.Time{})
+}
 
-			fmt.Fprintf(&sb, "Checked Out: %d\n", len(checkouts))
+// execAt executes the Command as of the time, e.g. the time it was first
+// executed at when it is replayed, or the current time according to the
+// library clock if zero, see Exec. The time is only passed to the command, so
+// the clock of the library, which other callers share, is unchanged.
+func (inv *Invocation) execAt(l *Library, at time.Time) error {
+	c, ok := commandByType(inv.Command)
+	if !ok {
+		return fmt.Errorf("exec: unknown command type, %T", inv.Command)
+	}
 
-			sb.WriteRune('\n')
-		})
+	inv.At = at
 
-		inv.Output = sb.String()
-	case *PrintAccounts:
-		var sb strings.Builder
+	if inv.At.IsZero() {
+		inv.At = l.Now()
+	}
 
-		sb.WriteString("# Accounts\n\n")
+	if err := l.checkReadOnly(c.name, inv.Command); err != nil {
+		inv.Output = fmt.Sprintf("could not run %s, the library is read-only", c.name)
+		inv.Result = newResult(c.name, inv.Command, err)
 
-		l.EachAccount(func(account *Account) {
-			fmt.Fprintf(&sb, "## %s (%d)\n", account.Name, account.ID)
+		return err
+	}
 
-			sb.WriteString("Checked Out Books:\n")
+	if err := l.checkFileAccess(c.name, inv.Command); err != nil {
+		inv.Output = fmt.Sprintf("could not run %s, file access is not allowed", c.name)
+		inv.Result = newResult(c.name, inv.Command, err)
 
-			checkouts := l.CheckoutsByAccount(account.ID)
+		return err
+	}
 
-			for _, checkout := range checkouts {
-				book := l.Book(checkout.BookID)
+	start := time.Now()
 
-				fmt.Fprintf(&sb, "- %s (%d)\n", book.Name, book.ID)
-			}
+	output, err := c.handler(l, inv.Command, inv.At)
 
-			sb.WriteRune('\n')
-		})
+	inv.Output = output
+	inv.Result = newResult(c.name, inv.Command, err)
 
-		inv.Output = sb.String()
-	default:
-		return fmt.Errorf("exec: unknown command type, %T", inv.Command)
+	if err == nil {
+		l.recordChange(inv)
 	}
 
-	return nil
+	l.log().Debug("executed command",
+		"command", c.name,
+		"status", inv.Result.Status,
+		"errorCode", inv.Result.ErrorCode,
+		"duration", time.Since(start),
+	)
+
+	return err
 }
 
 // MarshalJSON marshals the Invocation into JSON.
@@ -234,30 +205,12 @@ func (inv *Invocation) Exec(l *Library) error {
 //	  }
 //	}
 func (inv *Invocation) MarshalJSON() ([]byte, error) {
-	var cmd Command
-
-	switch inv.Command.(type) {
-	case *AddBook:
-		cmd.Name = "ADD_BOOK"
-	case *AddCopies:
-		cmd.Name = "ADD_COPIES"
-	case *RemoveCopies:
-		cmd.Name = "REMOVE_COPIES"
-	case *CreateAccount:
-		cmd.Name = "CREATE_ACCOUNT"
-	case *CheckoutBook:
-		cmd.Name = "CHECKOUT_BOOK"
-	case *ReturnBook:
-		cmd.Name = "RETURN_BOOK"
-	case *PrintCatalog:
-		cmd.Name = "PRINT_CATALOG"
-	case *PrintAccounts:
-		cmd.Name = "PRINT_ACCOUNTS"
-	default:
+	c, ok := commandByType(inv.Command)
+	if !ok {
 		return nil, fmt.Errorf("marshal: unknown command type, %T", inv.Command)
 	}
 
-	inv.RawCommand = cmd
+	inv.RawCommand = Command{Name: c.name}
 
 	bs, err := json.Marshal(inv.Command)
 	if err != nil {
@@ -296,87 +249,22 @@ func (inv *Invocation) UnmarshalJSON(bs []byte) error {
 		return err
 	}
 
-	rbs := []byte(inv.RawCommand.Arguments)
+	// Names that are not registered commands are assumed to be macros,
+	// which are defined by the Library as the commands are executed so
+	// they can only be resolved when the Invocation is executed, failing
+	// as an unknown command if there is no such macro.
+	c, ok := commandByName(inv.RawCommand.Name)
+	if !ok {
+		inv.Command = &CallMacro{
+			Macro:     inv.RawCommand.Name,
+			Arguments: inv.RawCommand.Arguments,
+			byName:    true,
+		}
 
-	// GOTCHA: The `Command` types *MUST* be pointer types to a concrete type
-	// to enable the `json.Decoder` logic to use reflection to determine the
-	// concrete type of the command to know which struct fields to
-	// unmarshal the arguments into.
-	//
-	// `Invocation.Command` is an `interface{}` (`any`) type so taking a
-	// pointer to `inv.Command` results in the `json.Decoder` receiving a
-	// `*interface{}` causing it to unmarshal incorrectly.
-	switch inv.RawCommand.Name {
-	case "ADD_BOOK":
-		inv.Command = &AddBook{}
-	case "ADD_COPIES":
-		inv.Command = &AddCopies{}
-	case "REMOVE_COPIES":
-		inv.Command = &RemoveCopies{}
-	case "CREATE_ACCOUNT":
-		inv.Command = &CreateAccount{}
-	case "CHECKOUT_BOOK":
-		inv.Command = &CheckoutBook{}
-	case "RETURN_BOOK":
-		inv.Command = &ReturnBook{}
-	case "PRINT_CATALOG":
-		inv.Command = &PrintCatalog{}
-		return nil
-	case "PRINT_ACCOUNTS":
-		inv.Command = &PrintAccounts{}
 		return nil
-	default:
-		return fmt.Errorf("unmarshal: unknown command type, %s", inv.RawCommand.Name)
 	}
 
-	return json.Unmarshal(rbs, inv.Command)
-}
-
-// AddBook represents the arguments for the ADD_BOOK command.
-type AddBook struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Count int    `json:"count"`
-}
-
-// AddCopies represents the arguments for the ADD_COPIES command.
-type AddCopies struct {
-	ID    int `json:"id"`
-	Count int `json:"count"`
-}
-
-// RemoveCopies represents the arguments for the REMOVE_COPIES command.
-type RemoveCopies struct {
-	ID    int `json:"id"`
-	Count int `json:"count"`
-}
-
-// CreateAccount represents the arguments for the CREATE_ACCOUNT command.
-type CreateAccount struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-}
-
-// CheckoutBook represents the arguments for the CHECKOUT_BOOK command.
-type CheckoutBook struct {
-	AccountID int `json:"accountId"`
-	BookID    int `json:"bookId"`
-}
+	inv.Command = c.factory()
 
-// ReturnBook represents the arguments for the RETURN_BOOK command.
-type ReturnBook struct {
-	AccountID int `json:"accountId"`
-	BookID    int `json:"bookId"`
+	return unmarshalArguments(inv.RawCommand.Arguments, inv.Command)
 }
-
-// PrintCatalog represents the arguments for the PRINT_CATALOG command.
-//
-// PrintCatlog has no arguments, but the type is required to implement the
-// implicit Command interface required by the Invocation.
-type PrintCatalog struct{}
-
-// PrintAccounts represents the arguments for the PRINT_ACCOUNTS command.
-//
-// PrintAccounts has no arguments, but the type is required to implement the
-// implicit Command interface required by the Invocation.
-type PrintAccounts struct{}
@@ -5,13 +5,35 @@
 package library
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLoanPeriod is the length of time a book may be checked out
+	// before it is due back.
+	DefaultLoanPeriod = 21 * 24 * time.Hour
+	// DefaultCheckoutLimit is the maximum number of books an account may
+	// have checked out at a time.
+	DefaultCheckoutLimit = 4
+	// DefaultFineRate is the fine in cents charged for each day a book is
+	// returned past its due date.
+	DefaultFineRate = 25
 )
 
 var (
@@ -21,6 +43,32 @@ var (
 	ErrAccountNotExist = errors.New("account does not exist")
 	// ErrCheckoutNotExist is returned when a checkout does not exist.
 	ErrCheckoutNotExist = errors.New("checkout does not exist")
+	// ErrDuplicateID is returned when a book or account with the same ID,
+	// or a macro with the same name, already exists.
+	ErrDuplicateID = errors.New("ID already exists")
+	// ErrLimitExceeded is returned when a checkout would exceed the
+	// checkout limit of an account.
+	ErrLimitExceeded = errors.New("checkout limit exceeded")
+	// ErrAlreadyCheckedOut is returned when an account checks out a book
+	// it already has checked out.
+	ErrAlreadyCheckedOut = errors.New("book already checked out")
+	// ErrNotEnoughCopies is returned when more copies of a book are
+	// removed than can be, or checked out than are available.
+	ErrNotEnoughCopies = errors.New("not enough copies")
+	// ErrInvalidArgument is returned when an argument is invalid, e.g. a
+	// negative count.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrInvalidCommand is returned when a command cannot be parsed.
+	ErrInvalidCommand = errors.New("invalid command")
+	// ErrIncludeCycle is returned when a command file includes itself,
+	// directly or indirectly.
+	ErrIncludeCycle = errors.New("include cycle")
+	// ErrNotConfirmed is returned when the execution of a destructive
+	// command is declined, see ImportOptions.Confirm.
+	ErrNotConfirmed = errors.New("not confirmed")
+	// ErrVersionMismatch is returned when a book or account is updated
+	// conditionally and has changed since the version, see UpdateBookIf.
+	ErrVersionMismatch = errors.New("version mismatch")
 )
 
 // Library represents a simple library system.
@@ -56,59 +104,254 @@ type Library struct {
 	// performance concern and, again, could even be faster than doing a
 	// nested map due to the constant factors.
 	checkoutsByBook map[int][]*Checkout
+
+	// history is the circulation history of the library, every checkout
+	// ever made in the order they were made, including the currently
+	// active checkouts.
+	//
+	// The history is kept in full rather than aggregated so that reports
+	// can be produced over arbitrary date ranges.
+	history []*Checkout
+
+	// holds are the holds on each book by ID, in the order of the hold
+	// queue of the book, see PlaceHold.
+	holds map[int][]*Hold
+
+	// clock provides the current time and is used to timestamp checkouts
+	// and returns, see SetClock.
+	clock Clock
+
+	// policy is the circulation policy of the library, see SetPolicy.
+	policy Policy
+
+	// quota limits the number of books and accounts, see SetQuota.
+	quota Quota
+
+	// inventory is the inventory audit in progress, nil if there is none.
+	inventory *inventory
+
+	// undo is the stack of inverse operations of the most recent
+	// mutations, see Undo.
+	undo []undoEntry
+	// undoSeq counts the undo entries ever recorded so that the entries
+	// recorded since a mark can be found after older entries are dropped.
+	undoSeq int
+	// marks counts the marks of the undo stack not yet collapsed or rolled
+	// back, while which the changes are held back, see markUndo, and held
+	// is the outermost of them.
+	marks int
+	held  undoMark
+	// version counts the mutations of the library, including those
+	// reverted by Undo, so that callers can tell whether a command
+	// changed the library.
+	version int
+
+	// macros are the user-defined macros by name and macroOrder is the
+	// order they were defined in, which is kept because a macro may only
+	// invoke macros defined before it.
+	macros     map[string]*Macro
+	macroOrder []string
+
+	// importing is the stack of the absolute paths of the command files
+	// being imported by ImportFile, used to detect include cycles.
+	importing []string
+
+	// wal is the write-ahead log the library was loaded from, nil if
+	// there is none, see Compact.
+	wal *WALStore
+
+	// imports is the stack of the options of the imports in progress, so
+	// that the imports of INCLUDE commands inherit them.
+	imports []ImportOptions
+
+	// changes are the commands that mutated the library since
+	// changesFrom, the version its state was last replaced at, and
+	// changed is the version as of the last change, see ExportSince.
+	changes     []Change
+	changesFrom int
+	changed     int
+	// watchers are the watchers of the changes, see Watch.
+	watchers map[*watcher]bool
+
+	// view is the view of the library as of its version, shared by the
+	// callers until the library changes, see View. It is set with the
+	// lock held only for reading, so it is an atomic.
+	view atomic.Pointer[View]
+
+	// logger is the logger of the library, slog.Default() if nil, see
+	// SetLogger.
+	logger *slog.Logger
+
+	// readOnly rejects the commands that may change the library, see
+	// SetReadOnly.
+	readOnly bool
+	// noFileAccess rejects the commands that read or write files, see
+	// SetFileAccess.
+	noFileAccess bool
+
+	// checkSnapshot is called with the snapshots before they are loaded,
+	// see SetSnapshotCheck.
+	checkSnapshot func(s *Snapshot)
+
+	// onEvent is called with the events of each change, see
+	// WithEventHandler.
+	onEvent func(event Event)
+	// available are the holds a copy became available for since the last
+	// change was recorded, see Change.Available.
+	available []Hold
 }
 
 // Account represents a library account.
 type Account struct {
-	ID   int    // Unique identifier for the account.
-	Name string // Name of the account holder, not required to be unique.
+	ID   int    `json:"id"`   // Unique identifier for the account.
+	Name string `json:"name"` // Name of the account holder, not required to be unique.
+	// Balance of the account in cents. Credit added to the account
+	// increases the balance and fines are deducted from it, so a negative
+	// balance is the amount of fines owed.
+	Balance int `json:"balance"`
+	// Version of the account, 1 when it is created and increased with every
+	// change to it, including its checkouts and returns, see
+	// UpdateAccountIf.
+	Version int `json:"version,omitempty"`
 }
 
 // Book represents a book in the library catalog.
 type Book struct {
-	ID    int    // Unique identifier for the book.
-	Name  string // Name of the book, not required to be unique.
-	Count int    // Number of copies of the book available in the library.
+	ID    int    `json:"id"`    // Unique identifier for the book.
+	Name  string `json:"name"`  // Name of the book, not required to be unique.
+	Count int    `json:"count"` // Number of copies of the book available in the library.
+	// Version of the book, 1 when it is added and increased with every
+	// change to it, including its checkouts and returns, see UpdateBookIf.
+	Version int `json:"version,omitempty"`
+
+	BookMetadata
+}
+
+// BookMetadata represents the optional descriptive metadata of a book.
+type BookMetadata struct {
+	Author string   `json:"author,omitempty"` // Author of the book.
+	ISBN   string   `json:"isbn,omitempty"`   // ISBN of the book.
+	Tags   []string `json:"tags,omitempty"`   // Tags for the book, e.g. genres or reading levels.
 }
 
 // Checkout represents a book checkout by an account.
 type Checkout struct {
-	BookID    int // ID of the book being checked out.
-	AccountID int // ID of the account checking out the book.
+	BookID     int       `json:"bookId"`     // ID of the book being checked out.
+	AccountID  int       `json:"accountId"`  // ID of the account checking out the book.
+	CheckedOut time.Time `json:"checkedOut"` // Time the book was checked out.
+	Due        time.Time `json:"due"`        // Time the book is due back.
+	Returned   time.Time `json:"returned"`   // Time the book was returned, zero while checked out.
+}
+
+// Overdue reports whether the checkout is past due as of the provided time.
+//
+// A checkout that has been returned is never overdue.
+func (c *Checkout) Overdue(now time.Time) bool {
+	return c.Returned.IsZero() && now.After(c.Due)
+}
+
+// BookCirculation represents the number of times a book was checked out.
+type BookCirculation struct {
+	BookID    int // ID of the book.
+	Checkouts int // Number of times the book was checked out.
 }
 
-// New creates a new library system.
-func New() *Library {
-	return &Library{
+// New creates a new library system configured by the options, e.g.:
+//
+//	l := library.New(library.WithCheckoutLimit(6), library.WithLogger(logger))
+//
+// Without options, the library has the DefaultPolicy, a real clock, the
+// default logger and no quota. New panics if an option is invalid, e.g. a
+// checkout limit that is not positive, since the options are set by the
+// program rather than its input; see SetPolicy and SetQuota to set them from
+// input.
+func New(opts ...Option) *Library {
+	l := &Library{
 		books:              make(map[int]*Book),
 		accounts:           make(map[int]*Account),
 		checkoutsByAccount: make(map[int][]*Checkout),
 		checkoutsByBook:    make(map[int][]*Checkout),
+		holds:              make(map[int][]*Hold),
+		clock:              realClock{},
+		policy:             DefaultPolicy(),
+		macros:             make(map[string]*Macro),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if err := validatePolicy(l.policy); err != nil {
+		panic(fmt.Errorf("invalid option, %w", err))
+	}
+
+	if err := validateQuota(l.quota); err != nil {
+		panic(fmt.Errorf("invalid option, %w", err))
 	}
+
+	return l
 }
 
 // AddBook adds a book to the library catalog.
 //
 // If a book with the provided ID already exists, an error is returned. The
-// count must be non-negative.
+// count must be non-negative. If the library has as many books as its quota
+// allows, see SetQuota, an error wrapping ErrQuotaExceeded is returned.
 func (l *Library) AddBook(id int, name string, count int) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	if _, ok := l.books[id]; ok {
-		return fmt.Errorf("book already exists")
+		return &DuplicateError{Kind: KindBook, ID: id}
 	}
 
 	if count < 0 {
-		return fmt.Errorf("cannot add negative copies")
+		return fmt.Errorf("%w, cannot add negative copies", ErrInvalidArgument)
+	}
+
+	if err := l.checkBookQuota(); err != nil {
+		return err
 	}
 
 	l.books[id] = &Book{
-		ID:    id,
-		Name:  name,
-		Count: count,
+		ID:      id,
+		Name:    name,
+		Count:   count,
+		Version: 1,
+	}
+
+	l.pushUndo(fmt.Sprintf("add %s (%d) to the catalog", name, id), func() {
+		delete(l.books, id)
+	})
+
+	return nil
+}
+
+// SetBookMetadata sets the descriptive metadata of an existing book in the
+// library catalog, replacing any existing metadata.
+//
+// If a book with the provided ID does not exist, an error is returned.
+func (l *Library) SetBookMetadata(id int, meta BookMetadata) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	book, ok := l.books[id]
+	if !ok {
+		return &NotExistError{Kind: KindBook, ID: id}
 	}
 
+	meta.Tags = slices.Clone(meta.Tags)
+
+	prev := book.BookMetadata
+
+	book.BookMetadata = meta
+	book.Version++
+
+	l.pushUndo(fmt.Sprintf("set metadata of %s (%d)", book.Name, book.ID), func() {
+		book.BookMetadata = prev
+		book.Version++
+	})
+
 	return nil
 }
 
@@ -122,14 +365,25 @@ func (l *Library) AddCopies(id, count int) error {
 
 	book, ok := l.books[id]
 	if !ok {
-		return ErrBookNotExist
+		return &NotExistError{Kind: KindBook, ID: id}
 	}
 
 	if count < 0 {
-		return fmt.Errorf("cannot add negative copies")
+		return fmt.Errorf("%w, cannot add negative copies", ErrInvalidArgument)
 	}
 
+	now := l.clock.Now()
+	ready := l.readyHolds(book, now)
+
 	book.Count += count
+	book.Version++
+
+	l.noteReadyHolds(book, now, ready)
+
+	l.pushUndo(fmt.Sprintf("add %d copies of %s (%d)", count, book.Name, book.ID), func() {
+		book.Count -= count
+		book.Version++
+	})
 
 	return nil
 }
@@ -145,85 +399,198 @@ func (l *Library) RemoveCopies(id, count int) error {
 
 	book, ok := l.books[id]
 	if !ok {
-		return ErrBookNotExist
+		return &NotExistError{Kind: KindBook, ID: id}
 	}
 
 	if count < 0 {
-		return fmt.Errorf("cannot remove negative copies")
+		return fmt.Errorf("%w, cannot remove negative copies", ErrInvalidArgument)
 	}
 
 	if book.Count < count {
-		return fmt.Errorf("cannot remove more copies than exist")
+		return &NotEnoughCopiesError{
+			BookID:    book.ID,
+			Available: book.Count,
+			detail:    fmt.Sprintf("cannot remove more copies of %s (%d) than exist (%d)", book.Name, book.ID, book.Count),
+		}
 	}
 
 	available := book.Count - len(l.checkoutsByBook[book.ID])
 	if available < count {
-		return fmt.Errorf("cannot remove more copies of %s (%d) than are available to check out (%d)", book.Name, book.ID, available)
+		return &NotEnoughCopiesError{
+			BookID:    book.ID,
+			Available: available,
+			detail:    fmt.Sprintf("cannot remove more copies of %s (%d) than are available to check out (%d)", book.Name, book.ID, available),
+		}
 	}
 
 	book.Count -= count
+	book.Version++
+
+	l.pushUndo(fmt.Sprintf("remove %d copies of %s (%d)", count, book.Name, book.ID), func() {
+		book.Count += count
+		book.Version++
+	})
 
 	return nil
 }
 
 // CreateAccount creates a new account in the library system.
 //
-// If an account with the provided ID already exists, an error is returned.
+// If an account with the provided ID already exists, an error is returned. If
+// the library has as many accounts as its quota allows, see SetQuota, an error
+// wrapping ErrQuotaExceeded is returned.
 func (l *Library) CreateAccount(id int, name string) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	if _, ok := l.accounts[id]; ok {
-		return fmt.Errorf("account already exists")
+		return &DuplicateError{Kind: KindAccount, ID: id}
+	}
+
+	if err := l.checkAccountQuota(); err != nil {
+		return err
 	}
 
 	l.accounts[id] = &Account{
-		ID:   id,
-		Name: name,
+		ID:      id,
+		Name:    name,
+		Version: 1,
 	}
 
+	l.pushUndo(fmt.Sprintf("create account %s (%d)", name, id), func() {
+		delete(l.accounts, id)
+	})
+
 	return nil
 }
 
 // CheckoutBook checks out a book to an account.
 //
 // If the account or book does not exist, an error is returned.
-// If the account already has 4 books checked out currently, an error is returned.
+// If the account already has as many books checked out currently as the
+// checkout limit of the policy allows, see SetPolicy, an error is returned.
 // If the account already has a copy of the book checked out currently, an
-// error is returned.
+// error is returned. If every copy of the book is checked out, or the copies
+// that are not are all held for the accounts ahead of the account in the hold
+// queue of the book, see PlaceHold, an error wrapping ErrNotEnoughCopies is
+// returned.
 func (l *Library) CheckoutBook(accountID, bookID int) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	return l.checkoutBook(accountID, bookID, l.clock.Now(), time.Time{})
+}
+
+// CheckoutBookAt checks out a book to an account as of the provided time.
+//
+// CheckoutBookAt behaves the same as CheckoutBook and exists to allow
+// restoring checkouts with their original checkout time.
+func (l *Library) CheckoutBookAt(accountID, bookID int, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.checkoutBook(accountID, bookID, at, time.Time{})
+}
+
+// CheckoutBookUntil checks out a book to an account as of the provided time
+// and due back at the provided due time rather than after the loan period of
+// the policy.
+//
+// CheckoutBookUntil exists to allow restoring checkouts made under a different
+// policy with their original due time, so it behaves the same as CheckoutBook
+// except that the checkout limit and hold queue are not enforced.
+func (l *Library) CheckoutBookUntil(accountID, bookID int, at, due time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.checkoutBook(accountID, bookID, at, due)
+}
+
+// checkoutBook checks out a book to an account as of the provided time. If
+// due is zero, the book is due after the loan period of the policy and the
+// checkout limit and hold queue are enforced, otherwise the checkout is being
+// restored.
+//
+// checkoutBook must be called with the lock held.
+func (l *Library) checkoutBook(accountID, bookID int, at, due time.Time) error {
 	account, ok := l.accounts[accountID]
 	if !ok {
-		return ErrAccountNotExist
+		return &NotExistError{Kind: KindAccount, ID: accountID}
 	}
 
 	book, ok := l.books[bookID]
 	if !ok {
-		return ErrBookNotExist
+		return &NotExistError{Kind: KindBook, ID: bookID}
 	}
 
 	checkouts := l.checkoutsByAccount[account.ID]
 
-	if len(checkouts) >= 4 {
-		return fmt.Errorf("%s (%d) cannot checkout more than 4 books at a time", account.Name, account.ID)
+	if due.IsZero() && len(checkouts) >= l.policy.CheckoutLimit {
+		return &LimitError{
+			AccountID: account.ID,
+			Limit:     l.policy.CheckoutLimit,
+			detail:    fmt.Sprintf("%s (%d) cannot checkout more than %d books at a time", account.Name, account.ID, l.policy.CheckoutLimit),
+		}
 	}
 
 	for _, checkout := range checkouts {
 		if checkout.AccountID == account.ID && checkout.BookID == book.ID {
-			return fmt.Errorf("%s (%d) cannot checkout more than one copy of %s (%d)", account.Name, account.ID, book.Name, book.ID)
+			return alreadyCheckedOut(account, book)
+		}
+	}
+
+	if err := l.checkAvailable(account, book, 0); err != nil {
+		return err
+	}
+
+	if due.IsZero() {
+		if err := l.checkHolds(account, book, at, 0, nil); err != nil {
+			return err
 		}
 	}
 
 	checkout := &Checkout{
-		AccountID: account.ID,
-		BookID:    book.ID,
+		AccountID:  account.ID,
+		BookID:     book.ID,
+		CheckedOut: at,
+		Due:        due,
+	}
+
+	if due.IsZero() {
+		checkout.Due = at.Add(l.policy.LoanPeriod)
 	}
 
 	l.checkoutsByAccount[account.ID] = append(l.checkoutsByAccount[account.ID], checkout)
 	l.checkoutsByBook[book.ID] = append(l.checkoutsByBook[book.ID], checkout)
+	l.history = append(l.history, checkout)
+
+	book.Version++
+	account.Version++
+
+	restoreHold := l.fulfillHold(account.ID, book.ID)
+
+	l.pushUndo(fmt.Sprintf("checkout of %s (%d) by %s (%d)", book.Name, book.ID, account.Name, account.ID), func() {
+		l.uncheckout(checkout)
+		book.Version++
+		account.Version++
+		restoreHold()
+	})
+
+	return nil
+}
+
+// checkAvailable returns an error if every copy of a book is checked out,
+// counting taken copies being checked out along with it.
+//
+// checkAvailable must be called with the lock held.
+func (l *Library) checkAvailable(account *Account, book *Book, taken int) error {
+	if available := book.Count - len(l.checkoutsByBook[book.ID]) - taken; available <= 0 {
+		return &NotEnoughCopiesError{
+			BookID:    book.ID,
+			Available: 0,
+			detail:    fmt.Sprintf("%s (%d) cannot check out %s (%d), every copy is checked out", account.Name, account.ID, book.Name, book.ID),
+		}
+	}
 
 	return nil
 }
@@ -236,174 +603,1574 @@ func (l *Library) ReturnBook(accountID, bookID int) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	return l.returnBook(accountID, bookID, l.clock.Now())
+}
+
+// ReturnBookAt returns a book to the library as of the provided time.
+//
+// ReturnBookAt behaves the same as ReturnBook and exists to allow replaying a
+// return with its original time, e.g. from an export or write-ahead log.
+func (l *Library) ReturnBookAt(accountID, bookID int, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.returnBook(accountID, bookID, at)
+}
+
+// returnBook returns a book to the library as of the provided time.
+//
+// returnBook must be called with the lock held.
+func (l *Library) returnBook(accountID, bookID int, at time.Time) error {
 	account, ok := l.accounts[accountID]
 	if !ok {
-		return ErrAccountNotExist
+		return &NotExistError{Kind: KindAccount, ID: accountID}
 	}
 
 	book, ok := l.books[bookID]
 	if !ok {
-		return ErrBookNotExist
+		return &NotExistError{Kind: KindBook, ID: bookID}
 	}
 
 	matchCheckout := func(checkout *Checkout) bool {
 		return checkout.AccountID == account.ID && checkout.BookID == book.ID
 	}
 
-	if !slices.ContainsFunc(l.checkoutsByAccount[account.ID], matchCheckout) {
-		return ErrCheckoutNotExist
+	i := slices.IndexFunc(l.checkoutsByAccount[account.ID], matchCheckout)
+	if i < 0 {
+		return &NotExistError{Kind: KindCheckout, ID: book.ID, AccountID: account.ID}
 	}
 
+	ready := l.readyHolds(book, at)
+
+	// The checkout remains in the history, only the active indexes are
+	// updated.
+	checkout := l.checkoutsByAccount[account.ID][i]
+	checkout.Returned = at
+
+	fine := l.fine(checkout)
+	account.Balance -= fine
+
+	book.Version++
+	account.Version++
+
 	l.checkoutsByAccount[account.ID] = slices.DeleteFunc(l.checkoutsByAccount[account.ID], matchCheckout)
 	l.checkoutsByBook[book.ID] = slices.DeleteFunc(l.checkoutsByBook[book.ID], matchCheckout)
 
+	l.noteReadyHolds(book, at, ready)
+
+	l.pushUndo(fmt.Sprintf("return of %s (%d) by %s (%d)", book.Name, book.ID, account.Name, account.ID), func() {
+		l.unreturn(checkout, fine)
+	})
+
 	return nil
 }
 
-// Account returns an account by ID.
-func (l *Library) Account(id int) *Account {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// BulkCheckoutOptions provides options for checking out books in bulk.
+type BulkCheckoutOptions struct {
+	// Limit overrides the maximum number of books the account may have
+	// checked out at a time, including the books being checked out, e.g.
+	// to allow a teacher to borrow a class set. The checkout limit of the
+	// policy is used if Limit is not positive.
+	Limit int
+}
+
+// BulkCheckout checks out multiple books to an account atomically, either
+// all of the books are checked out or none of them are.
+//
+// The same rules as CheckoutBook apply to each book, except that the
+// per-account limit may be overridden. A book may not be listed more than
+// once.
+func (l *Library) BulkCheckout(accountID int, bookIDs []int, opts BulkCheckoutOptions) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bulkCheckout(accountID, bookIDs, opts, l.clock.Now())
+}
+
+// BulkCheckoutAt checks out multiple books to an account atomically as of the
+// provided time, in the same way as ReturnBookAt.
+func (l *Library) BulkCheckoutAt(accountID int, bookIDs []int, opts BulkCheckoutOptions, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	return l.accounts[id]
+	return l.bulkCheckout(accountID, bookIDs, opts, at)
 }
 
-// EachBook calls the provided function for each book in the library.
+// bulkCheckout checks out multiple books to an account atomically as of the
+// provided time.
 //
-// The function exists to allow thread-safe iteration of the books in the
-// library.
-func (l *Library) EachBook(fn func(book *Book)) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// bulkCheckout must be called with the lock held.
+func (l *Library) bulkCheckout(accountID int, bookIDs []int, opts BulkCheckoutOptions, now time.Time) error {
+	account, ok := l.accounts[accountID]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: accountID}
+	}
 
-	for _, book := range l.books {
-		fn(book)
+	limit := l.policy.CheckoutLimit
+	if opts.Limit > 0 {
+		limit = opts.Limit
+	}
+
+	checkouts := l.checkoutsByAccount[account.ID]
+
+	if len(checkouts)+len(bookIDs) > limit {
+		return &LimitError{
+			AccountID: account.ID,
+			Limit:     limit,
+			detail:    fmt.Sprintf("%s (%d) cannot checkout more than %d books at a time", account.Name, account.ID, limit),
+		}
+	}
+
+	// Validate every book before checking any out so that a failure leaves
+	// the library unchanged.
+	seen := make(map[int]bool, len(bookIDs))
+
+	for _, bookID := range bookIDs {
+		book, ok := l.books[bookID]
+		if !ok {
+			return &NotExistError{Kind: KindBook, ID: bookID}
+		}
+
+		if seen[book.ID] || slices.ContainsFunc(checkouts, func(checkout *Checkout) bool { return checkout.BookID == book.ID }) {
+			return alreadyCheckedOut(account, book)
+		}
+
+		if err := l.checkAvailable(account, book, 0); err != nil {
+			return err
+		}
+
+		if err := l.checkHolds(account, book, now, 0, nil); err != nil {
+			return err
+		}
+
+		seen[book.ID] = true
+	}
+
+	var (
+		added        []*Checkout
+		restoreHolds []func()
+	)
+
+	for _, bookID := range bookIDs {
+		checkout := &Checkout{
+			AccountID:  account.ID,
+			BookID:     bookID,
+			CheckedOut: now,
+			Due:        now.Add(l.policy.LoanPeriod),
+		}
+
+		l.checkoutsByAccount[account.ID] = append(l.checkoutsByAccount[account.ID], checkout)
+		l.checkoutsByBook[bookID] = append(l.checkoutsByBook[bookID], checkout)
+		l.history = append(l.history, checkout)
+
+		l.books[bookID].Version++
+		account.Version++
+
+		added = append(added, checkout)
+		restoreHolds = append(restoreHolds, l.fulfillHold(account.ID, bookID))
 	}
+
+	l.pushUndo(fmt.Sprintf("checkout of %d books by %s (%d)", len(added), account.Name, account.ID), func() {
+		for _, checkout := range added {
+			l.uncheckout(checkout)
+			l.books[checkout.BookID].Version++
+			account.Version++
+		}
+
+		// The holds are restored in the reverse order they were
+		// fulfilled, so that each is restored in its place.
+		for _, restore := range slices.Backward(restoreHolds) {
+			restore()
+		}
+	})
+
+	return nil
 }
 
-// EachAccount calls the provided function for each account in the library.
+// BulkReturn returns multiple books checked out by an account atomically,
+// either all of the books are returned or none of them are.
 //
-// The function exists to allow thread-safe iteration of the accounts in the
-// library.
-func (l *Library) EachAccount(fn func(account *Account)) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// The same rules as ReturnBook apply to each book.
+func (l *Library) BulkReturn(accountID int, bookIDs []int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	for _, account := range l.accounts {
-		fn(account)
+	return l.bulkReturn(accountID, bookIDs, l.clock.Now())
+}
+
+// BulkReturnAt returns multiple books checked out by an account atomically as
+// of the provided time, in the same way as ReturnBookAt.
+func (l *Library) BulkReturnAt(accountID int, bookIDs []int, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bulkReturn(accountID, bookIDs, at)
+}
+
+// bulkReturn returns multiple books checked out by an account atomically as
+// of the provided time.
+//
+// bulkReturn must be called with the lock held.
+func (l *Library) bulkReturn(accountID int, bookIDs []int, now time.Time) error {
+	account, ok := l.accounts[accountID]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: accountID}
+	}
+
+	// Validate every book before returning any so that a failure leaves
+	// the library unchanged.
+	for _, bookID := range bookIDs {
+		book, ok := l.books[bookID]
+		if !ok {
+			return &NotExistError{Kind: KindBook, ID: bookID}
+		}
+
+		if !slices.ContainsFunc(l.checkoutsByAccount[account.ID], func(checkout *Checkout) bool { return checkout.BookID == book.ID }) {
+			return &NotExistError{Kind: KindCheckout, ID: book.ID, AccountID: account.ID, detail: fmt.Sprintf("%s (%d)", book.Name, book.ID)}
+		}
+	}
+
+	var (
+		returned []*Checkout
+		fines    []int
+	)
+
+	for _, bookID := range bookIDs {
+		matchCheckout := func(checkout *Checkout) bool {
+			return checkout.AccountID == account.ID && checkout.BookID == bookID
+		}
+
+		// Books listed more than once are only returned once.
+		i := slices.IndexFunc(l.checkoutsByAccount[account.ID], matchCheckout)
+		if i < 0 {
+			continue
+		}
+
+		book := l.books[bookID]
+		ready := l.readyHolds(book, now)
+
+		checkout := l.checkoutsByAccount[account.ID][i]
+		checkout.Returned = now
+
+		fine := l.fine(checkout)
+		account.Balance -= fine
+
+		book.Version++
+		account.Version++
+
+		l.checkoutsByAccount[account.ID] = slices.DeleteFunc(l.checkoutsByAccount[account.ID], matchCheckout)
+		l.checkoutsByBook[bookID] = slices.DeleteFunc(l.checkoutsByBook[bookID], matchCheckout)
+
+		l.noteReadyHolds(book, now, ready)
+
+		returned = append(returned, checkout)
+		fines = append(fines, fine)
 	}
+
+	l.pushUndo(fmt.Sprintf("return of %d books by %s (%d)", len(returned), account.Name, account.ID), func() {
+		for i, checkout := range returned {
+			l.unreturn(checkout, fines[i])
+		}
+	})
+
+	return nil
 }
 
-// Book returns a book by ID.
-func (l *Library) Book(id int) *Book {
+// fine returns the fine in cents for a returned checkout, charged for each day
+// or part of a day the book was returned past its due date.
+func (l *Library) fine(checkout *Checkout) int {
+	return daysLate(checkout.Due, checkout.Returned) * l.policy.FineRate
+}
+
+// Fine returns the fine in cents for a checkout at the fine rate of the
+// policy, the fine charged if it was returned late, or the fine accrued so far
+// if it is still checked out and overdue.
+func (l *Library) Fine(checkout *Checkout) int {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	return l.books[id]
+	at := checkout.Returned
+	if at.IsZero() {
+		at = l.clock.Now()
+	}
+
+	return daysLate(checkout.Due, at) * l.policy.FineRate
 }
 
-// CheckoutsByAccount returns the checkouts for an account by ID.
-func (l *Library) CheckoutsByAccount(id int) []*Checkout {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// daysLate returns the number of days, counting any part of a day as a whole
+// day, that at is past due.
+func daysLate(due, at time.Time) int {
+	late := at.Sub(due)
+	if late <= 0 {
+		return 0
+	}
 
-	return l.checkoutsByAccount[id]
+	return int((late + 24*time.Hour - 1) / (24 * time.Hour))
 }
 
-// CheckoutsByBook returns the checkouts for a book by ID.
-func (l *Library) CheckoutsByBook(id int) []*Checkout {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// OverdueCheckout represents an active checkout that is past due.
+type OverdueCheckout struct {
+	*Checkout
 
-	return l.checkoutsByBook[id]
+	Days int // Number of days, counting any part of a day, the checkout is overdue.
+	Fine int // Fine in cents accrued so far, charged when the book is returned.
 }
 
-// Export writes the library state to a writer in JSON format.
+// OverdueCheckouts returns the active checkouts that are past due as of the
+// current time, ordered from most to least days overdue.
 //
-// Export uses the same format as Import to allow for round-trip serialization
-// and persistence across invocations.
-func (l *Library) Export(w io.Writer) error {
+// Checkouts overdue by the same number of days are ordered by the time they
+// were made.
+func (l *Library) OverdueCheckouts() []OverdueCheckout {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	enc := json.NewEncoder(w)
+	now := l.clock.Now()
 
-	for _, book := range l.books {
-		inv := Invocation{
-			Command: &AddBook{
-				ID:    book.ID,
-				Name:  book.Name,
-				Count: book.Count,
-			},
-		}
+	var overdue []OverdueCheckout
 
-		if err := enc.Encode(&inv); err != nil {
-			return fmt.Errorf("failed to write library state, %w", err)
+	for _, checkout := range l.history {
+		if !checkout.Overdue(now) {
+			continue
 		}
-	}
 
-	for _, account := range l.accounts {
-		inv := Invocation{
-			Command: &CreateAccount{
-				ID:   account.ID,
-				Name: account.Name,
-			},
-		}
+		days := daysLate(checkout.Due, now)
 
-		if err := enc.Encode(&inv); err != nil {
-			return fmt.Errorf("failed to write library state, %w", err)
-		}
+		overdue = append(overdue, OverdueCheckout{
+			Checkout: checkout,
+			Days:     days,
+			Fine:     days * l.policy.FineRate,
+		})
 	}
 
-	for _, checkouts := range l.checkoutsByAccount {
-		for _, checkout := range checkouts {
-			inv := Invocation{
-				Command: &CheckoutBook{
-					AccountID: checkout.AccountID,
-					BookID:    checkout.BookID,
-				},
-			}
+	slices.SortStableFunc(overdue, func(a, b OverdueCheckout) int {
+		return cmp.Compare(b.Days, a.Days)
+	})
 
-			if err := enc.Encode(&inv); err != nil {
-				return fmt.Errorf("failed to write library state, %w", err)
-			}
-		}
+	return overdue
+}
+
+// AddCredit adds credit in cents to the balance of an account.
+//
+// Fines are deducted from the balance as they are charged, so credit pays any
+// outstanding fines first. If the account does not exist, an error is
+// returned. The amount must be positive.
+func (l *Library) AddCredit(id, amount int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account, ok := l.accounts[id]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: id}
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("%w, cannot add non-positive credit", ErrInvalidArgument)
 	}
 
+	account.Balance += amount
+	account.Version++
+
+	l.pushUndo(fmt.Sprintf("add credit to %s (%d)", account.Name, account.ID), func() {
+		account.Balance -= amount
+		account.Version++
+	})
+
 	return nil
 }
 
-// ImportOptions provides options for importing library state.
-type ImportOptions struct {
-	// LogOutput indicates whether to log the output of each invocation to stdout.
-	//
-	// This is used to avoid logging output when loading initial library
-	// state, but allow for logging output when executing the user
-	// commands.
-	LogOutput bool
-}
+// SetBalance sets the balance in cents of an account.
+//
+// SetBalance exists to allow restoring the balance of an account from exports
+// and for manual corrections. If the account does not exist, an error is
+// returned.
+func (l *Library) SetBalance(id, balance int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-// Import reads the library state from a reader in JSON format.
-func (l *Library) Import(r io.Reader, opts ImportOptions) error {
-	dec := json.NewDecoder(r)
+	account, ok := l.accounts[id]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: id}
+	}
 
-	for {
-		var inv Invocation
+	prev := account.Balance
 
-		if err := dec.Decode(&inv); errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return fmt.Errorf("failed to read library state, %w", err)
-		}
+	account.Balance = balance
+	account.Version++
 
-		err := inv.Exec(l)
+	l.pushUndo(fmt.Sprintf("set balance of %s (%d)", account.Name, account.ID), func() {
+		account.Balance = prev
+		account.Version++
+	})
 
-		if opts.LogOutput {
-			fmt.Fprintf(os.Stdout, "%s\n", inv.Output)
-		}
+	return nil
+}
 
-		if err != nil {
-			return err
-		}
-	}
+// AddHistory records a past checkout of a book by an account that has
+// already been returned.
+//
+// The history record does not affect the available copies of the book or the
+// checkout limit of the account, it is only used for reporting.
+//
+// If the account or book does not exist, an error is returned. The return
+// time must not be before the checkout time.
+func (l *Library) AddHistory(accountID, bookID int, checkedOut, returned time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.accounts[accountID]; !ok {
+		return &NotExistError{Kind: KindAccount, ID: accountID}
+	}
+
+	if _, ok := l.books[bookID]; !ok {
+		return &NotExistError{Kind: KindBook, ID: bookID}
+	}
+
+	if returned.Before(checkedOut) {
+		return fmt.Errorf("%w, cannot return a book before it was checked out", ErrInvalidArgument)
+	}
+
+	checkout := &Checkout{
+		AccountID:  accountID,
+		BookID:     bookID,
+		CheckedOut: checkedOut,
+		Due:        checkedOut.Add(l.policy.LoanPeriod),
+		Returned:   returned,
+	}
+
+	l.history = append(l.history, checkout)
+
+	l.pushUndo(fmt.Sprintf("add history of book (%d) for account (%d)", bookID, accountID), func() {
+		l.uncheckout(checkout)
+	})
+
+	return nil
+}
+
+// TopBooks returns up to n books with the most checkouts made within the
+// provided time range, ordered from most to least checkouts.
+//
+// The range includes checkouts made at or after from and before to. A zero
+// from or to leaves that side of the range unbounded. Books with the same
+// number of checkouts are ordered by ID. A non-positive n returns all books
+// with at least one checkout in the range.
+func (l *Library) TopBooks(n int, from, to time.Time) []BookCirculation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	counts := make(map[int]int)
+
+	for _, checkout := range l.history {
+		if !from.IsZero() && checkout.CheckedOut.Before(from) {
+			continue
+		}
+
+		if !to.IsZero() && !checkout.CheckedOut.Before(to) {
+			continue
+		}
+
+		counts[checkout.BookID]++
+	}
+
+	top := make([]BookCirculation, 0, len(counts))
+
+	for id, count := range counts {
+		top = append(top, BookCirculation{BookID: id, Checkouts: count})
+	}
+
+	slices.SortFunc(top, func(a, b BookCirculation) int {
+		if c := cmp.Compare(b.Checkouts, a.Checkouts); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.BookID, b.BookID)
+	})
+
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// HistoryByAccount returns copies of every checkout made by an account by ID,
+// including the currently active checkouts, in the order they were made.
+func (l *Library) HistoryByAccount(id int) []*Checkout {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var history []*Checkout
+
+	for _, checkout := range l.history {
+		if checkout.AccountID == id {
+			history = append(history, checkout.clone())
+		}
+	}
+
+	return history
+}
+
+// HistoryByBook returns copies of every checkout of a book by ID, including
+// the currently active checkouts, in the order they were made.
+func (l *Library) HistoryByBook(id int) []*Checkout {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var history []*Checkout
+
+	for _, checkout := range l.history {
+		if checkout.BookID == id {
+			history = append(history, checkout.clone())
+		}
+	}
+
+	return history
+}
+
+// Now returns the current time according to the library clock.
+func (l *Library) Now() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.clock.Now()
+}
+
+// SetClock sets the clock the library uses for the current time, e.g. a
+// SimulatedClock so that time can be advanced without waiting.
+func (l *Library) SetClock(clock Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.clock = clock
+}
+
+// SetLogger sets the logger the library logs to, e.g. the commands it
+// executes at the debug level, slog.Default() if nil.
+func (l *Library) SetLogger(logger *slog.Logger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.logger = logger
+}
+
+// log returns the logger of the library, see SetLogger.
+func (l *Library) log() *slog.Logger {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.logger == nil {
+		return slog.Default()
+	}
+
+	return l.logger
+}
+
+// Sleep waits for the duration to pass according to the library clock.
+//
+// The lock is not held while waiting so that the library remains usable.
+func (l *Library) Sleep(d time.Duration) {
+	l.mu.RLock()
+	clock := l.clock
+	l.mu.RUnlock()
+
+	clock.Sleep(d)
+}
+
+// Account returns a copy of an account by ID, nil if it does not exist.
+// Changing the copy does not change the library.
+func (l *Library) Account(id int) *Account {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.accounts[id].clone()
+}
+
+// EachBook calls the provided function with a copy of each book in the
+// library.
+//
+// The function exists to allow thread-safe iteration of the books in the
+// library.
+func (l *Library) EachBook(fn func(book *Book)) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, book := range l.books {
+		fn(book.clone())
+	}
+}
+
+// EachAccount calls the provided function with a copy of each account in the
+// library.
+//
+// The function exists to allow thread-safe iteration of the accounts in the
+// library.
+func (l *Library) EachAccount(fn func(account *Account)) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, account := range l.accounts {
+		fn(account.clone())
+	}
+}
+
+// AllCheckouts calls the provided function with a copy of each active checkout
+// in the library in the order the checkouts were made.
+//
+// The function exists to allow thread-safe iteration of the checkouts in the
+// library.
+func (l *Library) AllCheckouts(fn func(checkout *Checkout)) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, checkout := range l.history {
+		if checkout.Returned.IsZero() {
+			fn(checkout.clone())
+		}
+	}
+}
+
+// Books returns an iterator over copies of the books in the library in the
+// order of their IDs, e.g.:
+//
+//	for book := range l.Books() {
+//		fmt.Println(book.Name)
+//	}
+//
+// The books are collected when the iteration starts, so, unlike EachBook, the
+// loop may call the methods of the library, including those that change it,
+// and stop at any time.
+func (l *Library) Books() iter.Seq[*Book] {
+	return func(yield func(*Book) bool) {
+		l.mu.RLock()
+		books := cloneAll(l.sortedBooks())
+		l.mu.RUnlock()
+
+		for _, book := range books {
+			if !yield(book) {
+				return
+			}
+		}
+	}
+}
+
+// Accounts returns an iterator over copies of the accounts in the library in
+// the order of their IDs, in the same way as Books.
+func (l *Library) Accounts() iter.Seq[*Account] {
+	return func(yield func(*Account) bool) {
+		l.mu.RLock()
+		accounts := cloneAll(l.sortedAccounts())
+		l.mu.RUnlock()
+
+		for _, account := range accounts {
+			if !yield(account) {
+				return
+			}
+		}
+	}
+}
+
+// Checkouts returns an iterator over copies of the active checkouts in the
+// library in the order the checkouts were made, in the same way as Books.
+func (l *Library) Checkouts() iter.Seq[*Checkout] {
+	return func(yield func(*Checkout) bool) {
+		l.mu.RLock()
+		checkouts := make([]*Checkout, 0, len(l.history))
+
+		for _, checkout := range l.history {
+			if checkout.Returned.IsZero() {
+				checkouts = append(checkouts, checkout.clone())
+			}
+		}
+		l.mu.RUnlock()
+
+		for _, checkout := range checkouts {
+			if !yield(checkout) {
+				return
+			}
+		}
+	}
+}
+
+// Book returns a copy of a book by ID, nil if it does not exist. Changing the
+// copy does not change the library.
+func (l *Library) Book(id int) *Book {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.books[id].clone()
+}
+
+// CheckoutsByAccount returns copies of the active checkouts for an account by
+// ID.
+func (l *Library) CheckoutsByAccount(id int) []*Checkout {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return cloneAll(l.checkoutsByAccount[id])
+}
+
+// CheckoutsByBook returns copies of the active checkouts for a book by ID.
+func (l *Library) CheckoutsByBook(id int) []*Checkout {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return cloneAll(l.checkoutsByBook[id])
+}
+
+// clone returns a copy of the book that shares no state with it, nil for nil.
+func (b *Book) clone() *Book {
+	if b == nil {
+		return nil
+	}
+
+	c := *b
+	c.Tags = slices.Clone(b.Tags)
+
+	return &c
+}
+
+// clone returns a copy of the account, nil for nil.
+func (a *Account) clone() *Account {
+	if a == nil {
+		return nil
+	}
+
+	c := *a
+
+	return &c
+}
+
+// clone returns a copy of the checkout, nil for nil.
+func (c *Checkout) clone() *Checkout {
+	if c == nil {
+		return nil
+	}
+
+	cc := *c
+
+	return &cc
+}
+
+// cloneAll returns copies of the books, accounts or checkouts, nil for none,
+// so that the state of the library is not shared with the callers of its
+// accessors.
+func cloneAll[T interface{ clone() T }](values []T) []T {
+	if len(values) == 0 {
+		return nil
+	}
+
+	clones := make([]T, len(values))
+
+	for i, v := range values {
+		clones[i] = v.clone()
+	}
+
+	return clones
+}
+
+// sortedBooks returns the books of the library sorted by ID.
+//
+// sortedBooks must be called with the lock held.
+func (l *Library) sortedBooks() []*Book {
+	books := make([]*Book, 0, len(l.books))
+
+	for _, book := range l.books {
+		books = append(books, book)
+	}
+
+	slices.SortFunc(books, func(a, b *Book) int { return cmp.Compare(a.ID, b.ID) })
+
+	return books
+}
+
+// sortedAccounts returns the accounts of the library sorted by ID.
+//
+// sortedAccounts must be called with the lock held.
+func (l *Library) sortedAccounts() []*Account {
+	accounts := make([]*Account, 0, len(l.accounts))
+
+	for _, account := range l.accounts {
+		accounts = append(accounts, account)
+	}
+
+	slices.SortFunc(accounts, func(a, b *Account) int { return cmp.Compare(a.ID, b.ID) })
+
+	return accounts
+}
+
+// ExportOptions provides options for exporting library state.
+//
+// By default the whole state is exported. The filters select a subset of the
+// books and accounts instead, e.g. only the catalog to share with another
+// branch. A filtered export includes the checkouts and holds of the selected
+// books by the selected accounts, so that it can be imported on its own, but
+// not the policy, macros or an inventory in progress, which belong to the
+// library as a whole.
+type ExportOptions struct {
+	// Format is the format the state is exported in, FormatCommands if
+	// empty.
+	Format Format
+	// Key, if set, is the AES key ExportFile encrypts the file with, 16,
+	// 24 or 32 bytes. The state includes the checkout records of the
+	// patrons, which should not be readable by anyone with access to
+	// the file.
+	Key []byte
+
+	// OnlyBooks exports the books without any accounts.
+	OnlyBooks bool
+	// OnlyAccounts exports the accounts without any books.
+	OnlyAccounts bool
+	// BookIDs, if not empty, exports only the books with these IDs. IDs
+	// of books that do not exist are ignored.
+	BookIDs []int
+	// AccountIDs, if not empty, exports only the accounts with these
+	// IDs. IDs of accounts that do not exist are ignored.
+	AccountIDs []int
+
+	// ctx stops the export once it is done, see ExportContext.
+	ctx context.Context
+}
+
+// filtered reports whether the options select a subset of the state.
+func (opts ExportOptions) filtered() bool {
+	return opts.OnlyBooks || opts.OnlyAccounts || len(opts.BookIDs) > 0 || len(opts.AccountIDs) > 0
+}
+
+// filter removes the state not selected by the options from the snapshot.
+func (opts ExportOptions) filter(s *Snapshot) *Snapshot {
+	if !opts.filtered() {
+		return s
+	}
+
+	books := make(map[int]bool)
+	accounts := make(map[int]bool)
+
+	s.Books = slices.DeleteFunc(s.Books, func(book *Book) bool {
+		if opts.OnlyAccounts || len(opts.BookIDs) > 0 && !slices.Contains(opts.BookIDs, book.ID) {
+			return true
+		}
+
+		books[book.ID] = true
+
+		return false
+	})
+
+	s.Accounts = slices.DeleteFunc(s.Accounts, func(account *Account) bool {
+		if opts.OnlyBooks || len(opts.AccountIDs) > 0 && !slices.Contains(opts.AccountIDs, account.ID) {
+			return true
+		}
+
+		accounts[account.ID] = true
+
+		return false
+	})
+
+	s.Checkouts = slices.DeleteFunc(s.Checkouts, func(checkout *Checkout) bool {
+		return !books[checkout.BookID] || !accounts[checkout.AccountID]
+	})
+
+	s.Holds = slices.DeleteFunc(s.Holds, func(hold *Hold) bool {
+		return !books[hold.BookID] || !accounts[hold.AccountID]
+	})
+
+	s.Policy = DefaultPolicy()
+	s.Macros = nil
+	s.Inventory = nil
+
+	return s
+}
+
+// Export writes the library state to a writer in the format of the options.
+//
+// The default FormatCommands uses the same format as Import to allow for
+// round-trip serialization and persistence across invocations, while the
+// other formats write the state directly, see ExportSnapshot, ExportGob and
+// ExportProto.
+func (l *Library) Export(w io.Writer, opts ExportOptions) error {
+	if opts.OnlyBooks && opts.OnlyAccounts {
+		return fmt.Errorf("%w, only one of only books and only accounts may be set", ErrInvalidArgument)
+	}
+
+	var write func(w io.Writer, s *Snapshot) error
+
+	switch opts.Format {
+	case "", FormatCommands:
+		write = writeCommands
+	case FormatSnapshot:
+		write = writeSnapshot
+	case FormatGob:
+		write = writeGob
+	case FormatProto:
+		write = writeProto
+	default:
+		return fmt.Errorf("%w, unknown format %q", ErrInvalidArgument, opts.Format)
+	}
+
+	if opts.ctx != nil {
+		w = contextWriter{ctx: opts.ctx, w: w}
+	}
+
+	return write(w, opts.filter(l.Snapshot()))
+}
+
+// writeCommands writes the snapshot to a writer as JSON commands, which
+// restore the state when they are imported.
+//
+// The export starts with a comment of the FormatVersion so that it can be
+// migrated by later versions of the package.
+func writeCommands(w io.Writer, s *Snapshot) error {
+	if _, err := fmt.Fprintf(w, "%s %d\n", versionComment, FormatVersion); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	// The policy is written first so that the checkouts are restored with
+	// the due dates of the current loan period.
+	if s.Policy != DefaultPolicy() {
+		inv := Invocation{Command: newSetPolicy(s.Policy)}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	// The versions of books and accounts are written less the active
+	// checkouts of each, since restoring a checkout increases them in turn,
+	// so that they are restored at the same version and a stale version
+	// read before the export is still rejected after it is imported.
+	var (
+		booksOut    = make(map[int]int)
+		accountsOut = make(map[int]int)
+	)
+
+	for _, checkout := range s.Checkouts {
+		if checkout.Returned.IsZero() {
+			booksOut[checkout.BookID]++
+			accountsOut[checkout.AccountID]++
+		}
+	}
+
+	// Books and accounts are written in order of ID, as sorted by
+	// Snapshot, so that exports of the same state are identical, e.g. to
+	// diff them.
+	for _, book := range s.Books {
+		inv := Invocation{
+			Command: &AddBook{
+				ID:      book.ID,
+				Name:    book.Name,
+				Count:   book.Count,
+				Author:  book.Author,
+				ISBN:    book.ISBN,
+				Tags:    book.Tags,
+				Version: max(book.Version-booksOut[book.ID], 1),
+			},
+		}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	for _, account := range s.Accounts {
+		inv := Invocation{
+			Command: &CreateAccount{
+				ID:      account.ID,
+				Name:    account.Name,
+				Balance: account.Balance,
+				Version: max(account.Version-accountsOut[account.ID], 1),
+			},
+		}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	// The history is written in order so that it is restored in the same
+	// order. Active checkouts are restored as checkouts while returned
+	// checkouts are only restored as history.
+	for _, checkout := range s.Checkouts {
+		checkedOut, due := checkout.CheckedOut, checkout.Due
+
+		inv := Invocation{
+			Command: &CheckoutBook{
+				AccountID: checkout.AccountID,
+				BookID:    checkout.BookID,
+				Date:      &checkedOut,
+				Due:       &due,
+			},
+		}
+
+		if !checkout.Returned.IsZero() {
+			inv.Command = &AddHistory{
+				AccountID:  checkout.AccountID,
+				BookID:     checkout.BookID,
+				CheckedOut: checkout.CheckedOut,
+				Returned:   checkout.Returned,
+			}
+		}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	// Holds are written after the checkouts, since a hold cannot be placed
+	// on a book the account has checked out, and in the order of the hold
+	// queue of each book so that it is restored in the same order.
+	for _, hold := range s.Holds {
+		placed := hold.Placed
+
+		inv := Invocation{
+			Command: &PlaceHold{
+				AccountID: hold.AccountID,
+				BookID:    hold.BookID,
+				Date:      &placed,
+			},
+		}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	// Macros are written in the order they were defined since a macro may
+	// only invoke macros defined before it.
+	for _, macro := range s.Macros {
+		inv := Invocation{
+			Command: &DefineMacro{
+				Name:     macro.Name,
+				Params:   macro.Params,
+				Commands: macro.Commands,
+			},
+		}
+
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write library state, %w", err)
+		}
+	}
+
+	// An inventory in progress is written so that an audit can span
+	// multiple invocations.
+	if s.Inventory != nil {
+		started := s.Inventory.Started
+
+		invs := []Invocation{{Command: &StartInventory{Date: &started}}}
+
+		for _, barcode := range s.Inventory.Barcodes {
+			invs = append(invs, Invocation{Command: &ScanCopy{Barcode: barcode}})
+		}
+
+		for _, inv := range invs {
+			if err := enc.Encode(&inv); err != nil {
+				return fmt.Errorf("failed to write library state, %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ExportFile writes the library state to the file at path in the same way as
+// Export, replacing the file atomically, see writeFile. The file is gzip
+// compressed if the path has a .gz extension.
+//
+// The export is wrapped in a SHA-256 checksum header and trailer, which are
+// comments, so that Import can detect a corrupt or truncated file. The file is
+// then compressed and encrypted as configured.
+func (l *Library) ExportFile(path string, opts ExportOptions) error {
+	return writeFile(path, encryptWith(opts.Key, compressFor(path, withChecksum(func(w io.Writer) error {
+		return l.Export(w, opts)
+	}))))
+}
+
+// writeFile writes a file at path with the provided write function, replacing
+// the file atomically.
+//
+// The file is written to a temporary file in the same directory that is
+// renamed over the file once it is fully written, so that the existing file
+// is not lost or corrupted if the write fails during some combination of
+// truncating and writing directly into it.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary export file, %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		return err
+	}
+
+	// Force the export file to be written to disk before we replace the
+	// existing file.
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	// Rename is atomic on Linux systems, so we should not lose the
+	// existing file should it fail.
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s, %w", path, err)
+	}
+
+	return nil
+}
+
+// ImportOptions provides options for importing library state.
+type ImportOptions struct {
+	// Output, if set, receives the human readable output of each
+	// invocation, one per line.
+	//
+	// This is left unset to avoid logging output when loading initial
+	// library state, but set to log output when executing the user
+	// commands.
+	Output io.Writer
+	// ResultWriter, if set, receives the Result of each invocation as
+	// newline-delimited JSON, for programs that consume the outcome of
+	// the invocations rather than the human readable output.
+	ResultWriter io.Writer
+	// OnResult, if set, is called with the Result of each invocation,
+	// including those that fail, e.g. to count the commands executed and
+	// the failures by error code.
+	OnResult func(result Result)
+	// Confirm, if set, is called with a description of each destructive
+	// command, see ConfirmCommand, before it is executed and reports
+	// whether to execute it. A declined command fails with
+	// ErrNotConfirmed.
+	//
+	// This is left unset to execute every command without confirmation,
+	// but set by the CLI when run interactively.
+	Confirm func(desc string) bool
+	// AfterExec, if set, is called after each command executes
+	// successfully, e.g. to persist every command with Store.Append. An
+	// error stops the import.
+	//
+	// AfterExec is also called for each command of an INCLUDE before it
+	// is called for the INCLUDE itself.
+	AfterExec func(inv *Invocation) error
+	// Key, if set, is the AES key to decrypt encrypted input with, see
+	// ExportOptions.Key. Input that is not encrypted is read as is.
+	Key []byte
+	// OnConflict is the strategy for an ADD_BOOK or CREATE_ACCOUNT with
+	// the ID of an existing book or account, ConflictFail if empty. The
+	// other strategies allow the state files of two branches to be
+	// combined by importing one into the other.
+	OnConflict Conflict
+	// Checkpoint, if set, is called with the progress of the import after
+	// each command executes successfully, and after AfterExec, e.g. to
+	// record it to resume the import from if it fails. An error stops the
+	// import.
+	Checkpoint func(p Progress) error
+	// Resume, if set, resumes an import from the progress of a
+	// checkpoint: the commands up to it are read, to verify the checksum
+	// of the input, but not executed. If the input does not match the
+	// checkpoint, e.g. because it was modified, an error wrapping
+	// ErrInvalidArgument is returned.
+	Resume Progress
+	// DryRun executes the commands against a copy of the library rather
+	// than the library itself, so that their output and results can be
+	// seen without changing it. AfterExec and Checkpoint are not called.
+	DryRun bool
+	// OnDryRun, if set, is called with the state of the copy of a dry run
+	// once the commands are executed, including if one fails, e.g. to
+	// compare it to the state of the library to show what would change.
+	OnDryRun func(state *Snapshot)
+
+	// ctx stops the import once it is done, see ImportContext.
+	ctx context.Context
+}
+
+// Progress is the position of an import in its input, see
+// ImportOptions.Checkpoint.
+type Progress struct {
+	// Line is the number of the last line of the input that was
+	// imported.
+	Line int `json:"line"`
+	// Offset is the byte offset in the input of the end of the line, in
+	// the decrypted and decompressed input, which is checked when the
+	// import is resumed to detect input that changed since the
+	// checkpoint.
+	Offset int64 `json:"offset"`
+}
+
+// ConfirmCommand is implemented by destructive Commands that are confirmed
+// before they are executed when ImportOptions.Confirm is set.
+type ConfirmCommand interface {
+	// Confirmation returns a human readable description of the effect of
+	// the command on the library, e.g. "remove 2 of 5 copies of The
+	// Hobbit (7)".
+	Confirmation(l *Library) string
+}
+
+// confirm asks for confirmation of the Command of the Invocation if it is a
+// ConfirmCommand and sets the output and result of the Invocation if the
+// confirmation is declined.
+func (opts ImportOptions) confirm(l *Library, inv *Invocation) error {
+	cc, ok := inv.Command.(ConfirmCommand)
+	if !ok || opts.Confirm == nil {
+		return nil
+	}
+
+	desc := cc.Confirmation(l)
+	if opts.Confirm(desc) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w, %s", ErrNotConfirmed, desc)
+
+	inv.Output = fmt.Sprintf("did not %s, not confirmed", desc)
+
+	if c, ok := commandByType(inv.Command); ok {
+		inv.Result = newResult(c.name, inv.Command, err)
+	}
+
+	return err
+}
+
+// Import reads the library state from a reader in JSON format.
+//
+// The reader is expected to contain one JSON command per line. Empty lines
+// and lines starting with # or // are ignored to allow annotating command
+// files with comments. Gzip compressed input is decompressed transparently, as
+// is encrypted input given the key.
+//
+// The checksum of a checksummed export, see ExportFile, is verified as it is
+// read. If the checksum does not match or the export is truncated, an error
+// wrapping ErrCorrupt is returned, though the commands before the corruption
+// have already been executed; see FileStore to verify a file before loading
+// it.
+//
+// Command logs of an older FormatVersion, recorded by a version comment, are
+// migrated to the current version as they are read. A command preceded by an
+// at comment, as written by ExportSince, is executed with the clock stopped at
+// the recorded time.
+//
+// An import that fails can be resumed from its last checkpoint, see
+// ImportOptions.Checkpoint and ImportOptions.Resume, rather than restarted,
+// provided the state the commands before it were executed against was kept,
+// e.g. by a Store that persists every command with AfterExec.
+func (l *Library) Import(r io.Reader, opts ImportOptions) error {
+	if !validConflict(opts.OnConflict) {
+		return fmt.Errorf("%w, unknown conflict strategy %q", ErrInvalidArgument, opts.OnConflict)
+	}
+
+	if ok, err := l.dryRun(opts, func(c *Library, opts ImportOptions) error {
+		return c.Import(r, opts)
+	}); ok {
+		return err
+	}
+
+	r, err := decryptReader(r, opts.Key)
+	if err != nil {
+		return err
+	}
+
+	if r, err = decompress(r); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.imports = append(l.imports, opts)
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.imports = l.imports[:len(l.imports)-1]
+	}()
+
+	br := bufio.NewReader(r)
+
+	var results *json.Encoder
+	if opts.ResultWriter != nil {
+		results = json.NewEncoder(opts.ResultWriter)
+	}
+
+	var sum checksum
+
+	// Command logs without a version comment are the first version.
+	version := 1
+
+	// at is the time the next command was executed, if recorded by an at
+	// comment, see ExportSince.
+	var at time.Time
+
+	var offset int64
+
+	for n := 1; ; n++ {
+		if err := opts.context().Err(); err != nil {
+			return fmt.Errorf("import stopped before line %d, %w", n, err)
+		}
+
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read library state, %w", err)
+		}
+
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			if n <= opts.Resume.Line {
+				return fmt.Errorf("%w, input ends before the checkpoint at line %d", ErrInvalidArgument, opts.Resume.Line)
+			}
+
+			return sum.done()
+		}
+
+		offset += int64(len(line))
+
+		if n == opts.Resume.Line && offset != opts.Resume.Offset {
+			err := fmt.Errorf("%w, input does not match the checkpoint at line %d, offset %d", ErrInvalidArgument, opts.Resume.Line, opts.Resume.Offset)
+
+			return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: err}
+		}
+
+		skip, err := sum.line(line)
+		if err != nil {
+			return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: err}
+		}
+
+		if v, ok, err := parseVersionComment(line); ok {
+			if err != nil {
+				return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: err}
+			}
+
+			version = v
+		}
+
+		if t, ok, err := parseAtComment(line); ok {
+			if err != nil {
+				return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: err}
+			}
+
+			at = t
+		}
+
+		if skip || isBlankOrComment(line) {
+			continue
+		}
+
+		// The commands up to the checkpoint were executed by the import
+		// being resumed.
+		if n <= opts.Resume.Line {
+			at = time.Time{}
+
+			continue
+		}
+
+		migrated, err := migrateCommand(version, line)
+		if err != nil {
+			return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: err}
+		}
+
+		for _, line := range migrated {
+			if err := l.importLine(n, line, at, opts, results); err != nil {
+				return err
+			}
+		}
+
+		at = time.Time{}
+
+		if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(Progress{Line: n, Offset: offset}); err != nil {
+				return &ImportError{Line: n, Raw: string(bytes.TrimSpace(line)), Err: fmt.Errorf("failed to checkpoint import, %w", err)}
+			}
+		}
+	}
+}
+
+// importLine parses and executes a single command line of an import as of the
+// time, now if zero, writing its output and result as configured by the
+// ImportOptions.
+//
+// If the command cannot be parsed or fails, an *ImportError is returned.
+func (l *Library) importLine(n int, line []byte, at time.Time, opts ImportOptions, results *json.Encoder) error {
+	var inv Invocation
+
+	if err := json.Unmarshal(line, &inv); err != nil {
+		err = fmt.Errorf("%w, %w", ErrInvalidCommand, err)
+
+		// Commands that cannot be parsed are reported in the results
+		// too so that every failure has an error code.
+		result := newResult(inv.RawCommand.Name, nil, err)
+		result.Line = n
+
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+
+		if results != nil {
+			if err := results.Encode(result); err != nil {
+				return fmt.Errorf("failed to write invocation result, %w", err)
+			}
+		}
+
+		return &ImportError{
+			Line:    n,
+			Command: inv.RawCommand.Name,
+			Raw:     string(bytes.TrimSpace(line)),
+			Err:     err,
+		}
+	}
+
+	err := opts.confirm(l, &inv)
+	if err == nil {
+		err = inv.execAt(l, at)
+	}
+
+	if opts.Output != nil {
+		if _, err := fmt.Fprintf(opts.Output, "%s\n", inv.Output); err != nil {
+			return fmt.Errorf("failed to write invocation output, %w", err)
+		}
+	}
+
+	inv.Result.Line = n
+
+	if opts.OnResult != nil {
+		opts.OnResult(inv.Result)
+	}
+
+	if results != nil {
+		if err := results.Encode(inv.Result); err != nil {
+			return fmt.Errorf("failed to write invocation result, %w", err)
+		}
+	}
+
+	if err == nil && opts.AfterExec != nil {
+		err = opts.AfterExec(&inv)
+	}
+
+	if err != nil {
+		return &ImportError{
+			Line:    n,
+			Command: inv.RawCommand.Name,
+			Raw:     string(bytes.TrimSpace(line)),
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ImportFile imports the commands from the file at path in the same way as
+// Import.
+//
+// Command files may include other command files, see the INCLUDE command,
+// so the files being imported are tracked to reject a file that includes
+// itself, directly or indirectly, with ErrIncludeCycle.
+func (l *Library) ImportFile(path string, opts ImportOptions) error {
+	if ok, err := l.dryRun(opts, func(c *Library, opts ImportOptions) error {
+		return c.ImportFile(path, opts)
+	}); ok {
+		return err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s, %w", path, err)
+	}
+
+	l.mu.Lock()
+
+	if slices.Contains(l.importing, abs) {
+		chain := strings.Join(append(slices.Clone(l.importing), abs), " -> ")
+
+		l.mu.Unlock()
+
+		return fmt.Errorf("%w, %s", ErrIncludeCycle, chain)
+	}
+
+	l.importing = append(l.importing, abs)
+
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.importing = l.importing[:len(l.importing)-1]
+	}()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s, %w", path, err)
+	}
+	defer f.Close()
+
+	return l.Import(f, opts)
+}
+
+// includeOptions returns the options for the import of an INCLUDE command,
+// which inherit the confirmation, AfterExec, OnResult, key, conflict strategy
+// and context of the import in progress, if any, so that the included commands
+// are treated like the commands that include them.
+func (l *Library) includeOptions(output io.Writer) ImportOptions {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	opts := ImportOptions{Output: output}
+
+	if len(l.imports) > 0 {
+		current := l.imports[len(l.imports)-1]
+
+		opts.Confirm = current.Confirm
+		opts.AfterExec = current.AfterExec
+		opts.OnResult = current.OnResult
+		opts.Key = current.Key
+		opts.OnConflict = current.OnConflict
+		opts.ctx = current.ctx
+	}
+
+	return opts
+}
+
+// importDir returns the directory of the command file being imported by
+// ImportFile, or an empty string if there is none, so that the paths in a
+// command file can be resolved relative to it.
+func (l *Library) importDir() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.importing) == 0 {
+		return ""
+	}
+
+	return filepath.Dir(l.importing[len(l.importing)-1])
+}
+
+// ImportError represents a failure to import a command, with the context
+// needed to find the offending command in the input.
+type ImportError struct {
+	Line    int    // Line number of the command, starting at 1.
+	Command string // Name of the command, empty if it could not be parsed.
+	Raw     string // Raw JSON of the command.
+	Err     error  // Error that caused the failure.
+}
+
+// Error implements the error interface.
+func (e *ImportError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("line %d, %v, %s", e.Line, e.Err, e.Raw)
+	}
+
+	return fmt.Sprintf("line %d, %s failed, %v, %s", e.Line, e.Command, e.Err, e.Raw)
+}
+
+// Unwrap returns the error that caused the failure.
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// isBlankOrComment reports whether a line of a command file is empty or a
+// comment.
+func isBlankOrComment(line []byte) bool {
+	line = bytes.TrimSpace(line)
+
+	return len(line) == 0 || bytes.HasPrefix(line, []byte("#")) || bytes.HasPrefix(line, []byte("//"))
 }
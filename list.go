@@ -0,0 +1,240 @@
+package library
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SortBy is the order books or accounts are listed in, see ListOptions.
+type SortBy string
+
+const (
+	// SortByID lists books or accounts by ID.
+	SortByID SortBy = "id"
+	// SortByName lists books or accounts by name, ignoring case, and
+	// those with the same name by ID.
+	SortByName SortBy = "name"
+	// SortByAvailability lists the books with the most copies available
+	// to check out, or the accounts that can check out the most books,
+	// i.e. with the fewest checked out, first, and those with the same
+	// availability by ID.
+	SortByAvailability SortBy = "availability"
+)
+
+// validSortBy reports whether the order is known, the empty order being
+// SortByID.
+func validSortBy(s SortBy) bool {
+	switch s {
+	case "", SortByID, SortByName, SortByAvailability:
+		return true
+	default:
+		return false
+	}
+}
+
+// ListOptions provides options for listing books or accounts, see ListBooks.
+type ListOptions struct {
+	// Offset is the number of books or accounts skipped, e.g. those of the
+	// previous pages.
+	Offset int
+	// Limit is the maximum number of books or accounts listed, all after
+	// the offset if not positive.
+	Limit int
+	// SortBy is the order of the books or accounts, SortByID if empty.
+	SortBy SortBy
+}
+
+// Page is a page of the books or accounts of a library, see ListBooks.
+type Page[T any] struct {
+	// Items are the books or accounts of the page.
+	Items []T `json:"items"`
+	// Total is the number of books or accounts of all pages.
+	Total int `json:"total"`
+}
+
+// ListBooks returns a page of copies of the books in the library catalog in
+// the order of the options, e.g. for the REST API or UI to page through a large
+// catalog:
+//
+//	for opts := (library.ListOptions{Limit: 100}); ; opts.Offset += opts.Limit {
+//		page, err := l.ListBooks(opts)
+//		if err != nil {
+//			return err
+//		}
+//
+//		// Render page.Items.
+//
+//		if opts.Offset+len(page.Items) >= page.Total {
+//			break
+//		}
+//	}
+//
+// The books are listed from the view of the library, see View, which keeps
+// them sorted in each order until the library changes, so paging through a
+// library that does not change sorts the books once rather than for each page,
+// and a page is found by its offset rather than by sorting the books up to it.
+// Only the books of the page are copied from the view. The order is stable, so
+// the pages of a library that does not change have each book exactly once. If
+// the offset or limit is negative, or the order is unknown, an error wrapping
+// ErrInvalidArgument is returned.
+func (l *Library) ListBooks(opts ListOptions) (*Page[*Book], error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return l.View().ListBooks(opts)
+}
+
+// ListAccounts returns a page of copies of the accounts in the library in the
+// order of the options, in the same way as ListBooks.
+func (l *Library) ListAccounts(opts ListOptions) (*Page[*Account], error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return l.View().ListAccounts(opts)
+}
+
+// ListBooks returns a page of copies of the books of the view in the order of
+// the options, in the same way as Library.ListBooks.
+func (v *View) ListBooks(opts ListOptions) (*Page[*Book], error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return listPage(v.bookOrders.sortedBy(opts.SortBy), opts), nil
+}
+
+// ListAccounts returns a page of copies of the accounts of the view in the
+// order of the options, in the same way as Library.ListBooks.
+func (v *View) ListAccounts(opts ListOptions) (*Page[*Account], error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return listPage(v.accountOrders.sortedBy(opts.SortBy), opts), nil
+}
+
+// validate returns an error wrapping ErrInvalidArgument if the offset or limit
+// is negative, or the order is unknown.
+func (opts ListOptions) validate() error {
+	if opts.Offset < 0 {
+		return fmt.Errorf("%w, offset must not be negative", ErrInvalidArgument)
+	}
+
+	if opts.Limit < 0 {
+		return fmt.Errorf("%w, limit must not be negative", ErrInvalidArgument)
+	}
+
+	if !validSortBy(opts.SortBy) {
+		return fmt.Errorf("%w, unknown sort order %q", ErrInvalidArgument, opts.SortBy)
+	}
+
+	return nil
+}
+
+// listKey is what books or accounts are ordered by, see SortBy.
+type listKey struct {
+	id        int
+	name      string
+	lower     string // Name in lower case, set when the values are sorted.
+	available int    // Copies available, or books the account can check out.
+}
+
+// compareListKeys compares the keys of books or accounts in the order, which
+// must not be SortByID.
+func compareListKeys(by SortBy, x, y listKey) int {
+	if by == SortByAvailability {
+		return cmp.Or(cmp.Compare(y.available, x.available), cmp.Compare(x.id, y.id))
+	}
+
+	return cmp.Or(
+		strings.Compare(x.lower, y.lower),
+		strings.Compare(x.name, y.name),
+		cmp.Compare(x.id, y.id),
+	)
+}
+
+// listOrders are the books or accounts of a view in each order they are listed
+// in, see SortBy. They are sorted by ID, and in each other order when first
+// listed in it.
+type listOrders[T any] struct {
+	byID   []T
+	key    func(T) listKey
+	sorted map[SortBy]*listOrder[T]
+}
+
+// listOrder is the books or accounts of a view sorted in one order.
+type listOrder[T any] struct {
+	once   sync.Once
+	values []T
+}
+
+// newListOrders returns the orders of the values, which are sorted by ID, with
+// the keys they are ordered by.
+func newListOrders[T any](byID []T, key func(T) listKey) listOrders[T] {
+	return listOrders[T]{
+		byID: byID,
+		key:  key,
+		sorted: map[SortBy]*listOrder[T]{
+			SortByName:         {},
+			SortByAvailability: {},
+		},
+	}
+}
+
+// sortedBy returns the values in the order, sorting them the first time. The
+// key of each value is computed once rather than for each comparison.
+func (o listOrders[T]) sortedBy(by SortBy) []T {
+	order, ok := o.sorted[by]
+	if !ok {
+		return o.byID
+	}
+
+	order.once.Do(func() {
+		type keyed struct {
+			key   listKey
+			value T
+		}
+
+		values := make([]keyed, len(o.byID))
+
+		for i, value := range o.byID {
+			key := o.key(value)
+			key.lower = strings.ToLower(key.name)
+
+			values[i] = keyed{key: key, value: value}
+		}
+
+		slices.SortFunc(values, func(a, b keyed) int { return compareListKeys(by, a.key, b.key) })
+
+		order.values = make([]T, len(values))
+
+		for i, value := range values {
+			order.values[i] = value.value
+		}
+	})
+
+	return order.values
+}
+
+// listPage returns the page of copies of the values, which are in the order of
+// the options, see listOrders.
+func listPage[T interface{ clone() T }](values []T, opts ListOptions) *Page[T] {
+	page := &Page[T]{Items: []T{}, Total: len(values)}
+
+	values = values[min(opts.Offset, len(values)):]
+
+	if opts.Limit > 0 {
+		values = values[:min(opts.Limit, len(values))]
+	}
+
+	for _, value := range values {
+		page.Items = append(page.Items, value.clone())
+	}
+
+	return page
+}
@@ -0,0 +1,223 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrMacroNotExist is returned when a macro does not exist.
+	ErrMacroNotExist = errors.New("macro does not exist")
+)
+
+// Macro represents a named sequence of parameterized commands that can be
+// invoked as a single command.
+type Macro struct {
+	Name   string   `json:"name"`             // Name the macro is invoked by.
+	Params []string `json:"params,omitempty"` // Names of the parameters of the macro.
+
+	// Commands are the raw JSON commands executed in order when the macro
+	// is invoked. Any JSON string of the form "$param" is replaced with
+	// the value of the argument for the parameter, preserving its JSON
+	// type, e.g. "$id" is replaced with 7 rather than "7".
+	Commands []json.RawMessage `json:"commands"`
+}
+
+// DefineMacro defines a macro that can be invoked by name as a single command.
+//
+// A macro cannot be redefined and cannot use the name of a registered command.
+// Each command of the macro must be a registered command or a macro that is
+// already defined, which also prevents a macro from invoking itself. If the
+// macro is invalid, an error is returned.
+func (l *Library) DefineMacro(name string, params []string, commands []json.RawMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := commandByName(name); ok {
+		return &DuplicateError{Kind: KindMacro, Name: name, detail: fmt.Sprintf("macro %s is the name of a command", name)}
+	}
+
+	if _, ok := l.macros[name]; ok {
+		return &DuplicateError{Kind: KindMacro, Name: name}
+	}
+
+	seen := make(map[string]bool, len(params))
+
+	for _, param := range params {
+		if param == "" || seen[param] {
+			return fmt.Errorf("%w, macro %s has an empty or duplicate parameter %q", ErrInvalidArgument, name, param)
+		}
+
+		seen[param] = true
+	}
+
+	if len(commands) == 0 {
+		return fmt.Errorf("%w, macro %s has no commands", ErrInvalidArgument, name)
+	}
+
+	for i, raw := range commands {
+		var cmd Command
+
+		if err := json.Unmarshal(raw, &cmd); err != nil {
+			return fmt.Errorf("%w, macro %s command %d is invalid, %w", ErrInvalidArgument, name, i+1, err)
+		}
+
+		_, isCommand := commandByName(cmd.Name)
+		_, isMacro := l.macros[cmd.Name]
+
+		if !isCommand && !isMacro {
+			return fmt.Errorf("%w, macro %s command %d is unknown, %s", ErrInvalidArgument, name, i+1, cmd.Name)
+		}
+	}
+
+	macro := &Macro{
+		Name:     name,
+		Params:   slices.Clone(params),
+		Commands: slices.Clone(commands),
+	}
+
+	l.macros[name] = macro
+	l.macroOrder = append(l.macroOrder, name)
+
+	l.pushUndo(fmt.Sprintf("define macro %s", name), func() {
+		delete(l.macros, name)
+		l.macroOrder = slices.DeleteFunc(l.macroOrder, func(n string) bool { return n == name })
+	})
+
+	return nil
+}
+
+// Macro returns a copy of a macro by name, nil if it does not exist. Changing
+// the copy does not change the library.
+func (l *Library) Macro(name string) *Macro {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.macros[name].clone()
+}
+
+// EachMacro calls the provided function with a copy of each macro in the
+// library in the order the macros were defined.
+//
+// The function exists to allow thread-safe iteration of the macros in the
+// library.
+func (l *Library) EachMacro(fn func(macro *Macro)) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, name := range l.macroOrder {
+		fn(l.macros[name].clone())
+	}
+}
+
+// clone returns a copy of the macro, including its parameters and commands,
+// nil for nil.
+func (m *Macro) clone() *Macro {
+	if m == nil {
+		return nil
+	}
+
+	c := *m
+	c.Params = slices.Clone(m.Params)
+	c.Commands = make([]json.RawMessage, len(m.Commands))
+
+	for i, command := range m.Commands {
+		c.Commands[i] = bytes.Clone(command)
+	}
+
+	return &c
+}
+
+// Expand returns the commands of the macro with the parameters replaced by
+// the arguments.
+//
+// The arguments must be a JSON object with a value for each parameter of the
+// macro and no others.
+func (m *Macro) Expand(arguments json.RawMessage) ([]json.RawMessage, error) {
+	var args map[string]any
+
+	if len(arguments) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(arguments))
+		dec.UseNumber()
+
+		if err := dec.Decode(&args); err != nil {
+			return nil, fmt.Errorf("%w, macro arguments, %w", ErrInvalidArgument, err)
+		}
+	}
+
+	var verr ValidationError
+
+	// Sort the unknown arguments so that the errors are deterministic.
+	var unknown []string
+
+	for name := range args {
+		if !slices.Contains(m.Params, name) {
+			unknown = append(unknown, name)
+		}
+	}
+
+	slices.Sort(unknown)
+
+	for _, name := range unknown {
+		verr.add(name, "unknown argument")
+	}
+
+	for _, param := range m.Params {
+		if _, ok := args[param]; !ok {
+			verr.add(param, "missing required argument")
+		}
+	}
+
+	if err := verr.err(); err != nil {
+		return nil, err
+	}
+
+	commands := make([]json.RawMessage, 0, len(m.Commands))
+
+	for _, raw := range m.Commands {
+		var v any
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%w, macro command, %w", ErrInvalidCommand, err)
+		}
+
+		bs, err := json.Marshal(substitute(v, args))
+		if err != nil {
+			return nil, fmt.Errorf("%w, macro command, %w", ErrInvalidCommand, err)
+		}
+
+		commands = append(commands, bs)
+	}
+
+	return commands, nil
+}
+
+// substitute replaces any string of the form "$param" in a decoded JSON value
+// with the value of the argument for the parameter.
+func substitute(v any, args map[string]any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = substitute(e, args)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = substitute(e, args)
+		}
+	case string:
+		if param, ok := strings.CutPrefix(v, "$"); ok {
+			if arg, ok := args[param]; ok {
+				return arg
+			}
+		}
+	}
+
+	return v
+}
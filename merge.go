@@ -0,0 +1,175 @@
+package library
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MergeOptions provides options for merging libraries, see Merge.
+type MergeOptions struct {
+	// OnConflict is the strategy for a book or account of the source with
+	// the ID of a book or account of the destination, ConflictFail if
+	// empty.
+	OnConflict Conflict
+}
+
+// Merge folds the state of the library src into the library dst, e.g. to
+// consolidate the DBs of two branches:
+//
+//	err := library.Merge(main, branch, library.MergeOptions{OnConflict: library.ConflictMerge})
+//
+// The books and accounts of src are added to dst, resolving those with the ID
+// of a book or account of dst according to the conflict strategy of the
+// options, see Conflict. The checkouts of src are then restored in dst, with
+// their history, except those dst already has, i.e. of the same book by the
+// same account at the same time, so that libraries that share a history, e.g.
+// copies of the same DB, can be merged. A checkout dst has that src has since
+// returned is left checked out, since a return cannot be restored with its
+// time, see ReturnBook. The holds of src are queued after those of dst, except
+// those dst already has or of a book the account has checked out in dst. The
+// macros of src that dst does not have are defined;
+// with ConflictFail, a macro of src that differs from the macro of dst with its
+// name fails the merge, otherwise the macro of dst is kept. The policy, quota
+// and inventory audit of dst are kept.
+//
+// The state of src is merged by executing the commands that would export it
+// against dst, e.g. ADD_BOOK, as if by an import with the conflict strategy, so
+// the merge is recorded as changes of dst, see Watch. The commands are executed
+// against a copy of dst first, see Clone, so that a merge that fails, e.g.
+// because a book of src would be checked out more than once by the same
+// account, leaves dst unchanged, unless dst is changed while it is merged.
+func Merge(dst, src *Library, opts MergeOptions) error {
+	if !validConflict(opts.OnConflict) {
+		return fmt.Errorf("%w, unknown conflict strategy %q", ErrInvalidArgument, opts.OnConflict)
+	}
+
+	if dst == src {
+		return fmt.Errorf("%w, cannot merge a library into itself", ErrInvalidArgument)
+	}
+
+	to, from := dst.Snapshot(), src.Snapshot()
+
+	if err := execMerge(dst.Clone(), to, from, opts.OnConflict); err != nil {
+		return err
+	}
+
+	return execMerge(dst, to, from, opts.OnConflict)
+}
+
+// execMerge executes the commands merging the state from into the library l
+// with the state to, see Merge.
+func execMerge(l *Library, to, from *Snapshot, c Conflict) error {
+	return l.withConflict(c, func() error {
+		for _, cmd := range mergeCommands(to, from, c) {
+			inv := Invocation{Command: cmd}
+
+			if err := inv.Exec(l); err != nil {
+				return fmt.Errorf("failed to merge library, %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// mergeCommands returns the commands merging the state from into a library
+// with the state to, see Merge.
+func mergeCommands(to, from *Snapshot, c Conflict) []any {
+	var cmds []any
+
+	for _, book := range from.Books {
+		cmds = append(cmds, &AddBook{
+			ID:     book.ID,
+			Name:   book.Name,
+			Count:  book.Count,
+			Author: book.Author,
+			ISBN:   book.ISBN,
+			Tags:   book.Tags,
+		})
+	}
+
+	for _, account := range from.Accounts {
+		cmds = append(cmds, &CreateAccount{
+			ID:      account.ID,
+			Name:    account.Name,
+			Balance: account.Balance,
+		})
+	}
+
+	checkouts := make(map[checkoutKey]bool, len(to.Checkouts))
+	for _, checkout := range to.Checkouts {
+		checkouts[keyOfCheckout(checkout)] = true
+	}
+
+	for _, checkout := range from.Checkouts {
+		if checkouts[keyOfCheckout(checkout)] {
+			continue
+		}
+
+		if !checkout.Returned.IsZero() {
+			cmds = append(cmds, &AddHistory{
+				AccountID:  checkout.AccountID,
+				BookID:     checkout.BookID,
+				CheckedOut: checkout.CheckedOut,
+				Returned:   checkout.Returned,
+			})
+
+			continue
+		}
+
+		checkedOut, due := checkout.CheckedOut, checkout.Due
+
+		cmds = append(cmds, &CheckoutBook{
+			AccountID: checkout.AccountID,
+			BookID:    checkout.BookID,
+			Date:      &checkedOut,
+			Due:       &due,
+		})
+	}
+
+	// The holds of src are placed after its checkouts, in the order of the
+	// queue of each book, unless dst already has the hold or the account has
+	// the book checked out in dst.
+	holds := make(map[holdKey]bool, len(to.Holds)+len(to.Checkouts))
+	for _, hold := range to.Holds {
+		holds[keyOfHold(hold)] = true
+	}
+
+	for _, checkout := range to.Checkouts {
+		if checkout.Returned.IsZero() {
+			holds[holdKey{bookID: checkout.BookID, accountID: checkout.AccountID}] = true
+		}
+	}
+
+	for _, hold := range from.Holds {
+		if holds[keyOfHold(hold)] {
+			continue
+		}
+
+		placed := hold.Placed
+
+		cmds = append(cmds, &PlaceHold{
+			AccountID: hold.AccountID,
+			BookID:    hold.BookID,
+			Date:      &placed,
+		})
+	}
+
+	for _, macro := range from.Macros {
+		i := slices.IndexFunc(to.Macros, func(m *Macro) bool { return m.Name == macro.Name })
+
+		// A macro that differs is defined anyway with ConflictFail so
+		// that it fails with ErrDuplicateID.
+		if i >= 0 && (c != "" && c != ConflictFail || equalMacros(to.Macros[i], macro)) {
+			continue
+		}
+
+		cmds = append(cmds, &DefineMacro{
+			Name:     macro.Name,
+			Params:   macro.Params,
+			Commands: macro.Commands,
+		})
+	}
+
+	return cmds
+}
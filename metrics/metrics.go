@@ -0,0 +1,168 @@
+// Package metrics provides the Prometheus metrics of a library being served,
+// see Metrics, to monitor the commands executed, their failures, the
+// checkouts, and the latency of imports and of the storage.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/admtnnr/library"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are the metrics of a library:
+//
+//	library_commands_total{command}                counter of the commands executed
+//	library_command_failures_total{command,code}   counter of the commands that failed, by error code
+//	library_active_checkouts                       gauge of the checkouts not yet returned
+//	library_overdue_checkouts                      gauge of the active checkouts that are past due
+//	library_import_duration_seconds                histogram of the durations of the commands files executed
+//	library_store_duration_seconds{op,status}      histogram of the latencies of the saves and appends of the DB
+//
+// as well as the standard metrics of the Go runtime and the process.
+//
+// The commands are counted by the OnResult of the imports, see ObserveResult,
+// and the operations of the DB by a Store wrapped with Store.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	commands       *prometheus.CounterVec
+	failures       *prometheus.CounterVec
+	importDuration prometheus.Histogram
+	storeDuration  *prometheus.HistogramVec
+}
+
+// New returns the metrics of a library.
+func New(l *library.Library) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "library_commands_total",
+			Help: "Number of commands executed, by command.",
+		}, []string{"command"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "library_command_failures_total",
+			Help: "Number of commands that failed, by command and error code.",
+		}, []string{"command", "code"}),
+		importDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "library_import_duration_seconds",
+			Help:    "Duration of the execution of commands files.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "library_store_duration_seconds",
+			Help:    "Latency of the operations of the DB, save or append, by status.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"op", "status"}),
+	}
+
+	m.registry.MustRegister(
+		m.commands,
+		m.failures,
+		m.importDuration,
+		m.storeDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "library_active_checkouts",
+			Help: "Number of checkouts not yet returned.",
+		}, func() float64 {
+			n := 0
+
+			l.AllCheckouts(func(*library.Checkout) { n++ })
+
+			return float64(n)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "library_overdue_checkouts",
+			Help: "Number of checkouts not yet returned that are past due.",
+		}, func() float64 {
+			return float64(len(l.OverdueCheckouts()))
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler returns the handler of the /metrics endpoint that Prometheus
+// scrapes.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveResult counts the command of the result of an invocation, and its
+// failure if it failed, e.g. as the OnResult of an import.
+func (m *Metrics) ObserveResult(result library.Result) {
+	m.commands.WithLabelValues(result.Command).Inc()
+
+	if result.Status != library.StatusOK {
+		m.failures.WithLabelValues(result.Command, string(result.ErrorCode)).Inc()
+	}
+}
+
+// ObserveImport observes the duration of the execution of a commands file.
+func (m *Metrics) ObserveImport(d time.Duration) {
+	m.importDuration.Observe(d.Seconds())
+}
+
+// Store returns a Store that observes the latency of the saves and appends of
+// s. Appends are observed since some stores persist the state on every
+// append, e.g. boltstore.
+func (m *Metrics) Store(s library.Store) library.Store {
+	return &store{Store: s, m: m}
+}
+
+// store is a Store that observes the latency of its operations, see
+// Metrics.Store.
+type store struct {
+	library.Store
+
+	m *Metrics
+}
+
+// Save implements library.Store.
+func (s *store) Save(l *library.Library) error {
+	return s.observe("save", func() error { return s.Store.Save(l) })
+}
+
+// LoadContext implements library.ContextStore by loading the underlying store
+// with the context, see library.LoadStore.
+func (s *store) LoadContext(ctx context.Context, l *library.Library) error {
+	return library.LoadStore(ctx, s.Store, l)
+}
+
+// SaveContext implements library.ContextStore by saving the underlying store
+// with the context, see library.SaveStore.
+func (s *store) SaveContext(ctx context.Context, l *library.Library) error {
+	return s.observe("save", func() error { return library.SaveStore(ctx, s.Store, l) })
+}
+
+// Append implements library.Store.
+func (s *store) Append(l *library.Library, inv *library.Invocation) error {
+	return s.observe("append", func() error { return s.Store.Append(l, inv) })
+}
+
+// observe observes the latency of an operation of the store.
+func (s *store) observe(op string, fn func() error) error {
+	start := time.Now()
+
+	err := fn()
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	s.m.storeDuration.WithLabelValues(op, status).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// Ping implements library.Pinger, see library.PingStore.
+func (s *store) Ping(ctx context.Context) error {
+	return library.PingStore(ctx, s.Store)
+}
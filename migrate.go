@@ -0,0 +1,194 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FormatVersion is the version of the state written by Export and
+// ExportSnapshot.
+//
+// The version is bumped whenever a change to the commands or the snapshot
+// would prevent older state from loading, together with a migration from the
+// previous version, see migrations.
+const FormatVersion = 1
+
+// versionComment is the comment line that records the FormatVersion of a
+// command log written by Export, e.g. "# version 1". Command logs without it
+// are version 1, the version before it was recorded.
+const versionComment = "# version"
+
+// Migration upgrades state from one FormatVersion to the next.
+type Migration struct {
+	From int // Version migrated from, the migration produces version From+1.
+
+	// Command migrates a command of a command log, returning the commands
+	// that replace it, e.g. none to drop a command that no longer exists.
+	// Nil if the commands did not change.
+	Command func(cmd Command) ([]Command, error)
+	// Snapshot migrates the top-level fields of a snapshot in place. Nil
+	// if the snapshot did not change. The version is updated separately.
+	Snapshot func(fields map[string]json.RawMessage) error
+}
+
+// migrations are the migrations from each FormatVersion to the next, in order
+// starting from version 1, so that migrations[i] migrates from version i+1.
+//
+// A migration is added here whenever FormatVersion is bumped, e.g. a v2 that
+// renamed the count argument of ADD_BOOK to copies would add:
+//
+//	{
+//		From: 1,
+//		Command: func(cmd Command) ([]Command, error) {
+//			// rename "count" to "copies" in cmd.Arguments
+//		},
+//	}
+var migrations []Migration
+
+// checkVersion returns an error if state of the version cannot be migrated to
+// the current FormatVersion.
+func checkVersion(version int) error {
+	if version < 1 || version > FormatVersion || version-1 > len(migrations) {
+		return fmt.Errorf("%w, unsupported state version %d, expected at most %d", ErrInvalidArgument, version, FormatVersion)
+	}
+
+	return nil
+}
+
+// parseVersionComment returns the version recorded by a version comment line,
+// reporting whether the line is one.
+func parseVersionComment(line []byte) (int, bool, error) {
+	v, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte(versionComment+" "))
+	if !ok {
+		return 0, false, nil
+	}
+
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, true, fmt.Errorf("%w, invalid state version %q", ErrInvalidArgument, v)
+	}
+
+	return version, true, checkVersion(version)
+}
+
+// migrateCommand migrates a raw command of a command log of the version to
+// the current FormatVersion, returning the raw commands that replace it.
+func migrateCommand(version int, line []byte) ([][]byte, error) {
+	if version == FormatVersion {
+		return [][]byte{line}, nil
+	}
+
+	var cmd Command
+
+	if err := json.Unmarshal(line, &cmd); err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrInvalidCommand, err)
+	}
+
+	cmds := []Command{cmd}
+
+	for _, m := range migrations[version-1:] {
+		if m.Command == nil {
+			continue
+		}
+
+		var migrated []Command
+
+		for _, cmd := range cmds {
+			out, err := m.Command(cmd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to migrate %s from version %d, %w", cmd.Name, m.From, err)
+			}
+
+			migrated = append(migrated, out...)
+		}
+
+		cmds = migrated
+	}
+
+	lines := make([][]byte, 0, len(cmds))
+
+	for _, cmd := range cmds {
+		bs, err := json.Marshal(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s, %w", cmd.Name, err)
+		}
+
+		lines = append(lines, bs)
+	}
+
+	return lines, nil
+}
+
+// migrateSnapshot migrates a raw snapshot to the current FormatVersion.
+func migrateSnapshot(bs []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return nil, fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	var version int
+
+	if err := json.Unmarshal(fields["version"], &version); err != nil {
+		return nil, fmt.Errorf("failed to read library state version, %w", err)
+	}
+
+	if err := checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	if version == FormatVersion {
+		return bs, nil
+	}
+
+	for _, m := range migrations[version-1:] {
+		if m.Snapshot == nil {
+			continue
+		}
+
+		if err := m.Snapshot(fields); err != nil {
+			return nil, fmt.Errorf("failed to migrate snapshot from version %d, %w", m.From, err)
+		}
+	}
+
+	fields["version"] = json.RawMessage(strconv.Itoa(FormatVersion))
+
+	return json.Marshal(fields)
+}
+
+// UpgradeFile upgrades the state file at path to the current FormatVersion in
+// place, keeping its format and compression.
+//
+// State files are migrated whenever they are loaded, so upgrading is only
+// needed to keep a file readable once the migrations from its version are
+// removed, or to read it with tools other than the Library.
+func UpgradeFile(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s, %w", path, err)
+	}
+
+	if bs, err = readAll(bytes.NewReader(bs)); err != nil {
+		return fmt.Errorf("failed to read %s, %w", path, err)
+	}
+
+	if bs, err = stripChecksum(bs); err != nil {
+		return fmt.Errorf("%s, %w", path, err)
+	}
+
+	store := &FileStore{Path: path, Format: FormatCommands}
+	if isSnapshot(bs) {
+		store.Format = FormatSnapshot
+	}
+
+	l := New()
+
+	if err := store.Load(l); err != nil {
+		return err
+	}
+
+	return store.Save(l)
+}
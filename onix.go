@@ -0,0 +1,308 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// onixShortTags maps the short tags of ONIX 3.0 to the reference names of the
+// elements read by ImportONIX, so that feeds with either tag set are read the
+// same way.
+var onixShortTags = map[string]string{
+	"product":           "Product",
+	"a002":              "NotificationType",
+	"productidentifier": "ProductIdentifier",
+	"b221":              "ProductIDType",
+	"b244":              "IDValue",
+	"descriptivedetail": "DescriptiveDetail",
+	"titledetail":       "TitleDetail",
+	"b202":              "TitleType",
+	"titleelement":      "TitleElement",
+	"x409":              "TitleElementLevel",
+	"b203":              "TitleText",
+	"b030":              "TitlePrefix",
+	"b031":              "TitleWithoutPrefix",
+	"b029":              "Subtitle",
+	"contributor":       "Contributor",
+	"b035":              "ContributorRole",
+	"b036":              "PersonName",
+	"b039":              "NamesBeforeKey",
+	"b040":              "KeyNames",
+	"b047":              "CorporateName",
+	"subject":           "Subject",
+	"b070":              "SubjectHeadingText",
+}
+
+// onixProduct is the subset of an ONIX 3.0 Product record read by ImportONIX.
+type onixProduct struct {
+	NotificationType string `xml:"NotificationType"`
+	Identifiers      []struct {
+		Type  string `xml:"ProductIDType"`
+		Value string `xml:"IDValue"`
+	} `xml:"ProductIdentifier"`
+	Titles []struct {
+		Type     string `xml:"TitleType"`
+		Elements []struct {
+			Level         string `xml:"TitleElementLevel"`
+			Text          string `xml:"TitleText"`
+			Prefix        string `xml:"TitlePrefix"`
+			WithoutPrefix string `xml:"TitleWithoutPrefix"`
+			Subtitle      string `xml:"Subtitle"`
+		} `xml:"TitleElement"`
+	} `xml:"DescriptiveDetail>TitleDetail"`
+	Contributors []struct {
+		Roles          []string `xml:"ContributorRole"`
+		PersonName     string   `xml:"PersonName"`
+		NamesBeforeKey string   `xml:"NamesBeforeKey"`
+		KeyNames       string   `xml:"KeyNames"`
+		CorporateName  string   `xml:"CorporateName"`
+	} `xml:"DescriptiveDetail>Contributor"`
+	Subjects []struct {
+		HeadingText string `xml:"SubjectHeadingText"`
+	} `xml:"DescriptiveDetail>Subject"`
+}
+
+// ImportONIX imports the products of a publisher ONIX 3.0 product feed as
+// books, to pre-populate the catalog with the metadata of newly ordered
+// titles before their copies arrive.
+//
+// Each product is added with ADD_BOOK with no copies, the distinctive title
+// as the name, the authors, the ISBN-13, and the subject headings as tags.
+// A product with the ISBN of a book in the catalog is added with the ID of
+// the book, so that ImportOptions.OnConflict decides whether it is skipped,
+// overwrites the book, or is merged into it, e.g. ConflictMerge to fill in
+// missing metadata. Other products are given the IDs after the highest ID in
+// the catalog. Products whose notification is a deletion are ignored.
+//
+// Both the reference and short tags of ONIX 3.0 are supported. The books
+// are added in the same way as the commands of Import, and an *ImportError
+// reports the number of the product in the feed.
+func (l *Library) ImportONIX(r io.Reader, opts ImportOptions) error {
+	if ok, err := l.dryRun(opts, func(c *Library, opts ImportOptions) error {
+		return c.ImportONIX(r, opts)
+	}); ok {
+		return err
+	}
+
+	products, err := readONIX(r)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]int)
+	next := 1
+
+	l.EachBook(func(book *Book) {
+		if book.ISBN != "" {
+			ids[book.ISBN] = book.ID
+		}
+
+		next = max(next, book.ID+1)
+	})
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+
+	for _, product := range products {
+		cmd := product.addBook()
+
+		id, ok := ids[cmd.ISBN]
+		if !ok || cmd.ISBN == "" {
+			id = next
+			next++
+
+			if cmd.ISBN != "" {
+				ids[cmd.ISBN] = id
+			}
+		}
+
+		cmd.ID = id
+
+		if err := enc.Encode(&Invocation{Command: cmd}); err != nil {
+			return fmt.Errorf("failed to import ONIX, %w", err)
+		}
+	}
+
+	return l.Import(&buf, opts)
+}
+
+// readONIX reads the products of an ONIX 3.0 feed, other than those whose
+// notification is a deletion.
+func readONIX(r io.Reader) ([]*onixProduct, error) {
+	raw := xml.NewDecoder(r)
+	dec := xml.NewTokenDecoder(onixTokenReader{raw})
+
+	var products []*onixProduct
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return products, nil
+		}
+
+		if err != nil {
+			line, _ := raw.InputPos()
+
+			return nil, fmt.Errorf("%w, failed to read ONIX at line %d, %w", ErrInvalidArgument, line, err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Product" {
+			continue
+		}
+
+		var product onixProduct
+
+		if err := dec.DecodeElement(&product, &start); err != nil {
+			line, _ := raw.InputPos()
+
+			return nil, fmt.Errorf("%w, failed to read ONIX product at line %d, %w", ErrInvalidArgument, line, err)
+		}
+
+		// Notification type 05 is the deletion of a product.
+		if strings.TrimSpace(product.NotificationType) != "05" {
+			products = append(products, &product)
+		}
+	}
+}
+
+// onixTokenReader renames the short tags of ONIX 3.0 to their reference names
+// and drops the namespaces of the elements.
+type onixTokenReader struct {
+	dec *xml.Decoder
+}
+
+// Token implements xml.TokenReader.
+func (r onixTokenReader) Token() (xml.Token, error) {
+	tok, err := r.dec.Token()
+
+	switch t := tok.(type) {
+	case xml.StartElement:
+		t.Name = onixName(t.Name)
+		tok = t
+	case xml.EndElement:
+		t.Name = onixName(t.Name)
+		tok = t
+	}
+
+	return tok, err
+}
+
+// onixName returns the reference name of an element name.
+func onixName(name xml.Name) xml.Name {
+	if ref, ok := onixShortTags[name.Local]; ok {
+		return xml.Name{Local: ref}
+	}
+
+	return xml.Name{Local: name.Local}
+}
+
+// addBook returns the ADD_BOOK command of the product, without an ID.
+func (p *onixProduct) addBook() *AddBook {
+	cmd := &AddBook{
+		Name: p.title(),
+		ISBN: p.isbn(),
+	}
+
+	var authors []string
+
+	for _, c := range p.Contributors {
+		// Role A01 is "By (author)".
+		if !containsTrimmed(c.Roles, "A01") {
+			continue
+		}
+
+		name := strings.TrimSpace(c.PersonName)
+		if name == "" {
+			name = strings.TrimSpace(strings.TrimSpace(c.NamesBeforeKey) + " " + strings.TrimSpace(c.KeyNames))
+		}
+
+		if name == "" {
+			name = strings.TrimSpace(c.CorporateName)
+		}
+
+		if name != "" {
+			authors = append(authors, name)
+		}
+	}
+
+	cmd.Author = strings.Join(authors, ", ")
+
+	for _, s := range p.Subjects {
+		for _, tag := range strings.Split(s.HeadingText, ";") {
+			if tag = strings.TrimSpace(tag); tag != "" && !containsTrimmed(cmd.Tags, tag) {
+				cmd.Tags = append(cmd.Tags, tag)
+			}
+		}
+	}
+
+	return cmd
+}
+
+// title returns the distinctive title of the product, title type 01, at the
+// product level, element level 01, including its subtitle.
+func (p *onixProduct) title() string {
+	for _, t := range p.Titles {
+		if strings.TrimSpace(t.Type) != "01" {
+			continue
+		}
+
+		for _, e := range t.Elements {
+			if strings.TrimSpace(e.Level) != "01" {
+				continue
+			}
+
+			title := strings.TrimSpace(e.Text)
+			if title == "" {
+				title = strings.TrimSpace(strings.TrimSpace(e.Prefix) + " " + strings.TrimSpace(e.WithoutPrefix))
+			}
+
+			if subtitle := strings.TrimSpace(e.Subtitle); subtitle != "" {
+				title += ": " + subtitle
+			}
+
+			return title
+		}
+	}
+
+	return ""
+}
+
+// isbn returns the ISBN-13 of the product, from its ISBN-13 identifier, type
+// 15, or its GTIN-13 identifier, type 03, if that is a Bookland EAN.
+func (p *onixProduct) isbn() string {
+	var gtin string
+
+	for _, id := range p.Identifiers {
+		value := strings.TrimSpace(id.Value)
+
+		switch strings.TrimSpace(id.Type) {
+		case "15":
+			return value
+		case "03":
+			if strings.HasPrefix(value, "978") || strings.HasPrefix(value, "979") {
+				gtin = value
+			}
+		}
+	}
+
+	return gtin
+}
+
+// containsTrimmed reports whether the values contain the value, ignoring
+// surrounding whitespace.
+func containsTrimmed(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+package library
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Option configures a Library created by New.
+type Option func(l *Library)
+
+// WithPolicy sets the circulation policy of the library, see SetPolicy. The
+// policy is part of the state of the library, so a state loaded afterwards,
+// e.g. by a Store, replaces it with its own.
+func WithPolicy(policy Policy) Option {
+	return func(l *Library) {
+		l.policy = policy
+	}
+}
+
+// WithCheckoutLimit sets the checkout limit of the policy of the library, see
+// WithPolicy.
+func WithCheckoutLimit(limit int) Option {
+	return func(l *Library) {
+		l.policy.CheckoutLimit = limit
+	}
+}
+
+// WithLoanPeriod sets the loan period of the policy of the library, see
+// WithPolicy.
+func WithLoanPeriod(period time.Duration) Option {
+	return func(l *Library) {
+		l.policy.LoanPeriod = period
+	}
+}
+
+// WithFineRate sets the fine rate of the policy of the library, see
+// WithPolicy.
+func WithFineRate(rate int) Option {
+	return func(l *Library) {
+		l.policy.FineRate = rate
+	}
+}
+
+// WithHoldWindow sets the hold window of the policy of the library, see
+// WithPolicy.
+func WithHoldWindow(window time.Duration) Option {
+	return func(l *Library) {
+		l.policy.HoldWindow = window
+	}
+}
+
+// WithQuota sets the quota of the library, see SetQuota.
+func WithQuota(quota Quota) Option {
+	return func(l *Library) {
+		l.quota = quota
+	}
+}
+
+// WithClock sets the clock of the library, see SetClock.
+func WithClock(clock Clock) Option {
+	return func(l *Library) {
+		l.clock = clock
+	}
+}
+
+// WithLogger sets the logger of the library, see SetLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Library) {
+		l.logger = logger
+	}
+}
+
+// WithEventHandler sets a function called with the events of each change made
+// by a command as it is executed, e.g. a checkout.created event for a
+// CHECKOUT_BOOK, see EventsOf. As with Watch, the changes made by calling the
+// methods of the library directly are not commands and have no events. The
+// handler is called synchronously by the command that made the change, without
+// the library locked, so it may read the library but should not block; see
+// Watch to receive the changes on a channel instead.
+func WithEventHandler(fn func(event Event)) Option {
+	return func(l *Library) {
+		l.onEvent = fn
+	}
+}
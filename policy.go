@@ -0,0 +1,143 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Policy represents the circulation policy of the library.
+type Policy struct {
+	// CheckoutLimit is the maximum number of books an account may have
+	// checked out at a time.
+	CheckoutLimit int
+	// LoanPeriod is the length of time a book may be checked out before
+	// it is due back.
+	LoanPeriod time.Duration
+	// FineRate is the fine in cents charged for each day a book is
+	// returned past its due date.
+	FineRate int
+	// HoldWindow is the length of time a hold stays in the hold queue of
+	// a book after it is placed before it expires, so that the copies are
+	// not held for an account that no longer wants the book, see
+	// PlaceHold. Holds never expire if it is zero.
+	HoldWindow time.Duration
+}
+
+// DefaultPolicy returns the policy of a new library.
+func DefaultPolicy() Policy {
+	return Policy{
+		CheckoutLimit: DefaultCheckoutLimit,
+		LoanPeriod:    DefaultLoanPeriod,
+		FineRate:      DefaultFineRate,
+	}
+}
+
+// Policy returns the circulation policy of the library.
+func (l *Library) Policy() Policy {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.policy
+}
+
+// SetPolicy sets the circulation policy of the library.
+//
+// The policy applies to checkouts and returns made afterwards, existing
+// checkouts keep their due dates, while the hold window applies to the holds
+// already placed. The checkout limit and loan period must be positive and the
+// fine rate and hold window must not be negative.
+func (l *Library) SetPolicy(policy Policy) error {
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev := l.policy
+
+	l.policy = policy
+
+	l.pushUndo("set policy", func() {
+		l.policy = prev
+	})
+
+	return nil
+}
+
+// validatePolicy returns an error if the policy is invalid, see SetPolicy.
+func validatePolicy(policy Policy) error {
+	if policy.CheckoutLimit <= 0 {
+		return fmt.Errorf("%w, checkout limit must be positive", ErrInvalidArgument)
+	}
+
+	if policy.LoanPeriod <= 0 {
+		return fmt.Errorf("%w, loan period must be positive", ErrInvalidArgument)
+	}
+
+	if policy.FineRate < 0 {
+		return fmt.Errorf("%w, fine rate must not be negative", ErrInvalidArgument)
+	}
+
+	if policy.HoldWindow < 0 {
+		return fmt.Errorf("%w, hold window must not be negative", ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+// policyJSON is the JSON representation of a Policy, with the loan period and
+// hold window in the same format as the SET_POLICY command.
+type policyJSON struct {
+	CheckoutLimit int    `json:"checkoutLimit"`
+	LoanPeriod    string `json:"loanPeriod"`
+	FineRate      int    `json:"fineRate"`
+	HoldWindow    string `json:"holdWindow,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Policy) MarshalJSON() ([]byte, error) {
+	pj := policyJSON{
+		CheckoutLimit: p.CheckoutLimit,
+		LoanPeriod:    formatDuration(p.LoanPeriod),
+		FineRate:      p.FineRate,
+	}
+
+	if p.HoldWindow != 0 {
+		pj.HoldWindow = formatDuration(p.HoldWindow)
+	}
+
+	return json.Marshal(pj)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Policy) UnmarshalJSON(bs []byte) error {
+	var pj policyJSON
+
+	if err := json.Unmarshal(bs, &pj); err != nil {
+		return err
+	}
+
+	d, err := parseDuration(pj.LoanPeriod)
+	if err != nil {
+		return fmt.Errorf("invalid loan period, %w", err)
+	}
+
+	var window time.Duration
+
+	if pj.HoldWindow != "" {
+		if window, err = parseDuration(pj.HoldWindow); err != nil {
+			return fmt.Errorf("invalid hold window, %w", err)
+		}
+	}
+
+	*p = Policy{
+		CheckoutLimit: pj.CheckoutLimit,
+		LoanPeriod:    d,
+		FineRate:      pj.FineRate,
+		HoldWindow:    window,
+	}
+
+	return nil
+}
@@ -0,0 +1,472 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ExportProto writes the library state to a writer as a protocol buffer
+// Snapshot message, see proto/library.proto.
+//
+// Unlike gob, the protocol buffer format can be read by programs in other
+// languages with the schema, e.g. by other services consuming the state.
+func (l *Library) ExportProto(w io.Writer) error {
+	return writeProto(w, l.Snapshot())
+}
+
+// writeProto writes the snapshot to a writer as a protocol buffer message, see
+// ExportProto.
+func writeProto(w io.Writer, s *Snapshot) error {
+	var e protoEncoder
+
+	e.snapshot(s)
+
+	if _, err := w.Write(e.b); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	return nil
+}
+
+// ImportProto reads a protocol buffer Snapshot message written by ExportProto
+// and replaces the state of the library with it, in the same way as
+// ImportSnapshot.
+func (l *Library) ImportProto(r io.Reader) error {
+	bs, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if bs, err = stripChecksum(bs); err != nil {
+		return err
+	}
+
+	var s Snapshot
+
+	if err := decodeSnapshot(bs, &s); err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if err := checkVersion(s.Version); err != nil {
+		return err
+	}
+
+	// There are no migrations that change the fields of the schema, so a
+	// snapshot of an older version is loaded as is.
+	s.Version = SnapshotVersion
+
+	return l.LoadSnapshot(&s)
+}
+
+// protoEncoder appends the fields of protocol buffer messages to a buffer.
+//
+// The messages are encoded by hand from the schema in proto/library.proto
+// rather than with generated code. Fields with the zero value are omitted as
+// in proto3.
+type protoEncoder struct {
+	b []byte
+}
+
+func (e *protoEncoder) varint(num protowire.Number, v int64) {
+	if v == 0 {
+		return
+	}
+
+	e.b = protowire.AppendTag(e.b, num, protowire.VarintType)
+	e.b = protowire.AppendVarint(e.b, uint64(v))
+}
+
+func (e *protoEncoder) string(num protowire.Number, s string) {
+	if s == "" {
+		return
+	}
+
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendString(e.b, s)
+}
+
+func (e *protoEncoder) message(num protowire.Number, encode func(e *protoEncoder)) {
+	var m protoEncoder
+
+	encode(&m)
+
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendBytes(e.b, m.b)
+}
+
+// timestamp encodes a google.protobuf.Timestamp, omitted if t is zero.
+func (e *protoEncoder) timestamp(num protowire.Number, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	e.message(num, func(e *protoEncoder) {
+		e.varint(1, t.Unix())
+		e.varint(2, int64(t.Nanosecond()))
+	})
+}
+
+// duration encodes a google.protobuf.Duration.
+func (e *protoEncoder) duration(num protowire.Number, d time.Duration) {
+	e.message(num, func(e *protoEncoder) {
+		e.varint(1, int64(d/time.Second))
+		e.varint(2, int64(d%time.Second))
+	})
+}
+
+func (e *protoEncoder) snapshot(s *Snapshot) {
+	e.varint(1, int64(s.Version))
+
+	e.message(2, func(e *protoEncoder) {
+		e.varint(1, int64(s.Policy.CheckoutLimit))
+		e.duration(2, s.Policy.LoanPeriod)
+		e.varint(3, int64(s.Policy.FineRate))
+
+		if s.Policy.HoldWindow != 0 {
+			e.duration(4, s.Policy.HoldWindow)
+		}
+	})
+
+	for _, book := range s.Books {
+		e.message(3, func(e *protoEncoder) {
+			e.varint(1, int64(book.ID))
+			e.string(2, book.Name)
+			e.varint(3, int64(book.Count))
+			e.string(4, book.Author)
+			e.string(5, book.ISBN)
+
+			for _, tag := range book.Tags {
+				e.b = protowire.AppendTag(e.b, 6, protowire.BytesType)
+				e.b = protowire.AppendString(e.b, tag)
+			}
+
+			e.varint(7, int64(book.Version))
+		})
+	}
+
+	for _, account := range s.Accounts {
+		e.message(4, func(e *protoEncoder) {
+			e.varint(1, int64(account.ID))
+			e.string(2, account.Name)
+			e.varint(3, int64(account.Balance))
+			e.varint(4, int64(account.Version))
+		})
+	}
+
+	for _, checkout := range s.Checkouts {
+		e.message(5, func(e *protoEncoder) {
+			e.varint(1, int64(checkout.BookID))
+			e.varint(2, int64(checkout.AccountID))
+			e.timestamp(3, checkout.CheckedOut)
+			e.timestamp(4, checkout.Due)
+			e.timestamp(5, checkout.Returned)
+		})
+	}
+
+	for _, macro := range s.Macros {
+		e.message(6, func(e *protoEncoder) {
+			e.string(1, macro.Name)
+
+			for _, param := range macro.Params {
+				e.b = protowire.AppendTag(e.b, 2, protowire.BytesType)
+				e.b = protowire.AppendString(e.b, param)
+			}
+
+			for _, cmd := range macro.Commands {
+				e.b = protowire.AppendTag(e.b, 3, protowire.BytesType)
+				e.b = protowire.AppendBytes(e.b, cmd)
+			}
+		})
+	}
+
+	if s.Inventory != nil {
+		e.message(7, func(e *protoEncoder) {
+			e.timestamp(1, s.Inventory.Started)
+
+			for _, barcode := range s.Inventory.Barcodes {
+				e.b = protowire.AppendTag(e.b, 2, protowire.BytesType)
+				e.b = protowire.AppendString(e.b, barcode)
+			}
+		})
+	}
+
+	for _, hold := range s.Holds {
+		e.message(8, func(e *protoEncoder) {
+			e.varint(1, int64(hold.BookID))
+			e.varint(2, int64(hold.AccountID))
+			e.timestamp(3, hold.Placed)
+		})
+	}
+}
+
+// protoField represents a decoded field of a protocol buffer message, Varint
+// for varint fields and Bytes for length-delimited fields.
+type protoField struct {
+	Num    protowire.Number
+	Varint int64
+	Bytes  []byte
+}
+
+// decodeFields calls fn for each varint and length-delimited field of a
+// protocol buffer message. Fields of other types are skipped, e.g. fields
+// added to the schema with types this version does not use.
+func decodeFields(b []byte, fn func(f protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		f := protoField{Num: num}
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			f.Varint = int64(v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			f.Bytes = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			b = b[n:]
+
+			continue
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp.
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var sec, nsec int64
+
+	err := decodeFields(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			sec = f.Varint
+		case 2:
+			nsec = f.Varint
+		}
+
+		return nil
+	})
+
+	return time.Unix(sec, nsec).UTC(), err
+}
+
+// decodeDuration decodes a google.protobuf.Duration.
+func decodeDuration(b []byte) (time.Duration, error) {
+	var d time.Duration
+
+	err := decodeFields(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			d += time.Duration(f.Varint) * time.Second
+		case 2:
+			d += time.Duration(f.Varint)
+		}
+
+		return nil
+	})
+
+	return d, err
+}
+
+func decodeSnapshot(b []byte, s *Snapshot) error {
+	return decodeFields(b, func(f protoField) error {
+		var err error
+
+		switch f.Num {
+		case 1:
+			s.Version = int(f.Varint)
+		case 2:
+			err = decodePolicy(f.Bytes, &s.Policy)
+		case 3:
+			book := &Book{}
+			s.Books = append(s.Books, book)
+			err = decodeBook(f.Bytes, book)
+		case 4:
+			account := &Account{}
+			s.Accounts = append(s.Accounts, account)
+			err = decodeAccount(f.Bytes, account)
+		case 5:
+			checkout := &Checkout{}
+			s.Checkouts = append(s.Checkouts, checkout)
+			err = decodeCheckout(f.Bytes, checkout)
+		case 6:
+			macro := &Macro{}
+			s.Macros = append(s.Macros, macro)
+			err = decodeMacro(f.Bytes, macro)
+		case 7:
+			s.Inventory = &SnapshotInventory{}
+			err = decodeInventory(f.Bytes, s.Inventory)
+		case 8:
+			hold := &Hold{}
+			s.Holds = append(s.Holds, hold)
+			err = decodeHold(f.Bytes, hold)
+		}
+
+		return err
+	})
+}
+
+func decodePolicy(b []byte, p *Policy) error {
+	return decodeFields(b, func(f protoField) error {
+		var err error
+
+		switch f.Num {
+		case 1:
+			p.CheckoutLimit = int(f.Varint)
+		case 2:
+			p.LoanPeriod, err = decodeDuration(f.Bytes)
+		case 3:
+			p.FineRate = int(f.Varint)
+		case 4:
+			p.HoldWindow, err = decodeDuration(f.Bytes)
+		}
+
+		return err
+	})
+}
+
+func decodeBook(b []byte, book *Book) error {
+	return decodeFields(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			book.ID = int(f.Varint)
+		case 2:
+			book.Name = string(f.Bytes)
+		case 3:
+			book.Count = int(f.Varint)
+		case 4:
+			book.Author = string(f.Bytes)
+		case 5:
+			book.ISBN = string(f.Bytes)
+		case 6:
+			book.Tags = append(book.Tags, string(f.Bytes))
+		case 7:
+			book.Version = int(f.Varint)
+		}
+
+		return nil
+	})
+}
+
+func decodeAccount(b []byte, account *Account) error {
+	return decodeFields(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			account.ID = int(f.Varint)
+		case 2:
+			account.Name = string(f.Bytes)
+		case 3:
+			account.Balance = int(f.Varint)
+		case 4:
+			account.Version = int(f.Varint)
+		}
+
+		return nil
+	})
+}
+
+func decodeCheckout(b []byte, checkout *Checkout) error {
+	return decodeFields(b, func(f protoField) error {
+		var err error
+
+		switch f.Num {
+		case 1:
+			checkout.BookID = int(f.Varint)
+		case 2:
+			checkout.AccountID = int(f.Varint)
+		case 3:
+			checkout.CheckedOut, err = decodeTimestamp(f.Bytes)
+		case 4:
+			checkout.Due, err = decodeTimestamp(f.Bytes)
+		case 5:
+			checkout.Returned, err = decodeTimestamp(f.Bytes)
+		}
+
+		return err
+	})
+}
+
+func decodeHold(b []byte, hold *Hold) error {
+	return decodeFields(b, func(f protoField) error {
+		var err error
+
+		switch f.Num {
+		case 1:
+			hold.BookID = int(f.Varint)
+		case 2:
+			hold.AccountID = int(f.Varint)
+		case 3:
+			hold.Placed, err = decodeTimestamp(f.Bytes)
+		}
+
+		return err
+	})
+}
+
+func decodeMacro(b []byte, macro *Macro) error {
+	return decodeFields(b, func(f protoField) error {
+		switch f.Num {
+		case 1:
+			macro.Name = string(f.Bytes)
+		case 2:
+			macro.Params = append(macro.Params, string(f.Bytes))
+		case 3:
+			macro.Commands = append(macro.Commands, append([]byte(nil), f.Bytes...))
+		}
+
+		return nil
+	})
+}
+
+func decodeInventory(b []byte, inv *SnapshotInventory) error {
+	return decodeFields(b, func(f protoField) error {
+		var err error
+
+		switch f.Num {
+		case 1:
+			inv.Started, err = decodeTimestamp(f.Bytes)
+		case 2:
+			inv.Barcodes = append(inv.Barcodes, string(f.Bytes))
+		}
+
+		return err
+	})
+}
+
+// isProto reports whether the contents of a state file are a protocol buffer
+// export.
+//
+// Protocol buffers are not self-describing, but an export always starts with
+// the version field, whose tag is a control character that cannot start any of
+// the text formats.
+func isProto(bs []byte) bool {
+	return len(bs) > 0 && bs[0] == byte(protowire.EncodeTag(1, protowire.VarintType))
+}
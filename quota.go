@@ -0,0 +1,74 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned when adding a book or creating an account
+// would exceed the quota of the library, see SetQuota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the size of a library, e.g. of each tenant of a hosted
+// service. Unlike the Policy, the quota is set by the host of the library
+// rather than by commands, so it is not part of the state of the library.
+type Quota struct {
+	Books    int `json:"books,omitempty"`    // Maximum number of books, unlimited if zero.
+	Accounts int `json:"accounts,omitempty"` // Maximum number of accounts, unlimited if zero.
+}
+
+// Quota returns the quota of the library.
+func (l *Library) Quota() Quota {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.quota
+}
+
+// SetQuota sets the quota of the library, which applies to the books added
+// and accounts created afterwards. A library that already exceeds the quota
+// keeps its books and accounts.
+func (l *Library) SetQuota(quota Quota) error {
+	if err := validateQuota(quota); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.quota = quota
+
+	return nil
+}
+
+// validateQuota returns an error if the quota is invalid, see SetQuota.
+func validateQuota(quota Quota) error {
+	if quota.Books < 0 || quota.Accounts < 0 {
+		return fmt.Errorf("%w, quota must not be negative", ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+// checkBookQuota returns an error if adding a book would exceed the quota.
+//
+// checkBookQuota must be called with the lock held.
+func (l *Library) checkBookQuota() error {
+	if l.quota.Books > 0 && len(l.books) >= l.quota.Books {
+		return &QuotaError{Kind: KindBook, Limit: l.quota.Books}
+	}
+
+	return nil
+}
+
+// checkAccountQuota returns an error if creating an account would exceed the
+// quota.
+//
+// checkAccountQuota must be called with the lock held.
+func (l *Library) checkAccountQuota() error {
+	if l.quota.Accounts > 0 && len(l.accounts) >= l.quota.Accounts {
+		return &QuotaError{Kind: KindAccount, Limit: l.quota.Accounts}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package ratelimit limits the rate of the requests of each client of the
+// servers of a library, so that one client cannot monopolize the library,
+// which executes one command at a time, and starve the others.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/admtnnr/library/apikey"
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long a client makes no requests before its bucket is
+// forgotten, by which time it has refilled.
+const idleTimeout = 10 * time.Minute
+
+// Limiter is a token bucket rate limiter per client. Each client has a bucket
+// of Burst tokens, refilled at Rate tokens per second, and each request takes
+// a token from it, so that a client can make Burst requests at once but only
+// Rate requests per second over time.
+//
+// The clients are identified by the API key of the request if it was
+// authenticated by one, see apikey.FromContext, or else by its IP address. The
+// servers limit the requests before they are authenticated, so that the
+// requests with invalid keys are limited too, which identifies their clients
+// by IP address.
+type Limiter struct {
+	Rate  float64 // Requests per second of each client.
+	Burst int     // Requests a client can make at once, at least 1.
+
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time // Time the idle clients were last forgotten.
+}
+
+// client is the bucket of a client.
+type client struct {
+	limiter *rate.Limiter
+	seen    time.Time // Time of the last request.
+}
+
+// Allow takes a token from the bucket of the client, reporting whether it had
+// one and, if not, how long until it does.
+func (l *Limiter) Allow(clientID string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if l.clients == nil {
+		l.clients = make(map[string]*client)
+	}
+
+	if now.Sub(l.swept) > idleTimeout {
+		for id, c := range l.clients {
+			if now.Sub(c.seen) > idleTimeout {
+				delete(l.clients, id)
+			}
+		}
+
+		l.swept = now
+	}
+
+	c, ok := l.clients[clientID]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(l.Rate), max(l.Burst, 1))}
+		l.clients[clientID] = c
+	}
+
+	c.seen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// ClientID returns the ID of the client of a request with the context and
+// remote address, see Limiter.
+func ClientID(ctx context.Context, addr string) string {
+	if key, ok := apikey.FromContext(ctx); ok {
+		return "key:" + key.ID
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return "ip:" + addr
+}
+
+// Handler returns a handler that serves the requests of the clients with
+// tokens with h, and responds to the others with 429 Too Many Requests and a
+// Retry-After header of the seconds until the client has a token.
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, delay := l.Allow(ClientID(r.Context(), r.RemoteAddr))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(RetryAfter(delay)))
+			http.Error(w, fmt.Sprintf("rate limit exceeded, retry after %s", delay.Round(time.Millisecond)), http.StatusTooManyRequests)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RetryAfter returns the whole seconds of a delay, rounded up, e.g. for a
+// Retry-After header.
+func RetryAfter(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
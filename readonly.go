@@ -0,0 +1,52 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned when a command that changes the library is executed
+// against a read-only library, see SetReadOnly.
+var ErrReadOnly = errors.New("library is read-only")
+
+// ReadOnlyCommand is implemented by the Commands that only read the library,
+// e.g. PRINT_BOOK, which are the only commands a read-only library executes,
+// see SetReadOnly.
+type ReadOnlyCommand interface {
+	// ReadOnly marks the command as one that does not change the
+	// library.
+	ReadOnly()
+}
+
+// ReadOnly reports whether the library is read-only, see SetReadOnly.
+func (l *Library) ReadOnly() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.readOnly
+}
+
+// SetReadOnly sets whether the library is read-only. A read-only library only
+// executes the Commands that implement ReadOnlyCommand and rejects the others
+// with ErrReadOnly, e.g. to inspect a production DB without any risk of
+// changing it. The commands of a CALL_MACRO or INCLUDE are checked in turn.
+//
+// Only the execution of commands is checked, the methods of the library can
+// still change it, and a library is loaded by executing commands, so it must
+// be loaded before it is made read-only.
+func (l *Library) SetReadOnly(readOnly bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.readOnly = readOnly
+}
+
+// checkReadOnly returns an error wrapping ErrReadOnly if the library is
+// read-only and the command may change it.
+func (l *Library) checkReadOnly(name string, cmd any) error {
+	if _, ok := cmd.(ReadOnlyCommand); ok || !l.ReadOnly() {
+		return nil
+	}
+
+	return fmt.Errorf("%w, %s changes the library", ErrReadOnly, name)
+}
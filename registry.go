@@ -0,0 +1,173 @@
+package library
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CommandFactory returns a new zero value Command to unmarshal the arguments of
+// a command into.
+//
+// GOTCHA: The Command *MUST* be a pointer to a concrete type to enable the
+// `json.Decoder` logic to use reflection to determine the concrete type of the
+// command to know which struct fields to unmarshal the arguments into.
+//
+// `Invocation.Command` is an `interface{}` (`any`) type so taking a pointer to
+// `inv.Command` results in the `json.Decoder` receiving a `*interface{}`
+// causing it to unmarshal incorrectly.
+//
+// The arguments of a command are the JSON fields of its Command type. Fields
+// tagged with omitempty are optional and all other fields are required, and
+// arguments that do not match a field are rejected. Commands may implement
+// Validator to check their arguments further.
+type CommandFactory func() any
+
+// CommandHandler executes a Command created by the CommandFactory registered
+// with it against the Library and returns the human readable output of the
+// execution.
+//
+// The output should be set for both success and failure so that the user is
+// informed of the outcome either way.
+type CommandHandler func(l *Library, cmd any) (string, error)
+
+// execHandler executes a command as of the time it is executed at, see
+// Invocation.At, rather than the time according to the library clock, so that
+// a command executed again, e.g. replayed from ExportSince, is applied as it
+// was executed.
+type execHandler func(l *Library, cmd any, at time.Time) (string, error)
+
+// command represents a registered command.
+type command struct {
+	name    string
+	factory CommandFactory
+	handler execHandler
+}
+
+var (
+	commandsMu     sync.RWMutex
+	commandsByName = make(map[string]*command)
+	commandsByType = make(map[reflect.Type]*command)
+)
+
+// RegisterCommand registers a command by name so that it can be parsed,
+// executed, and serialized by an Invocation.
+//
+// The factory determines the concrete Command type the arguments of the
+// command are unmarshaled into and the handler executes it. Each command name
+// and Command type can only be registered once, RegisterCommand panics if
+// either is already registered or if the factory does not return a pointer.
+//
+// RegisterCommand is intended to be called from an init function, in the same
+// way the built-in commands are registered.
+func RegisterCommand(name string, factory CommandFactory, handler CommandHandler) {
+	if handler == nil {
+		panic(fmt.Sprintf("library: register command %s, nil factory or handler", name))
+	}
+
+	registerCommand(name, factory, func(l *Library, cmd any, _ time.Time) (string, error) {
+		return handler(l, cmd)
+	})
+}
+
+// registerCommand registers a command by name with a handler of the time it is
+// executed at, see RegisterCommand.
+func registerCommand(name string, factory CommandFactory, handler execHandler) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	if factory == nil || handler == nil {
+		panic(fmt.Sprintf("library: register command %s, nil factory or handler", name))
+	}
+
+	t := reflect.TypeOf(factory())
+	if t == nil || t.Kind() != reflect.Pointer {
+		panic(fmt.Sprintf("library: register command %s, factory must return a pointer, got %v", name, t))
+	}
+
+	if _, ok := commandsByName[name]; ok {
+		panic(fmt.Sprintf("library: register command %s, name already registered", name))
+	}
+
+	if c, ok := commandsByType[t]; ok {
+		panic(fmt.Sprintf("library: register command %s, type %v already registered as %s", name, t, c.name))
+	}
+
+	c := &command{
+		name:    name,
+		factory: factory,
+		handler: handler,
+	}
+
+	commandsByName[name] = c
+	commandsByType[t] = c
+}
+
+// register registers a built-in command with a handler for its concrete
+// Command type, which avoids a type assertion in every handler.
+func register[T any](name string, handler func(l *Library, cmd *T) (string, error)) {
+	registerCommand(
+		name,
+		func() any { return new(T) },
+		func(l *Library, cmd any, _ time.Time) (string, error) { return handler(l, cmd.(*T)) },
+	)
+}
+
+// registerAt registers a built-in command with a handler of the time it is
+// executed at, for the commands that use the current time, e.g. CHECKOUT_BOOK.
+func registerAt[T any](name string, handler func(l *Library, cmd *T, at time.Time) (string, error)) {
+	registerCommand(
+		name,
+		func() any { return new(T) },
+		func(l *Library, cmd any, at time.Time) (string, error) { return handler(l, cmd.(*T), at) },
+	)
+}
+
+// commandByName returns the command registered with the name.
+func commandByName(name string) (*command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	c, ok := commandsByName[name]
+
+	return c, ok
+}
+
+// commandByType returns the command registered for the concrete type of cmd.
+func commandByType(cmd any) (*command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	c, ok := commandsByType[reflect.TypeOf(cmd)]
+
+	return c, ok
+}
+
+// CommandNames returns the names of the registered commands, sorted.
+func CommandNames() []string {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	names := make([]string, 0, len(commandsByName))
+
+	for name := range commandsByName {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// NewCommand returns a new zero value Command of the command registered with
+// the name, to unmarshal its arguments into, or false if there is none.
+func NewCommand(name string) (any, bool) {
+	c, ok := commandByName(name)
+	if !ok {
+		return nil, false
+	}
+
+	return c.factory(), true
+}
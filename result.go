@@ -0,0 +1,190 @@
+package library
+
+import "errors"
+
+// Status is the outcome of the execution of a Command.
+type Status string
+
+const (
+	// StatusOK is the Status of a Command that executed successfully.
+	StatusOK Status = "ok"
+	// StatusError is the Status of a Command that failed.
+	StatusError Status = "error"
+)
+
+// Result represents the machine-readable outcome of the execution of a Command,
+// the counterpart of the human readable Invocation.Output.
+type Result struct {
+	// Command is the name of the command that was executed.
+	Command string `json:"command"`
+	// Status is the outcome of the execution.
+	Status Status `json:"status"`
+	// ErrorCode identifies the kind of failure if the execution failed.
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	// Error is the error message if the execution failed.
+	Error string `json:"error,omitempty"`
+	// BookIDs are the IDs of the books affected by the command.
+	BookIDs []int `json:"bookIds,omitempty"`
+	// AccountIDs are the IDs of the accounts affected by the command.
+	AccountIDs []int `json:"accountIds,omitempty"`
+	// Line is the line of the command in the input of an import, set in
+	// the results written to ImportOptions.ResultWriter, e.g. to report
+	// the progress of a commands file.
+	Line int `json:"line,omitempty"`
+}
+
+// Entities represents the entities affected by a Command.
+type Entities struct {
+	BookIDs    []int
+	AccountIDs []int
+}
+
+// EntityCommand is implemented by Commands that affect specific books or
+// accounts so that the entities can be reported in the Result.
+//
+// Custom commands registered with RegisterCommand may implement EntityCommand
+// to report their affected entities in the same way as the built-in commands.
+type EntityCommand interface {
+	Entities() Entities
+}
+
+// newResult returns the Result of the execution of a Command.
+func newResult(name string, cmd any, err error) Result {
+	result := Result{
+		Command: name,
+		Status:  StatusOK,
+	}
+
+	if ec, ok := cmd.(EntityCommand); ok {
+		entities := ec.Entities()
+
+		result.BookIDs = entities.BookIDs
+		result.AccountIDs = entities.AccountIDs
+	}
+
+	if err != nil {
+		result.Status = StatusError
+		result.ErrorCode = ErrorCodeOf(err)
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// ErrorCode identifies the kind of failure of a Command so that callers can
+// branch on the failure rather than matching error messages.
+//
+// Error codes are stable, new codes may be added but existing codes are not
+// changed or removed.
+type ErrorCode string
+
+const (
+	// CodeBookNotFound is the ErrorCode of ErrBookNotExist.
+	CodeBookNotFound ErrorCode = "BOOK_NOT_FOUND"
+	// CodeAccountNotFound is the ErrorCode of ErrAccountNotExist.
+	CodeAccountNotFound ErrorCode = "ACCOUNT_NOT_FOUND"
+	// CodeCheckoutNotFound is the ErrorCode of ErrCheckoutNotExist.
+	CodeCheckoutNotFound ErrorCode = "CHECKOUT_NOT_FOUND"
+	// CodeMacroNotFound is the ErrorCode of ErrMacroNotExist.
+	CodeMacroNotFound ErrorCode = "MACRO_NOT_FOUND"
+	// CodeHoldNotFound is the ErrorCode of ErrHoldNotExist.
+	CodeHoldNotFound ErrorCode = "HOLD_NOT_FOUND"
+	// CodeDuplicateID is the ErrorCode of ErrDuplicateID.
+	CodeDuplicateID ErrorCode = "DUPLICATE_ID"
+	// CodeLimitExceeded is the ErrorCode of ErrLimitExceeded.
+	CodeLimitExceeded ErrorCode = "LIMIT_EXCEEDED"
+	// CodeAlreadyCheckedOut is the ErrorCode of ErrAlreadyCheckedOut.
+	CodeAlreadyCheckedOut ErrorCode = "ALREADY_CHECKED_OUT"
+	// CodeNotEnoughCopies is the ErrorCode of ErrNotEnoughCopies.
+	CodeNotEnoughCopies ErrorCode = "NOT_ENOUGH_COPIES"
+	// CodeInventoryNotStarted is the ErrorCode of ErrInventoryNotStarted.
+	CodeInventoryNotStarted ErrorCode = "INVENTORY_NOT_STARTED"
+	// CodeInventoryInProgress is the ErrorCode of ErrInventoryInProgress.
+	CodeInventoryInProgress ErrorCode = "INVENTORY_IN_PROGRESS"
+	// CodeNothingToUndo is the ErrorCode of ErrNothingToUndo.
+	CodeNothingToUndo ErrorCode = "NOTHING_TO_UNDO"
+	// CodeAssertionFailed is the ErrorCode of ErrAssertionFailed.
+	CodeAssertionFailed ErrorCode = "ASSERTION_FAILED"
+	// CodeInvalidArguments is the ErrorCode of a *ValidationError and of
+	// ErrInvalidArgument.
+	CodeInvalidArguments ErrorCode = "INVALID_ARGUMENTS"
+	// CodeInvalidCommand is the ErrorCode of ErrInvalidCommand.
+	CodeInvalidCommand ErrorCode = "INVALID_COMMAND"
+	// CodeIncludeCycle is the ErrorCode of ErrIncludeCycle.
+	CodeIncludeCycle ErrorCode = "INCLUDE_CYCLE"
+	// CodeNotConfirmed is the ErrorCode of ErrNotConfirmed.
+	CodeNotConfirmed ErrorCode = "NOT_CONFIRMED"
+	// CodeCorrupt is the ErrorCode of ErrCorrupt.
+	CodeCorrupt ErrorCode = "CORRUPT"
+	// CodeEncrypted is the ErrorCode of ErrEncrypted.
+	CodeEncrypted ErrorCode = "ENCRYPTED"
+	// CodeQuotaExceeded is the ErrorCode of ErrQuotaExceeded.
+	CodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+	// CodeReadOnly is the ErrorCode of ErrReadOnly.
+	CodeReadOnly ErrorCode = "READ_ONLY"
+	// CodeFileAccess is the ErrorCode of ErrFileAccess.
+	CodeFileAccess ErrorCode = "FILE_ACCESS"
+	// CodeVersionMismatch is the ErrorCode of ErrVersionMismatch.
+	CodeVersionMismatch ErrorCode = "VERSION_MISMATCH"
+	// CodeFailed is the ErrorCode of any other failure.
+	CodeFailed ErrorCode = "FAILED"
+)
+
+// ErrorCodeOf returns the ErrorCode for an error returned by the Library or by
+// the execution of a Command, or an empty ErrorCode if the error is nil.
+func ErrorCodeOf(err error) ErrorCode {
+	var verr *ValidationError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrBookNotExist):
+		return CodeBookNotFound
+	case errors.Is(err, ErrAccountNotExist):
+		return CodeAccountNotFound
+	case errors.Is(err, ErrCheckoutNotExist):
+		return CodeCheckoutNotFound
+	case errors.Is(err, ErrMacroNotExist):
+		return CodeMacroNotFound
+	case errors.Is(err, ErrHoldNotExist):
+		return CodeHoldNotFound
+	case errors.Is(err, ErrDuplicateID):
+		return CodeDuplicateID
+	case errors.Is(err, ErrLimitExceeded):
+		return CodeLimitExceeded
+	case errors.Is(err, ErrAlreadyCheckedOut):
+		return CodeAlreadyCheckedOut
+	case errors.Is(err, ErrNotEnoughCopies):
+		return CodeNotEnoughCopies
+	case errors.Is(err, ErrInventoryNotStarted):
+		return CodeInventoryNotStarted
+	case errors.Is(err, ErrInventoryInProgress):
+		return CodeInventoryInProgress
+	case errors.Is(err, ErrNothingToUndo):
+		return CodeNothingToUndo
+	case errors.Is(err, ErrAssertionFailed):
+		return CodeAssertionFailed
+	case errors.Is(err, ErrIncludeCycle):
+		return CodeIncludeCycle
+	case errors.Is(err, ErrNotConfirmed):
+		return CodeNotConfirmed
+	case errors.Is(err, ErrCorrupt):
+		return CodeCorrupt
+	case errors.Is(err, ErrEncrypted):
+		return CodeEncrypted
+	case errors.Is(err, ErrQuotaExceeded):
+		return CodeQuotaExceeded
+	case errors.Is(err, ErrReadOnly):
+		return CodeReadOnly
+	case errors.Is(err, ErrFileAccess):
+		return CodeFileAccess
+	case errors.Is(err, ErrVersionMismatch):
+		return CodeVersionMismatch
+	case errors.As(err, &verr), errors.Is(err, ErrInvalidArgument):
+		return CodeInvalidArguments
+	case errors.Is(err, ErrInvalidCommand):
+		return CodeInvalidCommand
+	default:
+		return CodeFailed
+	}
+}
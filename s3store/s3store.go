@@ -0,0 +1,245 @@
+// Package s3store provides a library.Store backed by S3-compatible object
+// storage, so that the state can be persisted without a local file system.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/admtnnr/library"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// stateObject is the name of the object of the library state under the
+// prefix.
+const stateObject = "state.db"
+
+var (
+	// ErrConflict is returned by Save when the state was saved by another
+	// writer since it was loaded.
+	ErrConflict = errors.New("state was modified since it was loaded")
+)
+
+// Options provides the options for opening a Store.
+type Options struct {
+	// Endpoint is the URL of the S3-compatible service, e.g.
+	// "http://localhost:9000" for MinIO. If empty, the AWS endpoint of the
+	// region is used.
+	Endpoint string
+	// Region is the region of the bucket, "us-east-1" if empty.
+	Region string
+	// Bucket is the name of the bucket the state is stored in.
+	Bucket string
+	// Prefix is prepended to the name of the state object, e.g.
+	// "branches/main/" to store the state of several libraries in the
+	// same bucket.
+	Prefix string
+	// PathStyle addresses the bucket in the path rather than the host
+	// name, which most S3-compatible services other than AWS require.
+	PathStyle bool
+	// Credentials provides the credentials to sign requests with. If nil,
+	// the credentials are read from the AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+	Credentials aws.CredentialsProvider
+}
+
+// Store is a library.Store backed by an object in S3-compatible object
+// storage.
+//
+// Saves are conditional on the object being unchanged since it was loaded, so
+// that concurrent writers cannot silently overwrite each other's state; the
+// writer that saves second fails with ErrConflict and must load the state
+// again. Objects are replaced as a whole, so the state is only saved on Save
+// rather than after every command.
+type Store struct {
+	client *s3.Client
+	bucket string
+	key    string
+
+	// etag is the entity tag of the object as of the last Load or Save,
+	// nil if the object did not exist.
+	etag *string
+}
+
+// Open returns a Store for the state object in the bucket of the options.
+//
+// The bucket is not accessed until the state is loaded.
+func Open(opts Options) (*Store, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("%w, bucket must not be empty", library.ErrInvalidArgument)
+	}
+
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	creds := opts.Credentials
+	if creds == nil {
+		creds = aws.CredentialsProviderFunc(envCredentials)
+	}
+
+	client := s3.New(s3.Options{
+		Region:       region,
+		Credentials:  aws.NewCredentialsCache(creds),
+		UsePathStyle: opts.PathStyle,
+	}, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+
+	return &Store{
+		client: client,
+		bucket: opts.Bucket,
+		key:    opts.Prefix + stateObject,
+	}, nil
+}
+
+// envCredentials reads the credentials from the standard AWS environment
+// variables.
+func envCredentials(context.Context) (aws.Credentials, error) {
+	creds := aws.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "environment",
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	return creds, nil
+}
+
+// Load implements library.Store. A missing object loads nothing.
+func (s *Store) Load(l *library.Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements library.ContextStore. The object is downloaded with
+// the context.
+func (s *Store) LoadContext(ctx context.Context, l *library.Library) error {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+
+	var notFound *types.NoSuchKey
+	if errors.As(err, &notFound) {
+		s.etag = nil
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to get s3://%s/%s, %w", s.bucket, s.key, err)
+	}
+	defer out.Body.Close()
+
+	bs, err := io.ReadAll(out.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read s3://%s/%s, %w", s.bucket, s.key, err)
+	}
+
+	if err := l.ImportContext(ctx, bytes.NewReader(bs), library.ImportOptions{}); err != nil {
+		return err
+	}
+
+	s.etag = out.ETag
+
+	return nil
+}
+
+// Save implements library.Store by replacing the object if it is unchanged
+// since it was loaded or last saved, or creating it if it did not exist.
+//
+// If the object was changed or created by another writer in the meantime,
+// ErrConflict is returned and the object is left as is.
+func (s *Store) Save(l *library.Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements library.ContextStore. The object is uploaded with the
+// context, and left as is if the context is done before it is replaced.
+func (s *Store) SaveContext(ctx context.Context, l *library.Library) error {
+	var buf bytes.Buffer
+
+	if err := l.ExportContext(ctx, &buf, library.ExportOptions{}); err != nil {
+		return err
+	}
+
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+		Body:   bytes.NewReader(buf.Bytes()),
+	}
+
+	if s.etag != nil {
+		in.IfMatch = s.etag
+	} else {
+		in.IfNoneMatch = aws.String("*")
+	}
+
+	out, err := s.client.PutObject(ctx, in)
+	if isConflict(err) {
+		return fmt.Errorf("%w, s3://%s/%s", ErrConflict, s.bucket, s.key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s, %w", s.bucket, s.key, err)
+	}
+
+	s.etag = out.ETag
+
+	return nil
+}
+
+// isConflict reports whether the error is the failure of a conditional put.
+func isConflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	default:
+		return false
+	}
+}
+
+// Append implements library.Store. The object is only replaced on Save.
+func (s *Store) Append(l *library.Library, inv *library.Invocation) error {
+	return nil
+}
+
+// Close implements library.Store.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Ping implements library.Pinger by checking that the object can be read, or
+// that it does not exist yet.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+
+	var notFound *types.NotFound
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("failed to head s3://%s/%s, %w", s.bucket, s.key, err)
+	}
+
+	return nil
+}
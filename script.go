@@ -0,0 +1,129 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Script builds a command file for programs that generate commands, so that
+// the commands are constructed from the Command types rather than by hand and
+// cannot drift from the arguments the commands accept.
+//
+// The methods return the Script to allow chaining, e.g.:
+//
+//	err := library.NewScript().
+//		AddBook(1, "The Hobbit", 3).
+//		CreateAccount(1, "Adam Tanner").
+//		CheckoutBook(1, 1).
+//		Encode(w)
+//
+// The first invalid command is reported by Encode and the commands after it
+// are ignored.
+type Script struct {
+	invs []Invocation
+	err  error
+}
+
+// NewScript returns an empty Script.
+func NewScript() *Script {
+	return &Script{}
+}
+
+// Command adds a command to the script, where cmd is a pointer to any
+// registered Command type, e.g. &AddBook{...} or a custom command.
+//
+// Commands are validated in the same way as when they are parsed from a
+// command file.
+func (s *Script) Command(cmd any) *Script {
+	if s.err != nil {
+		return s
+	}
+
+	c, ok := commandByType(cmd)
+	if !ok {
+		s.err = fmt.Errorf("%w, command %d, unknown command type %T", ErrInvalidCommand, len(s.invs)+1, cmd)
+
+		return s
+	}
+
+	if v, ok := cmd.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			s.err = fmt.Errorf("command %d, %s, %w", len(s.invs)+1, c.name, err)
+
+			return s
+		}
+	}
+
+	s.invs = append(s.invs, Invocation{Command: cmd})
+
+	return s
+}
+
+// AddBook adds an ADD_BOOK command to the script.
+func (s *Script) AddBook(id int, name string, count int) *Script {
+	return s.Command(&AddBook{ID: id, Name: name, Count: count})
+}
+
+// AddCopies adds an ADD_COPIES command to the script.
+func (s *Script) AddCopies(id, count int) *Script {
+	return s.Command(&AddCopies{ID: id, Count: count})
+}
+
+// RemoveCopies adds a REMOVE_COPIES command to the script.
+func (s *Script) RemoveCopies(id, count int) *Script {
+	return s.Command(&RemoveCopies{ID: id, Count: count})
+}
+
+// CreateAccount adds a CREATE_ACCOUNT command to the script.
+func (s *Script) CreateAccount(id int, name string) *Script {
+	return s.Command(&CreateAccount{ID: id, Name: name})
+}
+
+// CheckoutBook adds a CHECKOUT_BOOK command to the script.
+func (s *Script) CheckoutBook(accountID, bookID int) *Script {
+	return s.Command(&CheckoutBook{AccountID: accountID, BookID: bookID})
+}
+
+// ReturnBook adds a RETURN_BOOK command to the script.
+func (s *Script) ReturnBook(accountID, bookID int) *Script {
+	return s.Command(&ReturnBook{AccountID: accountID, BookID: bookID})
+}
+
+// PrintCatalog adds a PRINT_CATALOG command to the script.
+func (s *Script) PrintCatalog() *Script {
+	return s.Command(&PrintCatalog{})
+}
+
+// PrintAccounts adds a PRINT_ACCOUNTS command to the script.
+func (s *Script) PrintAccounts() *Script {
+	return s.Command(&PrintAccounts{})
+}
+
+// Len returns the number of commands in the script.
+func (s *Script) Len() int {
+	return len(s.invs)
+}
+
+// Err returns the first invalid command added to the script, if any.
+func (s *Script) Err() error {
+	return s.err
+}
+
+// Encode writes the script to a writer as a command file, one JSON command
+// per line, in the same format read by Import.
+func (s *Script) Encode(w io.Writer) error {
+	if s.err != nil {
+		return fmt.Errorf("invalid script, %w", s.err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	for _, inv := range s.invs {
+		if err := enc.Encode(&inv); err != nil {
+			return fmt.Errorf("failed to write script, %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package library
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchQuery represents the filters for searching the library catalog.
+//
+// Every non-zero filter must match for a book to be included in the results.
+type SearchQuery struct {
+	// Title matches books with a name containing each of the words,
+	// ignoring case.
+	Title []string
+	// Author matches books with an author containing the value, ignoring
+	// case.
+	Author string
+	// ISBN matches books with the ISBN, ignoring hyphens and spaces.
+	ISBN string
+	// Tag matches books with the tag, ignoring case.
+	Tag string
+	// Available matches books with at least one copy available to check
+	// out if true, or no copies available if false.
+	Available *bool
+}
+
+// ParseSearchQuery parses a search query string into a SearchQuery.
+//
+// The query is a list of space separated terms. Terms of the form field:value
+// filter on the field, where field is one of author, isbn, tag, or available,
+// and all other terms are matched against the title. Values containing spaces
+// may be quoted, e.g. author:"Jane Austen". The available field accepts any
+// value accepted by strconv.ParseBool as well as yes and no.
+//
+// For example, the query `gatsby author:fitzgerald available:yes` matches
+// available books with "gatsby" in the title written by Fitzgerald.
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+
+	terms, err := splitQuery(s)
+	if err != nil {
+		return q, err
+	}
+
+	for _, term := range terms {
+		field, value, ok := strings.Cut(term, ":")
+		if !ok {
+			q.Title = append(q.Title, term)
+			continue
+		}
+
+		switch strings.ToLower(field) {
+		case "title":
+			q.Title = append(q.Title, value)
+		case "author":
+			q.Author = value
+		case "isbn":
+			q.ISBN = value
+		case "tag":
+			q.Tag = value
+		case "available":
+			available, err := parseBool(value)
+			if err != nil {
+				return q, fmt.Errorf("%w, available filter %q", ErrInvalidArgument, value)
+			}
+
+			q.Available = &available
+		default:
+			return q, fmt.Errorf("%w, unknown search field %q", ErrInvalidArgument, field)
+		}
+	}
+
+	return q, nil
+}
+
+// splitQuery splits a search query string into terms on spaces, keeping
+// quoted values together.
+func splitQuery(s string) ([]string, error) {
+	var (
+		terms  []string
+		term   strings.Builder
+		quoted bool
+	)
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("%w, unterminated quote in search query", ErrInvalidArgument)
+	}
+
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+
+	return terms, nil
+}
+
+// parseBool parses a boolean search value.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+
+	return strconv.ParseBool(s)
+}
+
+// normalizeISBN strips the hyphens and spaces commonly used to format ISBNs.
+func normalizeISBN(isbn string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+
+		return unicode.ToUpper(r)
+	}, isbn)
+}
+
+// Search returns copies of the books in the library catalog matching the
+// query, ordered by ID.
+func (l *Library) Search(q SearchQuery) []*Book {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var books []*Book
+
+	for _, book := range l.books {
+		if l.matchBook(book, q) {
+			books = append(books, book.clone())
+		}
+	}
+
+	slices.SortFunc(books, func(a, b *Book) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return books
+}
+
+// matchBook reports whether a book matches every filter of the query.
+func (l *Library) matchBook(book *Book, q SearchQuery) bool {
+	name := strings.ToLower(book.Name)
+
+	for _, word := range q.Title {
+		if !strings.Contains(name, strings.ToLower(word)) {
+			return false
+		}
+	}
+
+	if q.Author != "" && !strings.Contains(strings.ToLower(book.Author), strings.ToLower(q.Author)) {
+		return false
+	}
+
+	if q.ISBN != "" && normalizeISBN(book.ISBN) != normalizeISBN(q.ISBN) {
+		return false
+	}
+
+	if q.Tag != "" && !slices.ContainsFunc(book.Tags, func(tag string) bool { return strings.EqualFold(tag, q.Tag) }) {
+		return false
+	}
+
+	if q.Available != nil {
+		available := book.Count-len(l.checkoutsByBook[book.ID]) > 0
+		if available != *q.Available {
+			return false
+		}
+	}
+
+	return true
+}
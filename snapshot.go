@@ -0,0 +1,313 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// ExportSnapshot, see FormatVersion.
+const SnapshotVersion = FormatVersion
+
+// Format is the format library state is exported in.
+type Format string
+
+const (
+	// FormatCommands is the command log written by Export, which is
+	// replayed by Import.
+	FormatCommands Format = "commands"
+	// FormatSnapshot is the single JSON document written by
+	// ExportSnapshot, which is loaded by ImportSnapshot.
+	FormatSnapshot Format = "snapshot"
+	// FormatGob is the gob encoded Snapshot written by ExportGob, which
+	// is loaded by ImportGob.
+	FormatGob Format = "gob"
+	// FormatProto is the protocol buffer Snapshot message written by
+	// ExportProto, which is loaded by ImportProto.
+	FormatProto Format = "proto"
+)
+
+// Snapshot represents the state of a Library as a single versioned JSON
+// document.
+//
+// A snapshot is an alternative to the command log written by Export. It is
+// smaller, diffable, and is loaded directly rather than by executing commands.
+type Snapshot struct {
+	Version   int                `json:"version"`
+	Policy    Policy             `json:"policy"`
+	Books     []*Book            `json:"books"`
+	Accounts  []*Account         `json:"accounts"`
+	Checkouts []*Checkout        `json:"checkouts"`       // Every checkout in the order they were made, including those returned.
+	Holds     []*Hold            `json:"holds,omitempty"` // Holds by book ID, in the order of the hold queue of each book.
+	Macros    []*Macro           `json:"macros,omitempty"`
+	Inventory *SnapshotInventory `json:"inventory,omitempty"`
+
+	// LogSequence is the sequence number of the last write-ahead log
+	// entry folded into the snapshot, see WALStore.
+	LogSequence int `json:"logSequence,omitempty"`
+}
+
+// SnapshotInventory represents an inventory audit in progress in a Snapshot.
+type SnapshotInventory struct {
+	Started  time.Time `json:"started"`
+	Barcodes []string  `json:"barcodes"`
+}
+
+// Snapshot returns a copy of the state of the library.
+//
+// The books and accounts are sorted by ID so that snapshots of the same state
+// are identical. Use Snapshot.Equal to compare snapshots, e.g. of a library
+// before and after a round trip through Export and Import.
+func (l *Library) Snapshot() *Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		Policy:    l.policy,
+		Books:     make([]*Book, 0, len(l.books)),
+		Accounts:  make([]*Account, 0, len(l.accounts)),
+		Checkouts: make([]*Checkout, 0, len(l.history)),
+	}
+
+	for _, book := range l.sortedBooks() {
+		s.Books = append(s.Books, book.clone())
+	}
+
+	for _, account := range l.sortedAccounts() {
+		s.Accounts = append(s.Accounts, account.clone())
+	}
+
+	for _, checkout := range l.history {
+		s.Checkouts = append(s.Checkouts, checkout.clone())
+	}
+
+	for _, hold := range l.sortedHolds() {
+		s.Holds = append(s.Holds, hold.clone())
+	}
+
+	for _, name := range l.macroOrder {
+		s.Macros = append(s.Macros, l.macros[name].clone())
+	}
+
+	if l.inventory != nil {
+		s.Inventory = &SnapshotInventory{
+			Started:  l.inventory.started,
+			Barcodes: slices.Clone(l.inventory.barcodes),
+		}
+	}
+
+	return s
+}
+
+// ExportSnapshot writes the library state to a writer as an indented Snapshot
+// JSON document.
+func (l *Library) ExportSnapshot(w io.Writer) error {
+	return writeSnapshot(w, l.Snapshot())
+}
+
+// writeSnapshot writes the snapshot to a writer as indented JSON, see
+// ExportSnapshot.
+func writeSnapshot(w io.Writer, s *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("failed to write library state, %w", err)
+	}
+
+	return nil
+}
+
+// ImportSnapshot reads a Snapshot JSON document written by ExportSnapshot and
+// replaces the state of the library with it.
+//
+// The snapshot is loaded directly rather than by executing commands, so only
+// the consistency of the snapshot is checked, e.g. that every checkout refers
+// to a book and account in the snapshot. The replaced state cannot be undone.
+// A gzip compressed snapshot is decompressed transparently and the checksum of
+// a checksummed snapshot is verified, see ExportFile. A snapshot of an older
+// FormatVersion is migrated to the current version before it is loaded.
+func (l *Library) ImportSnapshot(r io.Reader) error {
+	bs, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	if bs, err = stripChecksum(bs); err != nil {
+		return err
+	}
+
+	if bs, err = migrateSnapshot(bs); err != nil {
+		return err
+	}
+
+	var s Snapshot
+
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("failed to read library state, %w", err)
+	}
+
+	return l.LoadSnapshot(&s)
+}
+
+// LoadSnapshot replaces the state of the library with a Snapshot, see
+// ImportSnapshot.
+func (l *Library) LoadSnapshot(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("%w, unsupported snapshot version %d, expected %d", ErrInvalidArgument, s.Version, SnapshotVersion)
+	}
+
+	l.mu.RLock()
+	check := l.checkSnapshot
+	l.mu.RUnlock()
+
+	if check != nil {
+		check(s)
+	}
+
+	// A snapshot without a policy has the default policy.
+	policy := s.Policy
+	if policy == (Policy{}) {
+		policy = DefaultPolicy()
+	}
+
+	books := make(map[int]*Book, len(s.Books))
+
+	for _, book := range s.Books {
+		if _, ok := books[book.ID]; ok {
+			return &DuplicateError{Kind: KindBook, ID: book.ID}
+		}
+
+		b := *book
+		b.Tags = slices.Clone(book.Tags)
+
+		// A book of a snapshot written before books were versioned is
+		// at the first version.
+		b.Version = max(b.Version, 1)
+
+		books[b.ID] = &b
+	}
+
+	accounts := make(map[int]*Account, len(s.Accounts))
+
+	for _, account := range s.Accounts {
+		if _, ok := accounts[account.ID]; ok {
+			return &DuplicateError{Kind: KindAccount, ID: account.ID}
+		}
+
+		a := *account
+		a.Version = max(a.Version, 1)
+
+		accounts[a.ID] = &a
+	}
+
+	checkoutsByAccount := make(map[int][]*Checkout)
+	checkoutsByBook := make(map[int][]*Checkout)
+	history := make([]*Checkout, 0, len(s.Checkouts))
+
+	for _, checkout := range s.Checkouts {
+		if _, ok := books[checkout.BookID]; !ok {
+			return &NotExistError{Kind: KindBook, ID: checkout.BookID, detail: fmt.Sprintf("checkout of book (%d)", checkout.BookID)}
+		}
+
+		if _, ok := accounts[checkout.AccountID]; !ok {
+			return &NotExistError{Kind: KindAccount, ID: checkout.AccountID, detail: fmt.Sprintf("checkout by account (%d)", checkout.AccountID)}
+		}
+
+		c := *checkout
+
+		if c.Returned.IsZero() {
+			checkoutsByAccount[c.AccountID] = append(checkoutsByAccount[c.AccountID], &c)
+			checkoutsByBook[c.BookID] = append(checkoutsByBook[c.BookID], &c)
+		}
+
+		history = append(history, &c)
+	}
+
+	holds := make(map[int][]*Hold)
+
+	for _, hold := range s.Holds {
+		if _, ok := books[hold.BookID]; !ok {
+			return &NotExistError{Kind: KindBook, ID: hold.BookID, detail: fmt.Sprintf("hold on book (%d)", hold.BookID)}
+		}
+
+		if _, ok := accounts[hold.AccountID]; !ok {
+			return &NotExistError{Kind: KindAccount, ID: hold.AccountID, detail: fmt.Sprintf("hold for account (%d)", hold.AccountID)}
+		}
+
+		if slices.ContainsFunc(holds[hold.BookID], func(h *Hold) bool { return h.AccountID == hold.AccountID }) {
+			return &DuplicateError{Kind: KindHold, ID: hold.BookID, detail: fmt.Sprintf("hold on book (%d) for account (%d)", hold.BookID, hold.AccountID)}
+		}
+
+		holds[hold.BookID] = append(holds[hold.BookID], hold.clone())
+	}
+
+	macros := make(map[string]*Macro, len(s.Macros))
+	macroOrder := make([]string, 0, len(s.Macros))
+
+	for _, macro := range s.Macros {
+		if _, ok := macros[macro.Name]; ok {
+			return &DuplicateError{Kind: KindMacro, Name: macro.Name}
+		}
+
+		macros[macro.Name] = macro.clone()
+		macroOrder = append(macroOrder, macro.Name)
+	}
+
+	var current *inventory
+
+	if s.Inventory != nil {
+		current = &inventory{
+			started: s.Inventory.Started,
+			scanned: make(map[string]bool, len(s.Inventory.Barcodes)),
+		}
+
+		for _, barcode := range s.Inventory.Barcodes {
+			if !current.scanned[barcode] {
+				current.scanned[barcode] = true
+				current.barcodes = append(current.barcodes, barcode)
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.policy = policy
+	l.books = books
+	l.accounts = accounts
+	l.checkoutsByAccount = checkoutsByAccount
+	l.checkoutsByBook = checkoutsByBook
+	l.history = history
+	l.holds = holds
+	l.macros = macros
+	l.macroOrder = macroOrder
+	l.inventory = current
+	l.undo = nil
+	l.version++
+	l.resetChanges()
+
+	return nil
+}
+
+// isSnapshot reports whether the contents of a state file are a Snapshot
+// rather than a command log.
+//
+// A command log of more than one command is not a single JSON value, and a
+// single command has no version, so the two cannot be confused.
+func isSnapshot(bs []byte) bool {
+	if !json.Valid(bs) {
+		return false
+	}
+
+	var s struct {
+		Version *int `json:"version"`
+	}
+
+	return json.Unmarshal(bytes.TrimSpace(bs), &s) == nil && s.Version != nil
+}
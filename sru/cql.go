@@ -0,0 +1,229 @@
+package sru
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/admtnnr/library"
+)
+
+// indexes maps the CQL indexes supported by searches, with or without the
+// prefix of their context set, to the fields of a library.SearchQuery.
+var indexes = map[string]string{
+	"cql.serverchoice": "title",
+	"cql.anywhere":     "title",
+	"dc.title":         "title",
+	"title":            "title",
+	"dc.creator":       "author",
+	"dc.author":        "author",
+	"creator":          "author",
+	"author":           "author",
+	"bath.isbn":        "isbn",
+	"dc.identifier":    "isbn",
+	"isbn":             "isbn",
+	"dc.subject":       "subject",
+	"subject":          "subject",
+}
+
+// parseQuery parses a CQL query into the search query of a catalog.
+//
+// Only the subset of CQL that a library.SearchQuery can express is supported:
+// search clauses of the indexes, see indexes, with the =, ==, exact, all, adj
+// and any relations, combined with and, optionally grouped by parentheses. A
+// term without an index is matched against the titles. Other queries are
+// rejected with a diagnostic, e.g. of an unsupported boolean operator for or.
+func parseQuery(s string) (library.SearchQuery, error) {
+	var q library.SearchQuery
+
+	tokens, err := tokenize(s)
+	if err != nil {
+		return q, err
+	}
+
+	// Parentheses do not change the meaning of clauses combined only with
+	// and, so they are only checked to be balanced.
+	depth := 0
+	expectClause := true
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		switch {
+		case tok.text == "(" && !tok.quoted:
+			if !expectClause {
+				return q, syntaxError("unexpected (")
+			}
+
+			depth++
+		case tok.text == ")" && !tok.quoted:
+			if expectClause || depth == 0 {
+				return q, syntaxError("unexpected )")
+			}
+
+			depth--
+		case !expectClause:
+			switch op := strings.ToLower(tok.text); op {
+			case "and":
+				expectClause = true
+			case "or", "not", "prox":
+				return q, &Diagnostic{Code: 37, Message: "Unsupported boolean operator", Details: op}
+			default:
+				return q, syntaxError(fmt.Sprintf("expected boolean operator, found %q", tok.text))
+			}
+		default:
+			index, relation, term := "cql.serverchoice", "=", tok
+
+			if i+2 < len(tokens) && !tokens[i+1].quoted && isRelation(tokens[i+1].text) {
+				index, relation, term = strings.ToLower(tok.text), strings.ToLower(tokens[i+1].text), tokens[i+2]
+				i += 2
+			}
+
+			if !term.quoted && (term.text == "(" || term.text == ")") {
+				return q, syntaxError("expected search term, found " + term.text)
+			}
+
+			if err := addClause(&q, index, relation, term.text); err != nil {
+				return q, err
+			}
+
+			expectClause = false
+		}
+	}
+
+	if expectClause || depth != 0 {
+		return q, syntaxError("incomplete query")
+	}
+
+	return q, nil
+}
+
+// addClause adds a search clause to the search query.
+func addClause(q *library.SearchQuery, index, relation, term string) error {
+	field, ok := indexes[index]
+	if !ok {
+		return &Diagnostic{Code: 16, Message: "Unsupported index", Details: index}
+	}
+
+	words := strings.Fields(term)
+
+	// A single word matches the same way with any relation, but the words
+	// of a term only match all together.
+	switch relation {
+	case "=", "==", "exact", "all", "adj":
+	case "any":
+		if len(words) > 1 {
+			return &Diagnostic{Code: 19, Message: "Unsupported relation", Details: relation}
+		}
+	default:
+		return &Diagnostic{Code: 19, Message: "Unsupported relation", Details: relation}
+	}
+
+	// The search query matches a single value of the fields other than the
+	// title, so they cannot be combined with themselves.
+	set := func(v *string) error {
+		if *v != "" && !strings.EqualFold(*v, term) {
+			return &Diagnostic{Code: 37, Message: "Unsupported boolean operator", Details: "and of two " + index + " clauses"}
+		}
+
+		*v = term
+
+		return nil
+	}
+
+	switch field {
+	case "title":
+		q.Title = append(q.Title, words...)
+	case "author":
+		return set(&q.Author)
+	case "isbn":
+		return set(&q.ISBN)
+	case "subject":
+		return set(&q.Tag)
+	}
+
+	return nil
+}
+
+// isRelation reports whether a token is a CQL relation.
+func isRelation(s string) bool {
+	switch strings.ToLower(s) {
+	case "=", "==", "<>", "<", ">", "<=", ">=", "exact", "all", "any", "adj", "within", "encloses":
+		return true
+	}
+
+	return false
+}
+
+// token is a token of a CQL query.
+type token struct {
+	text   string
+	quoted bool // Whether the token was quoted, and so is a term.
+}
+
+// tokenize splits a CQL query into its tokens: quoted strings, parentheses,
+// the symbolic relations, and words.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	rs := []rune(s)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var b strings.Builder
+
+			i++
+
+			for ; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+
+				b.WriteRune(rs[i])
+			}
+
+			if i == len(rs) {
+				return nil, syntaxError("unterminated quote")
+			}
+
+			i++
+
+			tokens = append(tokens, token{text: b.String(), quoted: true})
+		case r == '(' || r == ')':
+			tokens = append(tokens, token{text: string(r)})
+			i++
+		case strings.ContainsRune("=<>", r):
+			j := i + 1
+			for j < len(rs) && strings.ContainsRune("=<>", rs[j]) {
+				j++
+			}
+
+			tokens = append(tokens, token{text: string(rs[i:j])})
+			i = j
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("()=<>\"", rs[j]) {
+				j++
+			}
+
+			tokens = append(tokens, token{text: string(rs[i:j])})
+			i = j
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, syntaxError("empty query")
+	}
+
+	return tokens, nil
+}
+
+// syntaxError returns the diagnostic of a query syntax error.
+func syntaxError(details string) *Diagnostic {
+	return &Diagnostic{Code: 10, Message: "Query syntax error", Details: details}
+}
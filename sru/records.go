@@ -0,0 +1,142 @@
+package sru
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"github.com/admtnnr/library"
+)
+
+// The record schemas of the books, by their short names and identifiers.
+const (
+	SchemaDC      = "info:srw/schema/1/dc-v1.1"
+	SchemaMARCXML = "info:srw/schema/1/marcxml-v1.1"
+)
+
+// schemas maps the names of the record schemas requested by clients to their
+// identifiers.
+var schemas = map[string]string{
+	"":            SchemaDC,
+	"dc":          SchemaDC,
+	SchemaDC:      SchemaDC,
+	"marcxml":     SchemaMARCXML,
+	"marc21":      SchemaMARCXML,
+	SchemaMARCXML: SchemaMARCXML,
+}
+
+// dcRecord is the Dublin Core record of a book.
+type dcRecord struct {
+	XMLName     xml.Name `xml:"srw_dc:dc"`
+	XMLNS       string   `xml:"xmlns:srw_dc,attr"`
+	XMLNSDC     string   `xml:"xmlns:dc,attr"`
+	Title       string   `xml:"dc:title"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Subjects    []string `xml:"dc:subject"`
+	Type        string   `xml:"dc:type"`
+	Identifiers []string `xml:"dc:identifier"`
+}
+
+// newDCRecord returns the Dublin Core record of a book. The identifiers are
+// its ISBN, if any, as a URN and its ID in the catalog.
+func newDCRecord(book *library.Book) *dcRecord {
+	r := &dcRecord{
+		XMLNS:    "info:srw/schema/1/dc-schema",
+		XMLNSDC:  "http://purl.org/dc/elements/1.1/",
+		Title:    book.Name,
+		Creator:  book.Author,
+		Subjects: book.Tags,
+		Type:     "Text",
+	}
+
+	if book.ISBN != "" {
+		r.Identifiers = append(r.Identifiers, "urn:isbn:"+book.ISBN)
+	}
+
+	r.Identifiers = append(r.Identifiers, strconv.Itoa(book.ID))
+
+	return r
+}
+
+// marcRecord is the MARC 21 bibliographic record of a book in MARCXML.
+type marcRecord struct {
+	XMLName       xml.Name           `xml:"record"`
+	XMLNS         string             `xml:"xmlns,attr"`
+	Leader        string             `xml:"leader"`
+	ControlFields []marcControlField `xml:"controlfield"`
+	DataFields    []marcDataField    `xml:"datafield"`
+}
+
+// marcControlField is a control field of a marcRecord.
+type marcControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+// marcDataField is a data field of a marcRecord.
+type marcDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+// marcSubfield is a subfield of a marcDataField.
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// newMARCRecord returns the MARC 21 record of a book: its ID as the control
+// number (001), its ISBN (020), author as the main entry (100), name as the
+// title statement (245), and tags as topical subjects (650).
+func newMARCRecord(book *library.Book) *marcRecord {
+	r := &marcRecord{
+		XMLNS: "http://www.loc.gov/MARC21/slim",
+		// A new record of language material, a monograph, with full level
+		// encoding.
+		Leader:        "00000nam a2200000 a 4500",
+		ControlFields: []marcControlField{{Tag: "001", Value: strconv.Itoa(book.ID)}},
+	}
+
+	field := func(tag, ind1, ind2, value string) {
+		r.DataFields = append(r.DataFields, marcDataField{
+			Tag:       tag,
+			Ind1:      ind1,
+			Ind2:      ind2,
+			Subfields: []marcSubfield{{Code: "a", Value: value}},
+		})
+	}
+
+	if book.ISBN != "" {
+		field("020", " ", " ", book.ISBN)
+	}
+
+	if book.Author != "" {
+		field("100", "1", " ", book.Author)
+	}
+
+	// The title is not added under the main entry if there is no author,
+	// nor are any leading articles skipped when it is filed.
+	titleInd := "0"
+	if book.Author != "" {
+		titleInd = "1"
+	}
+
+	field("245", titleInd, "0", book.Name)
+
+	for _, tag := range book.Tags {
+		// The second indicator 4 is a subject of no particular thesaurus.
+		field("650", " ", "4", tag)
+	}
+
+	return r
+}
+
+// newRecord returns the record of a book in the schema, see schemas.
+func newRecord(schema string, book *library.Book) any {
+	if schema == SchemaMARCXML {
+		return newMARCRecord(book)
+	}
+
+	return newDCRecord(book)
+}
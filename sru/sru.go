@@ -0,0 +1,398 @@
+// Package sru provides an SRU 1.2 (Search/Retrieve via URL) endpoint of the
+// catalog of a library, see Handler, so that other library systems and
+// discovery layers can federate their searches of it, as they would of a
+// Z39.50 server.
+//
+// The queries are in the subset of CQL that the catalog search supports, see
+// library.SearchQuery, and the books are returned as Dublin Core or MARCXML
+// records.
+package sru
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/admtnnr/library"
+)
+
+// Version is the version of SRU that is supported.
+const Version = "1.2"
+
+const (
+	// DefaultMaximumRecords is the number of records of a search that does
+	// not request a number.
+	DefaultMaximumRecords = 10
+	// MaxMaximumRecords is the largest number of records of a search.
+	MaxMaximumRecords = 100
+)
+
+// Diagnostic is an SRU diagnostic, the error of a request, identified by its
+// code in the SRU diagnostics list, e.g. 16 for an unsupported index.
+type Diagnostic struct {
+	Code    int
+	Message string // Message of the code, e.g. "Unsupported index".
+	Details string // Details of the error, e.g. the index.
+}
+
+// Error implements the error interface.
+func (d *Diagnostic) Error() string {
+	if d.Details == "" {
+		return d.Message
+	}
+
+	return d.Message + ", " + d.Details
+}
+
+// URI returns the URI of the diagnostic.
+func (d *Diagnostic) URI() string {
+	return "info:srw/diagnostic/1/" + strconv.Itoa(d.Code)
+}
+
+// Handler serves the SRU operations of the catalog of a library over HTTP GET
+// or POST, e.g.:
+//
+//	/sru?operation=searchRetrieve&version=1.2&query=dc.creator=austen&recordSchema=marcxml
+//
+// searchRetrieve searches the catalog with the CQL query, returning the
+// records of the books from startRecord, from 1, up to maximumRecords, in the
+// recordSchema, dc (the default) or marcxml, packed as XML or, with a
+// recordPacking of string, as escaped strings. explain, also the response of
+// a request without an operation or query, describes the indexes and schemas
+// supported. Errors are reported as diagnostics of the responses.
+type Handler struct {
+	Library *library.Library
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	var resp any
+
+	switch op := r.FormValue("operation"); {
+	case op == "searchRetrieve" || op == "" && r.FormValue("query") != "":
+		resp = h.searchRetrieve(r)
+	case op == "explain" || op == "":
+		resp = h.explain(r)
+	default:
+		resp = &searchRetrieveResponse{
+			XMLNS:       "http://www.loc.gov/zing/srw/",
+			Version:     Version,
+			Diagnostics: diagnostics(&Diagnostic{Code: 4, Message: "Unsupported operation", Details: op}),
+		}
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode SRU response, %v", err), http.StatusInternalServerError)
+
+		return
+	}
+
+	// Diagnostics are part of the responses, so they are successful
+	// responses as far as HTTP is concerned.
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// searchRetrieveResponse is the response of the searchRetrieve operation.
+type searchRetrieveResponse struct {
+	XMLName            xml.Name        `xml:"srw:searchRetrieveResponse"`
+	XMLNS              string          `xml:"xmlns:srw,attr"`
+	Version            string          `xml:"srw:version"`
+	NumberOfRecords    int             `xml:"srw:numberOfRecords"`
+	Records            *records        `xml:"srw:records,omitempty"`
+	NextRecordPosition int             `xml:"srw:nextRecordPosition,omitempty"`
+	Diagnostics        *xmlDiagnostics `xml:"srw:diagnostics,omitempty"`
+}
+
+// records are the records of a response.
+type records struct {
+	Records []record `xml:"srw:record"`
+}
+
+// record is a record of a response, with its data in the schema, either
+// packed as XML or escaped as a string.
+type record struct {
+	Schema   string     `xml:"srw:recordSchema"`
+	Packing  string     `xml:"srw:recordPacking"`
+	Data     recordData `xml:"srw:recordData"`
+	Position int        `xml:"srw:recordPosition,omitempty"`
+}
+
+// recordData is the data of a record, either Record as XML or String.
+type recordData struct {
+	Record any
+	String string `xml:",chardata"`
+}
+
+// xmlDiagnostics are the diagnostics of a response.
+type xmlDiagnostics struct {
+	XMLNS       string          `xml:"xmlns:diag,attr"`
+	Diagnostics []xmlDiagnostic `xml:"diag:diagnostic"`
+}
+
+// xmlDiagnostic is a diagnostic of a response, see Diagnostic.
+type xmlDiagnostic struct {
+	URI     string `xml:"diag:uri"`
+	Details string `xml:"diag:details,omitempty"`
+	Message string `xml:"diag:message"`
+}
+
+// diagnostics returns the diagnostics of a response of an error, a
+// Diagnostic or else a general system error.
+func diagnostics(err error) *xmlDiagnostics {
+	d, ok := err.(*Diagnostic)
+	if !ok {
+		d = &Diagnostic{Code: 1, Message: "General system error", Details: err.Error()}
+	}
+
+	return &xmlDiagnostics{
+		XMLNS:       "http://www.loc.gov/zing/srw/diagnostic/",
+		Diagnostics: []xmlDiagnostic{{URI: d.URI(), Details: d.Details, Message: d.Message}},
+	}
+}
+
+// searchRetrieve executes the searchRetrieve operation of a request.
+func (h *Handler) searchRetrieve(r *http.Request) *searchRetrieveResponse {
+	resp := &searchRetrieveResponse{XMLNS: "http://www.loc.gov/zing/srw/", Version: Version}
+
+	books, err := h.search(r, resp)
+	if err != nil {
+		resp.Records = nil
+		resp.NextRecordPosition = 0
+		resp.Diagnostics = diagnostics(err)
+
+		return resp
+	}
+
+	resp.NumberOfRecords = len(books)
+
+	return resp
+}
+
+// search searches the catalog with the query of a request, adding the records
+// of the books of the requested page to the response, and returns all of the
+// books that match.
+func (h *Handler) search(r *http.Request, resp *searchRetrieveResponse) ([]*library.Book, error) {
+	if v := r.FormValue("version"); v != "" && v != Version {
+		return nil, &Diagnostic{Code: 5, Message: "Unsupported version", Details: Version}
+	}
+
+	query := r.FormValue("query")
+	if query == "" {
+		return nil, &Diagnostic{Code: 7, Message: "Mandatory parameter not supplied", Details: "query"}
+	}
+
+	start, err := intParam(r, "startRecord", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	maximum, err := intParam(r, "maximumRecords", DefaultMaximumRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	maximum = min(maximum, MaxMaximumRecords)
+
+	schema, ok := schemas[r.FormValue("recordSchema")]
+	if !ok {
+		return nil, &Diagnostic{Code: 66, Message: "Unknown schema for retrieval", Details: r.FormValue("recordSchema")}
+	}
+
+	packing := r.FormValue("recordPacking")
+
+	switch packing {
+	case "":
+		packing = "xml"
+	case "xml", "string":
+	default:
+		return nil, &Diagnostic{Code: 71, Message: "Unsupported record packing", Details: packing}
+	}
+
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	books := h.Library.Search(q)
+
+	if start < 1 || start > len(books) && len(books) > 0 {
+		return nil, &Diagnostic{Code: 61, Message: "First record position out of range", Details: strconv.Itoa(start)}
+	}
+
+	if start > len(books) || maximum == 0 {
+		return books, nil
+	}
+
+	end := min(start-1+maximum, len(books))
+
+	resp.Records = &records{}
+
+	for i, book := range books[start-1 : end] {
+		rec := record{Schema: schema, Packing: packing, Position: start + i}
+
+		if packing == "string" {
+			bs, err := xml.Marshal(newRecord(schema, book))
+			if err != nil {
+				return nil, err
+			}
+
+			rec.Data.String = string(bs)
+		} else {
+			rec.Data.Record = newRecord(schema, book)
+		}
+
+		resp.Records.Records = append(resp.Records.Records, rec)
+	}
+
+	if end < len(books) {
+		resp.NextRecordPosition = end + 1
+	}
+
+	return books, nil
+}
+
+// intParam returns the value of a non-negative integer parameter of a
+// request, or def if it is not set.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, &Diagnostic{Code: 6, Message: "Unsupported parameter value", Details: name}
+	}
+
+	return n, nil
+}
+
+// explainResponse is the response of the explain operation, with a ZeeRex
+// record of the server.
+type explainResponse struct {
+	XMLName xml.Name `xml:"srw:explainResponse"`
+	XMLNS   string   `xml:"xmlns:srw,attr"`
+	Version string   `xml:"srw:version"`
+	Record  record   `xml:"srw:record"`
+}
+
+// zeerex is the ZeeRex description of the server of the explain operation.
+type zeerex struct {
+	XMLName    xml.Name `xml:"zr:explain"`
+	XMLNS      string   `xml:"xmlns:zr,attr"`
+	ServerInfo struct {
+		Protocol string `xml:"protocol,attr"`
+		Version  string `xml:"version,attr"`
+		Host     string `xml:"zr:host"`
+		Port     string `xml:"zr:port"`
+		Database string `xml:"zr:database"`
+	} `xml:"zr:serverInfo"`
+	Indexes []zeerexIndex  `xml:"zr:indexInfo>zr:index"`
+	Schemas []zeerexSchema `xml:"zr:schemaInfo>zr:schema"`
+	Config  []zeerexConfig `xml:"zr:configInfo>zr:default"`
+}
+
+// zeerexIndex is a search index of a zeerex description.
+type zeerexIndex struct {
+	Title string `xml:"zr:title"`
+	Map   struct {
+		Name struct {
+			Set  string `xml:"set,attr"`
+			Name string `xml:",chardata"`
+		} `xml:"zr:name"`
+	} `xml:"zr:map"`
+}
+
+// zeerexSchema is a record schema of a zeerex description.
+type zeerexSchema struct {
+	Identifier string `xml:"identifier,attr"`
+	Name       string `xml:"name,attr"`
+	Title      string `xml:"zr:title"`
+}
+
+// zeerexConfig is a default of the configuration of a zeerex description.
+type zeerexConfig struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// explain executes the explain operation of a request.
+func (h *Handler) explain(r *http.Request) *explainResponse {
+	var z zeerex
+
+	z.XMLNS = "http://explain.z3950.org/dtd/2.0/"
+	z.ServerInfo.Protocol = "SRU"
+	z.ServerInfo.Version = Version
+	z.ServerInfo.Host = r.Host
+	z.ServerInfo.Port = "80"
+	z.ServerInfo.Database = r.URL.Path
+
+	// The path of the request, before any prefix was stripped, e.g. of a
+	// tenant.
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		z.ServerInfo.Database = u.Path
+	}
+
+	if r.TLS != nil {
+		z.ServerInfo.Port = "443"
+	}
+
+	if host, port, err := net.SplitHostPort(r.Host); err == nil {
+		z.ServerInfo.Host = host
+		z.ServerInfo.Port = port
+	}
+
+	for _, index := range []struct{ set, name, title string }{
+		{"dc", "title", "Title"},
+		{"dc", "creator", "Author"},
+		{"dc", "subject", "Subject"},
+		{"bath", "isbn", "ISBN"},
+	} {
+		var zi zeerexIndex
+
+		zi.Title = index.title
+		zi.Map.Name.Set = index.set
+		zi.Map.Name.Name = index.name
+
+		z.Indexes = append(z.Indexes, zi)
+	}
+
+	z.Schemas = []zeerexSchema{
+		{Identifier: SchemaDC, Name: "dc", Title: "Dublin Core"},
+		{Identifier: SchemaMARCXML, Name: "marcxml", Title: "MARC 21 XML"},
+	}
+
+	z.Config = []zeerexConfig{
+		{Type: "numberOfRecords", Value: strconv.Itoa(DefaultMaximumRecords)},
+		{Type: "retrieveSchema", Value: "dc"},
+	}
+
+	return &explainResponse{
+		XMLNS:   "http://www.loc.gov/zing/srw/",
+		Version: Version,
+		Record: record{
+			Schema:  "http://explain.z3950.org/dtd/2.0/",
+			Packing: "xml",
+			Data:    recordData{Record: &z},
+		},
+	}
+}
@@ -0,0 +1,278 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the state of a Library across invocations.
+//
+// The backends are interchangeable: FileStore persists the state to a file,
+// WALStore to a file and a write-ahead log, MemoryStore in memory, and other
+// backends are provided by the subpackages, e.g. boltstore.
+type Store interface {
+	// Load loads the persisted state into the library. An empty store
+	// loads nothing.
+	Load(l *Library) error
+	// Save persists the state of the library, atomically replacing the
+	// previously persisted state.
+	Save(l *Library) error
+	// Append persists the changes made by a command executed against the
+	// library since it was loaded, e.g. from ImportOptions.AfterExec, so
+	// that the command is not lost if the process exits before Save.
+	// Stores that only persist the state on Save do nothing.
+	Append(l *Library, inv *Invocation) error
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// Pinger is implemented by Stores that can check that their storage is
+// reachable without loading the state, e.g. for the readiness check of a
+// server.
+type Pinger interface {
+	// Ping returns an error if the storage is unreachable, e.g. because
+	// the directory of a file was removed or the service is down.
+	Ping(ctx context.Context) error
+}
+
+// ContextStore is implemented by Stores that can stop loading or saving the
+// state once a context is done, e.g. to honor the deadline of a request or
+// cancel a slow save to a remote service.
+type ContextStore interface {
+	// LoadContext loads the state like Load, returning an error wrapping
+	// the error of the context once it is done.
+	LoadContext(ctx context.Context, l *Library) error
+	// SaveContext persists the state like Save, returning an error
+	// wrapping the error of the context once it is done, in which case
+	// the previously persisted state is left as is.
+	SaveContext(ctx context.Context, l *Library) error
+}
+
+// LoadStore loads the state of a Store into the library with the context if
+// it implements ContextStore. Stores that do not are loaded with Load unless
+// the context is already done.
+func LoadStore(ctx context.Context, s Store, l *Library) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.LoadContext(ctx, l)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to load library state, %w", err)
+	}
+
+	return s.Load(l)
+}
+
+// SaveStore saves the state of the library to a Store with the context if it
+// implements ContextStore. Stores that do not are saved with Save unless the
+// context is already done.
+func SaveStore(ctx context.Context, s Store, l *Library) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.SaveContext(ctx, l)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save library state, %w", err)
+	}
+
+	return s.Save(l)
+}
+
+// PingStore checks that the storage of a Store is reachable if it implements
+// Pinger. Stores that do not are assumed to be.
+func PingStore(ctx context.Context, s Store) error {
+	if p, ok := s.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// FileStore is a Store that persists the state of a Library to a file.
+type FileStore struct {
+	Path string // Path of the file, created on the first Save.
+
+	// Format is the format the state is saved in, FormatCommands if
+	// empty. The format of the file is detected when it is loaded, so the
+	// format can be changed between invocations.
+	//
+	// The file is gzip compressed if Path has a .gz extension, and a
+	// compressed file is detected when loaded regardless of its name. The
+	// file is checksummed and a corrupt file fails to load, see ExportFile.
+	Format Format
+	// Key, if set, encrypts the file, see ExportOptions.Key. A file that
+	// is not encrypted is still loaded, so setting a key encrypts the
+	// existing state on the next Save.
+	Key []byte
+}
+
+// Load implements Store.
+func (s *FileStore) Load(l *Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements ContextStore. A command log stops loading before the
+// next command once the context is done, see ImportContext, the other formats
+// are loaded at once.
+func (s *FileStore) LoadContext(ctx context.Context, l *Library) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	bs, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	if bs, err = decrypt(bs, s.Key); err != nil {
+		return fmt.Errorf("%s, %w", s.Path, err)
+	}
+
+	if bs, err = readAll(bytes.NewReader(bs)); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	// The whole file is verified before any of it is loaded.
+	if bs, err = stripChecksum(bs); err != nil {
+		return fmt.Errorf("%s, %w", s.Path, err)
+	}
+
+	if isProto(bs) {
+		return l.ImportProto(bytes.NewReader(bs))
+	}
+
+	if isGob(bs) {
+		return l.importGob(bs)
+	}
+
+	if isSnapshot(bs) {
+		return l.ImportSnapshot(bytes.NewReader(bs))
+	}
+
+	return l.ImportContext(ctx, bytes.NewReader(bs), ImportOptions{})
+}
+
+// Save implements Store by replacing the file atomically, see ExportFile.
+func (s *FileStore) Save(l *Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements ContextStore, see ExportFileContext.
+func (s *FileStore) SaveContext(ctx context.Context, l *Library) error {
+	return l.ExportFileContext(ctx, s.Path, ExportOptions{Format: s.Format, Key: s.Key})
+}
+
+// Append implements Store. The file is only written on Save.
+func (s *FileStore) Append(l *Library, inv *Invocation) error {
+	return nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// Ping implements Pinger by checking that the file can be read, or created if
+// it does not exist yet.
+func (s *FileStore) Ping(ctx context.Context) error {
+	return pingFile(s.Path)
+}
+
+// pingFile checks that the file at path can be read if it exists, and that its
+// directory exists so that it can be created if not.
+func pingFile(path string) error {
+	f, err := os.Open(path)
+	if err == nil {
+		return f.Close()
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to open %s, %w", path, err)
+	}
+
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to stat directory of %s, %w", path, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("failed to stat directory of %s, not a directory", path)
+	}
+
+	return nil
+}
+
+// MemoryStore is a Store that keeps the state of a Library in memory, e.g. to
+// carry state between libraries in tests or to use a library without a file
+// system.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state []byte // Export of the saved state, nil if nothing was saved.
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(l *Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements ContextStore.
+func (s *MemoryStore) LoadContext(ctx context.Context, l *Library) error {
+	s.mu.Lock()
+	state := s.state
+	s.mu.Unlock()
+
+	return l.ImportContext(ctx, bytes.NewReader(state), ImportOptions{})
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(l *Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements ContextStore.
+func (s *MemoryStore) SaveContext(ctx context.Context, l *Library) error {
+	var buf bytes.Buffer
+
+	if err := l.ExportContext(ctx, &buf, ExportOptions{}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = buf.Bytes()
+
+	return nil
+}
+
+// Append implements Store. The state is only kept on Save.
+func (s *MemoryStore) Append(l *Library, inv *Invocation) error {
+	return nil
+}
+
+// Close implements Store.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// readAll reads the whole contents of r, decompressing them if they are gzip
+// compressed.
+func readAll(r io.Reader) ([]byte, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
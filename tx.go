@@ -0,0 +1,169 @@
+package library
+
+// Txn is a transaction of a library, see Library.Tx. It is a copy of the
+// library the mutations of the transaction are staged on, so that its methods,
+// e.g. AddBook, read and change the staged state:
+//
+//	err := l.Tx(func(tx *library.Txn) error {
+//		if err := tx.AddBook(7, "Dune", 2); err != nil {
+//			return err
+//		}
+//
+//		inv := library.Invocation{Command: &library.CheckoutBook{AccountID: 1, BookID: 7}}
+//
+//		return inv.Exec(tx.Library)
+//	})
+type Txn struct {
+	*Library
+}
+
+// Tx calls fn with a transaction of the library and applies the mutations made
+// by fn to the library at once if it returns nil, or discards them if it
+// returns an error or panics, returning the error. The mutations of a library
+// applied by a transaction are undone together, see Undo, and the commands it
+// executed are recorded as changes of the library, see Watch, once it is
+// applied.
+//
+// The library is locked while fn is called, so the transactions and mutations
+// of the library are serialized and its state never changes under fn. fn must
+// therefore only use the transaction and never the library itself, which would
+// deadlock, and should return as soon as possible, since the library cannot
+// be read until it does either. The transaction must not be used once fn
+// returns.
+func (l *Library) Tx(fn func(tx *Txn) error) error {
+	changes, err := l.tx(fn)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	onEvent := l.onEvent
+	l.mu.RUnlock()
+
+	// As with the commands executed against the library, the handler is
+	// called without the lock held, see recordChange.
+	if onEvent != nil {
+		for _, c := range changes {
+			for _, event := range EventsOf(c) {
+				onEvent(event)
+			}
+		}
+	}
+
+	return nil
+}
+
+// tx calls fn with a transaction of the library and applies its mutations, see
+// Tx, returning the changes it applied.
+func (l *Library) tx(fn func(tx *Txn) error) ([]Change, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tx := &Txn{Library: l.clone()}
+
+	err := fn(tx)
+
+	// The copy is detached from the transaction since it becomes the state
+	// of the library, which must only be changed with the lock held.
+	c := tx.Library
+	tx.Library = nil
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version == l.version {
+		return nil, nil
+	}
+
+	prev := l.state()
+
+	l.setState(c.state())
+
+	// The mutations of the transaction are counted by the copy from the
+	// version of the library, so the changes it recorded are numbered as
+	// changes of the library.
+	l.version = c.version
+	l.changed = c.changed
+	l.changes = append(l.changes, c.changes...)
+
+	for _, change := range c.changes {
+		l.sendChange(change)
+	}
+
+	l.pushUndo("transaction", func() {
+		books, accounts := l.books, l.accounts
+
+		l.setState(prev)
+
+		// The versions of the books and accounts are increased past those of
+		// the transaction rather than restored, so that they never repeat.
+		for id, book := range l.books {
+			if b, ok := books[id]; ok && b.Version > book.Version {
+				book.Version = b.Version + 1
+			}
+		}
+
+		for id, account := range l.accounts {
+			if a, ok := accounts[id]; ok && a.Version > account.Version {
+				account.Version = a.Version + 1
+			}
+		}
+	})
+
+	return c.changes, nil
+}
+
+// state is the state of a library that a transaction changes, see Tx.
+type state struct {
+	books              map[int]*Book
+	accounts           map[int]*Account
+	checkoutsByAccount map[int][]*Checkout
+	checkoutsByBook    map[int][]*Checkout
+	history            []*Checkout
+	holds              map[int][]*Hold
+	policy             Policy
+	quota              Quota
+	inventory          *inventory
+	macros             map[string]*Macro
+	macroOrder         []string
+}
+
+// state returns the state of the library.
+//
+// state must be called with the lock held.
+func (l *Library) state() state {
+	return state{
+		books:              l.books,
+		accounts:           l.accounts,
+		checkoutsByAccount: l.checkoutsByAccount,
+		checkoutsByBook:    l.checkoutsByBook,
+		history:            l.history,
+		holds:              l.holds,
+		policy:             l.policy,
+		quota:              l.quota,
+		inventory:          l.inventory,
+		macros:             l.macros,
+		macroOrder:         l.macroOrder,
+	}
+}
+
+// setState replaces the state of the library.
+//
+// setState must be called with the lock held.
+func (l *Library) setState(s state) {
+	l.books = s.books
+	l.accounts = s.accounts
+	l.checkoutsByAccount = s.checkoutsByAccount
+	l.checkoutsByBook = s.checkoutsByBook
+	l.history = s.history
+	l.holds = s.holds
+	l.policy = s.policy
+	l.quota = s.quota
+	l.inventory = s.inventory
+	l.macros = s.macros
+	l.macroOrder = s.macroOrder
+}
@@ -0,0 +1,229 @@
+package library
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// maxUndo is the maximum number of mutations that can be undone.
+const maxUndo = 100
+
+var (
+	// ErrNothingToUndo is returned when there are no mutations to undo.
+	ErrNothingToUndo = errors.New("nothing to undo")
+)
+
+// undoEntry represents the inverse operation of a mutation.
+type undoEntry struct {
+	desc string // Human readable description of the mutation.
+	fn   func() // Reverts the mutation, called with the lock held.
+}
+
+// pushUndo records the inverse operation of a mutation so that it can be
+// reverted by Undo. Only the most recent maxUndo mutations are kept.
+//
+// pushUndo must be called with the lock held.
+func (l *Library) pushUndo(desc string, fn func()) {
+	l.appendUndo(undoEntry{desc: desc, fn: fn})
+	l.version++
+}
+
+// appendUndo appends an entry to the undo stack without counting it as a
+// mutation, e.g. an entry replacing those of mutations already counted.
+//
+// appendUndo must be called with the lock held.
+func (l *Library) appendUndo(entry undoEntry) {
+	l.undo = append(l.undo, entry)
+	l.undoSeq++
+
+	if len(l.undo) > maxUndo {
+		l.undo = slices.Delete(l.undo, 0, len(l.undo)-maxUndo)
+	}
+}
+
+// Undo reverts the most recent mutation of the library and returns a human
+// readable description of the mutation that was reverted.
+//
+// Mutations are reverted in the reverse order they were made, so calling Undo
+// repeatedly reverts progressively older mutations. If there are no mutations
+// to undo, ErrNothingToUndo is returned.
+func (l *Library) Undo() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.undo) == 0 {
+		return "", ErrNothingToUndo
+	}
+
+	entry := l.undo[len(l.undo)-1]
+	l.undo = l.undo[:len(l.undo)-1]
+
+	entry.fn()
+	l.version++
+
+	return entry.desc, nil
+}
+
+// undoMark is a position in the undo stack and the changes of a library, see
+// markUndo.
+type undoMark struct {
+	seq     int // Undo entries ever recorded as of the mark, see undoSeq.
+	changes int // Changes recorded as of the mark.
+	version int // Version of the library as of the mark.
+}
+
+// markUndo returns a mark of the current position in the undo stack to pass to
+// collapseUndo or rollbackUndo, which must be called with it.
+//
+// The changes recorded until then are held back from the watchers and the
+// event handler, see Watch, since they may be rolled back.
+func (l *Library) markUndo() undoMark {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	mark := undoMark{seq: l.undoSeq, changes: len(l.changes), version: l.version}
+
+	if l.marks == 0 {
+		l.held = mark
+	}
+
+	l.marks++
+
+	return mark
+}
+
+// settled returns the changes that are not held back and the sequence number
+// as of the last of them, see markUndo.
+//
+// settled must be called with the lock held.
+func (l *Library) settled() ([]Change, int) {
+	if l.marks > 0 {
+		return l.changes[:min(l.held.changes, len(l.changes))], l.held.version
+	}
+
+	return l.changes, l.version
+}
+
+// popUndoSince removes and returns the undo entries recorded since the mark,
+// most recent first.
+//
+// popUndoSince must be called with the lock held.
+func (l *Library) popUndoSince(mark int) []undoEntry {
+	n := min(l.undoSeq-mark, len(l.undo))
+
+	entries := slices.Clone(l.undo[len(l.undo)-n:])
+	slices.Reverse(entries)
+
+	l.undo = l.undo[:len(l.undo)-n]
+
+	return entries
+}
+
+// collapseUndo replaces the undo entries recorded since the mark with a single
+// entry so that the mutations are undone together, e.g. the mutations made by
+// a macro, and releases the changes held back since the mark, see markUndo.
+func (l *Library) collapseUndo(mark undoMark, desc string) {
+	l.mu.Lock()
+
+	if entries := l.popUndoSince(mark.seq); len(entries) > 0 {
+		// The mutations were already counted, so the entry replacing
+		// theirs is not counted again, see recordChange.
+		l.appendUndo(undoEntry{desc: desc, fn: func() {
+			for _, entry := range entries {
+				entry.fn()
+			}
+		}})
+	}
+
+	l.marks--
+
+	var changes []Change
+
+	if l.marks == 0 {
+		changes = l.changes[min(mark.changes, len(l.changes)):]
+
+		for _, c := range changes {
+			l.sendChange(c)
+		}
+	}
+
+	onEvent := l.onEvent
+
+	l.mu.Unlock()
+
+	if onEvent != nil {
+		for _, c := range changes {
+			for _, event := range EventsOf(c) {
+				onEvent(event)
+			}
+		}
+	}
+}
+
+// rollbackUndo reverts the mutations recorded since the mark and discards the
+// changes recorded since, which were held back, see markUndo, so that they are
+// neither exported nor watched.
+func (l *Library) rollbackUndo(mark undoMark) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.popUndoSince(mark.seq) {
+		entry.fn()
+	}
+
+	l.marks--
+
+	l.changes = l.changes[:min(mark.changes, len(l.changes))]
+
+	// Reverting the mutations changes the library, e.g. for a view taken
+	// since the mark, but is not a change to export either.
+	l.version++
+	l.changed = l.version
+}
+
+// ClearUndo discards the recorded mutations so that they can no longer be
+// undone.
+//
+// This is used after loading the initial library state so that only the
+// mutations made afterwards can be undone.
+func (l *Library) ClearUndo() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.undo = nil
+}
+
+// uncheckout removes a checkout from the active indexes and the history as if
+// it was never made.
+//
+// uncheckout must be called with the lock held.
+func (l *Library) uncheckout(checkout *Checkout) {
+	match := func(c *Checkout) bool {
+		return c == checkout
+	}
+
+	l.checkoutsByAccount[checkout.AccountID] = slices.DeleteFunc(l.checkoutsByAccount[checkout.AccountID], match)
+	l.checkoutsByBook[checkout.BookID] = slices.DeleteFunc(l.checkoutsByBook[checkout.BookID], match)
+	l.history = slices.DeleteFunc(l.history, match)
+}
+
+// unreturn restores a returned checkout to the active indexes and refunds the
+// fine charged for it, increasing the versions of the book and account.
+//
+// unreturn must be called with the lock held.
+func (l *Library) unreturn(checkout *Checkout, fine int) {
+	checkout.Returned = time.Time{}
+
+	if account, ok := l.accounts[checkout.AccountID]; ok {
+		account.Balance += fine
+		account.Version++
+	}
+
+	if book, ok := l.books[checkout.BookID]; ok {
+		book.Version++
+	}
+
+	l.checkoutsByAccount[checkout.AccountID] = append(l.checkoutsByAccount[checkout.AccountID], checkout)
+	l.checkoutsByBook[checkout.BookID] = append(l.checkoutsByBook[checkout.BookID], checkout)
+}
@@ -0,0 +1,285 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Validator is implemented by Commands that validate their arguments beyond
+// the checks for unknown and missing arguments, e.g. that a count is
+// positive.
+//
+// Validate is called after the arguments are unmarshaled and should return a
+// *ValidationError, or nil if the arguments are valid.
+type Validator interface {
+	Validate() error
+}
+
+// ArgumentError represents an invalid argument of a command.
+type ArgumentError struct {
+	Argument string // Name of the argument as it appears in the JSON.
+	Reason   string // Reason the argument is invalid.
+}
+
+// Error implements the error interface.
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Argument, e.Reason)
+}
+
+// ValidationError represents the invalid arguments of a command.
+//
+// Every invalid argument is reported rather than only the first so that all
+// of the problems with a command can be fixed at once.
+type ValidationError struct {
+	Errors []*ArgumentError
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("invalid arguments, %s", strings.Join(msgs, "; "))
+}
+
+// add records an invalid argument.
+func (e *ValidationError) add(argument, reason string) {
+	e.Errors = append(e.Errors, &ArgumentError{Argument: argument, Reason: reason})
+}
+
+// err returns the ValidationError if any invalid arguments were recorded, or
+// nil otherwise.
+func (e *ValidationError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// ValidateCommands parses and checks every command of a command file without
+// executing them, returning an *ImportError for each problem found in the
+// order of their lines, or an error if the commands cannot be read.
+//
+// Besides the commands that cannot be parsed, including those with unknown
+// or missing arguments or arguments of the wrong type, see Validator, the
+// names that are neither a command nor a macro defined before them and the
+// books and accounts added more than once are reported. Problems that depend
+// on the state of a library, e.g. checking out a book that does not exist,
+// are only found by executing the commands.
+func ValidateCommands(r io.Reader) ([]*ImportError, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+
+	var (
+		problems []*ImportError
+
+		// Command logs without a version comment are the first version.
+		version = 1
+
+		macros   = make(map[string]bool)
+		books    = make(map[int]int) // Line each book is added on.
+		accounts = make(map[int]int) // Line each account is created on.
+	)
+
+	for n := 1; ; n++ {
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return problems, fmt.Errorf("failed to read commands, %w", readErr)
+		}
+
+		if len(line) == 0 && errors.Is(readErr, io.EOF) {
+			return problems, nil
+		}
+
+		raw := string(bytes.TrimSpace(line))
+
+		report := func(command string, err error) {
+			problems = append(problems, &ImportError{Line: n, Command: command, Raw: raw, Err: err})
+		}
+
+		if v, ok, err := parseVersionComment(line); ok {
+			if err != nil {
+				report("", err)
+			} else {
+				version = v
+			}
+		}
+
+		if isBlankOrComment(line) {
+			continue
+		}
+
+		migrated, err := migrateCommand(version, line)
+		if err != nil {
+			report("", err)
+
+			continue
+		}
+
+		for _, line := range migrated {
+			var inv Invocation
+
+			if err := json.Unmarshal(line, &inv); err != nil {
+				report(inv.RawCommand.Name, fmt.Errorf("%w, %w", ErrInvalidCommand, err))
+
+				continue
+			}
+
+			name := inv.RawCommand.Name
+
+			switch cmd := inv.Command.(type) {
+			case *DefineMacro:
+				macros[cmd.Name] = true
+			case *CallMacro:
+				if macros[cmd.Macro] {
+					break
+				}
+
+				// Names that are not commands are parsed as calls of
+				// macros, see Invocation.UnmarshalJSON.
+				if cmd.byName {
+					report(name, fmt.Errorf("%w, unknown command %q", ErrInvalidCommand, name))
+				} else {
+					report(name, &NotExistError{Kind: KindMacro, Name: cmd.Macro, detail: cmd.Macro})
+				}
+			case *AddBook:
+				if first, ok := books[cmd.ID]; ok {
+					report(name, &DuplicateError{Kind: KindBook, ID: cmd.ID, detail: fmt.Sprintf("book (%d) is already added on line %d", cmd.ID, first)})
+				} else {
+					books[cmd.ID] = n
+				}
+			case *CreateAccount:
+				if first, ok := accounts[cmd.ID]; ok {
+					report(name, &DuplicateError{Kind: KindAccount, ID: cmd.ID, detail: fmt.Sprintf("account (%d) is already created on line %d", cmd.ID, first)})
+				} else {
+					accounts[cmd.ID] = n
+				}
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return problems, nil
+		}
+	}
+}
+
+// unmarshalArguments strictly unmarshals the arguments of a command into cmd.
+//
+// The arguments of a command are the JSON fields of its Command type. Fields
+// tagged with omitempty are optional and all other fields are required.
+// Unknown and missing arguments are reported together, and the Command is
+// validated further if it implements Validator.
+func unmarshalArguments(bs []byte, cmd any) error {
+	var args map[string]json.RawMessage
+
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &args); err != nil {
+			return err
+		}
+	}
+
+	var verr ValidationError
+
+	fields := argumentFields(reflect.TypeOf(cmd).Elem())
+
+	// Sort the unknown arguments so that the errors are deterministic.
+	var unknown []string
+
+	for name := range args {
+		if _, ok := fields[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+
+	slices.Sort(unknown)
+
+	for _, name := range unknown {
+		verr.add(name, "unknown argument")
+	}
+
+	var missing []string
+
+	for name, required := range fields {
+		if _, ok := args[name]; required && !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	slices.Sort(missing)
+
+	for _, name := range missing {
+		verr.add(name, "missing required argument")
+	}
+
+	if err := verr.err(); err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(cmd); err != nil {
+			var terr *json.UnmarshalTypeError
+			if errors.As(err, &terr) && terr.Field != "" {
+				verr.add(terr.Field, fmt.Sprintf("expected %s, got %s", terr.Type, terr.Value))
+
+				return verr.err()
+			}
+
+			return err
+		}
+	}
+
+	if v, ok := cmd.(Validator); ok {
+		return v.Validate()
+	}
+
+	return nil
+}
+
+// argumentFields returns the JSON argument names of a Command struct type and
+// whether each is required.
+func argumentFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+
+		fields[name] = !slices.Contains(strings.Split(opts, ","), "omitempty")
+	}
+
+	return fields
+}
@@ -0,0 +1,135 @@
+package library
+
+import (
+	"fmt"
+	"slices"
+)
+
+// UpdateBookIf replaces the name, number of copies and metadata of the book
+// with the ID of book with those of book, if the book is still at the version,
+// e.g. the version an editor read it at, so that concurrent editors do not
+// overwrite each other's changes, e.g. to implement If-Match:
+//
+//	book := l.Book(7)
+//	book.Count++
+//
+//	if err := l.UpdateBookIf(*book, book.Version); errors.Is(err, library.ErrVersionMismatch) {
+//		// The book changed since it was read, read it again and retry.
+//	}
+//
+// The Version of book is ignored; the version of the book is increased. If the
+// book has changed since the version, a *VersionError wrapping
+// ErrVersionMismatch is returned. The count must be non-negative and not less
+// than the copies checked out.
+func (l *Library) UpdateBookIf(book Book, version int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.books[book.ID]
+	if !ok {
+		return &NotExistError{Kind: KindBook, ID: book.ID}
+	}
+
+	if current.Version != version {
+		return &VersionError{Kind: KindBook, ID: book.ID, Version: current.Version, Expected: version}
+	}
+
+	if book.Count < 0 {
+		return fmt.Errorf("%w, cannot set negative copies", ErrInvalidArgument)
+	}
+
+	if checkedOut := len(l.checkoutsByBook[book.ID]); book.Count < checkedOut {
+		return &NotEnoughCopiesError{
+			BookID:    book.ID,
+			Available: checkedOut,
+			detail:    fmt.Sprintf("cannot set fewer copies of %s (%d) than are checked out (%d)", current.Name, current.ID, checkedOut),
+		}
+	}
+
+	prev := *current
+	prev.Tags = slices.Clone(current.Tags)
+
+	current.Name = book.Name
+	current.Count = book.Count
+	current.BookMetadata = book.BookMetadata
+	current.Tags = slices.Clone(book.Tags)
+	current.Version++
+
+	l.pushUndo(fmt.Sprintf("update %s (%d)", current.Name, current.ID), func() {
+		version := current.Version
+		*current = prev
+		current.Version = version + 1
+	})
+
+	return nil
+}
+
+// UpdateAccountIf replaces the name and balance of the account with the ID of
+// account with those of account, if the account is still at the version, in
+// the same way as UpdateBookIf.
+func (l *Library) UpdateAccountIf(account Account, version int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.accounts[account.ID]
+	if !ok {
+		return &NotExistError{Kind: KindAccount, ID: account.ID}
+	}
+
+	if current.Version != version {
+		return &VersionError{Kind: KindAccount, ID: account.ID, Version: current.Version, Expected: version}
+	}
+
+	prev := *current
+
+	current.Name = account.Name
+	current.Balance = account.Balance
+	current.Version++
+
+	l.pushUndo(fmt.Sprintf("update account %s (%d)", current.Name, current.ID), func() {
+		version := current.Version
+		*current = prev
+		current.Version = version + 1
+	})
+
+	return nil
+}
+
+// restoreBookVersion sets the version of a book restored from an export, see
+// AddBook.Version, unless the book is already at a later version so that the
+// version of a book never decreases.
+func (l *Library) restoreBookVersion(id, version int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	book, ok := l.books[id]
+	if !ok || book.Version >= version {
+		return
+	}
+
+	prev := book.Version
+	book.Version = version
+
+	l.pushUndo(fmt.Sprintf("restore version of %s (%d)", book.Name, book.ID), func() {
+		book.Version = prev
+	})
+}
+
+// restoreAccountVersion sets the version of an account restored from an
+// export, see CreateAccount.Version, in the same way as restoreBookVersion.
+func (l *Library) restoreAccountVersion(id, version int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	account, ok := l.accounts[id]
+	if !ok || account.Version >= version {
+		return
+	}
+
+	prev := account.Version
+	account.Version = version
+
+	l.pushUndo(fmt.Sprintf("restore version of account %s (%d)", account.Name, account.ID), func() {
+		account.Version = prev
+	})
+}
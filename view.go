@@ -0,0 +1,172 @@
+package library
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+)
+
+// View is an immutable view of the state of a library at a point in time, see
+// Library.View.
+//
+// A View is safe for concurrent use and its methods return copies, in the same
+// way as those of the library, so that it never changes.
+type View struct {
+	version            int
+	policy             Policy
+	books              []*Book
+	accounts           []*Account
+	checkouts          []*Checkout
+	bookIndex          map[int]*Book
+	accountIndex       map[int]*Account
+	checkoutsByBook    map[int][]*Checkout
+	checkoutsByAccount map[int][]*Checkout
+
+	// bookOrders and accountOrders are the books and accounts sorted in
+	// each order they are listed in, see ListBooks, sorted when first
+	// listed.
+	bookOrders    listOrders[*Book]
+	accountOrders listOrders[*Account]
+}
+
+// View returns an immutable view of the current state of the library that can
+// serve reads without holding the lock of the library, e.g. to print the
+// catalog or a report while books are checked out and returned:
+//
+//	v := l.View()
+//	for book := range v.Books() {
+//		fmt.Printf("%s: %d available\n", book.Name, book.Count-len(v.CheckoutsByBook(book.ID)))
+//	}
+//
+// The view is shared by the callers until the library changes, so taking a
+// view of a library that has not changed since the last one does not copy
+// anything, and the state is copied at most once for each mutation rather than
+// for each read. The state is copied with the lock held only for reading, and
+// the view does not change with the library afterwards, so that its reads are
+// consistent with each other.
+//
+// View is a different type than Snapshot, which is the complete state of the
+// library, including its history, to export it.
+func (l *Library) View() *View {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if v := l.view.Load(); v != nil && v.version == l.version {
+		return v
+	}
+
+	// The views built concurrently are of the same version, since the
+	// lock is held for reading, so it does not matter which is kept.
+	v := l.newView()
+	l.view.Store(v)
+
+	return v
+}
+
+// newView returns a view of the current state of the library.
+//
+// newView must be called with the lock held.
+func (l *Library) newView() *View {
+	v := &View{
+		version:            l.version,
+		policy:             l.policy,
+		books:              make([]*Book, 0, len(l.books)),
+		accounts:           make([]*Account, 0, len(l.accounts)),
+		bookIndex:          make(map[int]*Book, len(l.books)),
+		accountIndex:       make(map[int]*Account, len(l.accounts)),
+		checkoutsByBook:    make(map[int][]*Checkout),
+		checkoutsByAccount: make(map[int][]*Checkout),
+	}
+
+	for _, book := range l.books {
+		book = book.clone()
+
+		v.books = append(v.books, book)
+		v.bookIndex[book.ID] = book
+	}
+
+	for _, account := range l.accounts {
+		account = account.clone()
+
+		v.accounts = append(v.accounts, account)
+		v.accountIndex[account.ID] = account
+	}
+
+	slices.SortFunc(v.books, func(a, b *Book) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(v.accounts, func(a, b *Account) int { return cmp.Compare(a.ID, b.ID) })
+
+	// Only the active checkouts are copied, not the whole history, and in
+	// the order they were made.
+	for _, checkout := range l.history {
+		if !checkout.Returned.IsZero() {
+			continue
+		}
+
+		checkout = checkout.clone()
+
+		v.checkouts = append(v.checkouts, checkout)
+		v.checkoutsByBook[checkout.BookID] = append(v.checkoutsByBook[checkout.BookID], checkout)
+		v.checkoutsByAccount[checkout.AccountID] = append(v.checkoutsByAccount[checkout.AccountID], checkout)
+	}
+
+	v.bookOrders = newListOrders(v.books, func(b *Book) listKey {
+		return listKey{id: b.ID, name: b.Name, available: b.Count - len(v.checkoutsByBook[b.ID])}
+	})
+	v.accountOrders = newListOrders(v.accounts, func(a *Account) listKey {
+		return listKey{id: a.ID, name: a.Name, available: v.policy.CheckoutLimit - len(v.checkoutsByAccount[a.ID])}
+	})
+
+	return v
+}
+
+// Policy returns the circulation policy of the library.
+func (v *View) Policy() Policy {
+	return v.policy
+}
+
+// Book returns a copy of a book by ID, nil if it does not exist.
+func (v *View) Book(id int) *Book {
+	return v.bookIndex[id].clone()
+}
+
+// Account returns a copy of an account by ID, nil if it does not exist.
+func (v *View) Account(id int) *Account {
+	return v.accountIndex[id].clone()
+}
+
+// Books returns an iterator over copies of the books, sorted by ID.
+func (v *View) Books() iter.Seq[*Book] {
+	return cloneSeq(v.books)
+}
+
+// Accounts returns an iterator over copies of the accounts, sorted by ID.
+func (v *View) Accounts() iter.Seq[*Account] {
+	return cloneSeq(v.accounts)
+}
+
+// Checkouts returns an iterator over copies of the active checkouts, in the
+// order the checkouts were made.
+func (v *View) Checkouts() iter.Seq[*Checkout] {
+	return cloneSeq(v.checkouts)
+}
+
+// CheckoutsByAccount returns copies of the active checkouts of an account.
+func (v *View) CheckoutsByAccount(id int) []*Checkout {
+	return cloneAll(v.checkoutsByAccount[id])
+}
+
+// CheckoutsByBook returns copies of the active checkouts of a book.
+func (v *View) CheckoutsByBook(id int) []*Checkout {
+	return cloneAll(v.checkoutsByBook[id])
+}
+
+// cloneSeq returns an iterator over copies of the values.
+func cloneSeq[T interface{ clone() T }](values []T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for _, value := range values {
+			if !yield(value.clone()) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrNoWAL is returned by Compact when the library has no write-ahead log.
+var ErrNoWAL = errors.New("no write-ahead log")
+
+// WALStore is a Store that appends each command that changes the library to a
+// write-ahead log rather than rewriting the whole state, so that every command
+// is durable as soon as it is executed.
+//
+// The state is kept as a Snapshot at Path and the log at Path with a ".wal"
+// suffix. Appending writes a command to the log, loading replays the tail of
+// the log on top of the snapshot, and saving compacts the log by folding it into the snapshot, see Compact. The
+// snapshot is gzip compressed if Path has a .gz extension.
+type WALStore struct {
+	Path string // Path of the snapshot, the log is at Path + ".wal".
+
+	log     *os.File
+	seq     int // Sequence number of the last entry of the log.
+	version int // Version of the library as of the last entry of the log.
+}
+
+// walEntry represents a command in the write-ahead log.
+//
+// The time the command was executed is logged with it so that commands that
+// use the current time, e.g. CHECKOUT_BOOK, are replayed as they were
+// executed, as is the conflict strategy of the import it was executed by, see
+// ImportOptions.OnConflict, so that an ADD_BOOK or CREATE_ACCOUNT that was
+// resolved with an existing book or account is replayed the same way.
+type walEntry struct {
+	Seq        int         `json:"seq"`
+	At         time.Time   `json:"at"`
+	OnConflict Conflict    `json:"onConflict,omitempty"`
+	Command    *Invocation `json:"command"`
+}
+
+// OpenWALStore opens the write-ahead log of the snapshot at path, creating it
+// if it does not exist.
+func OpenWALStore(path string) (*WALStore, error) {
+	log, err := os.OpenFile(path+".wal", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s.wal, %w", path, err)
+	}
+
+	return &WALStore{Path: path, log: log}, nil
+}
+
+// Load implements Store by loading the snapshot and replaying the entries of
+// the log that were not folded into it.
+//
+// A torn entry at the end of the log, e.g. from a crash while it was written,
+// is discarded. Once loaded, the commands executed against the library are
+// appended to the log.
+func (s *WALStore) Load(l *Library) error {
+	return s.LoadContext(context.Background(), l)
+}
+
+// LoadContext implements ContextStore. The replay of the log stops before the
+// next entry once the context is done.
+func (s *WALStore) LoadContext(ctx context.Context, l *Library) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	if err := s.loadSnapshot(l); err != nil {
+		return err
+	}
+
+	if err := s.replay(ctx, l); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.wal = s
+	s.version = l.version
+
+	return nil
+}
+
+// loadSnapshot loads the snapshot, if there is one, into the library.
+func (s *WALStore) loadSnapshot(l *Library) error {
+	bs, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	if bs, err = readAll(bytes.NewReader(bs)); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	if bs, err = stripChecksum(bs); err != nil {
+		return fmt.Errorf("%s, %w", s.Path, err)
+	}
+
+	if bs, err = migrateSnapshot(bs); err != nil {
+		return fmt.Errorf("%s, %w", s.Path, err)
+	}
+
+	var snapshot Snapshot
+
+	if err := json.Unmarshal(bs, &snapshot); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.Path, err)
+	}
+
+	if err := l.LoadSnapshot(&snapshot); err != nil {
+		return err
+	}
+
+	s.seq = snapshot.LogSequence
+
+	return nil
+}
+
+// replay executes the entries of the log after the snapshot against the
+// library, each as of the time it was executed.
+func (s *WALStore) replay(ctx context.Context, l *Library) error {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read %s, %w", s.log.Name(), err)
+	}
+
+	br := bufio.NewReader(s.log)
+
+	var offset int64
+
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s line %d, %w", s.log.Name(), n, err)
+		}
+
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read %s, %w", s.log.Name(), err)
+		}
+
+		// Entries are only complete once their newline is written, so
+		// anything after the last newline is a torn write and dropped.
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				l.log().Warn("dropped torn write-ahead log entry", "path", s.log.Name(), "line", n, "bytes", len(line))
+			}
+
+			if err := s.log.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate %s, %w", s.log.Name(), err)
+			}
+
+			_, err := s.log.Seek(offset, io.SeekStart)
+
+			return err
+		}
+
+		offset += int64(len(line))
+
+		var entry walEntry
+
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("%s line %d, %w", s.log.Name(), n, err)
+		}
+
+		if entry.Seq <= s.seq {
+			continue
+		}
+
+		exec := func() error { return entry.Command.execAt(l, entry.At) }
+
+		if err := l.withConflict(entry.OnConflict, exec); err != nil {
+			return fmt.Errorf("%s line %d, %w", s.log.Name(), n, err)
+		}
+
+		s.seq = entry.Seq
+	}
+}
+
+// Append implements Store by appending the command to the log and waiting for
+// it to be written to disk, if it changed the library.
+//
+// Commands that only read the library are not logged, nor is a command whose
+// changes were already appended by the commands it executed, e.g. INCLUDE.
+func (s *WALStore) Append(l *Library, inv *Invocation) error {
+	l.mu.RLock()
+	version := l.version
+	l.mu.RUnlock()
+
+	if version == s.version {
+		return nil
+	}
+
+	entry := walEntry{Seq: s.seq + 1, At: inv.At, Command: inv}
+
+	if c := l.onConflict(); c != ConflictFail {
+		entry.OnConflict = c
+	}
+
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to write %s, %w", s.log.Name(), err)
+	}
+
+	if _, err := s.log.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s, %w", s.log.Name(), err)
+	}
+
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s, %w", s.log.Name(), err)
+	}
+
+	s.seq++
+	s.version = version
+
+	return nil
+}
+
+// Save implements Store by compacting the log, see Compact.
+func (s *WALStore) Save(l *Library) error {
+	return s.SaveContext(context.Background(), l)
+}
+
+// SaveContext implements ContextStore. If the context is done before the
+// snapshot is written, the snapshot and the log are left as they are.
+func (s *WALStore) SaveContext(ctx context.Context, l *Library) error {
+	snapshot := l.Snapshot()
+	snapshot.LogSequence = s.seq
+
+	err := writeFile(s.Path, compressFor(s.Path, withChecksum(func(w io.Writer) error {
+		enc := json.NewEncoder(contextWriter{ctx: ctx, w: w})
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(snapshot)
+	})))
+	if err != nil {
+		return err
+	}
+
+	// The snapshot records the last entry folded into it, so a crash
+	// before the log is truncated does not replay the entries twice.
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate %s, %w", s.log.Name(), err)
+	}
+
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to truncate %s, %w", s.log.Name(), err)
+	}
+
+	return nil
+}
+
+// Close implements Store.
+func (s *WALStore) Close() error {
+	return s.log.Close()
+}
+
+// Ping implements Pinger by checking that the log is still open and that the
+// snapshot can be read.
+func (s *WALStore) Ping(ctx context.Context) error {
+	if _, err := s.log.Stat(); err != nil {
+		return fmt.Errorf("failed to stat %s.wal, %w", s.Path, err)
+	}
+
+	return pingFile(s.Path)
+}
+
+// Compact folds the write-ahead log of the library into its snapshot and
+// truncates the log, see WALStore.
+//
+// If the library was not loaded from a WALStore, ErrNoWAL is returned.
+func (l *Library) Compact() error {
+	l.mu.RLock()
+	wal := l.wal
+	l.mu.RUnlock()
+
+	if wal == nil {
+		return ErrNoWAL
+	}
+
+	return wal.Save(l)
+}
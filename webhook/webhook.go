@@ -0,0 +1,456 @@
+// Package webhook delivers the events of the changes of a library, see
+// library.Event, to webhook targets as signed JSON payloads, so that other
+// systems, e.g. a notification service, are told about checkouts, returns,
+// overdue books and holds as they happen, e.g. a hold.available event tells the
+// account of a hold that a copy of the book is waiting for it.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admtnnr/library"
+)
+
+const (
+	// DefaultMaxAttempts is the number of attempts to deliver an event to
+	// a target before it is dead-lettered.
+	DefaultMaxAttempts = 5
+	// DefaultBackoff is the wait before the first retry of a delivery,
+	// doubled for each retry after it.
+	DefaultBackoff = time.Second
+	// DefaultOverdueInterval is how often the checkouts are checked for
+	// ones that became overdue.
+	DefaultOverdueInterval = time.Minute
+
+	// queueSize is the number of events that may be waiting to be
+	// delivered to a target before further events are dead-lettered.
+	queueSize = 1024
+)
+
+// Target is a webhook target that receives events.
+type Target struct {
+	URL string `json:"url"`
+	// Secret is the key the payloads are signed with, see Dispatcher. The
+	// payloads are not signed if it is empty.
+	Secret string `json:"secret,omitempty"`
+	// Events are the types of the events delivered to the target, all of
+	// them if empty. A type ending in * matches the types with the prefix,
+	// e.g. checkout.* matches checkout.created and checkout.returned, and
+	// hold.* matches hold.placed, hold.canceled and hold.available.
+	Events []string `json:"events,omitempty"`
+}
+
+// wants reports whether the target receives events of the type.
+func (t *Target) wants(typ string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+
+	for _, filter := range t.Events {
+		if prefix, ok := strings.CutSuffix(filter, "*"); ok {
+			if strings.HasPrefix(typ, prefix) {
+				return true
+			}
+		} else if filter == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config is the configuration of the webhooks, e.g. as read by LoadConfig.
+type Config struct {
+	Targets []Target `json:"targets"`
+	// MaxAttempts is the number of attempts to deliver an event,
+	// DefaultMaxAttempts if not positive.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// DeadLetter is the path of the file the events that could not be
+	// delivered are appended to, see DeadLetter. They are dropped if it
+	// is empty.
+	DeadLetter string `json:"deadLetter,omitempty"`
+}
+
+// LoadConfig reads the configuration of the webhooks from a JSON file, e.g.:
+//
+//	{
+//		"targets": [
+//			{"url": "https://example.com/hooks/library", "secret": "s3cr3t", "events": ["checkout.*"]},
+//			{"url": "https://example.com/hooks/notify", "secret": "s3cr3t", "events": ["hold.available"]}
+//		],
+//		"maxAttempts": 5,
+//		"deadLetter": "webhooks.dead.jsonl"
+//	}
+func LoadConfig(path string) (*Config, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks config, %w", err)
+	}
+
+	var config Config
+
+	if err := json.Unmarshal(bs, &config); err != nil {
+		return nil, fmt.Errorf("%w, invalid webhooks config %s, %w", library.ErrInvalidArgument, path, err)
+	}
+
+	for i, target := range config.Targets {
+		if !strings.HasPrefix(target.URL, "http://") && !strings.HasPrefix(target.URL, "https://") {
+			return nil, fmt.Errorf("%w, webhook target %d has invalid URL %q", library.ErrInvalidArgument, i+1, target.URL)
+		}
+	}
+
+	return &config, nil
+}
+
+// DeadLetter is an event that could not be delivered to a target, written to
+// Dispatcher.DeadLetter as a line of JSON so that it can be inspected and
+// redelivered.
+type DeadLetter struct {
+	URL      string        `json:"url"`
+	Delivery string        `json:"delivery"`
+	Event    library.Event `json:"event"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error"`
+}
+
+// Dispatcher delivers the events of a library to webhook targets.
+//
+// Each event is POSTed to each target that wants it as JSON, with the
+// following headers:
+//
+//	X-Library-Event      type of the event
+//	X-Library-Delivery   unique ID of the delivery, the same for each attempt
+//	X-Library-Signature  t=<unix time>,v1=<signature>
+//
+// The signature is the hex encoded HMAC-SHA256 of the time, a period, and the
+// body, keyed by the secret of the target, so that a target can verify that
+// the payload was sent by the library and reject old payloads that are
+// replayed.
+//
+// The events are delivered to each target in order, independently of the
+// other targets. A delivery that fails, with a network error or a 408, 429 or
+// 5xx response, is retried with exponential backoff, and once MaxAttempts
+// fail or the response is another non-2xx status, the event is written to
+// DeadLetter.
+type Dispatcher struct {
+	Library *library.Library
+	Targets []Target
+	// Client is the HTTP client used to deliver the events,
+	// http.DefaultClient if nil.
+	Client *http.Client
+	// MaxAttempts is the number of attempts to deliver an event,
+	// DefaultMaxAttempts if not positive.
+	MaxAttempts int
+	// Backoff is the wait before the first retry of a delivery,
+	// DefaultBackoff if not positive.
+	Backoff time.Duration
+	// OverdueInterval is how often the active checkouts are checked for
+	// checkouts that became overdue, DefaultOverdueInterval if not
+	// positive.
+	OverdueInterval time.Duration
+	// DeadLetter, if set, receives the events that could not be
+	// delivered, one DeadLetter per line.
+	DeadLetter io.Writer
+	// Logger logs the deliveries, the failed attempts and the events
+	// that could not be delivered, slog.Default() if nil.
+	Logger *slog.Logger
+
+	deadMu sync.Mutex
+}
+
+// Run delivers the events of the changes of the library made after it is
+// called, and a checkout.overdue event for each active checkout that becomes
+// overdue, until the context is canceled. Checkouts that are already overdue
+// when it is called are not reported.
+//
+// The events waiting to be delivered when the context is canceled are written
+// to DeadLetter.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	queues := make([]chan library.Event, len(d.Targets))
+
+	var wg sync.WaitGroup
+
+	for i := range d.Targets {
+		queues[i] = make(chan library.Event, queueSize)
+
+		wg.Add(1)
+
+		go func(target *Target, queue chan library.Event) {
+			defer wg.Done()
+
+			d.deliverAll(ctx, target, queue)
+		}(&d.Targets[i], queues[i])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+
+		wg.Wait()
+	}()
+
+	dispatch := func(event library.Event) {
+		for i := range d.Targets {
+			if !d.Targets[i].wants(event.Type) {
+				continue
+			}
+
+			select {
+			case queues[i] <- event:
+			default:
+				d.deadLetter(&d.Targets[i], newDeliveryID(), event, 0, errors.New("too many events waiting to be delivered"))
+			}
+		}
+	}
+
+	interval := d.OverdueInterval
+	if interval <= 0 {
+		interval = DefaultOverdueInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	overdue := d.overdue(make(map[overdueKey]bool), nil)
+	seq := d.Library.Sequence()
+
+	for {
+		changes, stop, err := d.Library.Watch(seq)
+		if err != nil {
+			return fmt.Errorf("failed to watch library, %w", err)
+		}
+
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				stop()
+
+				return nil
+			case <-ticker.C:
+				overdue = d.overdue(overdue, dispatch)
+			case c, ok := <-changes:
+				if !ok {
+					// The dispatcher fell behind, so it watches
+					// again from the last change it received.
+					break watch
+				}
+
+				seq = c.Seq
+
+				for _, event := range library.EventsOf(c) {
+					dispatch(event)
+				}
+			}
+		}
+
+		stop()
+	}
+}
+
+// overdueKey identifies an active checkout.
+type overdueKey struct {
+	accountID, bookID int
+	checkedOut        time.Time
+}
+
+// overdue dispatches a checkout.overdue event for each overdue checkout that
+// was not overdue as of the previous check, and returns the overdue checkouts.
+// Nothing is dispatched if dispatch is nil.
+func (d *Dispatcher) overdue(previous map[overdueKey]bool, dispatch func(event library.Event)) map[overdueKey]bool {
+	current := make(map[overdueKey]bool)
+	now := d.Library.Now()
+	seq := d.Library.Sequence()
+
+	for _, checkout := range d.Library.OverdueCheckouts() {
+		key := overdueKey{checkout.AccountID, checkout.BookID, checkout.CheckedOut}
+		current[key] = true
+
+		if previous[key] || dispatch == nil {
+			continue
+		}
+
+		dispatch(library.Event{
+			Type:      library.EventCheckoutOverdue,
+			Sequence:  seq,
+			At:        now,
+			BookID:    checkout.BookID,
+			AccountID: checkout.AccountID,
+		})
+	}
+
+	return current
+}
+
+// deliverAll delivers the events of the queue to the target until it is
+// closed, dead-lettering the events left once the context is canceled.
+func (d *Dispatcher) deliverAll(ctx context.Context, target *Target, queue chan library.Event) {
+	for event := range queue {
+		id := newDeliveryID()
+
+		if ctx.Err() != nil {
+			d.deadLetter(target, id, event, 0, ctx.Err())
+
+			continue
+		}
+
+		attempts, err := d.deliver(ctx, target, id, event)
+		if err != nil {
+			d.log().Error("failed to deliver webhook event", "url", target.URL, "delivery", id, "event", event.Type, "attempts", attempts, "err", err)
+			d.deadLetter(target, id, event, attempts, err)
+
+			continue
+		}
+
+		d.log().Debug("delivered webhook event", "url", target.URL, "delivery", id, "event", event.Type, "attempts", attempts)
+	}
+}
+
+// deliver delivers an event to the target, retrying failed attempts, and
+// returns the number of attempts and the error of the last if it failed.
+func (d *Dispatcher) deliver(ctx context.Context, target *Target, id string, event library.Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event, %w", err)
+	}
+
+	attempts := d.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(ctx, target, id, event.Type, body)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if !retry || attempt == attempts {
+			return attempt, err
+		}
+
+		d.log().Warn("retrying webhook event", "url", target.URL, "delivery", id, "event", event.Type, "attempt", attempt, "backoff", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w, after %w", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// post makes an attempt to deliver an event, reporting whether a failed
+// attempt should be retried.
+func (d *Dispatcher) post(ctx context.Context, target *Target, id, typ string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request, %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Library-Event", typ)
+	req.Header.Set("X-Library-Delivery", id)
+
+	if target.Secret != "" {
+		req.Header.Set("X-Library-Signature", Sign(target.Secret, time.Now(), body))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to deliver event, %w", err)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("failed to deliver event, %s", resp.Status)
+	default:
+		return false, fmt.Errorf("failed to deliver event, %s", resp.Status)
+	}
+}
+
+// log returns the logger of the dispatcher.
+func (d *Dispatcher) log() *slog.Logger {
+	if d.Logger == nil {
+		return slog.Default()
+	}
+
+	return d.Logger
+}
+
+// deadLetter writes an event that could not be delivered to DeadLetter.
+func (d *Dispatcher) deadLetter(target *Target, id string, event library.Event, attempts int, err error) {
+	if d.DeadLetter == nil {
+		return
+	}
+
+	bs, merr := json.Marshal(DeadLetter{
+		URL:      target.URL,
+		Delivery: id,
+		Event:    event,
+		Attempts: attempts,
+		Error:    err.Error(),
+	})
+	if merr != nil {
+		return
+	}
+
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+
+	d.DeadLetter.Write(append(bs, '\n'))
+}
+
+// Sign returns the X-Library-Signature header of a payload sent at a time,
+// see Dispatcher, for targets to compute the signature to verify it.
+func Sign(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// newDeliveryID returns a random ID of a delivery.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}